@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/cmd/auth/config"
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// commandsUsage describes the administrative commands
+const commandsUsage = `commands:
+  keys list                         list keys in the key ring
+  keys add <kid> <alg> <key-file>   add a verify-only key (creates the ring if missing)
+  keys promote <kid>                make a key the active signing key
+  keys retire [<kid>]               remove a key, or every key whose tokens have expired
+  break-glass [<reason>]            revoke every issued token (requires DB_DSN)`
+
+// runCommand executes an administrative command instead of starting the server
+// args are the positional command line arguments, output is written to out
+func runCommand(conf *config.Config, args []string, out io.Writer) error {
+	switch args[0] {
+	case "keys":
+		return runKeysCommand(conf, args[1:], out)
+	case "break-glass":
+		return runBreakGlassCommand(conf, args[1:], out)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], commandsUsage)
+	}
+}
+
+// runKeysCommand manages the JWT key ring directory
+func runKeysCommand(conf *config.Config, args []string, out io.Writer) error {
+	if conf.JWTKeysDir == "" {
+		return errors.New("JWT_KEYS_DIR is not configured")
+	}
+	if len(args) == 0 {
+		return errors.New(commandsUsage)
+	}
+
+	if args[0] == "add" {
+		if len(args) != 4 {
+			return errors.New(commandsUsage)
+		}
+		data, err := os.ReadFile(args[3])
+		if err != nil {
+			return fmt.Errorf("can not read key file: %w", err)
+		}
+		ring, err := middleware.LoadKeyRing(conf.JWTKeysDir)
+		if errors.Is(err, os.ErrNotExist) {
+			if _, err := middleware.CreateKeyRing(conf.JWTKeysDir, args[1], args[2], data); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "created key ring with active key %s\n", args[1])
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := ring.ImportKey(args[1], args[2], data, time.Now()); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "added verify-only key %s\n", args[1])
+		return ring.Save()
+	}
+
+	ring, err := middleware.LoadKeyRing(conf.JWTKeysDir)
+	if err != nil {
+		return err
+	}
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		for _, info := range ring.Info() {
+			status := "verify-only"
+			if info.Active {
+				status = "active"
+			}
+			if info.RetireAt != nil {
+				status += ", retire after " + info.RetireAt.Format(time.RFC3339)
+			}
+			if !info.Active && info.PublishedAt != nil && time.Since(*info.PublishedAt) < middleware.MinPublishTime {
+				status += ", promote after " + info.PublishedAt.Add(middleware.MinPublishTime).Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%s\t%s\t%s\n", info.KeyID, info.Alg, status)
+		}
+		return nil
+	case args[0] == "promote" && len(args) == 2:
+		if err := ring.Promote(args[1], time.Now()); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "promoted key %s\n", args[1])
+		return ring.Save()
+	case args[0] == "retire" && len(args) == 1:
+		for _, kid := range ring.RetireExpired(time.Now()) {
+			fmt.Fprintf(out, "retired key %s\n", kid)
+		}
+		return ring.Save()
+	case args[0] == "retire" && len(args) == 2:
+		if err := ring.Retire(args[1], time.Now()); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "retired key %s\n", args[1])
+		return ring.Save()
+	default:
+		return errors.New(commandsUsage)
+	}
+}
+
+// runBreakGlassCommand revokes every issued token through the database
+// Running instances pick up the cut-off within NotBeforeReloadInterval
+// The file storage lives in the server's memory, so it can not be changed from another process
+func runBreakGlassCommand(conf *config.Config, args []string, out io.Writer) error {
+	if conf.DBDSN == "" {
+		return errors.New("break-glass requires DB_DSN; use POST /api/admin/break-glass with file storage")
+	}
+	store, err := storage.NewStorage(conf)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	defer store.CloseStorage(ctx)
+
+	actor := "cli"
+	if user := os.Getenv("USER"); user != "" {
+		actor += " " + user
+	}
+	notBefore, err := authservice.NewAuthService(store).BreakGlass(ctx, actor, strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "revoked every token issued before %s\n", notBefore.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/cmd/auth/config"
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+)
+
+func TestRunKeysCommand(t *testing.T) {
+	conf := config.NewConfig()
+	conf.JWTKeysDir = t.TempDir()
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("rotation-secret"), 0600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+
+	steps := [][]string{
+		{"keys", "add", "k1", "HS256", secretFile},
+		{"keys", "add", "k2", "HS256", secretFile},
+	}
+	for _, args := range steps {
+		if err := runCommand(conf, args, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+
+	// A new key is only promoted once verifiers caching the JWK set have had time to fetch it
+	var out bytes.Buffer
+	if err := runCommand(conf, []string{"keys", "promote", "k2"}, &bytes.Buffer{}); !errors.Is(err, middleware.ErrKeyNotPublished) {
+		t.Fatalf("expected ErrKeyNotPublished, got %v", err)
+	}
+	if err := runCommand(conf, []string{"keys", "list"}, &out); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if !strings.Contains(out.String(), "k2\tHS256\tverify-only, promote after") {
+		t.Errorf("unexpected list output:\n%s", out.String())
+	}
+	manifestPath := filepath.Join(conf.JWTKeysDir, "keyring.json")
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	published, _ := time.Now().Add(-middleware.MinPublishTime).MarshalJSON()
+	data = regexp.MustCompile(`"published_at": "[^"]+"`).ReplaceAll(data, []byte(`"published_at": `+string(published)))
+	if err := os.WriteFile(manifestPath, data, 0600); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	if err := runCommand(conf, []string{"keys", "promote", "k2"}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+
+	out.Reset()
+	if err := runCommand(conf, []string{"keys", "list"}, &out); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if !strings.Contains(out.String(), "k2\tHS256\tactive") || !strings.Contains(out.String(), "k1\tHS256\tverify-only, retire after") {
+		t.Errorf("unexpected list output:\n%s", out.String())
+	}
+
+	if err := runCommand(conf, []string{"keys", "retire", "k1"}, &bytes.Buffer{}); err == nil {
+		t.Error("expected error retiring a key that may still verify tokens")
+	}
+	if err := runCommand(conf, []string{"unknown"}, &bytes.Buffer{}); err == nil {
+		t.Error("expected error for unknown command")
+	}
+}
+
+func TestRunBreakGlassCommand_RequiresDatabase(t *testing.T) {
+	conf := config.NewConfig()
+	conf.DBDSN = ""
+	if err := runCommand(conf, []string{"break-glass", "key", "leaked"}, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "DB_DSN") {
+		t.Errorf("expected error without a database, got %v", err)
+	}
+}
@@ -2,11 +2,14 @@
 package config
 
 import (
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v10"
 )
@@ -23,6 +26,30 @@ const (
 
 	// defaultMigrationsPath is default path to SQL migrations
 	defaultMigrationsPath = "./migrations"
+
+	// defaultJWTAlgorithm is the default JWT signing algorithm
+	defaultJWTAlgorithm = "HS256"
+
+	// defaultJWTLeeway is the default allowed clock skew for token validation
+	defaultJWTLeeway = 30 * time.Second
+
+	// defaultRefreshTokenTTL is the default refresh token lifetime in hours
+	defaultRefreshTokenTTL = 720
+
+	// defaultMFAIssuer is the default service name shown in authenticator apps
+	defaultMFAIssuer = "auth-service"
+
+	// mfaKeySize is the AES-256 key size for TOTP secret encryption
+	mfaKeySize = 32
+
+	// defaultWebAuthnRPName is the default service name shown by passkey prompts
+	defaultWebAuthnRPName = "auth-service"
+
+	// defaultStepUpMaxAge is the default age after which sensitive changes require signing in again
+	defaultStepUpMaxAge = 15 * time.Minute
+
+	// defaultLoginLinkLandingPath is the default page login links redirect to
+	defaultLoginLinkLandingPath = "/"
 )
 
 // Config structure for storing application configuration
@@ -42,22 +69,105 @@ type Config struct {
 	// JWTSecret is the secret key for signing JWTs
 	JWTSecret string `env:"JWT_SECRET"`
 
+	// JWTAlgorithm is the JWT signing algorithm (HS256, RS256, ES256, EdDSA, ...)
+	JWTAlgorithm string `env:"JWT_ALGORITHM"`
+
+	// JWTPrivateKeyFile is the path to the PEM private key for asymmetric algorithms
+	JWTPrivateKeyFile string `env:"JWT_PRIVATE_KEY_FILE"`
+
+	// JWTKeyID is the kid header value (defaults to the key thumbprint)
+	JWTKeyID string `env:"JWT_KEY_ID"`
+
+	// JWTIssuer is the iss claim value (defaults to the base URL)
+	JWTIssuer string `env:"JWT_ISSUER"`
+
+	// JWTAudience is the list of aud claim values accepted and issued
+	JWTAudience []string `env:"JWT_AUDIENCE" envSeparator:","`
+
+	// JWTLeeway is the allowed clock skew for exp, nbf and iat
+	JWTLeeway time.Duration `env:"JWT_LEEWAY"`
+
+	// JWTKeysDir is the key ring directory; overrides the single key settings when set
+	JWTKeysDir string `env:"JWT_KEYS_DIR"`
+
+	// OAuthLoginURL is the login page unauthenticated users are sent to from /oauth/authorize
+	OAuthLoginURL string `env:"OAUTH_LOGIN_URL"`
+
+	// AdminToken is the bearer token for administrative endpoints (disabled when empty)
+	AdminToken string `env:"ADMIN_TOKEN"`
+
 	// RunMigrations toggles running migrations on startup
 	RunMigrations bool `env:"RUN_MIGRATIONS"`
 
 	// MigrationsPath is the path to migration files
 	MigrationsPath string `env:"MIGRATIONS_PATH"`
+
+	// RefreshTokenTTL is the refresh token lifetime in hours
+	RefreshTokenTTL int `env:"REFRESH_TOKEN_TTL"`
+
+	// RefreshTokenCookie toggles sending refresh tokens as HttpOnly cookies
+	RefreshTokenCookie bool `env:"REFRESH_TOKEN_COOKIE"`
+
+	// SMTPAddr is the SMTP server host:port for outgoing email (email features are disabled when empty)
+	SMTPAddr string `env:"SMTP_ADDR"`
+
+	// SMTPUsername is the SMTP user name (authentication is skipped when empty)
+	SMTPUsername string `env:"SMTP_USERNAME"`
+
+	// SMTPPassword is the SMTP password
+	SMTPPassword string `env:"SMTP_PASSWORD"`
+
+	// MailFrom is the sender address of outgoing email
+	MailFrom string `env:"MAIL_FROM"`
+
+	// PasswordResetURL is the page password reset links point to (defaults to <base URL>/reset-password)
+	PasswordResetURL string `env:"PASSWORD_RESET_URL"`
+
+	// EmailVerificationURL is the page email verification links point to (defaults to <base URL>/verify-email)
+	EmailVerificationURL string `env:"EMAIL_VERIFICATION_URL"`
+
+	// RequireVerifiedEmail blocks login until the user's email is verified
+	RequireVerifiedEmail bool `env:"REQUIRE_VERIFIED_EMAIL"`
+
+	// MFAEncryptionKey is the base64 AES-256 key encrypting TOTP secrets (MFA enrollment is disabled when empty)
+	MFAEncryptionKey string `env:"MFA_ENCRYPTION_KEY"`
+
+	// MFAIssuer is the service name shown in authenticator apps
+	MFAIssuer string `env:"MFA_ISSUER"`
+
+	// WebAuthnRPID is the passkey relying party ID (defaults to the host of the base URL)
+	WebAuthnRPID string `env:"WEBAUTHN_RP_ID"`
+
+	// WebAuthnRPName is the service name shown by passkey prompts
+	WebAuthnRPName string `env:"WEBAUTHN_RP_NAME"`
+
+	// WebAuthnOrigins are the origins passkey ceremonies may come from (defaults to the origin of the base URL)
+	WebAuthnOrigins []string `env:"WEBAUTHN_ORIGINS"`
+
+	// StepUpMaxAge is how recently the user must have signed in for sensitive account changes:
+	// email, password, passkeys, disabling TOTP and ending every session (zero disables the check)
+	StepUpMaxAge time.Duration `env:"STEP_UP_MAX_AGE"`
+
+	// LoginLinkLandingPath is the page, relative to the base URL, login links redirect to once the session is set
+	LoginLinkLandingPath string `env:"LOGIN_LINK_LANDING_PATH"`
 }
 
 // NewConfig creates a new configuration instance with default values
 // Returns a pointer to Config
 func NewConfig() *Config {
 	return &Config{
-		ServerAddress:   defaultServerAddress,
-		ResponseAddress: defaultResponseAddress,
-		FileStorePath:   filepath.Join(os.TempDir(), "short-url-db.json"),
-		DBDSN:           defaultDBDSN,
-		MigrationsPath:  defaultMigrationsPath,
+		ServerAddress:        defaultServerAddress,
+		ResponseAddress:      defaultResponseAddress,
+		FileStorePath:        filepath.Join(os.TempDir(), "short-url-db.json"),
+		DBDSN:                defaultDBDSN,
+		MigrationsPath:       defaultMigrationsPath,
+		JWTAlgorithm:         defaultJWTAlgorithm,
+		JWTLeeway:            defaultJWTLeeway,
+		RefreshTokenTTL:      defaultRefreshTokenTTL,
+		MFAIssuer:            defaultMFAIssuer,
+		WebAuthnRPName:       defaultWebAuthnRPName,
+		StepUpMaxAge:         defaultStepUpMaxAge,
+		LoginLinkLandingPath: defaultLoginLinkLandingPath,
 	}
 }
 
@@ -119,6 +229,47 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid response address format: %w", err)
 	}
 
+	// Check signing key for asymmetric algorithms
+	if c.JWTKeysDir == "" && c.JWTAlgorithm != defaultJWTAlgorithm && c.JWTPrivateKeyFile == "" {
+		return fmt.Errorf("private key file is required for %s", c.JWTAlgorithm)
+	}
+
+	// Check refresh token lifetime
+	if c.RefreshTokenTTL <= 0 {
+		return fmt.Errorf("refresh token TTL must be positive")
+	}
+
+	// Check step-up authentication age
+	if c.StepUpMaxAge < 0 {
+		return fmt.Errorf("step-up max age must not be negative")
+	}
+
+	// Check login link landing page; it must stay on the base URL, so links can not redirect elsewhere
+	if !strings.HasPrefix(c.LoginLinkLandingPath, "/") || strings.HasPrefix(c.LoginLinkLandingPath, "//") ||
+		strings.Contains(c.LoginLinkLandingPath, "\\") {
+		return fmt.Errorf("login link landing path must be a path starting with a single slash")
+	}
+
+	// Check mail settings
+	if c.SMTPAddr != "" && c.MailFrom == "" {
+		return fmt.Errorf("mail sender address is required when SMTP is configured")
+	}
+	if c.RequireVerifiedEmail && c.SMTPAddr == "" {
+		return fmt.Errorf("SMTP is required to verify emails")
+	}
+
+	// Check MFA key
+	if c.MFAEncryptionKey != "" {
+		if _, err := c.MFAKey(); err != nil {
+			return err
+		}
+	}
+
+	// Check passkey relying party
+	if _, _, err := c.WebAuthn(); err != nil {
+		return err
+	}
+
 	// Check storage file path (if file storage is used)
 	if c.DBDSN == "" && c.FileStorePath == "" {
 		return fmt.Errorf("either database DSN or file storage path must be provided")
@@ -126,3 +277,47 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+// MFAKey decodes the TOTP secret encryption key; nil if it is not set
+func (c *Config) MFAKey() ([]byte, error) {
+	if c.MFAEncryptionKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(c.MFAEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MFA encryption key: %w", err)
+	}
+	if len(key) != mfaKeySize {
+		return nil, fmt.Errorf("MFA encryption key must be %d bytes", mfaKeySize)
+	}
+	return key, nil
+}
+
+// WebAuthn returns the passkey relying party ID and the accepted origins, applying the base URL defaults
+// The host of every origin must be the RP ID or a subdomain of it
+func (c *Config) WebAuthn() (string, []string, error) {
+	base, err := url.Parse(c.ResponseAddress)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid response address format: %w", err)
+	}
+	rpID := c.WebAuthnRPID
+	if rpID == "" {
+		rpID = base.Hostname()
+	}
+	origins := c.WebAuthnOrigins
+	if len(origins) == 0 {
+		origins = []string{base.Scheme + "://" + base.Host}
+	}
+
+	for _, origin := range origins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" || u.Path != "" {
+			return "", nil, fmt.Errorf("invalid WebAuthn origin %q", origin)
+		}
+		host := u.Hostname()
+		if host != rpID && !strings.HasSuffix(host, "."+rpID) {
+			return "", nil, fmt.Errorf("WebAuthn origin %q does not belong to RP ID %q", origin, rpID)
+		}
+	}
+	return rpID, origins, nil
+}
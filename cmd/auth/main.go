@@ -4,19 +4,26 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/vitalykrupin/auth-service/cmd/auth/config"
+	"github.com/vitalykrupin/auth-service/internal/app/admin"
 	"github.com/vitalykrupin/auth-service/internal/app/auth"
 	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
 	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/mail"
+	"github.com/vitalykrupin/auth-service/internal/app/oauth"
 	"github.com/vitalykrupin/auth-service/internal/app/storage"
+	"github.com/vitalykrupin/auth-service/internal/app/webauthn"
 	"go.uber.org/zap"
 )
 
@@ -28,6 +35,15 @@ const (
 
 	// ServerTimeout is the timeout for reading and writing HTTP requests
 	ServerTimeout = 10 * time.Second
+
+	// KeyRingReloadInterval is how often the key ring directory is re-read
+	KeyRingReloadInterval = time.Minute
+
+	// CleanupInterval is how often expired refresh tokens and denylist entries are deleted
+	CleanupInterval = time.Hour
+
+	// NotBeforeReloadInterval is how often the global token cut-off is re-read from storage
+	NotBeforeReloadInterval = 30 * time.Second
 )
 
 // main is the entry point of the authentication service
@@ -57,6 +73,11 @@ func run(logger *zap.SugaredLogger) error {
 		return err
 	}
 
+	// Run an administrative command instead of the server if one was given
+	if args := flag.Args(); len(args) > 0 {
+		return runCommand(conf, args, os.Stdout)
+	}
+
 	// Create storage
 	store, err := storage.NewStorage(conf)
 	if err != nil {
@@ -77,16 +98,87 @@ func run(logger *zap.SugaredLogger) error {
 		}
 	}()
 
-	// Create auth service
-	authSvc := authservice.NewAuthService(store)
-
-	// Refresh token TTL (hours) from env, default 720h
-	refreshTTL := 720 * time.Hour
-	if ttlStr := os.Getenv("REFRESH_TOKEN_TTL"); ttlStr != "" {
-		if ttlParsed, err := time.ParseDuration(ttlStr + "h"); err == nil {
-			refreshTTL = ttlParsed
+	// Configure JWT signing keys
+	var keyRing *middleware.KeyRing
+	if conf.JWTKeysDir != "" {
+		keyRing, err = middleware.LoadKeyRing(conf.JWTKeysDir)
+		if err != nil {
+			logger.Errorw("Failed to load key ring", "error", err)
+			return err
 		}
+		middleware.SetKeyRing(keyRing)
+		go reloadKeyRing(keyRing, logger)
+	} else {
+		signingKey, err := newSigningKey(conf)
+		if err != nil {
+			logger.Errorw("Failed to load signing key", "error", err)
+			return err
+		}
+		if signingKey != nil {
+			middleware.SetSigningKey(signingKey)
+		}
+	}
+
+	// Configure registered claims
+	issuer := conf.JWTIssuer
+	if issuer == "" {
+		issuer = conf.ResponseAddress
+	}
+	middleware.SetTokenSettings(middleware.TokenSettings{
+		Issuer:   issuer,
+		Audience: conf.JWTAudience,
+		Leeway:   conf.JWTLeeway,
+	})
+
+	// Reject revoked access tokens and drop expired revocation records
+	middleware.SetRevocationList(store)
+	go cleanupExpiredTokens(store, logger)
+
+	// Reject access tokens issued before the user's token version was bumped
+	middleware.SetTokenVersionSource(store, middleware.DefaultTokenVersionCacheTTL)
+
+	// Reject every token issued before the global cut-off ("break glass")
+	if err := loadNotBefore(store); err != nil {
+		logger.Errorw("Failed to load token cut-off", "error", err)
+		return err
 	}
+	go reloadNotBefore(store, logger)
+
+	// Create auth service
+	authOpts := []authservice.Option{
+		authservice.WithRefreshTTL(time.Duration(conf.RefreshTokenTTL) * time.Hour),
+		authservice.WithSecurityEvents(func(ctx context.Context, event authservice.SecurityEvent) {
+			logger.Warnw("Security event", "type", event.Type, "user_id", event.UserID, "family_id", event.FamilyID)
+		}),
+	}
+	if conf.SMTPAddr != "" {
+		authOpts = append(authOpts, authservice.WithMailSender(mail.NewSMTPSender(conf.SMTPAddr, conf.MailFrom, conf.SMTPUsername, conf.SMTPPassword)))
+	}
+	authOpts = append(authOpts, authservice.WithRequireVerifiedEmail(conf.RequireVerifiedEmail))
+	mfaKey, err := conf.MFAKey()
+	if err != nil {
+		logger.Errorw("Failed to load MFA key", "error", err)
+		return err
+	}
+	if mfaKey != nil {
+		authOpts = append(authOpts, authservice.WithMFAKey(mfaKey))
+	}
+	rpID, origins, err := conf.WebAuthn()
+	if err != nil {
+		logger.Errorw("Failed to load WebAuthn settings", "error", err)
+		return err
+	}
+	authOpts = append(authOpts, authservice.WithWebAuthn(webauthn.NewRelyingParty(rpID, conf.WebAuthnRPName, origins)))
+	authSvc := authservice.NewAuthService(store, authOpts...)
+	// Sensitive account changes require a recent login
+	stepUp := middleware.AuthPolicy{MaxAge: conf.StepUpMaxAge}
+	handlerOpts := []auth.Option{
+		auth.WithRefreshCookie(conf.RefreshTokenCookie),
+		auth.WithStepUpPolicy(stepUp),
+	}
+
+	// Stamp the email_verified claim into user tokens
+	middleware.SetEmailVerificationSource(authSvc)
 
 	// Create mux router
 	mux := http.NewServeMux()
@@ -97,22 +189,124 @@ func run(logger *zap.SugaredLogger) error {
 		_, _ = w.Write([]byte("ok"))
 	})
 
+	// Public verification keys and discovery
+	mux.Handle("/.well-known/jwks.json", middleware.JWKSHandler())
+	mux.Handle("/.well-known/openid-configuration", auth.NewDiscoveryHandler(auth.Discovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             endpointURL(conf, "/oauth/authorize"),
+		TokenEndpoint:                     endpointURL(conf, "/oauth/token"),
+		DeviceAuthorizationEndpoint:       endpointURL(conf, "/oauth/device_authorization"),
+		IntrospectionEndpoint:             endpointURL(conf, "/oauth/introspect"),
+		RevocationEndpoint:                endpointURL(conf, "/oauth/revoke"),
+		JWKSURI:                           endpointURL(conf, "/.well-known/jwks.json"),
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{oauth.GrantAuthorizationCode, oauth.GrantRefreshToken, oauth.GrantClientCredentials, oauth.GrantDeviceCode},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+	}))
+
+	// OAuth 2.0 endpoints
+	clients := oauth.NewClientRegistry(store)
+	mux.Handle("/oauth/authorize", oauth.NewAuthorizeHandler(store, clients, conf.OAuthLoginURL))
+	mux.Handle("/oauth/token", oauth.NewTokenHandler(store, clients, authSvc))
+	mux.Handle("/oauth/device_authorization", oauth.NewDeviceAuthorizationHandler(store, clients, endpointURL(conf, "/oauth/device")))
+	mux.Handle("/oauth/device", oauth.NewDeviceVerificationHandler(store, clients, conf.OAuthLoginURL))
+	mux.Handle("/oauth/introspect", oauth.NewIntrospectHandler(store, clients))
+	mux.Handle("/oauth/revoke", oauth.NewRevokeHandler(store, clients, authSvc))
+
+	// Admin OAuth client management
+	clientsHandler := admin.NewClientsHandler(clients)
+	mux.Handle("POST /api/admin/clients", middleware.AdminMiddleware(conf.AdminToken, http.HandlerFunc(clientsHandler.Create)))
+	mux.Handle("GET /api/admin/clients/{id}", middleware.AdminMiddleware(conf.AdminToken, http.HandlerFunc(clientsHandler.Get)))
+	mux.Handle("PUT /api/admin/clients/{id}", middleware.AdminMiddleware(conf.AdminToken, http.HandlerFunc(clientsHandler.Update)))
+	mux.Handle("POST /api/admin/clients/{id}/secret", middleware.AdminMiddleware(conf.AdminToken, http.HandlerFunc(clientsHandler.RotateSecret)))
+
+	// Admin emergency revocation of every token
+	mux.Handle("POST /api/admin/break-glass", middleware.AdminMiddleware(conf.AdminToken, admin.NewBreakGlassHandler(authSvc)))
+
+	// Admin user management
+	usersHandler := admin.NewUsersHandler(authSvc)
+	mux.Handle("POST /api/admin/users/{id}/logout", middleware.AdminMiddleware(conf.AdminToken, http.HandlerFunc(usersHandler.Logout)))
+
+	// Admin key ring management
+	if keyRing != nil {
+		keysHandler := admin.NewKeysHandler(keyRing)
+		mux.Handle("/api/admin/keys", middleware.AdminMiddleware(conf.AdminToken, http.HandlerFunc(keysHandler.List)))
+		mux.Handle("/api/admin/keys/promote", middleware.AdminMiddleware(conf.AdminToken, http.HandlerFunc(keysHandler.Promote)))
+		mux.Handle("/api/admin/keys/retire", middleware.AdminMiddleware(conf.AdminToken, http.HandlerFunc(keysHandler.Retire)))
+	}
+
+	// Email verification
+	if conf.SMTPAddr != "" {
+		verifyURL := conf.EmailVerificationURL
+		if verifyURL == "" {
+			verifyURL = endpointURL(conf, "/verify-email")
+		}
+		handlerOpts = append(handlerOpts, auth.WithEmailVerificationURL(verifyURL))
+		emailHandler := auth.NewEmailHandler(authSvc, verifyURL)
+		mux.HandleFunc("POST /api/auth/email/verify", emailHandler.Verify)
+		mux.Handle("POST /api/auth/email/verify/resend", middleware.JWTMiddleware(http.HandlerFunc(emailHandler.Resend)))
+	}
+
 	// Register routes
-	mux.Handle("/api/auth/register", auth.NewRegisterHandler(store, authSvc))
-	mux.Handle("/api/auth/login", auth.NewLoginHandler(store, authSvc))
-
-	// Protected profile endpoint (returns JSON)
-	mux.Handle("/api/auth/profile", middleware.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userID := r.Context().Value(middleware.UserIDKey)
-		if userID == nil {
-			http.Error(w, "User not found in context", http.StatusInternalServerError)
-			return
+	mux.Handle("/api/auth/register", auth.NewRegisterHandler(store, authSvc, handlerOpts...))
+	loginHandler := auth.NewLoginHandler(store, authSvc, handlerOpts...)
+	mux.Handle("/api/auth/login", loginHandler)
+	mux.HandleFunc("POST /api/auth/login/mfa", loginHandler.MFA)
+	mux.HandleFunc("POST /api/auth/login/passkey/options", loginHandler.PasskeyOptions)
+	mux.HandleFunc("POST /api/auth/login/passkey", loginHandler.Passkey)
+
+	// Second factor management for the logged in user
+	if mfaKey != nil {
+		mfaHandler := auth.NewMFAHandler(authSvc, conf.MFAIssuer)
+		mux.Handle("GET /api/auth/mfa", middleware.JWTMiddleware(http.HandlerFunc(mfaHandler.Status)))
+		mux.Handle("POST /api/auth/mfa/totp", middleware.JWTMiddleware(http.HandlerFunc(mfaHandler.EnrollTOTP)))
+		mux.Handle("POST /api/auth/mfa/totp/confirm", middleware.JWTMiddleware(http.HandlerFunc(mfaHandler.ConfirmTOTP)))
+		mux.Handle("DELETE /api/auth/mfa/totp", middleware.StepUpMiddleware(stepUp, http.HandlerFunc(mfaHandler.DisableTOTP)))
+	}
+
+	// Passkeys of the logged in user; a passkey signs in without password or second factor,
+	// so adding or removing one requires a recent login
+	passkeyHandler := auth.NewPasskeyHandler(authSvc)
+	mux.Handle("GET /api/auth/passkeys", middleware.JWTMiddleware(http.HandlerFunc(passkeyHandler.List)))
+	mux.Handle("POST /api/auth/passkeys/options", middleware.StepUpMiddleware(stepUp, http.HandlerFunc(passkeyHandler.RegisterOptions)))
+	mux.Handle("POST /api/auth/passkeys", middleware.StepUpMiddleware(stepUp, http.HandlerFunc(passkeyHandler.Register)))
+	mux.Handle("DELETE /api/auth/passkeys/{id}", middleware.StepUpMiddleware(stepUp, http.HandlerFunc(passkeyHandler.Delete)))
+
+	// Profile of the logged in user
+	profileHandler := auth.NewProfileHandler(authSvc, handlerOpts...)
+	mux.Handle("GET /api/auth/profile", middleware.JWTMiddleware(http.HandlerFunc(profileHandler.Get)))
+	mux.Handle("PATCH /api/auth/profile", middleware.JWTMiddleware(http.HandlerFunc(profileHandler.Update)))
+
+	// Password change for the logged in user
+	mux.Handle("/api/auth/password", middleware.StepUpMiddleware(stepUp, auth.NewPasswordHandler(authSvc)))
+
+	// Self-service password reset via emailed link
+	if conf.SMTPAddr != "" {
+		resetURL := conf.PasswordResetURL
+		if resetURL == "" {
+			resetURL = endpointURL(conf, "/reset-password")
 		}
-		email, _ := store.GetUserProfile(r.Context(), userID.(string))
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("{\"user_id\":\"" + userID.(string) + "\",\"email\":\"" + email + "\"}"))
-	})))
+		resetHandler := auth.NewPasswordResetHandler(authSvc, resetURL)
+		mux.HandleFunc("POST /api/auth/password/reset", resetHandler.Request)
+		mux.HandleFunc("POST /api/auth/password/reset/confirm", resetHandler.Confirm)
+
+		// Passwordless login via emailed one-time code
+		mux.HandleFunc("POST /api/auth/login/email", loginHandler.EmailCode)
+		mux.HandleFunc("POST /api/auth/login/email/verify", loginHandler.EmailCodeLogin)
+
+		// Passwordless login via emailed single-use link, bound to the requesting browser
+		loginLinkHandler := auth.NewLoginLinkHandler(loginHandler,
+			endpointURL(conf, "/api/auth/login/link/verify"), endpointURL(conf, conf.LoginLinkLandingPath))
+		mux.HandleFunc("POST /api/auth/login/link", loginLinkHandler.Request)
+		mux.HandleFunc("GET /api/auth/login/link/verify", loginLinkHandler.Verify)
+	}
+
+	// Session management for the logged in user
+	sessionsHandler := auth.NewSessionsHandler(authSvc)
+	mux.Handle("GET /api/auth/sessions", middleware.JWTMiddleware(http.HandlerFunc(sessionsHandler.List)))
+	mux.Handle("DELETE /api/auth/sessions", middleware.StepUpMiddleware(stepUp, http.HandlerFunc(sessionsHandler.RevokeAll)))
+	mux.Handle("DELETE /api/auth/sessions/{id}", middleware.JWTMiddleware(http.HandlerFunc(sessionsHandler.Revoke)))
 
 	// Token refresh endpoint
 	mux.Handle("/api/auth/token/refresh", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -128,23 +322,36 @@ func run(logger *zap.SugaredLogger) error {
 			RefreshToken string `json:"refresh_token"`
 		}
 		var req refreshReq
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		if !decodeRefreshToken(r, &req.RefreshToken) {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
-		userID, expiresAt, revoked, err := store.GetRefreshToken(r.Context(), req.RefreshToken)
-		if err != nil || revoked || time.Now().After(expiresAt) {
+		userID, newRT, newExpiresAt, err := authSvc.RefreshSession(r.Context(), req.RefreshToken, authservice.ClientInfoFromRequest(r, ""))
+		if errors.Is(err, authservice.ErrInvalidRefreshToken) {
 			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
 			return
 		}
-		_ = store.RevokeRefreshToken(r.Context(), req.RefreshToken)
-		newRT := uuid.New().String()
-		_ = store.CreateRefreshToken(r.Context(), newRT, userID, time.Now().Add(refreshTTL))
-		token, err := middleware.GenerateToken(userID)
 		if err != nil {
+			logger.Errorw("Failed to refresh session", "error", err)
+			http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+			return
+		}
+		// The new access token keeps how and when the user signed in to the session
+		authn, err := authSvc.SessionAuthentication(r.Context(), newRT)
+		if err != nil {
+			logger.Errorw("Failed to read session authentication", "error", err)
+			http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+			return
+		}
+		token, err := middleware.GenerateAuthenticatedToken(userID, authn)
+		if err != nil {
+			logger.Errorw("Failed to generate token", "error", err)
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
 		}
+		if conf.RefreshTokenCookie {
+			auth.SetRefreshCookie(w, newRT, newExpiresAt)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(refreshResp{Token: token, RefreshToken: newRT})
@@ -156,14 +363,29 @@ func run(logger *zap.SugaredLogger) error {
 			http.Error(w, "Only POST requests are allowed!", http.StatusMethodNotAllowed)
 			return
 		}
-		var req struct {
-			RefreshToken string `json:"refresh_token"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		var refreshToken string
+		if !decodeRefreshToken(r, &refreshToken) {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
-		_ = store.RevokeRefreshToken(r.Context(), req.RefreshToken)
+		if err := authSvc.RevokeRefreshToken(r.Context(), refreshToken); err != nil {
+			logger.Errorw("Failed to revoke refresh token", "error", err)
+			http.Error(w, "Failed to logout", http.StatusInternalServerError)
+			return
+		}
+		// Also revoke the access token the request was made with, if any
+		if tokenString, err := middleware.TokenFromRequest(r); err == nil {
+			if claims, err := middleware.ParseToken(tokenString); err == nil {
+				if err := authSvc.RevokeAccessToken(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+					logger.Errorw("Failed to revoke access token", "error", err)
+					http.Error(w, "Failed to logout", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		if conf.RefreshTokenCookie {
+			auth.ClearRefreshCookie(w)
+		}
 		w.WriteHeader(http.StatusNoContent)
 	}))
 
@@ -208,3 +430,106 @@ func run(logger *zap.SugaredLogger) error {
 	logger.Info("Server shutdown completed")
 	return nil
 }
+
+// cleanupExpiredTokens periodically deletes expired refresh tokens and access token denylist entries
+func cleanupExpiredTokens(store storage.Storage, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(CleanupInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), ServerTimeout)
+		if err := store.DeleteExpiredRefreshTokens(ctx); err != nil {
+			logger.Errorw("Failed to delete expired refresh tokens", "error", err)
+		}
+		if err := store.DeleteExpiredRevokedAccessTokens(ctx); err != nil {
+			logger.Errorw("Failed to delete expired revoked access tokens", "error", err)
+		}
+		if err := store.DeleteExpiredMFAChallenges(ctx); err != nil {
+			logger.Errorw("Failed to delete expired MFA challenges", "error", err)
+		}
+		if err := store.DeleteExpiredWebAuthnChallenges(ctx); err != nil {
+			logger.Errorw("Failed to delete expired WebAuthn challenges", "error", err)
+		}
+		if err := store.DeleteExpiredEmailVerificationTokens(ctx); err != nil {
+			logger.Errorw("Failed to delete expired email verification tokens", "error", err)
+		}
+		if err := store.DeleteExpiredPasswordResetTokens(ctx); err != nil {
+			logger.Errorw("Failed to delete expired password reset tokens", "error", err)
+		}
+		if err := store.DeleteExpiredEmailLoginCodes(ctx); err != nil {
+			logger.Errorw("Failed to delete expired email login codes", "error", err)
+		}
+		if err := store.DeleteExpiredLoginLinks(ctx); err != nil {
+			logger.Errorw("Failed to delete expired login links", "error", err)
+		}
+		cancel()
+	}
+}
+
+// reloadKeyRing periodically re-reads the key ring so CLI changes reach running instances
+func reloadKeyRing(ring *middleware.KeyRing, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(KeyRingReloadInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := ring.Reload(); err != nil {
+			logger.Errorw("Failed to reload key ring", "error", err)
+		}
+	}
+}
+
+// loadNotBefore reads the global token cut-off from storage into the JWT middleware
+func loadNotBefore(store storage.Storage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ServerTimeout)
+	defer cancel()
+	notBefore, err := store.GetGlobalNotBefore(ctx)
+	if err != nil {
+		return err
+	}
+	middleware.SetNotBefore(notBefore)
+	return nil
+}
+
+// reloadNotBefore periodically re-reads the global token cut-off so CLI changes reach running instances
+func reloadNotBefore(store storage.Storage, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(NotBeforeReloadInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := loadNotBefore(store); err != nil {
+			logger.Errorw("Failed to reload token cut-off", "error", err)
+		}
+	}
+}
+
+// endpointURL returns the absolute URL of an endpoint under the base URL
+func endpointURL(conf *config.Config, path string) string {
+	return strings.TrimSuffix(conf.ResponseAddress, "/") + path
+}
+
+// newSigningKey builds the JWT signing key from configuration
+// Returns nil for HS256 without an explicit secret to keep the JWT_SECRET fallback
+func newSigningKey(conf *config.Config) (*middleware.SigningKey, error) {
+	if conf.JWTAlgorithm == "HS256" {
+		if conf.JWTSecret == "" {
+			return nil, nil
+		}
+		return middleware.NewHMACKey(conf.JWTKeyID, []byte(conf.JWTSecret)), nil
+	}
+	return middleware.LoadSigningKey(conf.JWTKeyID, conf.JWTAlgorithm, conf.JWTPrivateKeyFile)
+}
+
+// decodeRefreshToken reads the refresh token from the JSON body or, if absent, from the refresh cookie
+// Returns false if the request is malformed or carries no token
+func decodeRefreshToken(r *http.Request, token *string) bool {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return false
+	}
+	if req.RefreshToken == "" {
+		if cookie, err := r.Cookie(auth.RefreshCookieName); err == nil {
+			req.RefreshToken = cookie.Value
+		}
+	}
+	*token = req.RefreshToken
+	return *token != ""
+}
@@ -31,17 +31,8 @@ func buildTestMux(t *testing.T) (*http.ServeMux, storage.Storage) {
 	mux := http.NewServeMux()
 	mux.Handle("/api/auth/register", apiauth.NewRegisterHandler(st, authSvc))
 	mux.Handle("/api/auth/login", apiauth.NewLoginHandler(st, authSvc))
-	mux.Handle("/api/auth/profile", middleware.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userID := r.Context().Value(middleware.UserIDKey)
-		if userID == nil {
-			http.Error(w, "User not found in context", http.StatusInternalServerError)
-			return
-		}
-		email, _ := st.GetUserProfile(r.Context(), userID.(string))
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("{\"user_id\":\"" + userID.(string) + "\",\"email\":\"" + email + "\"}"))
-	})))
+	profileHandler := apiauth.NewProfileHandler(authSvc)
+	mux.Handle("GET /api/auth/profile", middleware.JWTMiddleware(http.HandlerFunc(profileHandler.Get)))
 	// refresh
 	refreshTTL := 2 * time.Hour
 	mux.Handle("/api/auth/token/refresh", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -60,18 +51,13 @@ func buildTestMux(t *testing.T) (*http.ServeMux, storage.Storage) {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
-		userID, expiresAt, revoked, err := st.GetRefreshToken(r.Context(), req.RefreshToken)
-		if err != nil || revoked || time.Now().After(expiresAt) {
-			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
-			return
-		}
-		_ = st.RevokeRefreshToken(r.Context(), req.RefreshToken)
 		newRT := uuid.New().String()
-		if err := st.CreateRefreshToken(r.Context(), newRT, userID, time.Now().Add(refreshTTL)); err != nil {
-			http.Error(w, "Failed to create refresh token: "+err.Error(), http.StatusInternalServerError)
+		rt, err := st.RotateRefreshToken(r.Context(), storage.HashToken(req.RefreshToken), &storage.RefreshToken{TokenHash: storage.HashToken(newRT), ExpiresAt: time.Now().Add(refreshTTL)})
+		if err != nil {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
 			return
 		}
-		token, err := middleware.GenerateToken(userID)
+		token, err := middleware.GenerateToken(rt.UserID)
 		if err != nil {
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
@@ -97,7 +83,7 @@ func buildTestMux(t *testing.T) (*http.ServeMux, storage.Storage) {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
-		_ = st.RevokeRefreshToken(r.Context(), req.RefreshToken)
+		_ = st.RevokeRefreshToken(r.Context(), storage.HashToken(req.RefreshToken))
 		w.WriteHeader(http.StatusNoContent)
 	}))
 
@@ -115,9 +101,9 @@ func TestProfileAndRefreshFlow(t *testing.T) {
 	// Prepare user + profile + refresh token
 	userID := uuid.New().String()
 	_ = st.CreateUser(nil, &storage.User{Login: "u", Password: "p", UserID: userID})
-	_ = st.SetUserProfile(nil, userID, "u@example.com")
+	_ = st.SetUserProfile(nil, &storage.Profile{UserID: userID, Email: "u@example.com"})
 	rt := uuid.New().String()
-	_ = st.CreateRefreshToken(nil, rt, userID, time.Now().Add(1*time.Hour))
+	_ = st.CreateRefreshToken(nil, &storage.RefreshToken{TokenHash: storage.HashToken(rt), UserID: userID, FamilyID: rt, ExpiresAt: time.Now().Add(1 * time.Hour)})
 
 	// Refresh
 	resp, err := http.Post(srv.URL+"/api/auth/token/refresh", "application/json", strings.NewReader(`{"refresh_token":"`+rt+`"}`))
@@ -153,3 +139,43 @@ func TestProfileAndRefreshFlow(t *testing.T) {
 	}
 	_ = pr.Body.Close()
 }
+
+func TestRegisterLoginIssueRefreshToken(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test-secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	mux, _ := buildTestMux(t)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var tokens struct {
+		UserID       string `json:"user_id"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	creds := `{"login":"rt-user","password":"secret"}`
+	for _, path := range []string{"/api/auth/register", "/api/auth/login"} {
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(creds))
+		if err != nil {
+			t.Fatalf("%s post: %v", path, err)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		_ = resp.Body.Close()
+		if tokens.Token == "" || tokens.RefreshToken == "" {
+			t.Fatalf("%s returned empty tokens: token=%q refresh=%q", path, tokens.Token, tokens.RefreshToken)
+		}
+	}
+
+	// The refresh token from login must be usable on the refresh endpoint
+	resp, err := http.Post(srv.URL+"/api/auth/token/refresh", "application/json", strings.NewReader(`{"refresh_token":"`+tokens.RefreshToken+`"}`))
+	if err != nil {
+		t.Fatalf("refresh post: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, string(body))
+	}
+}
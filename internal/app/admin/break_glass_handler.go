@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+)
+
+// breakGlassRequest represents the JSON request structure for a global revocation
+type breakGlassRequest struct {
+	Reason string `json:"reason"`
+}
+
+// breakGlassResponse represents the JSON response structure for a global revocation
+type breakGlassResponse struct {
+	NotBefore time.Time `json:"not_before"`
+}
+
+// BreakGlassHandler handles POST requests revoking every issued token
+type BreakGlassHandler struct {
+	authService *authservice.AuthService
+}
+
+// NewBreakGlassHandler is the constructor for BreakGlassHandler
+func NewBreakGlassHandler(authService *authservice.AuthService) *BreakGlassHandler {
+	return &BreakGlassHandler{authService: authService}
+}
+
+// ServeHTTP revokes every access and refresh token issued so far
+func (handler *BreakGlassHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	breakReq := new(breakGlassRequest)
+	if err := json.NewDecoder(req.Body).Decode(breakReq); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	notBefore, err := handler.authService.BreakGlass(req.Context(), "admin-api "+req.RemoteAddr, breakReq.Reason)
+	if err != nil {
+		log.Println("Failed to revoke all tokens", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Println("Revoked all tokens issued before", notBefore)
+	writeJSON(w, http.StatusOK, breakGlassResponse{NotBefore: notBefore})
+}
@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+func TestBreakGlassHandler(t *testing.T) {
+	defer middleware.SetNotBefore(time.Time{})
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	token, err := middleware.GenerateToken("user-1")
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewBreakGlassHandler(authservice.NewAuthService(store)).ServeHTTP(rr,
+		httptest.NewRequest(http.MethodPost, "/api/admin/break-glass", strings.NewReader(`{"reason":"key leaked"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp breakGlassResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.NotBefore.IsZero() {
+		t.Fatalf("expected cut-off in response, got %s", rr.Body.String())
+	}
+	if _, err := middleware.VerifyToken(t.Context(), token); err == nil {
+		t.Error("expected token issued before the cut-off to be rejected")
+	}
+}
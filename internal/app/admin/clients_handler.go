@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/oauth"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// clientRequest represents the JSON request structure for creating or updating a client
+type clientRequest struct {
+	oauth.ClientParams
+	ClientID     string `json:"client_id"`
+	Confidential bool   `json:"confidential"`
+}
+
+// clientResponse represents the JSON response structure for a client
+type clientResponse struct {
+	oauth.ClientParams
+	ClientID     string    `json:"client_id"`
+	Confidential bool      `json:"confidential"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ClientsHandler manages registered OAuth clients
+type ClientsHandler struct {
+	clients *oauth.ClientRegistry
+}
+
+// NewClientsHandler is the constructor for ClientsHandler
+func NewClientsHandler(clients *oauth.ClientRegistry) *ClientsHandler {
+	return &ClientsHandler{clients: clients}
+}
+
+// Create handles POST requests registering a new client
+// The secret of a confidential client is returned only in this response
+func (handler *ClientsHandler) Create(w http.ResponseWriter, req *http.Request) {
+	clientReq := new(clientRequest)
+	if err := json.NewDecoder(req.Body).Decode(clientReq); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	client, secret, err := handler.clients.Register(req.Context(), clientReq.ClientID, clientReq.ClientParams, clientReq.Confidential)
+	if err != nil {
+		log.Println("Failed to register client", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Println("Registered OAuth client", client.ClientID)
+	resp := newClientResponse(client)
+	resp.ClientSecret = secret
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// Get handles GET requests returning a client
+func (handler *ClientsHandler) Get(w http.ResponseWriter, req *http.Request) {
+	client, err := handler.clients.Get(req.Context(), req.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, newClientResponse(client))
+}
+
+// Update handles PUT requests replacing a client's settings
+func (handler *ClientsHandler) Update(w http.ResponseWriter, req *http.Request) {
+	clientReq := new(clientRequest)
+	if err := json.NewDecoder(req.Body).Decode(clientReq); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	clientID := req.PathValue("id")
+	if _, err := handler.clients.Get(req.Context(), clientID); err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	client, err := handler.clients.Update(req.Context(), clientID, clientReq.ClientParams)
+	if err != nil {
+		log.Println("Failed to update client", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, newClientResponse(client))
+}
+
+// RotateSecret handles POST requests replacing a client's secret
+func (handler *ClientsHandler) RotateSecret(w http.ResponseWriter, req *http.Request) {
+	clientID := req.PathValue("id")
+	secret, err := handler.clients.RotateSecret(req.Context(), clientID)
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	client, err := handler.clients.Get(req.Context(), clientID)
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	log.Println("Rotated secret of OAuth client", clientID)
+	resp := newClientResponse(client)
+	resp.ClientSecret = secret
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// newClientResponse builds the response for a client without its secret
+func newClientResponse(client *storage.Client) clientResponse {
+	return clientResponse{
+		ClientParams: oauth.ClientParams{
+			Name:         client.Name,
+			RedirectURIs: client.RedirectURIs,
+			GrantTypes:   client.GrantTypes,
+			Scopes:       client.Scopes,
+		},
+		ClientID:     client.ClientID,
+		Confidential: client.SecretHash != "",
+		CreatedAt:    client.CreatedAt,
+		UpdatedAt:    client.UpdatedAt,
+	}
+}
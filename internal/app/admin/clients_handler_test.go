@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vitalykrupin/auth-service/internal/app/oauth"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+func TestClientsHandler(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	handler := NewClientsHandler(oauth.NewClientRegistry(store))
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/admin/clients", handler.Create)
+	mux.HandleFunc("PUT /api/admin/clients/{id}", handler.Update)
+	mux.HandleFunc("POST /api/admin/clients/{id}/secret", handler.RotateSecret)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/admin/clients", strings.NewReader(
+		`{"client_id":"svc","confidential":true,"redirect_uris":["https://svc.example.com/cb"],"grant_types":["authorization_code"],"scopes":["links:read"]}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created clientResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if created.ClientSecret == "" || !created.Confidential {
+		t.Errorf("expected confidential client with secret, got %+v", created)
+	}
+
+	// Redirect URIs are https, http on a loopback host, or a private-use scheme of a native app
+	for uri, want := range map[string]int{
+		"not a uri":                       http.StatusBadRequest,
+		"http://svc.example.com/cb":       http.StatusBadRequest,
+		"javascript:alert(1)":             http.StatusBadRequest,
+		"data:text/html,hi":               http.StatusBadRequest,
+		"myapp:/cb":                       http.StatusBadRequest,
+		"https:/cb":                       http.StatusBadRequest,
+		"https://svc.example.com/cb":      http.StatusOK,
+		"http://127.0.0.1:8400/cb":        http.StatusOK,
+		"http://[::1]/cb":                 http.StatusOK,
+		"http://localhost/cb":             http.StatusOK,
+		"com.example.app:/oauth/redirect": http.StatusOK,
+	} {
+		body := `{"redirect_uris":["` + uri + `"],"grant_types":["authorization_code"],"scopes":["links:read"]}`
+		rr = httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/api/admin/clients/svc", strings.NewReader(body)))
+		if rr.Code != want {
+			t.Errorf("%s: expected %d, got %d", uri, want, rr.Code)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/admin/clients/svc/secret", nil))
+	var rotated clientResponse
+	if err := json.NewDecoder(rr.Body).Decode(&rotated); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if rr.Code != http.StatusOK || rotated.ClientSecret == "" || rotated.ClientSecret == created.ClientSecret {
+		t.Errorf("expected new secret, got %d %+v", rr.Code, rotated)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/admin/clients/unknown/secret", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown client, got %d", rr.Code)
+	}
+}
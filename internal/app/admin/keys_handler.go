@@ -0,0 +1,119 @@
+// Package admin provides HTTP handlers for administrative operations
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+)
+
+// keyRequest represents the JSON request structure for key operations
+type keyRequest struct {
+	KeyID string `json:"kid"`
+}
+
+// keysResponse represents the JSON response structure for key operations
+type keysResponse struct {
+	Keys    []middleware.KeyInfo `json:"keys"`
+	Retired []string             `json:"retired,omitempty"`
+}
+
+// KeysHandler manages the JWT signing key ring
+type KeysHandler struct {
+	ring *middleware.KeyRing
+}
+
+// NewKeysHandler is the constructor for KeysHandler
+func NewKeysHandler(ring *middleware.KeyRing) *KeysHandler {
+	return &KeysHandler{ring: ring}
+}
+
+// List handles GET requests returning all keys in the ring
+func (handler *KeysHandler) List(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, keysResponse{Keys: handler.ring.Info()})
+}
+
+// Promote handles POST requests making a verify-only key the active signing key
+func (handler *KeysHandler) Promote(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	keyReq := new(keyRequest)
+	if err := json.NewDecoder(req.Body).Decode(keyReq); err != nil || keyReq.KeyID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := handler.ring.Promote(keyReq.KeyID, time.Now()); err != nil {
+		writeKeyError(w, err)
+		return
+	}
+	if err := handler.ring.Save(); err != nil {
+		log.Println("Failed to save key ring", err)
+		http.Error(w, "Failed to save key ring", http.StatusInternalServerError)
+		return
+	}
+	log.Println("Promoted signing key", keyReq.KeyID)
+	writeJSON(w, http.StatusOK, keysResponse{Keys: handler.ring.Info()})
+}
+
+// Retire handles POST requests removing a verify-only key
+// Without a kid every key whose tokens have all expired is retired
+func (handler *KeysHandler) Retire(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	keyReq := new(keyRequest)
+	if err := json.NewDecoder(req.Body).Decode(keyReq); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	var retired []string
+	if keyReq.KeyID == "" {
+		retired = handler.ring.RetireExpired(time.Now())
+	} else {
+		if err := handler.ring.Retire(keyReq.KeyID, time.Now()); err != nil {
+			writeKeyError(w, err)
+			return
+		}
+		retired = []string{keyReq.KeyID}
+	}
+	if err := handler.ring.Save(); err != nil {
+		log.Println("Failed to save key ring", err)
+		http.Error(w, "Failed to save key ring", http.StatusInternalServerError)
+		return
+	}
+	log.Println("Retired signing keys", retired)
+	writeJSON(w, http.StatusOK, keysResponse{Keys: handler.ring.Info(), Retired: retired})
+}
+
+// writeKeyError maps key ring errors to HTTP statuses
+func writeKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, middleware.ErrKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, middleware.ErrKeyActive), errors.Is(err, middleware.ErrKeyInUse),
+		errors.Is(err, middleware.ErrKeyNotPublished):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeJSON writes a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("Can not encode response", err)
+	}
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+)
+
+func TestKeysHandler_Promote(t *testing.T) {
+	dir := t.TempDir()
+	ring, err := middleware.CreateKeyRing(dir, "k1", "HS256", []byte("first-secret"))
+	if err != nil {
+		t.Fatalf("create key ring: %v", err)
+	}
+	if _, err := ring.ImportKey("k2", "HS256", []byte("second-secret"), time.Now().Add(-middleware.MinPublishTime)); err != nil {
+		t.Fatalf("import key: %v", err)
+	}
+	if _, err := ring.ImportKey("k3", "HS256", []byte("third-secret"), time.Now()); err != nil {
+		t.Fatalf("import key: %v", err)
+	}
+	handler := NewKeysHandler(ring)
+
+	// A key verifiers may not have fetched yet can not be promoted
+	rr := httptest.NewRecorder()
+	handler.Promote(rr, httptest.NewRequest(http.MethodPost, "/api/admin/keys/promote", strings.NewReader(`{"kid":"k3"}`)))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a freshly published key, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.Promote(rr, httptest.NewRequest(http.MethodPost, "/api/admin/keys/promote", strings.NewReader(`{"kid":"k2"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ring.Active().ID != "k2" {
+		t.Errorf("expected k2 active, got %s", ring.Active().ID)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.Retire(rr, httptest.NewRequest(http.MethodPost, "/api/admin/keys/retire", strings.NewReader(`{"kid":"k1"}`)))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 for key still in use, got %d", rr.Code)
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	handler := middleware.AdminMiddleware("admin-secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{header: "", want: http.StatusUnauthorized},
+		{header: "Bearer wrong", want: http.StatusUnauthorized},
+		{header: "Bearer admin-secret", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/keys", nil)
+		req.Header.Set("Authorization", tt.header)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("header %q: expected %d, got %d", tt.header, tt.want, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// UsersHandler performs administrative actions on user accounts
+type UsersHandler struct {
+	authService *authservice.AuthService
+}
+
+// NewUsersHandler is the constructor for UsersHandler
+func NewUsersHandler(authService *authservice.AuthService) *UsersHandler {
+	return &UsersHandler{authService: authService}
+}
+
+// Logout handles POST requests signing a user out everywhere
+// Every access token issued so far is rejected and every session is revoked
+func (handler *UsersHandler) Logout(w http.ResponseWriter, req *http.Request) {
+	userID := req.PathValue("id")
+	err := handler.authService.InvalidateAccessTokens(req.Context(), userID)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = handler.authService.RevokeAllSessions(req.Context(), userID)
+	}
+	if err != nil {
+		log.Println("Failed to log out user", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Println("Logged out user", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+func TestUsersHandler_Logout(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	authSvc := authservice.NewAuthService(store)
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	if _, _, err := authSvc.IssueRefreshToken(t.Context(), userID, authservice.ClientInfo{}); err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/admin/users/{id}/logout", NewUsersHandler(authSvc).Logout)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/admin/users/"+userID+"/logout", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	if version, _ := store.GetTokenVersion(t.Context(), userID); version != 1 {
+		t.Errorf("expected token version 1, got %d", version)
+	}
+	if sessions, _ := authSvc.ListSessions(t.Context(), userID); len(sessions) != 0 {
+		t.Errorf("expected sessions to be revoked, got %+v", sessions)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/admin/users/unknown/logout", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown user, got %d", rr.Code)
+	}
+}
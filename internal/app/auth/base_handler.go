@@ -1,12 +1,102 @@
 // Package auth provides HTTP request handlers for authentication
 package auth
 
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+)
+
+// RefreshCookieName is the name of the cookie carrying the refresh token
+const RefreshCookieName = "refresh_token"
+
+// refreshCookiePath limits the refresh cookie to the auth endpoints
+const refreshCookiePath = "/api/auth"
+
 // BaseHandler provides base functionality for auth handlers
 type BaseHandler struct {
-	// Currently empty, but can be extended with common functionality for auth handlers
+	// refreshCookie enables sending refresh tokens as HttpOnly cookies
+	refreshCookie bool
+
+	// emailVerificationURL is the page email verification links point to; verification emails are not sent when empty
+	emailVerificationURL string
+
+	// stepUp is demanded from the caller's authentication for sensitive changes such as a new email
+	stepUp middleware.AuthPolicy
+}
+
+// Option configures optional handler settings
+type Option func(*BaseHandler)
+
+// WithRefreshCookie enables or disables the refresh token cookie
+func WithRefreshCookie(enabled bool) Option {
+	return func(h *BaseHandler) {
+		h.refreshCookie = enabled
+	}
+}
+
+// WithEmailVerificationURL enables sending a verification link to emails given at registration
+func WithEmailVerificationURL(verifyURL string) Option {
+	return func(h *BaseHandler) {
+		h.emailVerificationURL = verifyURL
+	}
+}
+
+// WithStepUpPolicy demands a recent or strong authentication for sensitive changes such as a new email
+func WithStepUpPolicy(policy middleware.AuthPolicy) Option {
+	return func(h *BaseHandler) {
+		h.stepUp = policy
+	}
 }
 
 // NewBaseHandler creates a new BaseHandler instance
-func NewBaseHandler() *BaseHandler {
-	return &BaseHandler{}
+func NewBaseHandler(opts ...Option) *BaseHandler {
+	h := &BaseHandler{}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// setRefreshCookie sets the refresh token cookie if enabled
+func (h *BaseHandler) setRefreshCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
+	if h.refreshCookie {
+		SetRefreshCookie(w, token, expiresAt)
+	}
+}
+
+// SetRefreshCookie writes the refresh token as an HttpOnly cookie
+func SetRefreshCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshCookieName,
+		Value:    token,
+		HttpOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		Path:     refreshCookiePath,
+		Expires:  expiresAt,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClearRefreshCookie removes the refresh token cookie
+func ClearRefreshCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshCookieName,
+		Value:    "",
+		HttpOnly: true,
+		Path:     refreshCookiePath,
+		MaxAge:   -1,
+	})
+}
+
+// writeJSON writes a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("Can not encode response", err)
+	}
 }
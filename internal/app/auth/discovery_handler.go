@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+)
+
+// Discovery is the OpenID Connect discovery document
+// Endpoints that are not served stay empty and are omitted
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                     string   `json:"token_endpoint,omitempty"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint,omitempty"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint                string   `json:"revocation_endpoint,omitempty"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// DiscoveryHandler serves /.well-known/openid-configuration
+type DiscoveryHandler struct {
+	document Discovery
+}
+
+// NewDiscoveryHandler is the constructor for DiscoveryHandler
+func NewDiscoveryHandler(document Discovery) *DiscoveryHandler {
+	if document.ResponseTypesSupported == nil {
+		document.ResponseTypesSupported = []string{}
+	}
+	if document.SubjectTypesSupported == nil {
+		document.SubjectTypesSupported = []string{"public"}
+	}
+	if document.ClaimsSupported == nil {
+		document.ClaimsSupported = []string{"iss", "sub", "aud", "exp", "iat", "nbf", "jti", "user_id"}
+	}
+	return &DiscoveryHandler{document: document}
+}
+
+// ServeHTTP handles the HTTP request for the discovery document
+func (handler *DiscoveryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		log.Println("Only GET requests are allowed!")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Algorithms follow the key ring, which may change at runtime
+	document := handler.document
+	document.IDTokenSigningAlgValuesSupported = middleware.SigningAlgorithms()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(document); err != nil {
+		log.Println("Can not encode response", err)
+	}
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiscoveryHandler(t *testing.T) {
+	handler := NewDiscoveryHandler(Discovery{
+		Issuer:  "https://auth.example.com",
+		JWKSURI: "https://auth.example.com/.well-known/jwks.json",
+	})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+
+	var doc map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&doc); err != nil {
+		t.Fatalf("decode document: %v", err)
+	}
+	if doc["issuer"] != "https://auth.example.com" || doc["jwks_uri"] != "https://auth.example.com/.well-known/jwks.json" {
+		t.Errorf("unexpected document: %v", doc)
+	}
+	if algs, ok := doc["id_token_signing_alg_values_supported"].([]interface{}); !ok || len(algs) == 0 {
+		t.Errorf("expected signing algorithms, got %v", doc["id_token_signing_alg_values_supported"])
+	}
+	if _, ok := doc["token_endpoint"]; ok {
+		t.Error("expected unset endpoints to be omitted")
+	}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// emailVerifyRequest represents the JSON request structure for email verification
+type emailVerifyRequest struct {
+	Token string `json:"token"`
+}
+
+// EmailHandler handles email address verification
+type EmailHandler struct {
+	authService *authservice.AuthService
+	verifyURL   string
+}
+
+// NewEmailHandler is the constructor for EmailHandler
+// verifyURL is the page the emailed link points to; it receives the token in the "token" query parameter
+func NewEmailHandler(authService *authservice.AuthService, verifyURL string) *EmailHandler {
+	return &EmailHandler{authService: authService, verifyURL: verifyURL}
+}
+
+// Verify handles POST /api/auth/email/verify with the token from the emailed link
+func (handler *EmailHandler) Verify(w http.ResponseWriter, req *http.Request) {
+	verifyReq := new(emailVerifyRequest)
+	if err := json.NewDecoder(req.Body).Decode(verifyReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if verifyReq.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	_, err := handler.authService.VerifyEmail(req.Context(), verifyReq.Token)
+	if errors.Is(err, authservice.ErrInvalidVerificationToken) {
+		http.Error(w, "Invalid or expired verification token", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, authservice.ErrEmailTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to verify email", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Resend handles POST /api/auth/email/verify/resend, sending a new link to the caller's email
+// It expects to run behind JWTMiddleware
+func (handler *EmailHandler) Resend(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	err := handler.authService.SendEmailVerification(req.Context(), userID, handler.verifyURL)
+	if errors.Is(err, storage.ErrProfileNotFound) {
+		http.Error(w, "No email address is set", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to send email verification", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
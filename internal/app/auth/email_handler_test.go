@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/mail"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+func TestEmailVerification_RequiredForLogin(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	sender := mail.NewMemorySender()
+	authSvc := authservice.NewAuthService(store,
+		authservice.WithMailSender(sender),
+		authservice.WithRequireVerifiedEmail(true),
+	)
+	middleware.SetEmailVerificationSource(authSvc)
+	t.Cleanup(func() { middleware.SetEmailVerificationSource(nil) })
+
+	opts := WithEmailVerificationURL("https://app.example.com/verify-email")
+	emailHandler := NewEmailHandler(authSvc, "https://app.example.com/verify-email")
+	mux := http.NewServeMux()
+	mux.Handle("/api/auth/register", NewRegisterHandler(store, authSvc, opts))
+	mux.Handle("/api/auth/login", NewLoginHandler(store, authSvc, opts))
+	mux.HandleFunc("POST /api/auth/email/verify", emailHandler.Verify)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := post("/api/auth/register", `{"login":"user","password":"password"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without email, got %d", rr.Code)
+	}
+	if rr := post("/api/auth/register", `{"login":"user","password":"password","email":"not an email"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid email, got %d", rr.Code)
+	}
+	rr := post("/api/auth/register", `{"login":"user","password":"password","email":"user@example.com"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rr.Code)
+	}
+	var regResp registerResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &regResp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if regResp.UserID == "" || regResp.Token != "" || regResp.RefreshToken != "" {
+		t.Errorf("expected only a user ID before verification, got %+v", regResp)
+	}
+	// An unverified claim does not keep anyone else from registering the address
+	rr = post("/api/auth/register", `{"login":"other","password":"password","email":"USER@example.com"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 while the email is unverified, got %d", rr.Code)
+	}
+	var otherResp registerResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &otherResp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if rr := post("/api/auth/login", `{"login":"user","password":"password"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 before verification, got %d", rr.Code)
+	}
+
+	var messages []mail.Message
+	for deadline := time.Now().Add(5 * time.Second); len(messages) < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		messages = sender.Messages()
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expected two verification emails, got %+v", messages)
+	}
+	tokens := make(map[string]string)
+	for _, message := range messages {
+		start := strings.Index(message.Body, "https://app.example.com/verify-email?token=")
+		if start < 0 {
+			t.Fatalf("verification link not found in %q", message.Body)
+		}
+		link, err := url.Parse(strings.Fields(message.Body[start:])[0])
+		if err != nil {
+			t.Fatalf("parse link: %v", err)
+		}
+		tokens[message.To] = link.Query().Get("token")
+	}
+	token := tokens["user@example.com"]
+
+	if rr := post("/api/auth/email/verify", `{"token":"`+token+`"}`); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	if rr := post("/api/auth/email/verify", `{"token":"`+token+`"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for used token, got %d", rr.Code)
+	}
+
+	// Verifying drops the other claim, whose link stops working, and the address is taken from now on
+	if profile, err := store.GetUserProfile(t.Context(), otherResp.UserID); err != nil || profile.Email != "" {
+		t.Errorf("expected the unverified claim to be dropped, got %+v err=%v", profile, err)
+	}
+	if rr := post("/api/auth/email/verify", `{"token":"`+tokens["USER@example.com"]+`"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for the dropped claim, got %d", rr.Code)
+	}
+	if rr := post("/api/auth/register", `{"login":"third","password":"password","email":"user@example.com"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 for taken email, got %d", rr.Code)
+	}
+
+	rr = post("/api/auth/login", `{"login":"user","password":"password"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 after verification, got %d", rr.Code)
+	}
+	var loginResp loginResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &loginResp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	claims, err := middleware.ParseToken(loginResp.Token)
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	if claims.EmailVerified == nil || !*claims.EmailVerified {
+		t.Errorf("expected email_verified claim, got %v", claims.EmailVerified)
+	}
+}
+
+func TestEmailVerification_ChangedEmail(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	sender := mail.NewMemorySender()
+	authSvc := authservice.NewAuthService(store, authservice.WithMailSender(sender))
+
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	if err := authSvc.SetEmail(t.Context(), userID, "old@example.com"); err != nil {
+		t.Fatalf("set email: %v", err)
+	}
+	if err := authSvc.SendEmailVerification(t.Context(), userID, "https://app.example.com/verify-email"); err != nil {
+		t.Fatalf("send verification: %v", err)
+	}
+	if err := authSvc.SetEmail(t.Context(), userID, "new@example.com"); err != nil {
+		t.Fatalf("set email: %v", err)
+	}
+
+	// A link sent to the old address must not verify the new one
+	body := sender.Messages()[0].Body
+	link, err := url.Parse(strings.Fields(body[strings.Index(body, "https://"):])[0])
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	if _, err := authSvc.VerifyEmail(t.Context(), link.Query().Get("token")); !errors.Is(err, authservice.ErrInvalidVerificationToken) {
+		t.Errorf("expected ErrInvalidVerificationToken, got %v", err)
+	}
+	if verified, _ := authSvc.IsEmailVerified(t.Context(), userID); verified {
+		t.Error("expected email to stay unverified")
+	}
+}
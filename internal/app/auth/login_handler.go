@@ -4,6 +4,7 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"time"
@@ -11,18 +12,63 @@ import (
 	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
 	"github.com/vitalykrupin/auth-service/internal/app/authservice"
 	"github.com/vitalykrupin/auth-service/internal/app/storage"
+	"github.com/vitalykrupin/auth-service/internal/app/webauthn"
 )
 
 // loginRequest represents the JSON request structure for login
 type loginRequest struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
+
+	// DeviceLabel optionally names the session, e.g. "Work laptop"
+	DeviceLabel string `json:"device_label,omitempty"`
 }
 
 // loginResponse represents the JSON response structure for login
 type loginResponse struct {
-	UserID string `json:"user_id"`
-	Token  string `json:"token"`
+	UserID       string `json:"user_id"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// mfaChallengeResponse is returned instead of tokens when the user has MFA enabled
+type mfaChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// mfaLoginRequest represents the JSON request structure for the second login step
+type mfaLoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	// Code is a TOTP code or a recovery code
+	Code string `json:"code"`
+
+	// DeviceLabel optionally names the session, e.g. "Work laptop"
+	DeviceLabel string `json:"device_label,omitempty"`
+}
+
+// passkeyLoginRequest represents the JSON request structure for a passkey login
+type passkeyLoginRequest struct {
+	// Credential is the PublicKeyCredential returned by navigator.credentials.get
+	Credential *webauthn.AssertionResponse `json:"credential"`
+
+	// DeviceLabel optionally names the session, e.g. "Work laptop"
+	DeviceLabel string `json:"device_label,omitempty"`
+}
+
+// emailCodeRequest represents the JSON request structure for an emailed login code
+type emailCodeRequest struct {
+	Email string `json:"email"`
+}
+
+// emailCodeLoginRequest represents the JSON request structure for a login with an emailed code
+type emailCodeLoginRequest struct {
+	Email string `json:"email"`
+	Code  string `json:"code"`
+
+	// DeviceLabel optionally names the session, e.g. "Work laptop"
+	DeviceLabel string `json:"device_label,omitempty"`
 }
 
 // LoginHandler handles POST requests for user login
@@ -33,9 +79,9 @@ type LoginHandler struct {
 }
 
 // NewLoginHandler is the constructor for LoginHandler
-func NewLoginHandler(store storage.Storage, authService *authservice.AuthService) *LoginHandler {
+func NewLoginHandler(store storage.Storage, authService *authservice.AuthService, opts ...Option) *LoginHandler {
 	return &LoginHandler{
-		BaseHandler: NewBaseHandler(),
+		BaseHandler: NewBaseHandler(opts...),
 		storage:     store,
 		authService: authService,
 	}
@@ -69,39 +115,247 @@ func (handler *LoginHandler) ServeHTTP(w http.ResponseWriter, req *http.Request)
 
 	// Authenticate user
 	userID, err := handler.authService.AuthenticateUser(ctx, loginReq.Login, loginReq.Password)
+	if errors.Is(err, authservice.ErrEmailNotVerified) {
+		http.Error(w, "Email address is not verified", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		log.Println("Failed to authenticate user", err)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	// Generate JWT token
-	token, err := middleware.GenerateToken(userID)
+	handler.finishFirstFactor(ctx, w, req, userID, loginReq.DeviceLabel, middleware.AMRPassword)
+}
+
+// finishFirstFactor completes a login after its first factor, or asks for the second one if the user has MFA enabled
+// methods are the amr values of the first factor
+func (handler *LoginHandler) finishFirstFactor(ctx context.Context, w http.ResponseWriter, req *http.Request, userID, deviceLabel string, methods ...string) {
+	// A second factor is required before any token is issued
+	mfaEnabled, err := handler.authService.MFAEnabled(ctx, userID)
 	if err != nil {
-		log.Println("Failed to generate token", err)
+		log.Println("Failed to check MFA", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if mfaEnabled {
+		mfaToken, expiresAt, err := handler.authService.StartMFAChallenge(ctx, userID, methods...)
+		if err != nil {
+			log.Println("Failed to start MFA challenge", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(mfaChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int(time.Until(expiresAt).Round(time.Second).Seconds()),
+		}); err != nil {
+			log.Println("Can not encode response", err)
+		}
+		return
+	}
 
-	// Set token as cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		Path:     "/",
-		MaxAge:   86400, // 24 hours
-	})
+	handler.completeLogin(ctx, w, req, userID, deviceLabel, middleware.NewAuthentication(methods...))
+}
+
+// MFA handles POST /api/auth/login/mfa, the second login step for users with MFA enabled
+func (handler *LoginHandler) MFA(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	mfaReq := new(mfaLoginRequest)
+	if err := json.NewDecoder(req.Body).Decode(mfaReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if mfaReq.MFAToken == "" || mfaReq.Code == "" {
+		log.Println("MFA token and code are required")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	userID, methods, err := handler.authService.VerifyMFAChallenge(ctx, mfaReq.MFAToken, mfaReq.Code)
+	if errors.Is(err, authservice.ErrInvalidMFACode) || errors.Is(err, authservice.ErrInvalidMFAChallenge) {
+		log.Println("Failed to verify MFA", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, authservice.ErrMFALocked) {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to verify MFA", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	handler.completeLogin(ctx, w, req, userID, mfaReq.DeviceLabel, middleware.NewAuthentication(methods...))
+}
+
+// EmailCode handles POST /api/auth/login/email, which emails a one-time login code
+// The response is 202 whether or not the email belongs to an account; the email is sent in the background
+// so the response time does not tell either
+func (handler *LoginHandler) EmailCode(w http.ResponseWriter, req *http.Request) {
+	codeReq := new(emailCodeRequest)
+	if err := json.NewDecoder(req.Body).Decode(codeReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if codeReq.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithoutCancel(req.Context())
+	go func() {
+		if err := handler.authService.RequestEmailLoginCode(ctx, codeReq.Email); err != nil {
+			log.Println("Failed to send email login code", err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// EmailCodeLogin handles POST /api/auth/login/email/verify, the login with an emailed code
+// The response is the same as for a password login, including the MFA step
+func (handler *LoginHandler) EmailCodeLogin(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	loginReq := new(emailCodeLoginRequest)
+	if err := json.NewDecoder(req.Body).Decode(loginReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if loginReq.Email == "" || loginReq.Code == "" {
+		log.Println("Email and code are required")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	userID, err := handler.authService.VerifyEmailLoginCode(ctx, loginReq.Email, loginReq.Code)
+	if errors.Is(err, authservice.ErrInvalidEmailLoginCode) {
+		log.Println("Failed to verify email login code", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to verify email login code", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	handler.finishFirstFactor(ctx, w, req, userID, loginReq.DeviceLabel, middleware.AMROTP)
+}
+
+// PasskeyOptions handles POST /api/auth/login/passkey/options, the start of a passkey login
+// The response holds the options for navigator.credentials.get; no login is needed
+func (handler *LoginHandler) PasskeyOptions(w http.ResponseWriter, req *http.Request) {
+	opts, err := handler.authService.BeginPasskeyLogin(req.Context())
+	if err != nil {
+		log.Println("Failed to start passkey login", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, opts)
+}
+
+// Passkey handles POST /api/auth/login/passkey, a passwordless login with a passkey assertion
+// A passkey verifies the user itself, so no password or second factor is asked for
+func (handler *LoginHandler) Passkey(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	passkeyReq := new(passkeyLoginRequest)
+	if err := json.NewDecoder(req.Body).Decode(passkeyReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if passkeyReq.Credential == nil {
+		log.Println("Credential is required")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	userID, err := handler.authService.FinishPasskeyLogin(ctx, passkeyReq.Credential)
+	if errors.Is(err, authservice.ErrInvalidPasskey) {
+		log.Println("Failed to verify passkey", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, authservice.ErrEmailNotVerified) {
+		http.Error(w, "Email address is not verified", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to verify passkey", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// A user verifying passkey is possession plus biometrics or PIN, i.e. multi-factor
+	handler.completeLogin(ctx, w, req, userID, passkeyReq.DeviceLabel,
+		middleware.NewAuthentication(middleware.AMRHardwareKey, middleware.AMRUserPresence, middleware.AMRMultiFactor))
+}
+
+// completeLogin issues the access and refresh tokens of an authenticated user
+// authn is recorded in the access token and the session, so refreshed tokens keep it
+func (handler *LoginHandler) completeLogin(ctx context.Context, w http.ResponseWriter, req *http.Request, userID, deviceLabel string, authn middleware.Authentication) {
+	token, refreshToken, ok := handler.startSession(ctx, w, req, userID, deviceLabel, authn)
+	if !ok {
+		return
+	}
 
 	// Return response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(loginResponse{
-		UserID: userID,
-		Token:  token,
+		UserID:       userID,
+		Token:        token,
+		RefreshToken: refreshToken,
 	}); err != nil {
 		log.Println("Can not encode response", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 }
+
+// startSession issues the access and refresh tokens of an authenticated user and sets their cookies
+// On failure the error response is written and false returned
+func (handler *LoginHandler) startSession(ctx context.Context, w http.ResponseWriter, req *http.Request, userID, deviceLabel string, authn middleware.Authentication) (string, string, bool) {
+	// Generate JWT token
+	token, err := middleware.GenerateAuthenticatedToken(userID, authn)
+	if err != nil {
+		log.Println("Failed to generate token", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", "", false
+	}
+
+	// Issue refresh token
+	client := authservice.ClientInfoFromRequest(req, deviceLabel)
+	client.Authentication = authn
+	refreshToken, refreshExpiresAt, err := handler.authService.IssueRefreshToken(ctx, userID, client)
+	if err != nil {
+		log.Println("Failed to issue refresh token", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", "", false
+	}
+
+	// Set token as cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    token,
+		HttpOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		Path:     "/",
+		MaxAge:   86400, // 24 hours
+	})
+	handler.setRefreshCookie(w, refreshToken, refreshExpiresAt)
+	return token, refreshToken, true
+}
@@ -1,9 +1,213 @@
 package auth
 
-import "testing"
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/mail"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
 
 func TestLoginHandler_Construct(t *testing.T) {
 	// Construction exercised in cmd/auth app; keep minimal placeholder here
 	t.Log("auth login handler placeholder")
 }
 
+func TestLoginHandler_IssuesRefreshToken(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	authSvc := authservice.NewAuthService(store)
+	if _, err := authSvc.RegisterUser(t.Context(), "user", "password"); err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+
+	handler := NewLoginHandler(store, authSvc, WithRefreshCookie(true))
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"login":"user","password":"password"}`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var resp loginResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.RefreshToken == "" {
+		t.Fatal("expected refresh token in response")
+	}
+	rt, err := store.GetRefreshToken(t.Context(), storage.HashToken(resp.RefreshToken))
+	if err != nil || rt.Revoked || rt.UserID != resp.UserID {
+		t.Fatalf("refresh token not stored for user: token=%+v err=%v", rt, err)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == RefreshCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value != resp.RefreshToken || !cookie.HttpOnly {
+		t.Fatalf("expected HttpOnly refresh cookie, got %+v", cookie)
+	}
+
+	// The access token and the session record the password sign-in
+	claims, err := middleware.ParseToken(resp.Token)
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	if claims.AuthTime == nil || len(claims.AMR) != 1 || claims.AMR[0] != middleware.AMRPassword || claims.ACR != middleware.ACRSingleFactor {
+		t.Errorf("unexpected authentication claims: auth_time=%v amr=%v acr=%q", claims.AuthTime, claims.AMR, claims.ACR)
+	}
+	authn, err := authSvc.SessionAuthentication(t.Context(), resp.RefreshToken)
+	if err != nil || !authn.Time.Truncate(time.Second).Equal(claims.AuthTime.Time) || len(authn.Methods) != 1 {
+		t.Errorf("unexpected session authentication: %+v err=%v", authn, err)
+	}
+
+	// A refreshed session keeps the original authentication
+	_, next, _, err := authSvc.RefreshSession(t.Context(), resp.RefreshToken, authservice.ClientInfo{})
+	if err != nil {
+		t.Fatalf("refresh session: %v", err)
+	}
+	if refreshed, err := authSvc.SessionAuthentication(t.Context(), next); err != nil || !refreshed.Time.Equal(authn.Time) || len(refreshed.Methods) != 1 {
+		t.Errorf("expected refreshed session to keep %+v, got %+v err=%v", authn, refreshed, err)
+	}
+}
+
+func TestLoginHandler_EmailCode(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	sender := mail.NewMemorySender()
+	authSvc := authservice.NewAuthService(store, authservice.WithMailSender(sender))
+	handler := NewLoginHandler(store, authSvc)
+	mux := http.NewServeMux()
+	mux.Handle("/api/auth/register", NewRegisterHandler(store, authSvc, WithEmailVerificationURL("https://app.example.com/verify-email")))
+	mux.Handle("/api/auth/login", handler)
+	mux.HandleFunc("POST /api/auth/login/email", handler.EmailCode)
+	mux.HandleFunc("POST /api/auth/login/email/verify", handler.EmailCodeLogin)
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	// waitCode returns the code of the n-th email
+	waitCode := func(n int) string {
+		t.Helper()
+		var messages []mail.Message
+		for deadline := time.Now().Add(5 * time.Second); len(messages) < n && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+			messages = sender.Messages()
+		}
+		if len(messages) != n || messages[n-1].To != "user@example.com" {
+			t.Fatalf("expected %d emails to the user, got %+v", n, messages)
+		}
+		code := strings.TrimSuffix(strings.Fields(strings.TrimPrefix(messages[n-1].Body, "Your sign-in code is "))[0], ".")
+		if len(code) != 6 {
+			t.Fatalf("code not found in %q", messages[n-1].Body)
+		}
+		return code
+	}
+
+	// Accounts without a password need an email and get no tokens until they verify it and sign in
+	if rr := post("/api/auth/register", `{"login":"user"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without password and email, got %d", rr.Code)
+	}
+	rr := post("/api/auth/register", `{"login":"user","email":"user@example.com"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var registered registerResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &registered); err != nil || registered.Token != "" {
+		t.Fatalf("expected no token for passwordless account, got %+v err=%v", registered, err)
+	}
+	if user, _ := store.GetUserByID(t.Context(), registered.UserID); user == nil || user.HasPassword() {
+		t.Fatalf("expected passwordless user, got %+v", user)
+	}
+	if rr := post("/api/auth/login", `{"login":"user","password":"x"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for password login, got %d", rr.Code)
+	}
+
+	// Registration sends a verification link; until it is followed no code is sent
+	var messages []mail.Message
+	for deadline := time.Now().Add(5 * time.Second); len(messages) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		messages = sender.Messages()
+	}
+	start := -1
+	if len(messages) == 1 {
+		start = strings.Index(messages[0].Body, "https://app.example.com/verify-email?token=")
+	}
+	if start < 0 {
+		t.Fatalf("expected a verification email, got %+v", messages)
+	}
+	link, err := url.Parse(strings.Fields(messages[0].Body[start:])[0])
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	if err := authSvc.RequestEmailLoginCode(t.Context(), "user@example.com"); err != nil {
+		t.Fatalf("request code: %v", err)
+	}
+	if messages := sender.Messages(); len(messages) != 1 {
+		t.Fatalf("expected no code for an unverified email, got %+v", messages)
+	}
+	if _, err := authSvc.VerifyEmail(t.Context(), link.Query().Get("token")); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+
+	// Unknown accounts get the same answer and no email
+	if rr := post("/api/auth/login/email", `{"email":"nobody@example.com"}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for unknown email, got %d", rr.Code)
+	}
+	if rr := post("/api/auth/login/email", `{"email":"USER@example.com"}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	code := waitCode(2)
+
+	if rr := post("/api/auth/login/email/verify", `{"email":"nobody@example.com","code":"`+code+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for unknown email, got %d", rr.Code)
+	}
+	rr = post("/api/auth/login/email/verify", `{"email":"user@example.com","code":"`+code+`","device_label":"Phone"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp loginResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.UserID != registered.UserID || resp.RefreshToken == "" {
+		t.Fatalf("unexpected login response: %+v err=%v", resp, err)
+	}
+	if claims, err := middleware.ParseToken(resp.Token); err != nil || len(claims.AMR) != 1 || claims.AMR[0] != middleware.AMROTP {
+		t.Errorf("expected amr [otp], got %+v err=%v", claims, err)
+	}
+	// The code is single-use
+	if rr := post("/api/auth/login/email/verify", `{"email":"user@example.com","code":"`+code+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for used code, got %d", rr.Code)
+	}
+
+	// Too many attempts drop the code, even the right one is refused afterwards
+	post("/api/auth/login/email", `{"email":"user@example.com"}`)
+	code = waitCode(3)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "000001"
+	}
+	for range 5 {
+		if rr := post("/api/auth/login/email/verify", `{"email":"user@example.com","code":"`+wrong+`"}`); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for wrong code, got %d", rr.Code)
+		}
+	}
+	if rr := post("/api/auth/login/email/verify", `{"email":"user@example.com","code":"`+code+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 after too many attempts, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+)
+
+// LoginLinkNonceCookieName is the name of the cookie binding login links to the browser that asked for them
+const LoginLinkNonceCookieName = "login_link_nonce"
+
+// loginLinkCookiePath limits the nonce cookie to the login link endpoints
+const loginLinkCookiePath = "/api/auth/login/link"
+
+// loginLinkRequest represents the JSON request structure for an emailed login link
+type loginLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// LoginLinkHandler handles login via emailed single-use links
+// Links only work in the browser that asked for them; it keeps a nonce in a cookie
+type LoginLinkHandler struct {
+	*LoginHandler
+	linkURL    string
+	landingURL string
+}
+
+// NewLoginLinkHandler is the constructor for LoginLinkHandler
+// linkURL is where the emailed link points to, i.e. the Verify endpoint; it receives the token in the "token" query parameter
+// landingURL is the page the browser is redirected to once the link was checked
+func NewLoginLinkHandler(loginHandler *LoginHandler, linkURL, landingURL string) *LoginLinkHandler {
+	return &LoginLinkHandler{LoginHandler: loginHandler, linkURL: linkURL, landingURL: landingURL}
+}
+
+// Request handles POST /api/auth/login/link, which emails a login link
+// The response is 202 and sets the nonce cookie whether or not the email belongs to an account;
+// the email is sent in the background so the response time does not tell either
+func (handler *LoginLinkHandler) Request(w http.ResponseWriter, req *http.Request) {
+	linkReq := new(loginLinkRequest)
+	if err := json.NewDecoder(req.Body).Decode(linkReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if linkReq.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	// A browser asking again keeps its nonce, so the links sent before keep working
+	var nonce string
+	if cookie, err := req.Cookie(LoginLinkNonceCookieName); err == nil && cookie.Value != "" {
+		nonce = cookie.Value
+	} else {
+		nonce, err = authservice.NewLoginLinkNonce()
+		if err != nil {
+			log.Println("Failed to generate login link nonce", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	setLoginLinkNonceCookie(w, nonce, int(authservice.LoginLinkTTL/time.Second))
+
+	ctx := context.WithoutCancel(req.Context())
+	go func() {
+		if err := handler.authService.RequestLoginLink(ctx, linkReq.Email, nonce, handler.linkURL); err != nil {
+			log.Println("Failed to send login link", err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Verify handles GET /api/auth/login/link/verify, the page emailed links point to
+// A valid link sets the session cookies the same way a login does and redirects to the landing page;
+// an invalid one redirects there with error=invalid_link. Users with MFA enabled get the MFA token
+// in the URL fragment instead, to finish the login at POST /api/auth/login/mfa
+func (handler *LoginLinkHandler) Verify(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	// The token in the URL must not leak to the landing page's resources
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+
+	token := req.URL.Query().Get("token")
+	cookie, err := req.Cookie(LoginLinkNonceCookieName)
+	if token == "" || err != nil {
+		log.Println("Login link token or nonce is missing")
+		handler.redirect(w, req, url.Values{"error": {"invalid_link"}}, nil)
+		return
+	}
+
+	userID, err := handler.authService.VerifyLoginLink(ctx, token, cookie.Value)
+	if errors.Is(err, authservice.ErrInvalidLoginLink) {
+		log.Println("Failed to verify login link", err)
+		handler.redirect(w, req, url.Values{"error": {"invalid_link"}}, nil)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to verify login link", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setLoginLinkNonceCookie(w, "", -1)
+
+	// A second factor is required before any token is issued
+	mfaEnabled, err := handler.authService.MFAEnabled(ctx, userID)
+	if err != nil {
+		log.Println("Failed to check MFA", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if mfaEnabled {
+		mfaToken, expiresAt, err := handler.authService.StartMFAChallenge(ctx, userID, middleware.AMROTP)
+		if err != nil {
+			log.Println("Failed to start MFA challenge", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// The fragment is not sent to servers, so the MFA token stays in the browser
+		handler.redirect(w, req, nil, url.Values{
+			"mfa_token":  {mfaToken},
+			"expires_in": {strconv.Itoa(int(time.Until(expiresAt).Round(time.Second).Seconds()))},
+		})
+		return
+	}
+
+	if _, _, ok := handler.startSession(ctx, w, req, userID, "", middleware.NewAuthentication(middleware.AMROTP)); !ok {
+		return
+	}
+	handler.redirect(w, req, nil, nil)
+}
+
+// redirect sends the browser to the landing page with the given query and fragment parameters
+func (handler *LoginLinkHandler) redirect(w http.ResponseWriter, req *http.Request, query, fragment url.Values) {
+	landing, err := url.Parse(handler.landingURL)
+	if err != nil {
+		log.Println("Invalid login link landing URL", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(query) > 0 {
+		values := landing.Query()
+		for k, v := range query {
+			values[k] = v
+		}
+		landing.RawQuery = values.Encode()
+	}
+	if len(fragment) > 0 {
+		landing.Fragment, landing.RawFragment = fragment.Encode(), ""
+	}
+	http.Redirect(w, req, landing.String(), http.StatusSeeOther)
+}
+
+// setLoginLinkNonceCookie sets the nonce cookie; a negative maxAge deletes it
+// SameSite is Lax so the cookie is sent when the link is opened from a mail client
+func setLoginLinkNonceCookie(w http.ResponseWriter, nonce string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     LoginLinkNonceCookieName,
+		Value:    nonce,
+		HttpOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		Path:     loginLinkCookiePath,
+		MaxAge:   maxAge,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
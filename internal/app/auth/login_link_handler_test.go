@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/mail"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+func TestLoginLinkHandler(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	sender := mail.NewMemorySender()
+	authSvc := authservice.NewAuthService(store, authservice.WithMailSender(sender))
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := authSvc.SetEmail(t.Context(), userID, "user@example.com"); err != nil {
+		t.Fatalf("set email: %v", err)
+	}
+	if err := store.MarkEmailVerified(t.Context(), userID, "user@example.com", time.Now()); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+
+	handler := NewLoginLinkHandler(NewLoginHandler(store, authSvc),
+		"http://auth.example.com/api/auth/login/link/verify", "http://auth.example.com/welcome")
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/auth/login/link", handler.Request)
+	mux.HandleFunc("GET /api/auth/login/link/verify", handler.Verify)
+	do := func(req *http.Request, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	cookie := func(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+	// waitLink returns the link of the first email
+	waitLink := func() string {
+		t.Helper()
+		var messages []mail.Message
+		for deadline := time.Now().Add(5 * time.Second); len(messages) < 1 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+			messages = sender.Messages()
+		}
+		if len(messages) != 1 || messages[0].To != "user@example.com" {
+			t.Fatalf("expected an email to the user, got %+v", messages)
+		}
+		for _, field := range strings.Fields(messages[0].Body) {
+			if strings.HasPrefix(field, "http://auth.example.com/") {
+				return field
+			}
+		}
+		t.Fatalf("link not found in %q", messages[0].Body)
+		return ""
+	}
+
+	// Unknown accounts get the same answer, including the nonce cookie
+	rr := do(httptest.NewRequest(http.MethodPost, "/api/auth/login/link", strings.NewReader(`{"email":"nobody@example.com"}`)))
+	if rr.Code != http.StatusAccepted || cookie(rr, LoginLinkNonceCookieName) == nil {
+		t.Fatalf("expected 202 with nonce cookie for unknown email, got %d", rr.Code)
+	}
+	// An unverified email may belong to someone else, so it gets no link either
+	otherID, err := authSvc.RegisterUser(t.Context(), "other", "")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := authSvc.SetEmail(t.Context(), otherID, "victim@example.com"); err != nil {
+		t.Fatalf("set email: %v", err)
+	}
+	if err := authSvc.RequestLoginLink(t.Context(), "victim@example.com", "nonce", "http://auth.example.com/api/auth/login/link/verify"); err != nil {
+		t.Fatalf("request link: %v", err)
+	}
+	if messages := sender.Messages(); len(messages) != 0 {
+		t.Fatalf("expected no email for an unverified address, got %+v", messages)
+	}
+	rr = do(httptest.NewRequest(http.MethodPost, "/api/auth/login/link", strings.NewReader(`{"email":"user@example.com"}`)))
+	nonce := cookie(rr, LoginLinkNonceCookieName)
+	if rr.Code != http.StatusAccepted || nonce == nil || !nonce.HttpOnly || nonce.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("expected 202 with nonce cookie, got %d %+v", rr.Code, nonce)
+	}
+	link := waitLink()
+
+	// Without the browser's nonce the link is refused but stays usable, e.g. after a mail scanner opened it
+	for _, c := range []*http.Cookie{nil, {Name: LoginLinkNonceCookieName, Value: "other"}} {
+		req := httptest.NewRequest(http.MethodGet, link, nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		rr := do(req)
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "http://auth.example.com/welcome?error=invalid_link" {
+			t.Fatalf("expected redirect with error, got %d %q", rr.Code, rr.Header().Get("Location"))
+		}
+		if cookie(rr, "token") != nil {
+			t.Fatal("expected no session without the nonce")
+		}
+	}
+
+	rr = do(httptest.NewRequest(http.MethodGet, link, nil), &http.Cookie{Name: LoginLinkNonceCookieName, Value: nonce.Value})
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "http://auth.example.com/welcome" {
+		t.Fatalf("expected redirect to landing page, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	session := cookie(rr, "token")
+	if session == nil || !session.HttpOnly || session.Path != "/" {
+		t.Fatalf("expected token cookie, got %+v", session)
+	}
+	if claims, err := middleware.ParseToken(session.Value); err != nil || claims.UserID != userID || len(claims.AMR) != 1 || claims.AMR[0] != middleware.AMROTP {
+		t.Errorf("expected user token with amr [otp], got %+v err=%v", claims, err)
+	}
+	if c := cookie(rr, LoginLinkNonceCookieName); c == nil || c.MaxAge >= 0 {
+		t.Errorf("expected nonce cookie to be cleared, got %+v", c)
+	}
+	if verified, _ := authSvc.IsEmailVerified(t.Context(), userID); !verified {
+		t.Error("expected the email to be verified by the link")
+	}
+
+	// The link is single-use
+	rr = do(httptest.NewRequest(http.MethodGet, link, nil), &http.Cookie{Name: LoginLinkNonceCookieName, Value: nonce.Value})
+	if location, _ := url.Parse(rr.Header().Get("Location")); location == nil || location.Query().Get("error") != "invalid_link" {
+		t.Errorf("expected used link to be refused, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	// Repeated requests for the same address are limited
+	for i := range 3 {
+		if err := authSvc.RequestLoginLink(t.Context(), "user@example.com", nonce.Value, "http://auth.example.com/verify"); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if err := authSvc.RequestLoginLink(t.Context(), "user@example.com", nonce.Value, "http://auth.example.com/verify"); !errors.Is(err, authservice.ErrLoginLinkThrottled) {
+		t.Errorf("expected ErrLoginLinkThrottled, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+)
+
+// mfaCodeRequest represents the JSON request structure carrying a TOTP or recovery code
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// totpEnrollResponse represents the JSON response structure for a TOTP enrollment
+type totpEnrollResponse struct {
+	// Secret is the base32 secret for manual entry
+	Secret string `json:"secret"`
+	// URI is the otpauth:// URI, usually shown as a QR code
+	URI string `json:"otpauth_uri"`
+}
+
+// recoveryCodesResponse represents the JSON response structure for a confirmed TOTP enrollment
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAHandler handles second factor management for the logged in user
+// It expects to run behind JWTMiddleware
+type MFAHandler struct {
+	authService *authservice.AuthService
+	issuer      string
+}
+
+// NewMFAHandler is the constructor for MFAHandler
+// issuer is the service name authenticator apps show next to the account
+func NewMFAHandler(authService *authservice.AuthService, issuer string) *MFAHandler {
+	return &MFAHandler{authService: authService, issuer: issuer}
+}
+
+// Status handles GET /api/auth/mfa
+func (handler *MFAHandler) Status(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	status, err := handler.authService.GetMFAStatus(req.Context(), userID)
+	if err != nil {
+		log.Println("Failed to read MFA status", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// EnrollTOTP handles POST /api/auth/mfa/totp
+func (handler *MFAHandler) EnrollTOTP(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	secret, uri, err := handler.authService.EnrollTOTP(req.Context(), userID, handler.issuer)
+	if errors.Is(err, authservice.ErrMFAAlreadyEnabled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to enroll TOTP", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, totpEnrollResponse{Secret: secret, URI: uri})
+}
+
+// ConfirmTOTP handles POST /api/auth/mfa/totp/confirm
+func (handler *MFAHandler) ConfirmTOTP(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	codeReq, ok := decodeMFACode(w, req)
+	if !ok {
+		return
+	}
+	codes, err := handler.authService.ConfirmTOTP(req.Context(), userID, codeReq.Code)
+	if errors.Is(err, authservice.ErrInvalidMFACode) || errors.Is(err, authservice.ErrMFANotEnrolled) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, authservice.ErrMFAAlreadyEnabled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to confirm TOTP", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP handles DELETE /api/auth/mfa/totp; a current TOTP or recovery code is required
+func (handler *MFAHandler) DisableTOTP(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	codeReq, ok := decodeMFACode(w, req)
+	if !ok {
+		return
+	}
+	err := handler.authService.DisableTOTP(req.Context(), userID, codeReq.Code)
+	if errors.Is(err, authservice.ErrInvalidMFACode) || errors.Is(err, authservice.ErrMFANotEnrolled) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, authservice.ErrMFALocked) {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to disable TOTP", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeMFACode reads the code from the request body, writing 400 if it is missing
+func decodeMFACode(w http.ResponseWriter, req *http.Request) (*mfaCodeRequest, bool) {
+	codeReq := new(mfaCodeRequest)
+	if err := json.NewDecoder(req.Body).Decode(codeReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	if codeReq.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return nil, false
+	}
+	return codeReq, true
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// testTOTP computes the RFC 6238 code of a base32 secret, independently of authservice
+func testTOTP(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestMFA_EnrollAndLogin(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	authSvc := authservice.NewAuthService(store, authservice.WithMFAKey(bytes.Repeat([]byte{1}, 32)))
+	mfaHandler := NewMFAHandler(authSvc, "Example")
+	loginHandler := NewLoginHandler(store, authSvc)
+	mux := http.NewServeMux()
+	mux.Handle("/api/auth/login", loginHandler)
+	mux.HandleFunc("POST /api/auth/login/mfa", loginHandler.MFA)
+	mux.Handle("GET /api/auth/mfa", middleware.JWTMiddleware(http.HandlerFunc(mfaHandler.Status)))
+	mux.Handle("POST /api/auth/mfa/totp", middleware.JWTMiddleware(http.HandlerFunc(mfaHandler.EnrollTOTP)))
+	mux.Handle("POST /api/auth/mfa/totp/confirm", middleware.JWTMiddleware(http.HandlerFunc(mfaHandler.ConfirmTOTP)))
+	mux.Handle("DELETE /api/auth/mfa/totp", middleware.JWTMiddleware(http.HandlerFunc(mfaHandler.DisableTOTP)))
+
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	accessToken, err := middleware.GenerateToken(userID)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	do := func(method, path, body string, out any) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if out != nil && rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), out); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+		}
+		return rr.Code
+	}
+
+	var enroll totpEnrollResponse
+	if code := do(http.MethodPost, "/api/auth/mfa/totp", "", &enroll); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	uri, err := url.Parse(enroll.URI)
+	if err != nil || uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Example:user" || uri.Query().Get("secret") != enroll.Secret {
+		t.Fatalf("unexpected otpauth URI %q", enroll.URI)
+	}
+	secret, _ := store.GetTOTPSecret(t.Context(), userID)
+	if bytes.Contains(secret.Secret, []byte(enroll.Secret)) {
+		t.Error("expected the TOTP secret to be encrypted at rest")
+	}
+
+	// Not enabled before confirmation
+	if code := do(http.MethodPost, "/api/auth/login", `{"login":"user","password":"password"}`, nil); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := do(http.MethodPost, "/api/auth/mfa/totp/confirm", `{"code":"000000x"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for wrong code, got %d", code)
+	}
+	var recovery recoveryCodesResponse
+	totp := testTOTP(t, enroll.Secret, time.Now())
+	if code := do(http.MethodPost, "/api/auth/mfa/totp/confirm", `{"code":"`+totp+`"}`, &recovery); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(recovery.RecoveryCodes) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %v", recovery.RecoveryCodes)
+	}
+	if code := do(http.MethodPost, "/api/auth/mfa/totp", "", nil); code != http.StatusConflict {
+		t.Errorf("expected 409 for second enrollment, got %d", code)
+	}
+
+	// The password alone now yields a challenge instead of tokens
+	login := func() mfaChallengeResponse {
+		var challenge mfaChallengeResponse
+		if code := do(http.MethodPost, "/api/auth/login", `{"login":"user","password":"password"}`, &challenge); code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+		if !challenge.MFARequired || challenge.MFAToken == "" {
+			t.Fatalf("expected MFA challenge, got %+v", challenge)
+		}
+		return challenge
+	}
+	challenge := login()
+	// The code used for confirmation can not be replayed
+	if code := do(http.MethodPost, "/api/auth/login/mfa", `{"mfa_token":"`+challenge.MFAToken+`","code":"`+totp+`"}`, nil); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for replayed code, got %d", code)
+	}
+	var tokens loginResponse
+	body := `{"mfa_token":"` + challenge.MFAToken + `","code":"` + strings.ToUpper(recovery.RecoveryCodes[0]) + `"}`
+	if code := do(http.MethodPost, "/api/auth/login/mfa", body, &tokens); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if tokens.Token == "" || tokens.RefreshToken == "" || tokens.UserID != userID {
+		t.Errorf("expected tokens, got %+v", tokens)
+	}
+	if code := do(http.MethodPost, "/api/auth/login/mfa", body, nil); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for used challenge, got %d", code)
+	}
+
+	// Recovery codes are single-use
+	challenge = login()
+	body = `{"mfa_token":"` + challenge.MFAToken + `","code":"` + recovery.RecoveryCodes[0] + `"}`
+	if code := do(http.MethodPost, "/api/auth/login/mfa", body, nil); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for used recovery code, got %d", code)
+	}
+
+	// Too many wrong codes drop the challenge
+	for range 5 {
+		do(http.MethodPost, "/api/auth/login/mfa", `{"mfa_token":"`+challenge.MFAToken+`","code":"aaaaa-aaaaa"}`, nil)
+	}
+	body = `{"mfa_token":"` + challenge.MFAToken + `","code":"` + recovery.RecoveryCodes[1] + `"}`
+	if code := do(http.MethodPost, "/api/auth/login/mfa", body, nil); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 after too many attempts, got %d", code)
+	}
+
+	// Wrong codes add up across challenges and lock the second factor: 5 since the last success, the 10th locks it
+	for range 4 {
+		challenge = login()
+		do(http.MethodPost, "/api/auth/login/mfa", `{"mfa_token":"`+challenge.MFAToken+`","code":"aaaaa-aaaaa"}`, nil)
+	}
+	challenge = login()
+	if code := do(http.MethodPost, "/api/auth/login/mfa", `{"mfa_token":"`+challenge.MFAToken+`","code":"aaaaa-aaaaa"}`, nil); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 once the second factor is locked, got %d", code)
+	}
+	body = `{"mfa_token":"` + challenge.MFAToken + `","code":"` + recovery.RecoveryCodes[1] + `"}`
+	if code := do(http.MethodPost, "/api/auth/login/mfa", body, nil); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 for a valid code while locked, got %d", code)
+	}
+	if err := store.ResetMFAFailures(t.Context(), userID, time.Time{}); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+
+	var status authservice.MFAStatus
+	if code := do(http.MethodGet, "/api/auth/mfa", "", &status); code != http.StatusOK || !status.TOTPEnabled || status.RecoveryCodesLeft != 9 {
+		t.Errorf("unexpected MFA status %d %+v", code, status)
+	}
+	if code := do(http.MethodDelete, "/api/auth/mfa/totp", `{"code":"`+recovery.RecoveryCodes[2]+`"}`, nil); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	if code := do(http.MethodPost, "/api/auth/login", `{"login":"user","password":"password"}`, &tokens); code != http.StatusOK || tokens.Token == "" {
+		t.Errorf("expected login without MFA after disabling, got %d", code)
+	}
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+)
+
+// EmailVerificationSource reports whether a user's profile email is verified
+type EmailVerificationSource interface {
+	IsEmailVerified(ctx context.Context, userID string) (bool, error)
+}
+
+// emailVerification holds the configured email verification source
+var emailVerification struct {
+	mu     sync.RWMutex
+	source EmailVerificationSource
+}
+
+// SetEmailVerificationSource configures where the email_verified claim of user tokens is read from
+// A nil source leaves the claim out
+func SetEmailVerificationSource(source EmailVerificationSource) {
+	emailVerification.mu.Lock()
+	defer emailVerification.mu.Unlock()
+	emailVerification.source = source
+}
+
+// currentEmailVerified reports whether the user's email is verified; nil if no source is configured
+func currentEmailVerified(ctx context.Context, userID string) (*bool, error) {
+	emailVerification.mu.RLock()
+	source := emailVerification.source
+	emailVerification.mu.RUnlock()
+	if source == nil {
+		return nil, nil
+	}
+	verified, err := source.IsEmailVerified(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &verified, nil
+}
@@ -3,101 +3,440 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 // ContextKey represents the context key type
 type ContextKey string
 
+// CallerType tells who a verified token was issued to
+type CallerType string
+
+const (
+	// CallerUser is a user, possibly acting through an OAuth client
+	CallerUser CallerType = "user"
+
+	// CallerClient is an OAuth client acting on its own behalf (client_credentials)
+	CallerClient CallerType = "client"
+)
+
 // Claims represents the JWT claims structure for authentication
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID string `json:"user_id"`
+
+	// UserID is the authenticated user; empty for client credentials tokens
+	UserID string `json:"user_id,omitempty"`
+
+	// ClientID is the OAuth client the token was issued to
+	ClientID string `json:"client_id,omitempty"`
+
+	// Scope is the space separated list of granted OAuth scopes
+	Scope string `json:"scope,omitempty"`
+
+	// TokenVersion is the user's token version at issue time (see SetTokenVersionSource)
+	TokenVersion int `json:"ver,omitempty"`
+
+	// EmailVerified tells whether the user's email was verified at issue time (see SetEmailVerificationSource)
+	EmailVerified *bool `json:"email_verified,omitempty"`
+
+	// AuthTime is when the user last actively authenticated; refreshed tokens keep the original time
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+
+	// AMR lists the authentication methods used (RFC 8176), e.g. ["pwd", "otp", "mfa"]
+	AMR []string `json:"amr,omitempty"`
+
+	// ACR is the authentication context class reached (see ACRSingleFactor and ACRMultiFactor)
+	ACR string `json:"acr,omitempty"`
 }
 
 const (
 	// UserIDKey is the key for user ID in context
 	UserIDKey ContextKey = "user_id"
-	
+
+	// CallerTypeKey is the key for the CallerType in context
+	CallerTypeKey ContextKey = "caller_type"
+
+	// ClientIDKey is the key for the OAuth client ID in context
+	ClientIDKey ContextKey = "client_id"
+
+	// ScopeKey is the key for the granted scope in context
+	ScopeKey ContextKey = "scope"
+
+	// AuthenticationKey is the key for the user's Authentication in context
+	AuthenticationKey ContextKey = "authentication"
+
 	// tokenLT is the token lifetime
 	tokenLT = time.Hour * 24
 )
 
+// TokenSettings holds the registered claim settings shared by GenerateToken and JWTMiddleware
+type TokenSettings struct {
+	// Issuer is written to iss and required on verification when set
+	Issuer string
+
+	// Audience is written to aud; verification requires one of them when set
+	Audience []string
+
+	// Leeway is the allowed clock skew for exp, nbf and iat
+	Leeway time.Duration
+}
+
+// settings holds the configured token settings
+var settings struct {
+	mu    sync.RWMutex
+	value TokenSettings
+}
+
+// SetTokenSettings configures issuer, audience and clock skew handling
+func SetTokenSettings(value TokenSettings) {
+	settings.mu.Lock()
+	defer settings.mu.Unlock()
+	settings.value = value
+}
+
+// currentSettings returns the configured token settings
+func currentSettings() TokenSettings {
+	settings.mu.RLock()
+	defer settings.mu.RUnlock()
+	return settings.value
+}
+
+// signing holds the configured key ring
+var signing struct {
+	mu   sync.RWMutex
+	ring *KeyRing
+}
+
+// SetKeyRing configures the keys used by GenerateToken and JWTMiddleware
+// A nil ring restores the HS256 fallback based on JWT_SECRET
+func SetKeyRing(ring *KeyRing) {
+	signing.mu.Lock()
+	defer signing.mu.Unlock()
+	signing.ring = ring
+}
+
+// SetSigningKey configures a single key used for both signing and verification
+// A nil key restores the HS256 fallback based on JWT_SECRET
+func SetSigningKey(key *SigningKey) {
+	if key == nil {
+		SetKeyRing(nil)
+		return
+	}
+	SetKeyRing(NewKeyRing(key))
+}
+
+// currentRing returns the configured key ring or the JWT_SECRET fallback
+func currentRing() *KeyRing {
+	signing.mu.RLock()
+	defer signing.mu.RUnlock()
+	if signing.ring != nil {
+		return signing.ring
+	}
+	secretKey := os.Getenv("JWT_SECRET")
+	if secretKey == "" {
+		secretKey = "insecure-default-change-me"
+	}
+	return NewKeyRing(NewHMACKey("", []byte(secretKey)))
+}
+
+// RevocationList reports whether an access token ID was revoked
+type RevocationList interface {
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+var (
+	// ErrTokenRevoked is returned for access tokens on the denylist
+	ErrTokenRevoked = errors.New("token has been revoked")
+
+	// ErrRevocationUnavailable is returned when the denylist can not be checked
+	ErrRevocationUnavailable = errors.New("can not check token revocation")
+)
+
+// revocation holds the configured revocation list
+var revocation struct {
+	mu   sync.RWMutex
+	list RevocationList
+}
+
+// SetRevocationList configures the denylist checked by VerifyToken and JWTMiddleware
+// A nil list disables revocation checks
+func SetRevocationList(list RevocationList) {
+	revocation.mu.Lock()
+	defer revocation.mu.Unlock()
+	revocation.list = list
+}
+
+// currentRevocationList returns the configured revocation list, if any
+func currentRevocationList() RevocationList {
+	revocation.mu.RLock()
+	defer revocation.mu.RUnlock()
+	return revocation.list
+}
+
+// notBefore holds the global token cut-off
+var notBefore struct {
+	mu    sync.RWMutex
+	value time.Time
+}
+
+// SetNotBefore rejects every token issued before t ("break glass"); the zero time disables the check
+func SetNotBefore(t time.Time) {
+	notBefore.mu.Lock()
+	defer notBefore.mu.Unlock()
+	notBefore.value = t
+}
+
+// currentNotBefore returns the global token cut-off
+func currentNotBefore() time.Time {
+	notBefore.mu.RLock()
+	defer notBefore.mu.RUnlock()
+	return notBefore.value
+}
+
 // SetUserID is a helper function for tests to set user ID in context
 func SetUserID(ctx context.Context, userID string) context.Context {
 	return context.WithValue(ctx, UserIDKey, userID)
 }
 
+// CallerTypeFromContext returns the caller type set by JWTMiddleware
+func CallerTypeFromContext(ctx context.Context) CallerType {
+	callerType, _ := ctx.Value(CallerTypeKey).(CallerType)
+	return callerType
+}
+
+// ClientIDFromContext returns the OAuth client a verified token was issued to, or an empty string
+// for first-party tokens
+func ClientIDFromContext(ctx context.Context) string {
+	clientID, _ := ctx.Value(ClientIDKey).(string)
+	return clientID
+}
+
+// Caller returns the type of the token holder
+func (c *Claims) Caller() CallerType {
+	if c.UserID == "" && c.ClientID != "" {
+		return CallerClient
+	}
+	return CallerUser
+}
+
 // GenerateToken creates a new JWT token for the given user ID
 func GenerateToken(userID string) (string, error) {
-	secretKey := os.Getenv("JWT_SECRET")
-	if secretKey == "" {
-		secretKey = "insecure-default-change-me"
-	}
+	return GenerateTokenWithClaims(&Claims{UserID: userID})
+}
+
+// GenerateTokenWithClaims signs the given claims, filling in unset registered claims
+// sub defaults to the user ID, iss and aud come from the token settings
+func GenerateTokenWithClaims(claims *Claims) (string, error) {
+	key := currentRing().Active()
+	conf := currentSettings()
+	now := time.Now()
 
-	expirationTime := time.Now().Add(tokenLT)
-	
-	claims := &Claims{
-		UserID: userID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
+	if claims.Subject == "" {
+		claims.Subject = claims.UserID
+	}
+	if claims.Issuer == "" {
+		claims.Issuer = conf.Issuer
+	}
+	if claims.Audience == nil && len(conf.Audience) > 0 {
+		claims.Audience = conf.Audience
+	}
+	if claims.IssuedAt == nil {
+		claims.IssuedAt = jwt.NewNumericDate(now)
+	}
+	if claims.NotBefore == nil {
+		claims.NotBefore = jwt.NewNumericDate(now)
 	}
-	
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(secretKey))
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(now.Add(tokenLT))
+	}
+	if claims.ID == "" {
+		claims.ID = uuid.New().String()
+	}
+	if claims.UserID != "" && claims.TokenVersion == 0 {
+		// Read the version past the cache so a token issued right after a bump is not outdated
+		version, _, err := currentTokenVersion(context.Background(), claims.UserID, true)
+		if err != nil {
+			return "", fmt.Errorf("can not read token version: %w", err)
+		}
+		claims.TokenVersion = version
+	}
+	if claims.ACR == "" && len(claims.AMR) > 0 {
+		claims.ACR = ACRForMethods(claims.AMR)
+	}
+	if claims.UserID != "" && claims.EmailVerified == nil {
+		verified, err := currentEmailVerified(context.Background(), claims.UserID)
+		if err != nil {
+			return "", fmt.Errorf("can not read email verification: %w", err)
+		}
+		claims.EmailVerified = verified
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.private)
 	if err != nil {
 		return "", err
 	}
-	
+
 	return tokenString, nil
 }
 
-// JWTMiddleware provides JWT authorization middleware for auth service
-func JWTMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		secretKey := os.Getenv("JWT_SECRET")
-		if secretKey == "" {
-			secretKey = "insecure-default-change-me"
+// keyFunc resolves the verification key for a parsed token by its kid header
+// Tokens without kid were issued before key IDs existed and are checked against the active key
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	ring := currentRing()
+	key := ring.Active()
+	if kid, ok := token.Header["kid"].(string); ok {
+		var found bool
+		if key, found = ring.Lookup(kid); !found {
+			return nil, fmt.Errorf("unknown key id: %s", kid)
+		}
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.public, nil
+}
+
+// ParseToken verifies a token and returns its claims
+// Signature, exp, nbf, iat and the configured issuer and audience are checked
+func ParseToken(tokenString string) (*Claims, error) {
+	conf := currentSettings()
+	opts := []jwt.ParserOption{jwt.WithLeeway(conf.Leeway), jwt.WithIssuedAt()}
+	if conf.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(conf.Issuer))
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyFunc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if len(conf.Audience) > 0 && !audienceMatches(claims.Audience, conf.Audience) {
+		return nil, errors.New("token audience mismatch")
+	}
+	return claims, nil
+}
+
+// VerifyToken verifies a token like ParseToken and rejects tokens issued before the global cut-off,
+// tokens on the revocation list and user tokens older than the user's current token version
+func VerifyToken(ctx context.Context, tokenString string) (*Claims, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if cutoff := currentNotBefore(); !cutoff.IsZero() && (claims.IssuedAt == nil || claims.IssuedAt.Before(cutoff)) {
+		return nil, ErrTokenRevoked
+	}
+	if list := currentRevocationList(); list != nil && claims.ID != "" {
+		revoked, err := list.IsAccessTokenRevoked(ctx, claims.ID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRevocationUnavailable, err)
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
 		}
+	}
+	if claims.UserID != "" {
+		version, ok, err := currentTokenVersion(ctx, claims.UserID, false)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRevocationUnavailable, err)
+		}
+		if ok && claims.TokenVersion < version {
+			return nil, ErrTokenOutdated
+		}
+	}
+	return claims, nil
+}
 
-		// Get token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			// Try to get token from cookie
-			cookie, err := r.Cookie("token")
-			if err != nil {
-				http.Error(w, "Missing token", http.StatusUnauthorized)
-				return
+// audienceMatches reports whether any token audience is accepted
+func audienceMatches(tokenAudience, accepted []string) bool {
+	for _, aud := range tokenAudience {
+		for _, ok := range accepted {
+			if aud == ok {
+				return true
 			}
-			authHeader = "Bearer " + cookie.Value
 		}
+	}
+	return false
+}
 
-		// Parse token
-		tokenString := ""
-		if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-			tokenString = authHeader[7:]
-		} else {
-			http.Error(w, "Invalid token format", http.StatusUnauthorized)
-			return
+// TokenFromRequest extracts the bearer token from the Authorization header or the token cookie
+func TokenFromRequest(r *http.Request) (string, error) {
+	// Get token from Authorization header
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		// Try to get token from cookie
+		cookie, err := r.Cookie("token")
+		if err != nil {
+			return "", errors.New("Missing token")
 		}
+		authHeader = "Bearer " + cookie.Value
+	}
 
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(secretKey), nil
-		})
+	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
+		return authHeader[7:], nil
+	}
+	return "", errors.New("Invalid token format")
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+// JWTMiddleware provides JWT authorization middleware for auth service
+func JWTMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := authorize(w, r)
+		if !ok {
 			return
 		}
-
-		// Add user ID to context
-		ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r.WithContext(withClaims(r.Context(), claims)))
 	})
 }
+
+// authorize verifies the request's token, writing the error response if it is not valid
+func authorize(w http.ResponseWriter, r *http.Request) (*Claims, bool) {
+	tokenString, err := TokenFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	// Parse token and check the denylist
+	claims, err := VerifyToken(r.Context(), tokenString)
+	if errors.Is(err, ErrRevocationUnavailable) {
+		log.Println("Failed to verify token", err)
+		http.Error(w, "Can not verify token", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+	return claims, true
+}
+
+// withClaims adds the caller identity of verified claims to the context; client tokens carry no user ID
+func withClaims(ctx context.Context, claims *Claims) context.Context {
+	ctx = context.WithValue(ctx, CallerTypeKey, claims.Caller())
+	if claims.Caller() == CallerUser {
+		ctx = context.WithValue(ctx, UserIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, AuthenticationKey, claims.Authentication())
+	}
+	if claims.ClientID != "" {
+		ctx = context.WithValue(ctx, ClientIDKey, claims.ClientID)
+	}
+	if claims.Scope != "" {
+		ctx = context.WithValue(ctx, ScopeKey, claims.Scope)
+	}
+	return ctx
+}
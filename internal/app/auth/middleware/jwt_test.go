@@ -3,9 +3,13 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 func TestJWTMiddleware(t *testing.T) {
@@ -88,3 +92,208 @@ func TestSetUserID(t *testing.T) {
 		t.Errorf("Incorrect user ID in context: got %v want %v", userID.(string), "test-user-id")
 	}
 }
+
+func TestGenerateToken_RegisteredClaims(t *testing.T) {
+	SetTokenSettings(TokenSettings{Issuer: "https://auth.example.com", Audience: []string{"shortener"}})
+	defer SetTokenSettings(TokenSettings{})
+
+	token, err := GenerateToken("test-user-id")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if claims.Issuer != "https://auth.example.com" || claims.Subject != "test-user-id" || claims.ID == "" {
+		t.Errorf("Unexpected registered claims: %+v", claims.RegisteredClaims)
+	}
+	if claims.IssuedAt == nil || claims.NotBefore == nil || len(claims.Audience) != 1 || claims.Audience[0] != "shortener" {
+		t.Errorf("Missing iat/nbf/aud claims: %+v", claims.RegisteredClaims)
+	}
+}
+
+func TestParseToken_IssuerAndAudience(t *testing.T) {
+	defer SetTokenSettings(TokenSettings{})
+
+	SetTokenSettings(TokenSettings{Issuer: "https://other.example.com", Audience: []string{"other"}})
+	token, err := GenerateToken("test-user-id")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		settings TokenSettings
+		wantErr  bool
+	}{
+		{name: "matching", settings: TokenSettings{Issuer: "https://other.example.com", Audience: []string{"shortener", "other"}}},
+		{name: "wrong issuer", settings: TokenSettings{Issuer: "https://auth.example.com"}, wantErr: true},
+		{name: "wrong audience", settings: TokenSettings{Issuer: "https://other.example.com", Audience: []string{"shortener"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetTokenSettings(tt.settings)
+			_, err := ParseToken(token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseToken_Leeway(t *testing.T) {
+	defer SetTokenSettings(TokenSettings{})
+
+	claims := &Claims{UserID: "test-user-id"}
+	claims.NotBefore = jwt.NewNumericDate(time.Now().Add(10 * time.Second))
+	token, err := GenerateTokenWithClaims(claims)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	if _, err := ParseToken(token); err == nil {
+		t.Error("Expected error for token not yet valid without leeway")
+	}
+	SetTokenSettings(TokenSettings{Leeway: 30 * time.Second})
+	if _, err := ParseToken(token); err != nil {
+		t.Errorf("Expected token within leeway to be valid, got %v", err)
+	}
+}
+
+func TestJWTMiddleware_CallerType(t *testing.T) {
+	tests := []struct {
+		name       string
+		claims     *Claims
+		wantCaller CallerType
+		wantUserID interface{}
+	}{
+		{name: "user", claims: &Claims{UserID: "test-user-id"}, wantCaller: CallerUser, wantUserID: "test-user-id"},
+		{name: "user via client", claims: &Claims{UserID: "test-user-id", ClientID: "spa"}, wantCaller: CallerUser, wantUserID: "test-user-id"},
+		{name: "client", claims: &Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "svc"}, ClientID: "svc", Scope: "links:read"}, wantCaller: CallerClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := GenerateTokenWithClaims(tt.claims)
+			if err != nil {
+				t.Fatalf("Failed to generate token: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := CallerTypeFromContext(r.Context()); got != tt.wantCaller {
+					t.Errorf("Expected caller %q, got %q", tt.wantCaller, got)
+				}
+				if got := r.Context().Value(UserIDKey); got != tt.wantUserID {
+					t.Errorf("Expected user ID %v, got %v", tt.wantUserID, got)
+				}
+				if got := r.Context().Value(ClientIDKey); tt.claims.ClientID != "" && got != tt.claims.ClientID {
+					t.Errorf("Expected client ID %q, got %v", tt.claims.ClientID, got)
+				}
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", rr.Code)
+			}
+		})
+	}
+}
+
+// fakeRevocationList is an in-memory RevocationList
+type fakeRevocationList struct {
+	revoked map[string]bool
+	err     error
+}
+
+func (f *fakeRevocationList) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return f.revoked[jti], f.err
+}
+
+func TestJWTMiddleware_RevokedToken(t *testing.T) {
+	defer SetRevocationList(nil)
+
+	claims := &Claims{UserID: "test-user-id"}
+	token, err := GenerateTokenWithClaims(claims)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	list := &fakeRevocationList{revoked: map[string]bool{}}
+	SetRevocationList(list)
+
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("Expected status 200 before revocation, got %d", code)
+	}
+	list.revoked[claims.ID] = true
+	if code := serve(); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for revoked token, got %d", code)
+	}
+	if _, err := VerifyToken(context.Background(), token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected ErrTokenRevoked, got %v", err)
+	}
+	list.err = errors.New("database down")
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 when the denylist is unavailable, got %d", code)
+	}
+}
+
+type fakeTokenVersions struct {
+	versions map[string]int
+	calls    int
+}
+
+func (f *fakeTokenVersions) GetTokenVersion(ctx context.Context, userID string) (int, error) {
+	f.calls++
+	return f.versions[userID], nil
+}
+
+func TestVerifyToken_TokenVersion(t *testing.T) {
+	defer SetTokenVersionSource(nil, 0)
+
+	source := &fakeTokenVersions{versions: map[string]int{"test-user-id": 1}}
+	SetTokenVersionSource(source, time.Minute)
+
+	token, err := GenerateToken("test-user-id")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, err := VerifyToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Expected token stamped with the current version to verify, got %v", err)
+	}
+	if claims.TokenVersion != 1 {
+		t.Errorf("Expected token version 1, got %d", claims.TokenVersion)
+	}
+
+	// The bump is only seen once the cached version is dropped
+	source.versions["test-user-id"] = 2
+	if _, err := VerifyToken(context.Background(), token); err != nil {
+		t.Errorf("Expected cached version to be used, got %v", err)
+	}
+	calls := source.calls
+	ForgetTokenVersion("test-user-id")
+	if _, err := VerifyToken(context.Background(), token); !errors.Is(err, ErrTokenOutdated) {
+		t.Errorf("Expected ErrTokenOutdated, got %v", err)
+	}
+	if source.calls != calls+1 {
+		t.Errorf("Expected one lookup after forgetting the version, got %d", source.calls-calls)
+	}
+
+	// Client tokens carry no user and are not checked
+	clientToken, err := GenerateTokenWithClaims(&Claims{ClientID: "svc"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	if _, err := VerifyToken(context.Background(), clientToken); err != nil {
+		t.Errorf("Expected client token to verify, got %v", err)
+	}
+}
@@ -0,0 +1,375 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxTokenLifetime is the longest lifetime of an issued access token
+// A key that stopped signing must stay verifiable at least this long
+const MaxTokenLifetime = tokenLT
+
+// MinPublishTime is how long a key must be in the ring before it can be promoted
+// Running instances re-read the ring within a minute, and verifiers cache the JWK set for JWKSMaxAge;
+// a key signing earlier would produce tokens they can not verify yet
+const MinPublishTime = JWKSMaxAge + time.Minute
+
+// keyRingManifest is the name of the key ring manifest inside the keys directory
+const keyRingManifest = "keyring.json"
+
+var (
+	// ErrKeyNotFound is returned when a key ID is not in the ring
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrKeyActive is returned when trying to retire the active signing key
+	ErrKeyActive = errors.New("key is the active signing key")
+
+	// ErrKeyInUse is returned when tokens signed by the key may still be valid
+	ErrKeyInUse = errors.New("key may still verify unexpired tokens")
+
+	// ErrKeyNotPublished is returned when promoting a key verifiers may not have fetched yet
+	ErrKeyNotPublished = errors.New("key has not been published long enough")
+)
+
+// ringEntry is a key ring member
+type ringEntry struct {
+	key *SigningKey
+
+	// retireAt is when the last token signed by the key expires (zero if the key never stopped signing)
+	retireAt time.Time
+
+	// publishedAt is when the key was added to the ring (zero if it was there before this was recorded)
+	publishedAt time.Time
+}
+
+// KeyRing holds one active signing key and any number of verify-only keys selected by kid
+type KeyRing struct {
+	mu     sync.RWMutex
+	dir    string
+	active string
+	keys   map[string]*ringEntry
+}
+
+// KeyInfo describes a key ring member
+type KeyInfo struct {
+	KeyID       string     `json:"kid"`
+	Alg         string     `json:"alg"`
+	Active      bool       `json:"active"`
+	RetireAt    *time.Time `json:"retire_at,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+}
+
+// NewKeyRing creates an in-memory key ring
+// active signs new tokens; verifyOnly keys are only used to verify existing ones
+func NewKeyRing(active *SigningKey, verifyOnly ...*SigningKey) *KeyRing {
+	r := &KeyRing{
+		active: active.ID,
+		keys:   map[string]*ringEntry{active.ID: {key: active}},
+	}
+	for _, key := range verifyOnly {
+		r.keys[key.ID] = &ringEntry{key: key}
+	}
+	return r
+}
+
+// Active returns the signing key
+func (r *KeyRing) Active() *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keys[r.active].key
+}
+
+// Lookup returns the key with the given ID
+func (r *KeyRing) Lookup(kid string) (*SigningKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.keys[kid]
+	if !ok {
+		return nil, false
+	}
+	return entry.key, true
+}
+
+// Keys returns all keys, the active key first
+func (r *KeyRing) Keys() []*SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := []*SigningKey{r.keys[r.active].key}
+	for _, kid := range r.sortedIDs() {
+		if kid != r.active {
+			keys = append(keys, r.keys[kid].key)
+		}
+	}
+	return keys
+}
+
+// Info describes all keys in the ring
+func (r *KeyRing) Info() []KeyInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	infos := make([]KeyInfo, 0, len(r.keys))
+	for _, kid := range r.sortedIDs() {
+		infos = append(infos, r.info(kid))
+	}
+	return infos
+}
+
+// info describes a single key; the caller must hold the lock
+func (r *KeyRing) info(kid string) KeyInfo {
+	entry := r.keys[kid]
+	info := KeyInfo{KeyID: kid, Alg: entry.key.Method.Alg(), Active: kid == r.active}
+	if !entry.retireAt.IsZero() {
+		retireAt := entry.retireAt
+		info.RetireAt = &retireAt
+	}
+	if !entry.publishedAt.IsZero() {
+		publishedAt := entry.publishedAt
+		info.PublishedAt = &publishedAt
+	}
+	return info
+}
+
+// sortedIDs returns key IDs in lexicographic order; the caller must hold the lock
+func (r *KeyRing) sortedIDs() []string {
+	ids := make([]string, 0, len(r.keys))
+	for kid := range r.keys {
+		ids = append(ids, kid)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Add inserts a verify-only key published at now
+func (r *KeyRing) Add(key *SigningKey, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.keys[key.ID]; ok {
+		return fmt.Errorf("key already exists: %s", key.ID)
+	}
+	r.keys[key.ID] = &ringEntry{key: key, publishedAt: now}
+	return nil
+}
+
+// Promote makes the given key the active signing key
+// The key must have been published for MinPublishTime, so verifiers caching the JWK set know it;
+// the previous active key stays verify-only until MaxTokenLifetime has passed
+func (r *KeyRing) Promote(kid string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.keys[kid]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if kid == r.active {
+		return nil
+	}
+	if now.Before(entry.publishedAt.Add(MinPublishTime)) {
+		return fmt.Errorf("%w: promote %s after %s", ErrKeyNotPublished, kid,
+			entry.publishedAt.Add(MinPublishTime).Format(time.RFC3339))
+	}
+	r.keys[r.active].retireAt = now.Add(MaxTokenLifetime)
+	entry.retireAt = time.Time{}
+	r.active = kid
+	return nil
+}
+
+// Retire removes a verify-only key once no token signed by it can still be valid
+func (r *KeyRing) Retire(kid string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.keys[kid]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if kid == r.active {
+		return ErrKeyActive
+	}
+	if now.Before(entry.retireAt) {
+		return ErrKeyInUse
+	}
+	delete(r.keys, kid)
+	return nil
+}
+
+// RetireExpired removes every key whose retirement time has passed
+// Returns the IDs of removed keys
+func (r *KeyRing) RetireExpired(now time.Time) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var retired []string
+	for _, kid := range r.sortedIDs() {
+		entry := r.keys[kid]
+		if kid != r.active && !entry.retireAt.IsZero() && !now.Before(entry.retireAt) {
+			delete(r.keys, kid)
+			retired = append(retired, kid)
+		}
+	}
+	return retired
+}
+
+// manifest is the persisted form of a key ring
+type manifest struct {
+	Active string        `json:"active"`
+	Keys   []manifestKey `json:"keys"`
+}
+
+// manifestKey is the persisted form of a key ring member
+type manifestKey struct {
+	KeyID       string     `json:"kid"`
+	Alg         string     `json:"alg"`
+	RetireAt    *time.Time `json:"retire_at,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+}
+
+// LoadKeyRing loads a key ring from a directory
+// The directory holds keyring.json and one <kid>.pem (or <kid>.secret for HMAC) file per key
+func LoadKeyRing(dir string) (*KeyRing, error) {
+	r := &KeyRing{dir: dir}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the key ring from its directory
+func (r *KeyRing) Reload() error {
+	if r.dir == "" {
+		return errors.New("key ring is not backed by a directory")
+	}
+	data, err := os.ReadFile(filepath.Join(r.dir, keyRingManifest))
+	if err != nil {
+		return fmt.Errorf("can not read key ring manifest: %w", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("can not parse key ring manifest: %w", err)
+	}
+	keys := make(map[string]*ringEntry, len(m.Keys))
+	for _, info := range m.Keys {
+		key, err := loadRingKey(r.dir, info.KeyID, info.Alg)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", info.KeyID, err)
+		}
+		entry := &ringEntry{key: key}
+		if info.RetireAt != nil {
+			entry.retireAt = *info.RetireAt
+		}
+		if info.PublishedAt != nil {
+			entry.publishedAt = *info.PublishedAt
+		}
+		keys[info.KeyID] = entry
+	}
+	if _, ok := keys[m.Active]; !ok {
+		return fmt.Errorf("active key %q is not in the key ring", m.Active)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active = m.Active
+	r.keys = keys
+	return nil
+}
+
+// Save writes the key ring manifest back to its directory
+func (r *KeyRing) Save() error {
+	if r.dir == "" {
+		return errors.New("key ring is not backed by a directory")
+	}
+	r.mu.RLock()
+	m := manifest{Active: r.active}
+	for _, kid := range r.sortedIDs() {
+		info := r.info(kid)
+		m.Keys = append(m.Keys, manifestKey{KeyID: info.KeyID, Alg: info.Alg, RetireAt: info.RetireAt, PublishedAt: info.PublishedAt})
+	}
+	r.mu.RUnlock()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(r.dir, keyRingManifest+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(r.dir, keyRingManifest))
+}
+
+// CreateKeyRing initialises a key ring directory with a single active key
+func CreateKeyRing(dir, kid, alg string, data []byte) (*KeyRing, error) {
+	key, err := writeRingKey(dir, kid, alg, data)
+	if err != nil {
+		return nil, err
+	}
+	r := NewKeyRing(key)
+	r.dir = dir
+	if err := r.Save(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ImportKey copies key material into the ring directory and adds it as a verify-only key published at now
+func (r *KeyRing) ImportKey(kid, alg string, data []byte, now time.Time) (*SigningKey, error) {
+	if r.dir == "" {
+		return nil, errors.New("key ring is not backed by a directory")
+	}
+	if _, ok := r.Lookup(kid); ok {
+		return nil, fmt.Errorf("key already exists: %s", kid)
+	}
+	key, err := writeRingKey(r.dir, kid, alg, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Add(key, now); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ringKeyPath returns the key file path for the given key ID and algorithm
+func ringKeyPath(dir, kid, alg string) string {
+	if alg == "HS256" {
+		return filepath.Join(dir, kid+".secret")
+	}
+	return filepath.Join(dir, kid+".pem")
+}
+
+// parseRingKey builds a key from raw key file contents
+func parseRingKey(kid, alg string, data []byte) (*SigningKey, error) {
+	if alg == "HS256" {
+		return NewHMACKey(kid, bytes.TrimSpace(data)), nil
+	}
+	return ParseSigningKey(kid, alg, data)
+}
+
+// writeRingKey validates key material and writes it into the ring directory
+func writeRingKey(dir, kid, alg string, data []byte) (*SigningKey, error) {
+	if kid == "" || strings.ContainsAny(kid, `/\`) || strings.HasPrefix(kid, ".") {
+		return nil, fmt.Errorf("invalid key id: %q", kid)
+	}
+	key, err := parseRingKey(kid, alg, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(ringKeyPath(dir, kid, alg), data, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// loadRingKey reads a single key file from the ring directory
+func loadRingKey(dir, kid, alg string) (*SigningKey, error) {
+	data, err := os.ReadFile(ringKeyPath(dir, kid, alg))
+	if err != nil {
+		return nil, err
+	}
+	return parseRingKey(kid, alg, data)
+}
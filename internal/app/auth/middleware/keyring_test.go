@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestKey creates an ES256 key with the given ID
+func newTestKey(t *testing.T, kid string) *SigningKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	key, err := NewSigningKey(kid, "ES256", private)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	return key
+}
+
+// verify runs a token through JWTMiddleware and returns the status code
+func verify(token string) int {
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestKeyRing_Rotation(t *testing.T) {
+	oldKey, newKey := newTestKey(t, "old"), newTestKey(t, "new")
+	ring := NewKeyRing(oldKey, newKey)
+	SetKeyRing(ring)
+	defer SetKeyRing(nil)
+
+	oldToken, err := GenerateToken("test-user-id")
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	now := time.Now()
+	if err := ring.Promote("new", now); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	if ring.Active().ID != "new" {
+		t.Fatalf("expected new active key, got %s", ring.Active().ID)
+	}
+	newToken, err := GenerateToken("test-user-id")
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	// Both generations verify while the old key is kept
+	if code := verify(oldToken); code != http.StatusOK {
+		t.Errorf("old token: expected 200, got %d", code)
+	}
+	if code := verify(newToken); code != http.StatusOK {
+		t.Errorf("new token: expected 200, got %d", code)
+	}
+
+	if err := ring.Retire("new", now); !errors.Is(err, ErrKeyActive) {
+		t.Errorf("expected ErrKeyActive, got %v", err)
+	}
+	if err := ring.Retire("old", now); !errors.Is(err, ErrKeyInUse) {
+		t.Errorf("expected ErrKeyInUse, got %v", err)
+	}
+	if retired := ring.RetireExpired(now.Add(MaxTokenLifetime)); len(retired) != 1 || retired[0] != "old" {
+		t.Errorf("expected old key retired, got %v", retired)
+	}
+	if code := verify(oldToken); code != http.StatusUnauthorized {
+		t.Errorf("old token after retirement: expected 401, got %d", code)
+	}
+}
+
+func TestKeyRing_SaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	first, err := CreateKeyRing(dir, "k1", "HS256", []byte("first-secret\n"))
+	if err != nil {
+		t.Fatalf("create key ring: %v", err)
+	}
+	now := time.Now()
+	if _, err := first.ImportKey("k2", "HS256", []byte("second-secret"), now); err != nil {
+		t.Fatalf("import key: %v", err)
+	}
+	if err := first.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// A new key can not sign before verifiers caching the JWK set have had a chance to fetch it
+	if err := first.Promote("k2", now.Add(JWKSMaxAge)); !errors.Is(err, ErrKeyNotPublished) {
+		t.Errorf("expected ErrKeyNotPublished, got %v", err)
+	}
+	// The publication time survives a reload
+	reloaded, err := LoadKeyRing(dir)
+	if err != nil {
+		t.Fatalf("load key ring: %v", err)
+	}
+	if err := reloaded.Promote("k2", now); !errors.Is(err, ErrKeyNotPublished) {
+		t.Errorf("expected ErrKeyNotPublished after reload, got %v", err)
+	}
+	if err := first.Promote("k2", now.Add(MinPublishTime)); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	if err := first.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded, err := LoadKeyRing(dir)
+	if err != nil {
+		t.Fatalf("load key ring: %v", err)
+	}
+	if loaded.Active().ID != "k2" {
+		t.Errorf("expected k2 active, got %s", loaded.Active().ID)
+	}
+	infos := loaded.Info()
+	if len(infos) != 2 || infos[0].KeyID != "k1" || infos[0].RetireAt == nil || infos[1].PublishedAt == nil {
+		t.Errorf("unexpected key info: %+v", infos)
+	}
+	if _, err := first.ImportKey("../evil", "HS256", []byte("x"), now); err == nil {
+		t.Error("expected error for path-like key id")
+	}
+}
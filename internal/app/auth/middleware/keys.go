@@ -0,0 +1,264 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultHMACKeyID is the key ID used for HMAC keys when none is configured
+const DefaultHMACKeyID = "default"
+
+// JWKSMaxAge is how long clients may cache the JWK set
+const JWKSMaxAge = 5 * time.Minute
+
+// SigningKey is a key used to sign and verify JWTs
+type SigningKey struct {
+	// ID is the key identifier written to the kid header
+	ID string
+
+	// Method is the JWT signing method
+	Method jwt.SigningMethod
+
+	// private is the signing key: []byte for HMAC, crypto.Signer otherwise
+	private interface{}
+
+	// public is the verification key: []byte for HMAC, crypto.PublicKey otherwise
+	public interface{}
+}
+
+// JWK represents a public JSON Web Key (RFC 7517)
+type JWK struct {
+	KeyType string `json:"kty"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	KeyID   string `json:"kid"`
+	Curve   string `json:"crv,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+}
+
+// JWKSet represents a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewHMACKey creates a symmetric HS256 key
+// An empty id falls back to DefaultHMACKeyID
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	if id == "" {
+		id = DefaultHMACKeyID
+	}
+	return &SigningKey{
+		ID:      id,
+		Method:  jwt.SigningMethodHS256,
+		private: secret,
+		public:  secret,
+	}
+}
+
+// NewSigningKey creates an asymmetric key for the given algorithm
+// An empty id falls back to the RFC 7638 thumbprint of the public key
+func NewSigningKey(id, alg string, private crypto.Signer) (*SigningKey, error) {
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if err := checkKeyType(alg, private); err != nil {
+		return nil, err
+	}
+	key := &SigningKey{
+		ID:      id,
+		Method:  method,
+		private: private,
+		public:  private.Public(),
+	}
+	if key.ID == "" {
+		jwk, _ := key.JWK()
+		thumbprint, err := jwkThumbprint(jwk)
+		if err != nil {
+			return nil, err
+		}
+		key.ID = thumbprint
+	}
+	return key, nil
+}
+
+// ParseSigningKey parses a PEM encoded private key for the given algorithm
+func ParseSigningKey(id, alg string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var (
+		private interface{}
+		err     error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not parse private key: %w", err)
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key can not sign")
+	}
+	return NewSigningKey(id, alg, signer)
+}
+
+// LoadSigningKey reads a PEM encoded private key from a file
+func LoadSigningKey(id, alg, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read key file: %w", err)
+	}
+	return ParseSigningKey(id, alg, data)
+}
+
+// checkKeyType ensures the private key matches the algorithm family
+func checkKeyType(alg string, private crypto.Signer) error {
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		switch alg {
+		case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+			return nil
+		}
+	case *ecdsa.PrivateKey:
+		expected := map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()}
+		if curve, ok := expected[alg]; ok && curve == k.Curve {
+			return nil
+		}
+	case ed25519.PrivateKey:
+		if alg == "EdDSA" {
+			return nil
+		}
+	}
+	return fmt.Errorf("key type %T does not match algorithm %s", private, alg)
+}
+
+// Symmetric reports whether the key is a shared secret
+func (k *SigningKey) Symmetric() bool {
+	_, ok := k.public.([]byte)
+	return ok
+}
+
+// JWK returns the public part of the key as a JWK
+// Returns false for symmetric keys, which must never be published
+func (k *SigningKey) JWK() (JWK, bool) {
+	jwk := JWK{Use: "sig", Alg: k.Method.Alg(), KeyID: k.ID}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+// jwkThumbprint computes the RFC 7638 thumbprint of a public JWK
+func jwkThumbprint(jwk JWK) (string, error) {
+	// Required members only, in lexicographic order
+	var members interface{}
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type: %s", jwk.KeyType)
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return b64(sum[:]), nil
+}
+
+// b64 encodes bytes as unpadded base64url
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// JWKSHandler serves the public keys of the key ring as a JWK set
+func JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET requests are allowed!", http.StatusMethodNotAllowed)
+			return
+		}
+		set := JWKSet{Keys: []JWK{}}
+		for _, key := range currentRing().Keys() {
+			if jwk, ok := key.JWK(); ok {
+				set.Keys = append(set.Keys, jwk)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(JWKSMaxAge.Seconds())))
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(set)
+	})
+}
+
+// SigningAlgorithms returns the distinct algorithms of the key ring, the active one first
+func SigningAlgorithms() []string {
+	var algs []string
+	seen := make(map[string]bool)
+	for _, key := range currentRing().Keys() {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// pemKey encodes a private key as PKCS#8 PEM
+func pemKey(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		alg string
+		key crypto.Signer
+		kty string
+	}{
+		{alg: "RS256", key: rsaKey, kty: "RSA"},
+		{alg: "ES256", key: ecKey, kty: "EC"},
+		{alg: "EdDSA", key: edKey, kty: "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			key, err := ParseSigningKey("", tt.alg, pemKey(t, tt.key))
+			if err != nil {
+				t.Fatalf("parse key: %v", err)
+			}
+			SetSigningKey(key)
+			defer SetSigningKey(nil)
+
+			token, err := GenerateToken("test-user-id")
+			if err != nil {
+				t.Fatalf("generate token: %v", err)
+			}
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+			if err != nil {
+				t.Fatalf("parse token: %v", err)
+			}
+			if parsed.Header["kid"] != key.ID || parsed.Method.Alg() != tt.alg {
+				t.Errorf("unexpected header: %v", parsed.Header)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Errorf("expected 200, got %d", rr.Code)
+			}
+
+			rr = httptest.NewRecorder()
+			JWKSHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+			var set JWKSet
+			if err := json.NewDecoder(rr.Body).Decode(&set); err != nil {
+				t.Fatalf("decode jwks: %v", err)
+			}
+			if len(set.Keys) != 1 || set.Keys[0].KeyID != key.ID || set.Keys[0].KeyType != tt.kty {
+				t.Errorf("unexpected jwks: %+v", set)
+			}
+		})
+	}
+}
+
+func TestParseSigningKey_AlgorithmMismatch(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := ParseSigningKey("", "RS256", pemKey(t, ecKey)); err == nil {
+		t.Error("expected error for EC key with RS256")
+	}
+}
+
+func TestJWTMiddleware_RejectsForeignKey(t *testing.T) {
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, err := NewSigningKey("other", "ES256", otherKey)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	SetSigningKey(other)
+	token, err := GenerateToken("test-user-id")
+	SetSigningKey(nil)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestJWKSHandler_HidesSymmetricKey(t *testing.T) {
+	rr := httptest.NewRecorder()
+	JWKSHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	var set JWKSet
+	if err := json.NewDecoder(rr.Body).Decode(&set); err != nil {
+		t.Fatalf("decode jwks: %v", err)
+	}
+	if len(set.Keys) != 0 {
+		t.Errorf("expected no published keys for HS256, got %+v", set.Keys)
+	}
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Authentication methods recorded in the amr claim (RFC 8176)
+const (
+	AMRPassword     = "pwd"
+	AMROTP          = "otp"
+	AMRHardwareKey  = "hwk"
+	AMRUserPresence = "user"
+	AMRMultiFactor  = "mfa"
+)
+
+// Authentication context classes recorded in the acr claim, named after the NIST SP 800-63B assurance levels
+const (
+	ACRSingleFactor = "aal1"
+	ACRMultiFactor  = "aal2"
+)
+
+// acrLevels orders the known authentication context classes; a higher level satisfies a lower one
+var acrLevels = map[string]int{
+	ACRSingleFactor: 1,
+	ACRMultiFactor:  2,
+}
+
+// ErrInsufficientAuthentication is the error code of the step-up challenge (RFC 9470)
+const ErrInsufficientAuthentication = "insufficient_user_authentication"
+
+// Authentication describes how and when a user actively authenticated
+type Authentication struct {
+	// Time is when the user authenticated; zero if unknown
+	Time time.Time
+
+	// Methods are the amr values of the authentication
+	Methods []string
+}
+
+// NewAuthentication records an authentication with the given methods happening now
+func NewAuthentication(methods ...string) Authentication {
+	return Authentication{Time: time.Now(), Methods: methods}
+}
+
+// ACR returns the authentication context class reached by the authentication
+func (a Authentication) ACR() string {
+	return ACRForMethods(a.Methods)
+}
+
+// ACRForMethods returns the authentication context class reached by the given amr values
+func ACRForMethods(methods []string) string {
+	switch {
+	case len(methods) == 0:
+		return ""
+	case slices.Contains(methods, AMRMultiFactor):
+		return ACRMultiFactor
+	default:
+		return ACRSingleFactor
+	}
+}
+
+// Authentication returns how and when the token holder authenticated
+// Tokens issued without auth_time (e.g. to OAuth clients) yield a zero Time
+func (c *Claims) Authentication() Authentication {
+	authn := Authentication{Methods: c.AMR}
+	if c.AuthTime != nil {
+		authn.Time = c.AuthTime.Time
+	}
+	return authn
+}
+
+// GenerateAuthenticatedToken creates a new JWT token recording the user's authentication
+func GenerateAuthenticatedToken(userID string, authn Authentication) (string, error) {
+	claims := &Claims{UserID: userID, AMR: authn.Methods}
+	if !authn.Time.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(authn.Time)
+	}
+	return GenerateTokenWithClaims(claims)
+}
+
+// AuthenticationFromContext returns the authentication set by JWTMiddleware for user tokens
+func AuthenticationFromContext(ctx context.Context) (Authentication, bool) {
+	authn, ok := ctx.Value(AuthenticationKey).(Authentication)
+	return authn, ok
+}
+
+// AuthPolicy describes how recent and how strong a user's authentication must be
+// The zero value accepts every user token
+type AuthPolicy struct {
+	// MaxAge is the longest time since the user authenticated; zero means any age
+	MaxAge time.Duration
+
+	// ACR is the least authentication context class required, e.g. ACRMultiFactor
+	ACR string
+
+	// Methods are amr values that must all be present
+	Methods []string
+}
+
+// IsZero reports whether the policy sets no requirement
+func (p AuthPolicy) IsZero() bool {
+	return p.MaxAge <= 0 && p.ACR == "" && len(p.Methods) == 0
+}
+
+// Check returns a description of the first requirement the authentication does not meet, or an empty string
+func (p AuthPolicy) Check(authn Authentication, now time.Time) string {
+	if p.MaxAge > 0 && (authn.Time.IsZero() || now.Sub(authn.Time) > p.MaxAge) {
+		return "A more recent authentication is required"
+	}
+	if p.ACR != "" {
+		required, known := acrLevels[p.ACR]
+		if !known || acrLevels[authn.ACR()] < required {
+			return "A stronger authentication is required"
+		}
+	}
+	for _, method := range p.Methods {
+		if !slices.Contains(authn.Methods, method) {
+			return fmt.Sprintf("Authentication with %q is required", method)
+		}
+	}
+	return ""
+}
+
+// stepUpChallenge is the JSON body of the step-up challenge
+type stepUpChallenge struct {
+	Error            string   `json:"error"`
+	ErrorDescription string   `json:"error_description"`
+	MaxAge           int64    `json:"max_age,omitempty"`
+	ACRValues        string   `json:"acr_values,omitempty"`
+	AMRValues        []string `json:"amr_values,omitempty"`
+}
+
+// writeChallenge answers with the insufficient_user_authentication challenge so the client can re-authenticate
+// The WWW-Authenticate header follows RFC 9470; the body repeats it and adds the required amr values
+func (p AuthPolicy) writeChallenge(w http.ResponseWriter, description string) {
+	challenge := stepUpChallenge{
+		Error:            ErrInsufficientAuthentication,
+		ErrorDescription: description,
+		ACRValues:        p.ACR,
+		AMRValues:        p.Methods,
+	}
+	params := []string{
+		fmt.Sprintf("error=%q", challenge.Error),
+		fmt.Sprintf("error_description=%q", challenge.ErrorDescription),
+	}
+	if p.ACR != "" {
+		params = append(params, fmt.Sprintf("acr_values=%q", p.ACR))
+	}
+	if p.MaxAge > 0 {
+		challenge.MaxAge = int64(p.MaxAge / time.Second)
+		params = append(params, fmt.Sprintf("max_age=%d", challenge.MaxAge))
+	}
+
+	w.Header().Set("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	if err := json.NewEncoder(w).Encode(challenge); err != nil {
+		log.Println("Can not encode response", err)
+	}
+}
+
+// RequireAuthentication checks the authentication of a request that passed JWTMiddleware against the policy
+// If it is not met the challenge is written and false returned; handlers use it for operations that need step-up only sometimes
+func RequireAuthentication(w http.ResponseWriter, r *http.Request, policy AuthPolicy) bool {
+	if policy.IsZero() {
+		return true
+	}
+	// Client tokens carry no authentication and meet no requirement
+	authn, _ := AuthenticationFromContext(r.Context())
+	if description := policy.Check(authn, time.Now()); description != "" {
+		policy.writeChallenge(w, description)
+		return false
+	}
+	return true
+}
+
+// StepUpMiddleware works like JWTMiddleware and additionally demands the policy's authentication
+// Client tokens and tokens without auth_time never meet a MaxAge requirement
+func StepUpMiddleware(policy AuthPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := authorize(w, r)
+		if !ok {
+			return
+		}
+		r = r.WithContext(withClaims(r.Context(), claims))
+		if !RequireAuthentication(w, r, policy) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
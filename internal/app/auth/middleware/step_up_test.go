@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestGenerateAuthenticatedToken(t *testing.T) {
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	token, err := GenerateAuthenticatedToken("test-user-id", Authentication{Time: authTime, Methods: []string{AMRPassword, AMROTP, AMRMultiFactor}})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if claims.AuthTime == nil || !claims.AuthTime.Time.Equal(authTime) {
+		t.Errorf("Expected auth_time %v, got %v", authTime, claims.AuthTime)
+	}
+	if claims.ACR != ACRMultiFactor || len(claims.AMR) != 3 {
+		t.Errorf("Expected acr %q and three amr values, got %q %v", ACRMultiFactor, claims.ACR, claims.AMR)
+	}
+}
+
+func TestStepUpMiddleware(t *testing.T) {
+	policy := AuthPolicy{MaxAge: 5 * time.Minute, ACR: ACRMultiFactor}
+	handler := StepUpMiddleware(policy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authn, ok := AuthenticationFromContext(r.Context()); !ok || authn.ACR() != ACRMultiFactor {
+			t.Errorf("Expected multi-factor authentication in context, got %+v", authn)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name     string
+		claims   *Claims
+		wantCode int
+	}{
+		{
+			name:     "recent multi-factor",
+			claims:   &Claims{UserID: "test-user-id", AuthTime: jwt.NewNumericDate(time.Now()), AMR: []string{AMRHardwareKey, AMRUserPresence, AMRMultiFactor}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "too old",
+			claims:   &Claims{UserID: "test-user-id", AuthTime: jwt.NewNumericDate(time.Now().Add(-time.Hour)), AMR: []string{AMRPassword, AMROTP, AMRMultiFactor}},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "single factor",
+			claims:   &Claims{UserID: "test-user-id", AuthTime: jwt.NewNumericDate(time.Now()), AMR: []string{AMRPassword}},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "without auth_time",
+			claims:   &Claims{UserID: "test-user-id"},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "client",
+			claims:   &Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "svc"}, ClientID: "svc"},
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := GenerateTokenWithClaims(tt.claims)
+			if err != nil {
+				t.Fatalf("Failed to generate token: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodDelete, "/test", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("Expected status %d, got %d", tt.wantCode, rr.Code)
+			}
+			if rr.Code == http.StatusOK {
+				return
+			}
+
+			header := rr.Header().Get("WWW-Authenticate")
+			for _, want := range []string{`Bearer error="insufficient_user_authentication"`, `acr_values="aal2"`, "max_age=300"} {
+				if !strings.Contains(header, want) {
+					t.Errorf("Expected %q in WWW-Authenticate, got %q", want, header)
+				}
+			}
+			var body stepUpChallenge
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode challenge: %v", err)
+			}
+			if body.Error != ErrInsufficientAuthentication || body.MaxAge != 300 || body.ACRValues != ACRMultiFactor {
+				t.Errorf("Unexpected challenge: %+v", body)
+			}
+		})
+	}
+
+	// Invalid tokens are rejected before the policy is checked
+	req := httptest.NewRequest(http.MethodDelete, "/test", nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("Expected plain 401 for invalid token, got %d %q", rr.Code, rr.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestAuthPolicy_Methods(t *testing.T) {
+	policy := AuthPolicy{Methods: []string{AMRHardwareKey}}
+	now := time.Now()
+	if policy.Check(Authentication{Time: now, Methods: []string{AMRPassword, AMROTP, AMRMultiFactor}}, now) == "" {
+		t.Error("Expected a password and OTP authentication to miss the hardware key requirement")
+	}
+	if got := policy.Check(Authentication{Time: now, Methods: []string{AMRHardwareKey, AMRUserPresence, AMRMultiFactor}}, now); got != "" {
+		t.Errorf("Expected a passkey authentication to pass, got %q", got)
+	}
+	if (AuthPolicy{}).Check(Authentication{}, now) != "" {
+		t.Error("Expected the zero policy to accept any authentication")
+	}
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultTokenVersionCacheTTL is how long a user's token version is cached by default
+const DefaultTokenVersionCacheTTL = 30 * time.Second
+
+// tokenVersionCacheSize bounds the number of cached users; expired entries are dropped when it is reached
+const tokenVersionCacheSize = 10000
+
+// ErrTokenOutdated is returned for access tokens issued before the user's token version was bumped
+var ErrTokenOutdated = errors.New("token version is outdated")
+
+// TokenVersionSource looks up the current access token version of a user
+type TokenVersionSource interface {
+	GetTokenVersion(ctx context.Context, userID string) (int, error)
+}
+
+// tokenVersionEntry is a cached token version
+type tokenVersionEntry struct {
+	version   int
+	fetchedAt time.Time
+}
+
+// tokenVersions holds the configured token version source and its cache
+var tokenVersions struct {
+	mu     sync.Mutex
+	source TokenVersionSource
+	ttl    time.Duration
+	cache  map[string]tokenVersionEntry
+}
+
+// SetTokenVersionSource configures where user token versions are read from
+// Versions are cached in process for ttl, so a bump made by another instance takes effect within ttl
+// A nil source disables version checks and stamping
+func SetTokenVersionSource(source TokenVersionSource, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultTokenVersionCacheTTL
+	}
+	tokenVersions.mu.Lock()
+	defer tokenVersions.mu.Unlock()
+	tokenVersions.source = source
+	tokenVersions.ttl = ttl
+	tokenVersions.cache = make(map[string]tokenVersionEntry)
+}
+
+// ForgetTokenVersion drops the cached version of a user so the next check reads it again
+// Call it after bumping the version so the change is effective immediately on this instance
+func ForgetTokenVersion(userID string) {
+	tokenVersions.mu.Lock()
+	defer tokenVersions.mu.Unlock()
+	delete(tokenVersions.cache, userID)
+}
+
+// currentTokenVersion returns the user's token version, served from the cache unless fresh is set
+// ok is false if no source is configured
+func currentTokenVersion(ctx context.Context, userID string, fresh bool) (version int, ok bool, err error) {
+	tokenVersions.mu.Lock()
+	source, ttl := tokenVersions.source, tokenVersions.ttl
+	entry, cached := tokenVersions.cache[userID]
+	tokenVersions.mu.Unlock()
+	if source == nil {
+		return 0, false, nil
+	}
+	now := time.Now()
+	if cached && !fresh && now.Sub(entry.fetchedAt) < ttl {
+		return entry.version, true, nil
+	}
+
+	version, err = source.GetTokenVersion(ctx, userID)
+	if err != nil {
+		return 0, true, err
+	}
+
+	tokenVersions.mu.Lock()
+	defer tokenVersions.mu.Unlock()
+	if tokenVersions.source == source {
+		if len(tokenVersions.cache) >= tokenVersionCacheSize {
+			for id, e := range tokenVersions.cache {
+				if now.Sub(e.fetchedAt) >= ttl {
+					delete(tokenVersions.cache, id)
+				}
+			}
+		}
+		if len(tokenVersions.cache) < tokenVersionCacheSize {
+			tokenVersions.cache[userID] = tokenVersionEntry{version: version, fetchedAt: now}
+		}
+	}
+	return version, true, nil
+}
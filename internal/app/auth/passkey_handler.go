@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/webauthn"
+)
+
+// passkeyRegisterRequest represents the JSON request structure completing a passkey registration
+type passkeyRegisterRequest struct {
+	// Name optionally labels the passkey, e.g. "iPhone"
+	Name string `json:"name,omitempty"`
+
+	// Credential is the PublicKeyCredential returned by navigator.credentials.create
+	Credential *webauthn.AttestationResponse `json:"credential"`
+}
+
+// PasskeyHandler handles passkey registration and management for the logged in user
+// It expects to run behind JWTMiddleware; logging in with a passkey is handled by LoginHandler
+type PasskeyHandler struct {
+	authService *authservice.AuthService
+}
+
+// NewPasskeyHandler is the constructor for PasskeyHandler
+func NewPasskeyHandler(authService *authservice.AuthService) *PasskeyHandler {
+	return &PasskeyHandler{authService: authService}
+}
+
+// List handles GET /api/auth/passkeys
+func (handler *PasskeyHandler) List(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	passkeys, err := handler.authService.ListPasskeys(req.Context(), userID)
+	if err != nil {
+		log.Println("Failed to list passkeys", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, passkeys)
+}
+
+// RegisterOptions handles POST /api/auth/passkeys/options, the start of a passkey registration
+// The response holds the options for navigator.credentials.create
+func (handler *PasskeyHandler) RegisterOptions(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	opts, err := handler.authService.BeginPasskeyRegistration(req.Context(), userID)
+	if err != nil {
+		log.Println("Failed to start passkey registration", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, opts)
+}
+
+// Register handles POST /api/auth/passkeys, completing a passkey registration
+func (handler *PasskeyHandler) Register(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	registerReq := new(passkeyRegisterRequest)
+	if err := json.NewDecoder(req.Body).Decode(registerReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if registerReq.Credential == nil {
+		http.Error(w, "Credential is required", http.StatusBadRequest)
+		return
+	}
+
+	passkey, err := handler.authService.FinishPasskeyRegistration(req.Context(), userID, registerReq.Name, registerReq.Credential)
+	if errors.Is(err, authservice.ErrInvalidPasskey) {
+		log.Println("Failed to verify passkey", err)
+		http.Error(w, "Invalid passkey", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to register passkey", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, passkey)
+}
+
+// Delete handles DELETE /api/auth/passkeys/{id}
+func (handler *PasskeyHandler) Delete(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	err := handler.authService.DeletePasskey(req.Context(), userID, req.PathValue("id"))
+	if errors.Is(err, authservice.ErrPasskeyNotFound) {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to delete passkey", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
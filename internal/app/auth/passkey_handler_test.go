@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+	"github.com/vitalykrupin/auth-service/internal/app/webauthn"
+	"github.com/vitalykrupin/auth-service/internal/app/webauthn/webauthntest"
+)
+
+func TestPasskey_RegisterAndLogin(t *testing.T) {
+	const origin = "https://auth.example.com"
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	rp := webauthn.NewRelyingParty("example.com", "Example", []string{origin})
+	authSvc := authservice.NewAuthService(store, authservice.WithWebAuthn(rp))
+	passkeyHandler := NewPasskeyHandler(authSvc)
+	loginHandler := NewLoginHandler(store, authSvc)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/auth/login/passkey/options", loginHandler.PasskeyOptions)
+	mux.HandleFunc("POST /api/auth/login/passkey", loginHandler.Passkey)
+	stepUp := middleware.AuthPolicy{MaxAge: 5 * time.Minute}
+	mux.Handle("GET /api/auth/passkeys", middleware.JWTMiddleware(http.HandlerFunc(passkeyHandler.List)))
+	mux.Handle("POST /api/auth/passkeys/options", middleware.StepUpMiddleware(stepUp, http.HandlerFunc(passkeyHandler.RegisterOptions)))
+	mux.Handle("POST /api/auth/passkeys", middleware.StepUpMiddleware(stepUp, http.HandlerFunc(passkeyHandler.Register)))
+	mux.Handle("DELETE /api/auth/passkeys/{id}", middleware.StepUpMiddleware(stepUp, http.HandlerFunc(passkeyHandler.Delete)))
+
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	accessToken, err := middleware.GenerateAuthenticatedToken(userID, middleware.NewAuthentication(middleware.AMRPassword))
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	do := func(method, path string, body any, out any) int {
+		var reqBody bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+				t.Fatalf("encode request: %v", err)
+			}
+		}
+		req := httptest.NewRequest(method, path, &reqBody)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if out != nil && (rr.Code == http.StatusOK || rr.Code == http.StatusCreated) {
+			if err := json.Unmarshal(rr.Body.Bytes(), out); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+		}
+		return rr.Code
+	}
+	register := func(authenticator *webauthntest.Authenticator, name string) int {
+		var opts webauthn.CreationOptions
+		if code := do(http.MethodPost, "/api/auth/passkeys/options", nil, &opts); code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+		if string(opts.User.ID) != userID || opts.User.Name != "user" || opts.RP.ID != "example.com" {
+			t.Fatalf("unexpected creation options: %+v", opts)
+		}
+		cred, err := authenticator.Create(origin, &opts)
+		if err != nil {
+			t.Fatalf("create credential: %v", err)
+		}
+		return do(http.MethodPost, "/api/auth/passkeys", passkeyRegisterRequest{Name: name, Credential: cred}, nil)
+	}
+	login := func(authenticator *webauthntest.Authenticator) (int, loginResponse) {
+		var opts webauthn.RequestOptions
+		if code := do(http.MethodPost, "/api/auth/login/passkey/options", nil, &opts); code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+		assertion, err := authenticator.Get(origin, &opts)
+		if err != nil {
+			t.Fatalf("get assertion: %v", err)
+		}
+		var resp loginResponse
+		code := do(http.MethodPost, "/api/auth/login/passkey", passkeyLoginRequest{Credential: assertion, DeviceLabel: "Phone"}, &resp)
+		return code, resp
+	}
+
+	// Adding a passkey requires a recent login
+	staleToken, _ := middleware.GenerateAuthenticatedToken(userID, middleware.Authentication{
+		Time:    time.Now().Add(-time.Hour),
+		Methods: []string{middleware.AMRPassword},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/passkeys/options", nil)
+	req.Header.Set("Authorization", "Bearer "+staleToken)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Header().Get("WWW-Authenticate"), middleware.ErrInsufficientAuthentication) {
+		t.Errorf("expected step-up challenge for stale token, got %d", rr.Code)
+	}
+
+	phone := webauthntest.New()
+	phone.Format = webauthntest.FormatPackedX5C
+	if code := register(phone, "Phone"); code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	// The same authenticator can not be registered twice
+	if code := register(phone, "Phone again"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for duplicate credential, got %d", code)
+	}
+	// An authenticator that does not verify the user is rejected
+	unverified := webauthntest.New()
+	unverified.Flags = webauthn.FlagUserPresent
+	if code := register(unverified, ""); code != http.StatusBadRequest {
+		t.Errorf("expected 400 without user verification, got %d", code)
+	}
+	if code := register(webauthntest.New(), ""); code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+
+	var passkeys []authservice.Passkey
+	if code := do(http.MethodGet, "/api/auth/passkeys", nil, &passkeys); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(passkeys) != 2 || passkeys[0].Name != "Phone" || passkeys[0].AttestationType != webauthn.AttestationBasic ||
+		passkeys[1].Name != "Passkey" || passkeys[1].AttestationType != webauthn.AttestationNone {
+		t.Fatalf("unexpected passkeys: %+v", passkeys)
+	}
+
+	// Passwordless login
+	code, resp := login(phone)
+	if code != http.StatusOK || resp.UserID != userID || resp.Token == "" || resp.RefreshToken == "" {
+		t.Fatalf("unexpected login result %d: %+v", code, resp)
+	}
+	if sessions, _ := authSvc.ListSessions(t.Context(), userID); len(sessions) != 1 || sessions[0].DeviceLabel != "Phone" {
+		t.Errorf("unexpected sessions: %+v", sessions)
+	}
+
+	// A challenge can be answered only once
+	var opts webauthn.RequestOptions
+	do(http.MethodPost, "/api/auth/login/passkey/options", nil, &opts)
+	assertion, _ := phone.Get(origin, &opts)
+	if code := do(http.MethodPost, "/api/auth/login/passkey", passkeyLoginRequest{Credential: assertion}, nil); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := do(http.MethodPost, "/api/auth/login/passkey", passkeyLoginRequest{Credential: assertion}, nil); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for replayed assertion, got %d", code)
+	}
+
+	// A cloned authenticator shows up as a signature counter that does not increase
+	phone.SignCount = 0
+	if code, _ := login(phone); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for counter regression, got %d", code)
+	}
+
+	// Unknown credentials can not log in
+	if code, _ := login(webauthntest.New()); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for unknown credential, got %d", code)
+	}
+
+	// Passkeys of other users can not be deleted
+	otherID, _ := authSvc.RegisterUser(t.Context(), "other", "password")
+	otherToken, _ := middleware.GenerateAuthenticatedToken(otherID, middleware.NewAuthentication(middleware.AMRPassword))
+	req = httptest.NewRequest(http.MethodDelete, "/api/auth/passkeys/"+passkeys[0].ID, nil)
+	req.Header.Set("Authorization", "Bearer "+otherToken)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for foreign passkey, got %d", rr.Code)
+	}
+
+	if code := do(http.MethodDelete, "/api/auth/passkeys/"+passkeys[0].ID, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	phone.SignCount = 100
+	if code, _ := login(phone); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for deleted passkey, got %d", code)
+	}
+}
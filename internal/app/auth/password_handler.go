@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+)
+
+// passwordRequest represents the JSON request structure for a password change
+type passwordRequest struct {
+	// CurrentPassword is left out by passwordless accounts setting their first password
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+
+	// RevokeOtherSessions ends every session except the one RefreshToken (or the refresh cookie) belongs to;
+	// it defaults to true so a stolen session does not outlive the password change, false keeps them
+	RevokeOtherSessions *bool  `json:"revoke_other_sessions,omitempty"`
+	RefreshToken        string `json:"refresh_token,omitempty"`
+}
+
+// passwordResponse represents the JSON response structure for a password change
+type passwordResponse struct {
+	// Token replaces the access token used for the request, which is no longer valid
+	Token string `json:"token"`
+}
+
+// PasswordHandler handles POST requests changing the caller's password
+// It expects to run behind JWTMiddleware
+type PasswordHandler struct {
+	authService *authservice.AuthService
+}
+
+// NewPasswordHandler is the constructor for PasswordHandler
+func NewPasswordHandler(authService *authservice.AuthService) *PasswordHandler {
+	return &PasswordHandler{authService: authService}
+}
+
+// ServeHTTP handles the HTTP request for a password change
+func (handler *PasswordHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		log.Println("Only POST requests are allowed!")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+
+	passReq := new(passwordRequest)
+	if err := json.NewDecoder(req.Body).Decode(passReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if passReq.NewPassword == "" {
+		http.Error(w, "New password is required", http.StatusBadRequest)
+		return
+	}
+
+	err := handler.authService.ChangePassword(req.Context(), userID, passReq.CurrentPassword, passReq.NewPassword)
+	if errors.Is(err, authservice.ErrInvalidPassword) {
+		http.Error(w, "Current password is wrong", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, authservice.ErrWeakPassword) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to change password", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Without a refresh token the caller's own session can not be told apart, so every session ends
+	if passReq.RevokeOtherSessions == nil || *passReq.RevokeOtherSessions {
+		refreshToken := passReq.RefreshToken
+		if cookie, err := req.Cookie(RefreshCookieName); refreshToken == "" && err == nil {
+			refreshToken = cookie.Value
+		}
+		if err := handler.authService.RevokeOtherSessions(req.Context(), userID, refreshToken); err != nil {
+			log.Println("Failed to revoke other sessions", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The password change invalidated every access token, including the caller's; only first-party
+	// tokens get here (see sessionUser), so the replacement is one as well
+	// A verified current password re-authenticates the caller, adding to how they signed in;
+	// a passwordless account setting its first password proved nothing new and keeps its authentication
+	authn, _ := middleware.AuthenticationFromContext(req.Context())
+	if passReq.CurrentPassword != "" {
+		authn.Time = time.Now()
+		if !slices.Contains(authn.Methods, middleware.AMRPassword) {
+			authn.Methods = append(slices.Clone(authn.Methods), middleware.AMRPassword)
+		}
+	}
+	token, err := middleware.GenerateAuthenticatedToken(userID, authn)
+	if err != nil {
+		log.Println("Failed to generate token", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(passwordResponse{Token: token}); err != nil {
+		log.Println("Can not encode response", err)
+	}
+}
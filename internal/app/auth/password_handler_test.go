@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+func TestPasswordHandler_ChangePassword(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	middleware.SetTokenVersionSource(store, time.Minute)
+	defer middleware.SetTokenVersionSource(nil, 0)
+
+	authSvc := authservice.NewAuthService(store)
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "old-password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	current, _, err := authSvc.IssueRefreshToken(t.Context(), userID, authservice.ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	other, _, err := authSvc.IssueRefreshToken(t.Context(), userID, authservice.ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	accessToken, err := middleware.GenerateToken(userID)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	handler := middleware.JWTMiddleware(NewPasswordHandler(authSvc))
+	change := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/password", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := change(`{"current_password":"wrong-password","new_password":"new-password"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for wrong current password, got %d", rr.Code)
+	}
+	if rr := change(`{"current_password":"old-password","new_password":"short"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for weak password, got %d", rr.Code)
+	}
+	if rr := change(`{"current_password":"old-password","new_password":"old-password"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unchanged password, got %d", rr.Code)
+	}
+
+	rr := change(`{"current_password":"old-password","new_password":"new-password","refresh_token":"` + current + `"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp passwordResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("expected new access token, got %s", rr.Body.String())
+	}
+
+	if _, err := authSvc.AuthenticateUser(t.Context(), "user", "new-password"); err != nil {
+		t.Errorf("expected new password to work, got %v", err)
+	}
+	if _, err := authSvc.AuthenticateUser(t.Context(), "user", "old-password"); err == nil {
+		t.Error("expected old password to be rejected")
+	}
+	if _, err := middleware.VerifyToken(t.Context(), accessToken); err == nil {
+		t.Error("expected access token issued before the change to be rejected")
+	}
+	if _, err := middleware.VerifyToken(t.Context(), resp.Token); err != nil {
+		t.Errorf("expected new access token to verify, got %v", err)
+	}
+	if _, _, _, err := authSvc.RefreshSession(t.Context(), other, authservice.ClientInfo{}); err == nil {
+		t.Error("expected other session to be revoked")
+	}
+	_, current, _, err = authSvc.RefreshSession(t.Context(), current, authservice.ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected current session to stay, got %v", err)
+	}
+
+	// Other sessions are kept only when asked for; without a refresh token every session ends
+	accessToken = resp.Token
+	if rr := change(`{"current_password":"new-password","new_password":"newer-password","revoke_other_sessions":false}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	} else if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	_, current, _, err = authSvc.RefreshSession(t.Context(), current, authservice.ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected session to be kept, got %v", err)
+	}
+	accessToken = resp.Token
+	if rr := change(`{"current_password":"newer-password","new_password":"newest-password"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if _, _, _, err := authSvc.RefreshSession(t.Context(), current, authservice.ClientInfo{}); err == nil {
+		t.Error("expected every session to be revoked without a refresh token")
+	}
+}
+
+func TestPasswordHandler_FirstPasswordKeepsAuthentication(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	authSvc := authservice.NewAuthService(store)
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	signedIn := time.Now().Add(-time.Hour).Truncate(time.Second)
+	accessToken, err := middleware.GenerateAuthenticatedToken(userID, middleware.Authentication{
+		Time:    signedIn,
+		Methods: []string{middleware.AMROTP},
+	})
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/password", strings.NewReader(`{"new_password":"first-password"}`))
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rr := httptest.NewRecorder()
+	middleware.JWTMiddleware(NewPasswordHandler(authSvc)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp passwordResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	// No password was verified, so the new token does not count as a fresh password login
+	claims, err := middleware.ParseToken(resp.Token)
+	if err != nil || claims.AuthTime == nil || !claims.AuthTime.Time.Equal(signedIn) ||
+		len(claims.AMR) != 1 || claims.AMR[0] != middleware.AMROTP {
+		t.Errorf("expected the original authentication, got %+v err=%v", claims, err)
+	}
+}
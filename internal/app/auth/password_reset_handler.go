@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+)
+
+// passwordResetRequest represents the JSON request structure for a password reset link
+type passwordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// passwordResetConfirmRequest represents the JSON request structure for setting a new password
+type passwordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// PasswordResetHandler handles self-service password reset requests
+type PasswordResetHandler struct {
+	authService *authservice.AuthService
+	resetURL    string
+}
+
+// NewPasswordResetHandler is the constructor for PasswordResetHandler
+// resetURL is the page the emailed link points to; it receives the token in the "token" query parameter
+func NewPasswordResetHandler(authService *authservice.AuthService, resetURL string) *PasswordResetHandler {
+	return &PasswordResetHandler{authService: authService, resetURL: resetURL}
+}
+
+// Request handles POST /api/auth/password/reset
+// The response is 202 whether or not the email belongs to an account; the email is sent in the background
+// so the response time does not tell either
+func (handler *PasswordResetHandler) Request(w http.ResponseWriter, req *http.Request) {
+	resetReq := new(passwordResetRequest)
+	if err := json.NewDecoder(req.Body).Decode(resetReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if resetReq.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithoutCancel(req.Context())
+	go func() {
+		if err := handler.authService.RequestPasswordReset(ctx, resetReq.Email, handler.resetURL); err != nil {
+			log.Println("Failed to send password reset email", err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Confirm handles POST /api/auth/password/reset/confirm
+// It sets the new password and ends every session of the user
+func (handler *PasswordResetHandler) Confirm(w http.ResponseWriter, req *http.Request) {
+	confirmReq := new(passwordResetConfirmRequest)
+	if err := json.NewDecoder(req.Body).Decode(confirmReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if confirmReq.Token == "" || confirmReq.NewPassword == "" {
+		http.Error(w, "Token and new password are required", http.StatusBadRequest)
+		return
+	}
+
+	err := handler.authService.ResetPassword(req.Context(), confirmReq.Token, confirmReq.NewPassword)
+	if errors.Is(err, authservice.ErrInvalidResetToken) {
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, authservice.ErrWeakPassword) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to reset password", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/mail"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+func TestPasswordResetHandler(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	sender := mail.NewMemorySender()
+	authSvc := authservice.NewAuthService(store, authservice.WithMailSender(sender))
+	handler := NewPasswordResetHandler(authSvc, "https://app.example.com/reset-password")
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/auth/password/reset", handler.Request)
+	mux.HandleFunc("POST /api/auth/password/reset/confirm", handler.Confirm)
+
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	if err := store.SetUserProfile(t.Context(), &storage.Profile{UserID: userID, Email: "user@example.com"}); err != nil {
+		t.Fatalf("set profile: %v", err)
+	}
+	if err := store.MarkEmailVerified(t.Context(), userID, "user@example.com", time.Now()); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+	session, _, err := authSvc.IssueRefreshToken(t.Context(), userID, authservice.ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Unknown accounts get the same answer and no email
+	if rr := post("/api/auth/password/reset", `{"email":"nobody@example.com"}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for unknown email, got %d", rr.Code)
+	}
+	// An unverified email may belong to someone else, so it gets no reset link either
+	otherID, err := authSvc.RegisterUser(t.Context(), "other", "password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	if err := store.SetUserProfile(t.Context(), &storage.Profile{UserID: otherID, Email: "victim@example.com"}); err != nil {
+		t.Fatalf("set profile: %v", err)
+	}
+	if err := authSvc.RequestPasswordReset(t.Context(), "victim@example.com", "https://app.example.com/reset-password"); err != nil {
+		t.Fatalf("request reset: %v", err)
+	}
+	if messages := sender.Messages(); len(messages) != 0 {
+		t.Fatalf("expected no email for an unverified address, got %+v", messages)
+	}
+	// The link goes to the profile address, not to a differently cased or folded one typed in
+	// ("ſ" folds to "s"), which may be another mailbox
+	for _, email := range []string{"USER@example.com", "uſer@example.com"} {
+		if rr := post("/api/auth/password/reset", `{"email":"`+email+`"}`); rr.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d", rr.Code)
+		}
+	}
+	var messages []mail.Message
+	for deadline := time.Now().Add(5 * time.Second); len(messages) < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		messages = sender.Messages()
+	}
+	if len(messages) != 2 || messages[0].To != "user@example.com" || messages[1].To != "user@example.com" {
+		t.Fatalf("expected two reset emails to the profile address, got %+v", messages)
+	}
+	start := strings.Index(messages[0].Body, "https://app.example.com/reset-password?token=")
+	if start < 0 {
+		t.Fatalf("reset link not found in %q", messages[0].Body)
+	}
+	link, err := url.Parse(strings.Fields(messages[0].Body[start:])[0])
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	token := link.Query().Get("token")
+
+	if rr := post("/api/auth/password/reset/confirm", `{"token":"`+token+`","new_password":"short"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for weak password, got %d", rr.Code)
+	}
+	if rr := post("/api/auth/password/reset/confirm", `{"token":"`+token+`","new_password":"new password"}`); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rr.Code, rr.Body.String())
+	}
+	// The token is single-use
+	if rr := post("/api/auth/password/reset/confirm", `{"token":"`+token+`","new_password":"other password"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for used token, got %d", rr.Code)
+	}
+
+	if _, err := authSvc.AuthenticateUser(t.Context(), "user", "new password"); err != nil {
+		t.Errorf("expected new password to work: %v", err)
+	}
+	if _, err := authSvc.AuthenticateUser(t.Context(), "user", "password"); err == nil {
+		t.Error("expected old password to be rejected")
+	}
+	if _, _, _, err := authSvc.RefreshSession(t.Context(), session, authservice.ClientInfo{}); err == nil {
+		t.Error("expected sessions to be revoked")
+	}
+}
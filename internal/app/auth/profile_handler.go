@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// profileUpdateRequest represents the JSON request structure for a profile update
+// Omitted fields are left as they are; an empty string clears a field
+type profileUpdateRequest struct {
+	Email       *string `json:"email"`
+	DisplayName *string `json:"display_name"`
+	Locale      *string `json:"locale"`
+	Timezone    *string `json:"timezone"`
+	AvatarURL   *string `json:"avatar_url"`
+}
+
+// profileResponse represents the JSON response structure for a profile
+type profileResponse struct {
+	UserID          string     `json:"user_id"`
+	Email           string     `json:"email"`
+	EmailVerified   bool       `json:"email_verified"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	DisplayName     string     `json:"display_name"`
+	Locale          string     `json:"locale"`
+	Timezone        string     `json:"timezone"`
+	AvatarURL       string     `json:"avatar_url"`
+	CreatedAt       *time.Time `json:"created_at,omitempty"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+}
+
+// newProfileResponse converts a stored profile; times are left out while the user has no profile
+func newProfileResponse(profile *storage.Profile) profileResponse {
+	resp := profileResponse{
+		UserID:      profile.UserID,
+		Email:       profile.Email,
+		DisplayName: profile.DisplayName,
+		Locale:      profile.Locale,
+		Timezone:    profile.Timezone,
+		AvatarURL:   profile.AvatarURL,
+	}
+	if !profile.EmailVerifiedAt.IsZero() {
+		resp.EmailVerified = true
+		resp.EmailVerifiedAt = &profile.EmailVerifiedAt
+	}
+	if !profile.CreatedAt.IsZero() {
+		resp.CreatedAt = &profile.CreatedAt
+		resp.UpdatedAt = &profile.UpdatedAt
+	}
+	return resp
+}
+
+// ProfileHandler handles reading and updating the caller's profile
+// It expects to run behind JWTMiddleware
+type ProfileHandler struct {
+	*BaseHandler
+	authService *authservice.AuthService
+}
+
+// NewProfileHandler is the constructor for ProfileHandler
+// With WithEmailVerificationURL a verification link is sent whenever the email changes
+// With WithStepUpPolicy changing the email requires the policy's authentication
+func NewProfileHandler(authService *authservice.AuthService, opts ...Option) *ProfileHandler {
+	return &ProfileHandler{
+		BaseHandler: NewBaseHandler(opts...),
+		authService: authService,
+	}
+}
+
+// Get handles GET /api/auth/profile
+func (handler *ProfileHandler) Get(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	profile, err := handler.authService.GetProfile(req.Context(), userID)
+	if err != nil {
+		log.Println("Failed to read profile", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeProfile(w, profile)
+}
+
+// Update handles PATCH /api/auth/profile
+func (handler *ProfileHandler) Update(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	updateReq := new(profileUpdateRequest)
+	if err := json.NewDecoder(req.Body).Decode(updateReq); err != nil {
+		log.Println("Can not parse request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if updateReq.Email != nil && !handler.stepUp.IsZero() {
+		current, err := handler.authService.GetProfile(req.Context(), userID)
+		if err != nil {
+			log.Println("Failed to read profile", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if *updateReq.Email != current.Email && !middleware.RequireAuthentication(w, req, handler.stepUp) {
+			return
+		}
+	}
+
+	profile, err := handler.authService.UpdateProfile(req.Context(), userID, authservice.ProfileUpdate{
+		Email:       updateReq.Email,
+		DisplayName: updateReq.DisplayName,
+		Locale:      updateReq.Locale,
+		Timezone:    updateReq.Timezone,
+		AvatarURL:   updateReq.AvatarURL,
+	})
+	if errors.Is(err, authservice.ErrInvalidProfile) || errors.Is(err, authservice.ErrInvalidEmail) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, authservice.ErrEmailTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to update profile", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// A new email has to be verified again
+	if updateReq.Email != nil && profile.Email != "" && profile.EmailVerifiedAt.IsZero() && handler.emailVerificationURL != "" {
+		sendCtx := context.WithoutCancel(req.Context())
+		go func() {
+			if err := handler.authService.SendEmailVerification(sendCtx, userID, handler.emailVerificationURL); err != nil {
+				log.Println("Failed to send email verification", err)
+			}
+		}()
+	}
+	writeProfile(w, profile)
+}
+
+// writeProfile writes the profile as JSON
+func writeProfile(w http.ResponseWriter, profile *storage.Profile) {
+	writeJSON(w, http.StatusOK, newProfileResponse(profile))
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+func TestProfileHandler(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	authSvc := authservice.NewAuthService(store)
+	handler := NewProfileHandler(authSvc)
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/auth/profile", middleware.JWTMiddleware(http.HandlerFunc(handler.Get)))
+	mux.Handle("PATCH /api/auth/profile", middleware.JWTMiddleware(http.HandlerFunc(handler.Update)))
+
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	otherID, err := authSvc.RegisterUser(t.Context(), "other", "password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	if err := authSvc.SetEmail(t.Context(), otherID, "other@example.com"); err != nil {
+		t.Fatalf("set email: %v", err)
+	}
+	if err := store.MarkEmailVerified(t.Context(), otherID, "other@example.com", time.Now()); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+	accessToken, err := middleware.GenerateToken(userID)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	do := func(method, body string) (*httptest.ResponseRecorder, profileResponse) {
+		req := httptest.NewRequest(method, "/api/auth/profile", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		var resp profileResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+		}
+		return rr, resp
+	}
+
+	rr, resp := do(http.MethodGet, "")
+	if rr.Code != http.StatusOK || resp.UserID != userID || resp.CreatedAt != nil {
+		t.Fatalf("unexpected empty profile: %d %+v", rr.Code, resp)
+	}
+
+	// Values are encoded, not concatenated into the JSON
+	rr, resp = do(http.MethodPatch, `{"email":"user@example.com","display_name":"Ann \"A\" <b>","locale":"pt-BR","timezone":"Europe/Moscow","avatar_url":"https://cdn.example.com/a.png"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if resp.Email != "user@example.com" || resp.DisplayName != `Ann "A" <b>` || resp.Locale != "pt-BR" ||
+		resp.Timezone != "Europe/Moscow" || resp.AvatarURL != "https://cdn.example.com/a.png" || resp.EmailVerified {
+		t.Errorf("unexpected profile: %+v", resp)
+	}
+	if resp.CreatedAt == nil || resp.UpdatedAt == nil {
+		t.Errorf("expected created_at and updated_at, got %+v", resp)
+	}
+
+	// Omitted fields stay, empty strings clear
+	_, resp = do(http.MethodPatch, `{"avatar_url":""}`)
+	if resp.AvatarURL != "" || resp.Locale != "pt-BR" || resp.Email != "user@example.com" {
+		t.Errorf("unexpected profile after partial update: %+v", resp)
+	}
+
+	for body, want := range map[string]int{
+		`{"email":"other@example.com"}`:                       http.StatusConflict,
+		`{"email":"not an email"}`:                            http.StatusBadRequest,
+		`{"locale":"english please"}`:                         http.StatusBadRequest,
+		`{"timezone":"Mars/Olympus"}`:                         http.StatusBadRequest,
+		`{"avatar_url":"javascript:alert(1)"}`:                http.StatusBadRequest,
+		`{"display_name":"` + strings.Repeat("x", 101) + `"}`: http.StatusBadRequest,
+	} {
+		if rr, _ := do(http.MethodPatch, body); rr.Code != want {
+			t.Errorf("%s: expected %d, got %d", body, want, rr.Code)
+		}
+	}
+
+	if _, resp := do(http.MethodGet, ""); resp.DisplayName != `Ann "A" <b>` || resp.Locale != "pt-BR" {
+		t.Errorf("rejected updates must not change the profile: %+v", resp)
+	}
+}
+
+func TestProfileHandler_EmailChangeRequiresStepUp(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	authSvc := authservice.NewAuthService(store)
+	handler := NewProfileHandler(authSvc, WithStepUpPolicy(middleware.AuthPolicy{MaxAge: 15 * time.Minute}))
+	mux := http.NewServeMux()
+	mux.Handle("PATCH /api/auth/profile", middleware.JWTMiddleware(http.HandlerFunc(handler.Update)))
+
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	if err := authSvc.SetEmail(t.Context(), userID, "user@example.com"); err != nil {
+		t.Fatalf("set email: %v", err)
+	}
+	do := func(authn middleware.Authentication, body string) *httptest.ResponseRecorder {
+		accessToken, err := middleware.GenerateAuthenticatedToken(userID, authn)
+		if err != nil {
+			t.Fatalf("generate token: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPatch, "/api/auth/profile", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	stale := middleware.Authentication{Time: time.Now().Add(-time.Hour), Methods: []string{middleware.AMRPassword}}
+
+	// Other fields and an unchanged email do not need a fresh sign-in
+	if rr := do(stale, `{"email":"user@example.com","display_name":"Ann"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := do(stale, `{"email":"new@example.com"}`)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Header().Get("WWW-Authenticate"), "insufficient_user_authentication") {
+		t.Fatalf("expected step-up challenge, got %d %q", rr.Code, rr.Header().Get("WWW-Authenticate"))
+	}
+	if profile, _ := authSvc.GetProfile(t.Context(), userID); profile.Email != "user@example.com" {
+		t.Errorf("expected email to stay, got %q", profile.Email)
+	}
+
+	if rr := do(middleware.NewAuthentication(middleware.AMRPassword), `{"email":"new@example.com"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 after signing in again, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -4,6 +4,7 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"time"
@@ -15,14 +16,24 @@ import (
 
 // registerRequest represents the JSON request structure for registration
 type registerRequest struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login string `json:"login"`
+	// Password may be left out when email login is available; the account then signs in by emailed code
+	Password string `json:"password,omitempty"`
+
+	// Email is optional unless login requires a verified email or there is no password; a verification link is sent to it
+	Email string `json:"email,omitempty"`
+
+	// DeviceLabel optionally names the session, e.g. "Work laptop"
+	DeviceLabel string `json:"device_label,omitempty"`
 }
 
 // registerResponse represents the JSON response structure for registration
+// Tokens are left out while login requires the email to be verified first, and for passwordless accounts,
+// which sign in with an emailed code once the email is verified
 type registerResponse struct {
-	UserID string `json:"user_id"`
-	Token  string `json:"token"`
+	UserID       string `json:"user_id"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 }
 
 // RegisterHandler handles POST requests for user registration
@@ -33,9 +44,9 @@ type RegisterHandler struct {
 }
 
 // NewRegisterHandler is the constructor for RegisterHandler
-func NewRegisterHandler(store storage.Storage, authService *authservice.AuthService) *RegisterHandler {
+func NewRegisterHandler(store storage.Storage, authService *authservice.AuthService, opts ...Option) *RegisterHandler {
 	return &RegisterHandler{
-		BaseHandler: NewBaseHandler(),
+		BaseHandler: NewBaseHandler(opts...),
 		storage:     store,
 		authService: authService,
 	}
@@ -60,13 +71,37 @@ func (handler *RegisterHandler) ServeHTTP(w http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	// Check if login and password are provided
-	if regReq.Login == "" || regReq.Password == "" {
+	// Check if login and password are provided; passwordless accounts need email login
+	// and a verification link, since login codes are only sent to verified emails
+	passwordless := regReq.Password == ""
+	if regReq.Login == "" || passwordless && (!handler.authService.EmailLoginEnabled() || handler.emailVerificationURL == "") {
 		log.Println("Login and password are required")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	// Check email before the user is created
+	if regReq.Email == "" && (passwordless || handler.authService.RequiresVerifiedEmail()) {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+	if regReq.Email != "" {
+		err := handler.authService.CheckEmail(ctx, regReq.Email)
+		if errors.Is(err, authservice.ErrInvalidEmail) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, authservice.ErrEmailTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Println("Failed to check email", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Register user
 	userID, err := handler.authService.RegisterUser(ctx, regReq.Login, regReq.Password)
 	if err != nil {
@@ -75,14 +110,52 @@ func (handler *RegisterHandler) ServeHTTP(w http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	// Generate JWT token
-	token, err := middleware.GenerateToken(userID)
+	// Store the email and send the verification link in the background
+	if regReq.Email != "" {
+		if err := handler.authService.SetEmail(ctx, userID, regReq.Email); err != nil {
+			log.Println("Failed to set email", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if handler.emailVerificationURL != "" {
+			sendCtx := context.WithoutCancel(req.Context())
+			go func() {
+				if err := handler.authService.SendEmailVerification(sendCtx, userID, handler.emailVerificationURL); err != nil {
+					log.Println("Failed to send email verification", err)
+				}
+			}()
+		}
+	}
+
+	// Login is not possible before the email is verified; passwordless accounts then sign in by emailed code
+	if passwordless || handler.authService.RequiresVerifiedEmail() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(registerResponse{UserID: userID}); err != nil {
+			log.Println("Can not encode response", err)
+		}
+		return
+	}
+
+	// Generate JWT token; registering counts as signing in with the password
+	authn := middleware.NewAuthentication(middleware.AMRPassword)
+	token, err := middleware.GenerateAuthenticatedToken(userID, authn)
 	if err != nil {
 		log.Println("Failed to generate token", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	// Issue refresh token
+	client := authservice.ClientInfoFromRequest(req, regReq.DeviceLabel)
+	client.Authentication = authn
+	refreshToken, refreshExpiresAt, err := handler.authService.IssueRefreshToken(ctx, userID, client)
+	if err != nil {
+		log.Println("Failed to issue refresh token", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	// Set token as cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     "token",
@@ -92,13 +165,15 @@ func (handler *RegisterHandler) ServeHTTP(w http.ResponseWriter, req *http.Reque
 		Path:     "/",
 		MaxAge:   86400, // 24 hours
 	})
+	handler.setRefreshCookie(w, refreshToken, refreshExpiresAt)
 
 	// Return response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(registerResponse{
-		UserID: userID,
-		Token:  token,
+		UserID:       userID,
+		Token:        token,
+		RefreshToken: refreshToken,
 	}); err != nil {
 		log.Println("Can not encode response", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+)
+
+// SessionsHandler lets users list and end their own sessions
+// Its methods expect to run behind JWTMiddleware
+type SessionsHandler struct {
+	authService *authservice.AuthService
+}
+
+// NewSessionsHandler is the constructor for SessionsHandler
+func NewSessionsHandler(authService *authservice.AuthService) *SessionsHandler {
+	return &SessionsHandler{authService: authService}
+}
+
+// List handles GET requests returning the caller's active sessions
+func (handler *SessionsHandler) List(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	sessions, err := handler.authService.ListSessions(req.Context(), userID)
+	if err != nil {
+		log.Println("Failed to list sessions", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		log.Println("Can not encode response", err)
+	}
+}
+
+// Revoke handles DELETE requests ending one of the caller's sessions
+func (handler *SessionsHandler) Revoke(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	err := handler.authService.RevokeSession(req.Context(), userID, req.PathValue("id"))
+	if errors.Is(err, authservice.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to revoke session", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeAll handles DELETE requests ending every session of the caller ("log out everywhere")
+// Access tokens issued so far, including the one used for this request, stop working too
+func (handler *SessionsHandler) RevokeAll(w http.ResponseWriter, req *http.Request) {
+	userID, ok := sessionUser(w, req)
+	if !ok {
+		return
+	}
+	err := handler.authService.RevokeAllSessions(req.Context(), userID)
+	if err == nil {
+		err = handler.authService.InvalidateAccessTokens(req.Context(), userID)
+	}
+	if err != nil {
+		log.Println("Failed to revoke sessions", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// The refresh cookie, if any, now carries a revoked token
+	ClearRefreshCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionUser returns the user ID set by JWTMiddleware
+// Managing the account takes a first-party login: client credentials tokens have no sessions, and
+// tokens a user delegated to an OAuth client only grant their scope; both are rejected with 403
+func sessionUser(w http.ResponseWriter, req *http.Request) (string, bool) {
+	if middleware.CallerTypeFromContext(req.Context()) == middleware.CallerClient {
+		http.Error(w, "Sessions are only available to users", http.StatusForbidden)
+		return "", false
+	}
+	if middleware.ClientIDFromContext(req.Context()) != "" {
+		http.Error(w, "Tokens issued to OAuth clients can not manage the account", http.StatusForbidden)
+		return "", false
+	}
+	userID, ok := req.Context().Value(middleware.UserIDKey).(string)
+	if !ok || userID == "" {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return "", false
+	}
+	return userID, true
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+func TestSessionsHandler_ListAndRevoke(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	authSvc := authservice.NewAuthService(store)
+	handler := NewSessionsHandler(authSvc)
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/auth/sessions", middleware.JWTMiddleware(http.HandlerFunc(handler.List)))
+	mux.Handle("DELETE /api/auth/sessions", middleware.JWTMiddleware(http.HandlerFunc(handler.RevokeAll)))
+	mux.Handle("DELETE /api/auth/sessions/{id}", middleware.JWTMiddleware(http.HandlerFunc(handler.Revoke)))
+
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	laptop, _, err := authSvc.IssueRefreshToken(t.Context(), userID, authservice.ClientInfo{UserAgent: "curl/8.0", IP: "192.0.2.1", DeviceLabel: "Work laptop"})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	if _, _, err := authSvc.IssueRefreshToken(t.Context(), userID, authservice.ClientInfo{DeviceLabel: "Phone"}); err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	foreign, _, err := authSvc.IssueRefreshToken(t.Context(), "user-2", authservice.ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	// Rotation keeps the session and its label
+	if _, laptop, _, err = authSvc.RefreshSession(t.Context(), laptop, authservice.ClientInfo{UserAgent: "curl/8.1", IP: "192.0.2.2"}); err != nil {
+		t.Fatalf("refresh session: %v", err)
+	}
+
+	accessToken, err := middleware.GenerateToken(userID)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodGet, "/api/auth/sessions")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var sessions []authservice.Session
+	if err := json.Unmarshal(rr.Body.Bytes(), &sessions); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %+v", sessions)
+	}
+	laptopToken, _ := store.GetRefreshToken(t.Context(), storage.HashToken(laptop))
+	var laptopSession *authservice.Session
+	for i := range sessions {
+		if sessions[i].ID == laptopToken.FamilyID {
+			laptopSession = &sessions[i]
+		}
+	}
+	if laptopSession == nil || laptopSession.DeviceLabel != "Work laptop" || laptopSession.UserAgent != "curl/8.1" || laptopSession.IP != "192.0.2.2" {
+		t.Fatalf("unexpected laptop session: %+v", laptopSession)
+	}
+
+	// Sessions of other users are not visible
+	foreignToken, _ := store.GetRefreshToken(t.Context(), storage.HashToken(foreign))
+	if rr := do(http.MethodDelete, "/api/auth/sessions/"+foreignToken.FamilyID); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for foreign session, got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodDelete, "/api/auth/sessions/"+laptopSession.ID); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	if _, _, _, err := authSvc.RefreshSession(t.Context(), laptop, authservice.ClientInfo{}); err == nil {
+		t.Error("expected refresh token of revoked session to be rejected")
+	}
+
+	// Log out everywhere also invalidates issued access tokens
+	if rr := do(http.MethodDelete, "/api/auth/sessions"); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	if sessions, _ := authSvc.ListSessions(t.Context(), userID); len(sessions) != 0 {
+		t.Errorf("expected no sessions left, got %+v", sessions)
+	}
+	if sessions, _ := authSvc.ListSessions(t.Context(), "user-2"); len(sessions) != 1 {
+		t.Errorf("expected sessions of other users to stay, got %+v", sessions)
+	}
+	if version, _ := store.GetTokenVersion(t.Context(), userID); version != 1 {
+		t.Errorf("expected token version 1, got %d", version)
+	}
+}
+
+func TestSessionUser_RejectsDelegatedTokens(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	authSvc := authservice.NewAuthService(store)
+	passkeyHandler := NewPasskeyHandler(authSvc)
+	mux := http.NewServeMux()
+	mux.Handle("/api/auth/password", middleware.JWTMiddleware(NewPasswordHandler(authSvc)))
+	mux.Handle("GET /api/auth/passkeys", middleware.JWTMiddleware(http.HandlerFunc(passkeyHandler.List)))
+	mux.Handle("POST /api/auth/passkeys/options", middleware.JWTMiddleware(http.HandlerFunc(passkeyHandler.RegisterOptions)))
+	mux.Handle("DELETE /api/auth/sessions", middleware.JWTMiddleware(http.HandlerFunc(NewSessionsHandler(authSvc).RevokeAll)))
+
+	userID, err := authSvc.RegisterUser(t.Context(), "user", "password")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	// A token the user granted to a third-party client through OAuth
+	delegated, err := middleware.GenerateTokenWithClaims(&middleware.Claims{UserID: userID, ClientID: "third-party", Scope: "links:read"})
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	for _, route := range []struct{ method, path, body string }{
+		{http.MethodPost, "/api/auth/password", `{"new_password":"stolen-password"}`},
+		{http.MethodGet, "/api/auth/passkeys", ""},
+		{http.MethodPost, "/api/auth/passkeys/options", ""},
+		{http.MethodDelete, "/api/auth/sessions", ""},
+	} {
+		req := httptest.NewRequest(route.method, route.path, strings.NewReader(route.body))
+		req.Header.Set("Authorization", "Bearer "+delegated)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected 403 for delegated token, got %d", route.method, route.path, rr.Code)
+		}
+	}
+	if _, err := authSvc.AuthenticateUser(t.Context(), "user", "password"); err != nil {
+		t.Errorf("expected the password to be unchanged: %v", err)
+	}
+}
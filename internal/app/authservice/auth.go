@@ -3,26 +3,270 @@ package authservice
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"log"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/mail"
 	"github.com/vitalykrupin/auth-service/internal/app/storage"
+	"github.com/vitalykrupin/auth-service/internal/app/webauthn"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// defaultRefreshTTL is the default refresh token lifetime
+const defaultRefreshTTL = 720 * time.Hour
+
+// refreshTokenBytes is the number of random bytes in a refresh token
+const refreshTokenBytes = 32
+
+// ErrInvalidRefreshToken is returned for unknown, revoked or expired refresh tokens
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// ErrRefreshTokenReused is returned when a revoked refresh token is presented again
+// It wraps ErrInvalidRefreshToken; the whole token family has been revoked by then
+var ErrRefreshTokenReused = fmt.Errorf("%w: token reuse detected", ErrInvalidRefreshToken)
+
+// EventRefreshTokenReuse is emitted when a revoked refresh token is presented again
+const EventRefreshTokenReuse = "refresh_token_reuse"
+
+// SecurityEvent describes a security relevant incident
+type SecurityEvent struct {
+	Type     string
+	UserID   string
+	FamilyID string
+	Time     time.Time
+}
+
 // AuthService provides user authentication functionality
 type AuthService struct {
-	store storage.Storage
+	store      storage.Storage
+	refreshTTL time.Duration
+	onEvent    func(ctx context.Context, event SecurityEvent)
+	mailer     mail.Sender
+
+	// requireVerifiedEmail blocks login until the user's email is verified
+	requireVerifiedEmail bool
+
+	// mfaKey encrypts TOTP secrets at rest
+	mfaKey []byte
+
+	// webauthn verifies passkey ceremonies
+	webauthn *webauthn.RelyingParty
+}
+
+// Option configures optional AuthService settings
+type Option func(*AuthService)
+
+// WithRefreshTTL sets the lifetime of issued refresh tokens
+func WithRefreshTTL(ttl time.Duration) Option {
+	return func(s *AuthService) {
+		if ttl > 0 {
+			s.refreshTTL = ttl
+		}
+	}
+}
+
+// WithSecurityEvents sets the callback receiving security events
+// By default events are written to the standard logger
+func WithSecurityEvents(fn func(ctx context.Context, event SecurityEvent)) Option {
+	return func(s *AuthService) {
+		if fn != nil {
+			s.onEvent = fn
+		}
+	}
+}
+
+// WithMailSender sets the sender used for account emails such as password reset links
+func WithMailSender(sender mail.Sender) Option {
+	return func(s *AuthService) {
+		s.mailer = sender
+	}
+}
+
+// WithRequireVerifiedEmail blocks login until the user has verified their email
+func WithRequireVerifiedEmail(required bool) Option {
+	return func(s *AuthService) {
+		s.requireVerifiedEmail = required
+	}
+}
+
+// logSecurityEvent is the default security event callback
+func logSecurityEvent(ctx context.Context, event SecurityEvent) {
+	log.Printf("Security event %s: user=%s family=%s", event.Type, event.UserID, event.FamilyID)
 }
 
 // NewAuthService is the constructor for AuthService
-func NewAuthService(store storage.Storage) *AuthService {
-	return &AuthService{
-		store: store,
+func NewAuthService(store storage.Storage, opts ...Option) *AuthService {
+	s := &AuthService{
+		store:      store,
+		refreshTTL: defaultRefreshTTL,
+		onEvent:    logSecurityEvent,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// RefreshTTL returns the lifetime of issued refresh tokens
+func (s *AuthService) RefreshTTL() time.Duration {
+	return s.refreshTTL
+}
+
+// IssueRefreshToken creates and stores a new refresh token for the given user
+// Every call starts a new token family (session); only the token digest is stored
+// Returns the token and its expiration time
+func (s *AuthService) IssueRefreshToken(ctx context.Context, userID string, client ClientInfo) (string, time.Time, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(s.refreshTTL)
+	if err := s.store.CreateRefreshToken(ctx, &storage.RefreshToken{
+		TokenHash:   storage.HashToken(token),
+		UserID:      userID,
+		FamilyID:    uuid.New().String(),
+		ExpiresAt:   expiresAt,
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		UserAgent:   client.UserAgent,
+		IP:          client.IP,
+		DeviceLabel: client.DeviceLabel,
+		AuthTime:    client.Authentication.Time,
+		AMR:         client.Authentication.Methods,
+		ClientID:    client.ClientID,
+		Scope:       client.Scope,
+	}); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// newRefreshToken returns 256 random bits from the CSPRNG, base64url encoded
+func newRefreshToken() (string, error) {
+	b := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// RefreshSession exchanges a valid refresh token for a new one of the same family and revokes the old token
+// A revoked token presented again revokes the whole family (OAuth 2.0 Security BCP, section 4.14)
+// The client the refresh came from is recorded as the session's last use; client.ClientID must be the one
+// the token was issued to, so OAuth clients can not redeem each other's or first-party tokens and vice versa
+// Returns the user ID, the new refresh token and its expiration time
+func (s *AuthService) RefreshSession(ctx context.Context, token string, client ClientInfo) (string, string, time.Time, error) {
+	current, err := s.store.GetRefreshToken(ctx, storage.HashToken(token))
+	if err == nil && current.ClientID != client.ClientID {
+		return "", "", time.Time{}, ErrInvalidRefreshToken
+	}
+	if err != nil && !errors.Is(err, storage.ErrRefreshTokenNotFound) {
+		return "", "", time.Time{}, err
+	}
+
+	newToken, err := newRefreshToken()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	now := time.Now()
+	next := &storage.RefreshToken{
+		TokenHash:  storage.HashToken(newToken),
+		ExpiresAt:  now.Add(s.refreshTTL),
+		LastUsedAt: now,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+	}
+	// Revoking the old token and storing the new one is a single storage operation,
+	// so of two concurrent refreshes with the same token only one can succeed
+	rt, err := s.store.RotateRefreshToken(ctx, storage.HashToken(token), next)
+	switch {
+	case errors.Is(err, storage.ErrRefreshTokenRevoked):
+		// Tokens revoked in bulk by BreakGlass are not a sign of reuse
+		if cutoff, err := s.store.GetGlobalNotBefore(ctx); err == nil && rt.CreatedAt.Before(cutoff) {
+			return "", "", time.Time{}, ErrInvalidRefreshToken
+		}
+		if err := s.store.RevokeRefreshTokenFamily(ctx, rt.FamilyID); err != nil {
+			return "", "", time.Time{}, err
+		}
+		s.onEvent(ctx, SecurityEvent{
+			Type:     EventRefreshTokenReuse,
+			UserID:   rt.UserID,
+			FamilyID: rt.FamilyID,
+			Time:     time.Now(),
+		})
+		return "", "", time.Time{}, ErrRefreshTokenReused
+	case errors.Is(err, storage.ErrRefreshTokenNotFound), errors.Is(err, storage.ErrRefreshTokenExpired):
+		return "", "", time.Time{}, ErrInvalidRefreshToken
+	case err != nil:
+		return "", "", time.Time{}, err
+	}
+	return rt.UserID, newToken, next.ExpiresAt, nil
+}
+
+// SessionAuthentication returns how the user signed in to the session of a refresh token
+// Access tokens issued on refresh carry it, so refreshing does not count as a new authentication
+func (s *AuthService) SessionAuthentication(ctx context.Context, token string) (middleware.Authentication, error) {
+	rt, err := s.store.GetRefreshToken(ctx, storage.HashToken(token))
+	if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+		return middleware.Authentication{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return middleware.Authentication{}, err
+	}
+	return middleware.Authentication{Time: rt.AuthTime, Methods: rt.AMR}, nil
+}
+
+// SessionScope returns the scope the user granted the OAuth client of a refresh token's session
+func (s *AuthService) SessionScope(ctx context.Context, token string) (string, error) {
+	rt, err := s.store.GetRefreshToken(ctx, storage.HashToken(token))
+	if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+		return "", ErrInvalidRefreshToken
 	}
+	if err != nil {
+		return "", err
+	}
+	return rt.Scope, nil
+}
+
+// RevokeRefreshToken revokes a refresh token
+// Unknown tokens are not an error so that logout and revocation stay idempotent
+func (s *AuthService) RevokeRefreshToken(ctx context.Context, token string) error {
+	err := s.store.RevokeRefreshToken(ctx, storage.HashToken(token))
+	if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+		return nil
+	}
+	return err
+}
+
+// RevokeAccessToken puts an access token ID on the denylist until the token expires
+// Tokens without an ID were issued before IDs existed and can not be revoked
+func (s *AuthService) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+	return s.store.RevokeAccessToken(ctx, jti, expiresAt)
+}
+
+// InvalidateAccessTokens rejects every access token issued to the user so far
+// Use it on password change, lockout or when an administrator suspects a compromise
+// Other instances stop accepting the tokens once their token version cache expires
+func (s *AuthService) InvalidateAccessTokens(ctx context.Context, userID string) error {
+	if _, err := s.store.IncrementTokenVersion(ctx, userID); err != nil {
+		return err
+	}
+	middleware.ForgetTokenVersion(userID)
+	return nil
 }
 
 // RegisterUser registers a new user with the given login and password
+// An empty password creates a passwordless account, which signs in by email code
 // Returns the user ID of the newly created user
 func (s *AuthService) RegisterUser(ctx context.Context, login, password string) (string, error) {
 	// Check if user already exists
@@ -32,10 +276,13 @@ func (s *AuthService) RegisterUser(ctx context.Context, login, password string)
 		return "", errors.New("user already exists")
 	}
 
-	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
+	// Hash the password; passwordless accounts sign in by email code
+	var hashedPassword []byte
+	if password != "" {
+		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	// Generate user ID
@@ -65,11 +312,24 @@ func (s *AuthService) AuthenticateUser(ctx context.Context, login, password stri
 		return "", errors.New("invalid login or password")
 	}
 
-	// Check password
+	// Check password; passwordless accounts can not sign in with one
+	if !user.HasPassword() {
+		return "", errors.New("invalid login or password")
+	}
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
 		return "", errors.New("invalid login or password")
 	}
 
+	if s.requireVerifiedEmail {
+		verified, err := s.IsEmailVerified(ctx, user.UserID)
+		if err != nil {
+			return "", err
+		}
+		if !verified {
+			return "", ErrEmailNotVerified
+		}
+	}
+
 	return user.UserID, nil
 }
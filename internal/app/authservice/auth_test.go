@@ -3,9 +3,12 @@ package authservice
 import (
 	"context"
 	"errors"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
 	"github.com/vitalykrupin/auth-service/internal/app/storage"
 )
 
@@ -20,24 +23,211 @@ func (f *fakeStorage) CloseStorage(ctx context.Context) error
 func (f *fakeStorage) PingStorage(ctx context.Context) error                    { return nil }
 
 // New interface methods for profiles and refresh tokens
-func (f *fakeStorage) GetUserProfile(ctx context.Context, userID string) (string, error) {
-	return "", errors.New("not implemented")
+func (f *fakeStorage) GetUserProfile(ctx context.Context, userID string) (*storage.Profile, error) {
+	return nil, storage.ErrProfileNotFound
 }
-func (f *fakeStorage) SetUserProfile(ctx context.Context, userID, email string) error {
+func (f *fakeStorage) SetUserProfile(ctx context.Context, profile *storage.Profile) error {
 	return nil
 }
-func (f *fakeStorage) CreateRefreshToken(ctx context.Context, token, userID string, expiresAt time.Time) error {
+func (f *fakeStorage) GetUserByID(ctx context.Context, userID string) (*storage.User, error) {
+	return nil, errors.New("user not found")
+}
+func (f *fakeStorage) UpdateUserPassword(ctx context.Context, userID, passwordHash string) error {
+	return nil
+}
+func (f *fakeStorage) GetTokenVersion(ctx context.Context, userID string) (int, error) {
+	return 0, nil
+}
+func (f *fakeStorage) IncrementTokenVersion(ctx context.Context, userID string) (int, error) {
+	return 0, nil
+}
+func (f *fakeStorage) CreateRefreshToken(ctx context.Context, token *storage.RefreshToken) error {
+	return nil
+}
+func (f *fakeStorage) GetRefreshToken(ctx context.Context, tokenHash string) (*storage.RefreshToken, error) {
+	return nil, errors.New("not implemented")
+}
+func (f *fakeStorage) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
 	return nil
 }
-func (f *fakeStorage) GetRefreshToken(ctx context.Context, token string) (string, time.Time, bool, error) {
-	return "", time.Time{}, false, errors.New("not implemented")
+func (f *fakeStorage) RotateRefreshToken(ctx context.Context, tokenHash string, next *storage.RefreshToken) (*storage.RefreshToken, error) {
+	return nil, errors.New("not implemented")
 }
-func (f *fakeStorage) RevokeRefreshToken(ctx context.Context, token string) error {
+func (f *fakeStorage) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	return nil
+}
+func (f *fakeStorage) ListActiveRefreshTokens(ctx context.Context, userID string) ([]*storage.RefreshToken, error) {
+	return nil, nil
+}
+func (f *fakeStorage) RevokeAllRefreshTokens(ctx context.Context, userID string) error {
 	return nil
 }
 func (f *fakeStorage) DeleteExpiredRefreshTokens(ctx context.Context) error {
 	return nil
 }
+func (f *fakeStorage) GetGlobalNotBefore(ctx context.Context) (time.Time, error) {
+	return time.Time{}, nil
+}
+func (f *fakeStorage) SetGlobalNotBefore(ctx context.Context, notBefore time.Time, record *storage.AuditRecord) error {
+	return nil
+}
+func (f *fakeStorage) GetUserIDByEmail(ctx context.Context, email string) (string, error) {
+	return "", storage.ErrUserNotFound
+}
+func (f *fakeStorage) GetEmailVerifiedAt(ctx context.Context, userID string) (time.Time, error) {
+	return time.Time{}, nil
+}
+func (f *fakeStorage) MarkEmailVerified(ctx context.Context, userID, email string, verifiedAt time.Time) error {
+	return storage.ErrProfileNotFound
+}
+func (f *fakeStorage) CreateEmailVerificationToken(ctx context.Context, token *storage.EmailVerificationToken) error {
+	return nil
+}
+func (f *fakeStorage) ConsumeEmailVerificationToken(ctx context.Context, tokenHash string) (*storage.EmailVerificationToken, error) {
+	return nil, storage.ErrEmailVerificationTokenNotFound
+}
+func (f *fakeStorage) DeleteExpiredEmailVerificationTokens(ctx context.Context) error {
+	return nil
+}
+func (f *fakeStorage) SetTOTPSecret(ctx context.Context, secret *storage.TOTPSecret) error {
+	return nil
+}
+func (f *fakeStorage) GetTOTPSecret(ctx context.Context, userID string) (*storage.TOTPSecret, error) {
+	return nil, storage.ErrTOTPNotFound
+}
+func (f *fakeStorage) ConfirmTOTPSecret(ctx context.Context, userID string, confirmedAt time.Time, step int64, recoveryCodeHashes []string) error {
+	return storage.ErrTOTPNotFound
+}
+func (f *fakeStorage) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return storage.ErrTOTPStepUsed
+}
+func (f *fakeStorage) DeleteTOTPSecret(ctx context.Context, userID string) error {
+	return nil
+}
+func (f *fakeStorage) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	return storage.ErrRecoveryCodeNotFound
+}
+func (f *fakeStorage) RecordMFAFailure(ctx context.Context, userID string) (int, error) {
+	return 0, storage.ErrTOTPNotFound
+}
+func (f *fakeStorage) ResetMFAFailures(ctx context.Context, userID string, lockedUntil time.Time) error {
+	return nil
+}
+func (f *fakeStorage) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	return 0, nil
+}
+func (f *fakeStorage) SetEmailLoginCode(ctx context.Context, code *storage.EmailLoginCode) error {
+	return nil
+}
+func (f *fakeStorage) GetEmailLoginCode(ctx context.Context, userID string) (*storage.EmailLoginCode, error) {
+	return nil, storage.ErrEmailLoginCodeNotFound
+}
+func (f *fakeStorage) IncrementEmailLoginCodeAttempts(ctx context.Context, userID string) (*storage.EmailLoginCode, error) {
+	return nil, storage.ErrEmailLoginCodeNotFound
+}
+func (f *fakeStorage) ConsumeEmailLoginCode(ctx context.Context, userID, codeHash string) error {
+	return storage.ErrEmailLoginCodeNotFound
+}
+func (f *fakeStorage) DeleteExpiredEmailLoginCodes(ctx context.Context) error {
+	return nil
+}
+func (f *fakeStorage) CreateLoginLink(ctx context.Context, link *storage.LoginLink) error {
+	return nil
+}
+func (f *fakeStorage) GetLoginLink(ctx context.Context, tokenHash string) (*storage.LoginLink, error) {
+	return nil, storage.ErrLoginLinkNotFound
+}
+func (f *fakeStorage) ConsumeLoginLink(ctx context.Context, tokenHash string) error {
+	return storage.ErrLoginLinkNotFound
+}
+func (f *fakeStorage) CountLoginLinks(ctx context.Context, userID string, since time.Time) (int, error) {
+	return 0, nil
+}
+func (f *fakeStorage) DeleteExpiredLoginLinks(ctx context.Context) error {
+	return nil
+}
+func (f *fakeStorage) CreateMFAChallenge(ctx context.Context, challenge *storage.MFAChallenge) error {
+	return nil
+}
+func (f *fakeStorage) GetMFAChallenge(ctx context.Context, tokenHash string) (*storage.MFAChallenge, error) {
+	return nil, storage.ErrMFAChallengeNotFound
+}
+func (f *fakeStorage) IncrementMFAChallengeAttempts(ctx context.Context, tokenHash string) (int, error) {
+	return 0, storage.ErrMFAChallengeNotFound
+}
+func (f *fakeStorage) ConsumeMFAChallenge(ctx context.Context, tokenHash string) (*storage.MFAChallenge, error) {
+	return nil, storage.ErrMFAChallengeNotFound
+}
+func (f *fakeStorage) DeleteExpiredMFAChallenges(ctx context.Context) error {
+	return nil
+}
+func (f *fakeStorage) CreateWebAuthnCredential(ctx context.Context, cred *storage.WebAuthnCredential) error {
+	return nil
+}
+func (f *fakeStorage) GetWebAuthnCredential(ctx context.Context, credentialID string) (*storage.WebAuthnCredential, error) {
+	return nil, storage.ErrWebAuthnCredentialNotFound
+}
+func (f *fakeStorage) ListWebAuthnCredentials(ctx context.Context, userID string) ([]storage.WebAuthnCredential, error) {
+	return nil, nil
+}
+func (f *fakeStorage) UseWebAuthnCredential(ctx context.Context, credentialID string, signCount uint32, usedAt time.Time) error {
+	return storage.ErrWebAuthnCredentialNotFound
+}
+func (f *fakeStorage) DeleteWebAuthnCredential(ctx context.Context, userID, credentialID string) error {
+	return storage.ErrWebAuthnCredentialNotFound
+}
+func (f *fakeStorage) CreateWebAuthnChallenge(ctx context.Context, challenge *storage.WebAuthnChallenge) error {
+	return nil
+}
+func (f *fakeStorage) ConsumeWebAuthnChallenge(ctx context.Context, challengeHash string) (*storage.WebAuthnChallenge, error) {
+	return nil, storage.ErrWebAuthnChallengeNotFound
+}
+func (f *fakeStorage) DeleteExpiredWebAuthnChallenges(ctx context.Context) error {
+	return nil
+}
+func (f *fakeStorage) CreatePasswordResetToken(ctx context.Context, token *storage.PasswordResetToken) error {
+	return nil
+}
+func (f *fakeStorage) ConsumePasswordResetToken(ctx context.Context, tokenHash string) (*storage.PasswordResetToken, error) {
+	return nil, errors.New("not implemented")
+}
+func (f *fakeStorage) DeleteExpiredPasswordResetTokens(ctx context.Context) error {
+	return nil
+}
+func (f *fakeStorage) CreateAuthorizationCode(ctx context.Context, code *storage.AuthorizationCode) error {
+	return nil
+}
+func (f *fakeStorage) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*storage.AuthorizationCode, error) {
+	return nil, errors.New("not implemented")
+}
+func (f *fakeStorage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return nil
+}
+func (f *fakeStorage) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return false, nil
+}
+func (f *fakeStorage) DeleteExpiredRevokedAccessTokens(ctx context.Context) error { return nil }
+func (f *fakeStorage) CreateDeviceCode(ctx context.Context, code *storage.DeviceCode) error {
+	return nil
+}
+func (f *fakeStorage) GetDeviceCode(ctx context.Context, deviceCodeHash string) (*storage.DeviceCode, error) {
+	return nil, errors.New("not implemented")
+}
+func (f *fakeStorage) GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*storage.DeviceCode, error) {
+	return nil, errors.New("not implemented")
+}
+func (f *fakeStorage) UpdateDeviceCodePolling(ctx context.Context, deviceCodeHash string, polledAt time.Time, interval time.Duration) error {
+	return nil
+}
+func (f *fakeStorage) SetDeviceCodeStatus(ctx context.Context, userCode, status, userID string) error {
+	return nil
+}
+func (f *fakeStorage) DeleteDeviceCode(ctx context.Context, deviceCodeHash string) error { return nil }
+func (f *fakeStorage) CreateClient(ctx context.Context, client *storage.Client) error    { return nil }
+func (f *fakeStorage) GetClient(ctx context.Context, clientID string) (*storage.Client, error) {
+	return nil, errors.New("not implemented")
+}
+func (f *fakeStorage) UpdateClient(ctx context.Context, client *storage.Client) error { return nil }
 
 // TestNewAuthService_Construct ensures the package compiles and constructs the service
 func TestNewAuthService_Construct(t *testing.T) {
@@ -50,3 +240,166 @@ func TestNewAuthService_Construct(t *testing.T) {
 		t.Error("expected error for unknown user")
 	}
 }
+
+func TestRefreshSession_ReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	var events []SecurityEvent
+	svc := NewAuthService(store, WithSecurityEvents(func(ctx context.Context, event SecurityEvent) {
+		events = append(events, event)
+	}))
+
+	first, _, err := svc.IssueRefreshToken(ctx, "user-1", ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	other, _, err := svc.IssueRefreshToken(ctx, "user-1", ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	_, second, _, err := svc.RefreshSession(ctx, first, ClientInfo{})
+	if err != nil {
+		t.Fatalf("refresh session: %v", err)
+	}
+	firstToken, _ := store.GetRefreshToken(ctx, storage.HashToken(first))
+	secondToken, _ := store.GetRefreshToken(ctx, storage.HashToken(second))
+	if firstToken.FamilyID != secondToken.FamilyID {
+		t.Errorf("expected rotated token to stay in family %s, got %s", firstToken.FamilyID, secondToken.FamilyID)
+	}
+
+	// Presenting the rotated token again revokes the whole family
+	if _, _, _, err := svc.RefreshSession(ctx, first, ClientInfo{}); !errors.Is(err, ErrRefreshTokenReused) || !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, _, _, err := svc.RefreshSession(ctx, second, ClientInfo{}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected latest token of the family to be revoked, got %v", err)
+	}
+	if len(events) == 0 || events[0].Type != EventRefreshTokenReuse || events[0].UserID != "user-1" || events[0].FamilyID != firstToken.FamilyID {
+		t.Errorf("unexpected security events: %+v", events)
+	}
+
+	// Other logins of the same user are not affected
+	if _, _, _, err := svc.RefreshSession(ctx, other, ClientInfo{}); err != nil {
+		t.Errorf("expected token of another family to stay valid, got %v", err)
+	}
+}
+
+func TestIssueRefreshToken_StoresDigestOnly(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	svc := NewAuthService(store)
+
+	token, _, err := svc.IssueRefreshToken(ctx, "user-1", ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	if len(token) != 43 {
+		t.Errorf("expected 256-bit base64url token, got %q", token)
+	}
+	if _, err := store.GetRefreshToken(ctx, token); err == nil {
+		t.Error("expected plaintext token not to be stored")
+	}
+	rt, err := store.GetRefreshToken(ctx, storage.HashToken(token))
+	if err != nil || rt.UserID != "user-1" {
+		t.Fatalf("expected token stored by digest, got %+v, %v", rt, err)
+	}
+
+	// Tokens migrated from plaintext are found by the digest of the original value
+	legacy := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	if err := store.CreateRefreshToken(ctx, &storage.RefreshToken{
+		TokenHash: storage.HashToken(legacy),
+		UserID:    "user-1",
+		FamilyID:  legacy,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}); err != nil {
+		t.Fatalf("create refresh token: %v", err)
+	}
+	if _, _, _, err := svc.RefreshSession(ctx, legacy, ClientInfo{}); err != nil {
+		t.Errorf("expected migrated token to refresh, got %v", err)
+	}
+}
+
+func TestRefreshSession_ConcurrentRotation(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	svc := NewAuthService(store, WithSecurityEvents(func(ctx context.Context, event SecurityEvent) {}))
+
+	token, _, err := svc.IssueRefreshToken(ctx, "user-1", ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+
+	const workers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _, err := svc.RefreshSession(ctx, token, ClientInfo{})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else if !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("expected exactly one refresh to succeed, got %d", succeeded)
+	}
+}
+
+func TestBreakGlass_RevokesEveryToken(t *testing.T) {
+	defer middleware.SetNotBefore(time.Time{})
+	ctx := context.Background()
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	var events []SecurityEvent
+	svc := NewAuthService(store, WithSecurityEvents(func(ctx context.Context, event SecurityEvent) {
+		events = append(events, event)
+	}))
+
+	refreshToken, _, err := svc.IssueRefreshToken(ctx, "user-1", ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	accessToken, err := middleware.GenerateToken("user-1")
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	notBefore, err := svc.BreakGlass(ctx, "test", "key leaked")
+	if err != nil {
+		t.Fatalf("break glass: %v", err)
+	}
+	if stored, _ := store.GetGlobalNotBefore(ctx); !stored.Equal(notBefore) {
+		t.Errorf("expected cut-off %v to be stored, got %v", notBefore, stored)
+	}
+	if _, err := middleware.VerifyToken(ctx, accessToken); !errors.Is(err, middleware.ErrTokenRevoked) {
+		t.Errorf("expected access token to be revoked, got %v", err)
+	}
+	if _, _, _, err := svc.RefreshSession(ctx, refreshToken, ClientInfo{}); !errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("expected refresh token to be invalid without reuse detection, got %v", err)
+	}
+	if len(events) != 1 || events[0].Type != EventBreakGlass {
+		t.Errorf("unexpected security events: %+v", events)
+	}
+}
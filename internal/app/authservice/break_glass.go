@@ -0,0 +1,36 @@
+package authservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// EventBreakGlass is emitted when every token is revoked at once
+const EventBreakGlass = "break_glass"
+
+// AuditBreakGlass is the audit log action of a global token revocation
+const AuditBreakGlass = "break_glass"
+
+// BreakGlass revokes every access and refresh token issued so far, e.g. after a signing key leaked
+// actor identifies who triggered it and reason is kept in the audit log
+// Other instances pick up the cut-off from storage, see middleware.SetNotBefore
+// Returns the cut-off; access tokens issued before it are rejected
+func (s *AuthService) BreakGlass(ctx context.Context, actor, reason string) (time.Time, error) {
+	now := time.Now()
+	// iat has second precision, so round up to also catch tokens issued earlier in this second
+	notBefore := now.Truncate(time.Second).Add(time.Second)
+	if err := s.store.SetGlobalNotBefore(ctx, notBefore, &storage.AuditRecord{
+		Action:    AuditBreakGlass,
+		Actor:     actor,
+		Details:   reason,
+		CreatedAt: now,
+	}); err != nil {
+		return time.Time{}, err
+	}
+	middleware.SetNotBefore(notBefore)
+	s.onEvent(ctx, SecurityEvent{Type: EventBreakGlass, Time: now})
+	return notBefore, nil
+}
@@ -0,0 +1,165 @@
+package authservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/mail"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// Email login settings
+const (
+	// emailLoginCodeTTL is how long an emailed login code may be entered
+	emailLoginCodeTTL = 10 * time.Minute
+
+	// emailLoginCodeDigits is the length of an emailed login code
+	emailLoginCodeDigits = 6
+
+	// maxEmailLoginAttempts is the number of codes that may be entered before the pending code is dropped
+	maxEmailLoginAttempts = 5
+
+	// emailLoginResendInterval is the least time between two codes for the same user,
+	// so requesting new codes does not multiply the attempts
+	emailLoginResendInterval = time.Minute
+)
+
+var (
+	// ErrInvalidEmailLoginCode is returned for wrong, used or expired email login codes,
+	// and for codes of a user with too many attempts
+	ErrInvalidEmailLoginCode = errors.New("invalid email login code")
+
+	// ErrEmailLoginThrottled is returned when a code was sent to the user less than a minute ago
+	ErrEmailLoginThrottled = errors.New("email login code was sent recently")
+)
+
+// EmailLoginEnabled tells whether login codes can be emailed (see WithMailSender)
+func (s *AuthService) EmailLoginEnabled() bool {
+	return s.mailer != nil
+}
+
+// RequestEmailLoginCode emails a one-time login code to the owner of the email
+// Only a verified email finds its owner, so an unverified claim can not be used to sign in
+// An unknown email is not an error, so callers can not tell which accounts exist
+func (s *AuthService) RequestEmailLoginCode(ctx context.Context, email string) error {
+	if s.mailer == nil {
+		return ErrMailNotConfigured
+	}
+	// The lookup ignores case; the code goes to the address as the profile has it
+	profile, err := s.verifiedEmailOwner(ctx, email)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	userID := profile.UserID
+
+	now := time.Now()
+	pending, err := s.store.GetEmailLoginCode(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrEmailLoginCodeNotFound) {
+		return err
+	}
+	if err == nil && now.Sub(pending.CreatedAt) < emailLoginResendInterval {
+		return ErrEmailLoginThrottled
+	}
+
+	code, err := newEmailLoginCode()
+	if err != nil {
+		return err
+	}
+	if err := s.store.SetEmailLoginCode(ctx, &storage.EmailLoginCode{
+		UserID:    userID,
+		Email:     profile.Email,
+		CodeHash:  hashEmailLoginCode(userID, code),
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailLoginCodeTTL),
+	}); err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mail.Message{
+		To:      profile.Email,
+		Subject: "Your sign-in code",
+		Body: fmt.Sprintf("Your sign-in code is %s. It is valid for %d minutes.\n\n"+
+			"If you did not try to sign in, ignore this email; nobody can sign in without the code.\n",
+			code, int(emailLoginCodeTTL.Minutes())),
+	})
+}
+
+// VerifyEmailLoginCode checks a code from RequestEmailLoginCode and returns the user ID
+// Every attempt counts, so concurrent guesses can not exceed the limit; the code can be used only once
+// Codes are only sent to verified emails; one sent before the user changed the email is rejected
+func (s *AuthService) VerifyEmailLoginCode(ctx context.Context, email, code string) (string, error) {
+	userID, err := s.store.GetUserIDByEmail(ctx, email)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return "", ErrInvalidEmailLoginCode
+	}
+	if err != nil {
+		return "", err
+	}
+	pending, err := s.store.IncrementEmailLoginCodeAttempts(ctx, userID)
+	if errors.Is(err, storage.ErrEmailLoginCodeNotFound) {
+		return "", ErrInvalidEmailLoginCode
+	}
+	if err != nil {
+		return "", err
+	}
+	if time.Now().After(pending.ExpiresAt) || pending.Attempts > maxEmailLoginAttempts {
+		_ = s.store.ConsumeEmailLoginCode(ctx, userID, pending.CodeHash)
+		return "", ErrInvalidEmailLoginCode
+	}
+
+	codeHash := hashEmailLoginCode(userID, strings.TrimSpace(code))
+	if subtle.ConstantTimeCompare([]byte(codeHash), []byte(pending.CodeHash)) != 1 || !strings.EqualFold(pending.Email, email) {
+		return "", ErrInvalidEmailLoginCode
+	}
+	err = s.store.ConsumeEmailLoginCode(ctx, userID, codeHash)
+	if errors.Is(err, storage.ErrEmailLoginCodeNotFound) {
+		return "", ErrInvalidEmailLoginCode
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.checkEmailUnchanged(ctx, userID, pending.Email); err != nil {
+		if errors.Is(err, storage.ErrProfileNotFound) {
+			return "", ErrInvalidEmailLoginCode
+		}
+		return "", err
+	}
+	return userID, nil
+}
+
+// checkEmailUnchanged makes sure a secret was sent to what still is the user's verified email
+// The profile email may have changed since the secret was sent; then storage.ErrProfileNotFound is returned
+func (s *AuthService) checkEmailUnchanged(ctx context.Context, userID, email string) error {
+	profile, err := s.GetProfile(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if profile.Email != email || profile.EmailVerifiedAt.IsZero() {
+		return fmt.Errorf("%w: %s changed the email", storage.ErrProfileNotFound, userID)
+	}
+	return nil
+}
+
+// newEmailLoginCode returns a uniformly random decimal code
+func newEmailLoginCode() (string, error) {
+	n, err := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(10), big.NewInt(emailLoginCodeDigits), nil))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", emailLoginCodeDigits, n.Int64()), nil
+}
+
+// hashEmailLoginCode salts the code with the user ID, so one table of every code does not match all users
+func hashEmailLoginCode(userID, code string) string {
+	return storage.HashToken(userID + ":" + code)
+}
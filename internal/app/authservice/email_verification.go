@@ -0,0 +1,171 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"net/url"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/mail"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// emailVerificationTTL is the lifetime of an emailed verification link
+const emailVerificationTTL = 24 * time.Hour
+
+var (
+	// ErrInvalidEmail is returned for malformed email addresses
+	ErrInvalidEmail = errors.New("invalid email address")
+
+	// ErrEmailTaken is returned when the email belongs to another user
+	ErrEmailTaken = errors.New("email address is already in use")
+
+	// ErrInvalidVerificationToken is returned for unknown, used or expired email verification tokens,
+	// and for tokens sent to an address the user has changed since
+	ErrInvalidVerificationToken = errors.New("invalid email verification token")
+
+	// ErrEmailNotVerified is returned by AuthenticateUser when login requires a verified email (see WithRequireVerifiedEmail)
+	ErrEmailNotVerified = errors.New("email address is not verified")
+)
+
+// RequiresVerifiedEmail tells whether login is blocked until the email is verified
+func (s *AuthService) RequiresVerifiedEmail() bool {
+	return s.requireVerifiedEmail
+}
+
+// CheckEmail validates an email address and makes sure no user has verified it yet
+// An unverified claim does not count, so it can not keep the real owner from registering the address
+func (s *AuthService) CheckEmail(ctx context.Context, email string) error {
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return ErrInvalidEmail
+	}
+	_, err = s.store.GetUserIDByEmail(ctx, email)
+	if err == nil {
+		return ErrEmailTaken
+	}
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		return err
+	}
+	return nil
+}
+
+// verifiedEmailOwner finds the user who verified the email and returns their profile
+// Unknown emails and unverified claims, which anyone can make, return storage.ErrUserNotFound
+func (s *AuthService) verifiedEmailOwner(ctx context.Context, email string) (*storage.Profile, error) {
+	userID, err := s.store.GetUserIDByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if profile.EmailVerifiedAt.IsZero() {
+		return nil, fmt.Errorf("%w: %s is not verified", storage.ErrUserNotFound, email)
+	}
+	return profile, nil
+}
+
+// SetEmail sets the email address of the user's profile; a new address starts unverified
+func (s *AuthService) SetEmail(ctx context.Context, userID, email string) error {
+	profile, err := s.GetProfile(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if profile.Email == email {
+		return nil
+	}
+	if err := s.CheckEmail(ctx, email); err != nil {
+		return err
+	}
+	profile.Email = email
+	return s.store.SetUserProfile(ctx, profile)
+}
+
+// SendEmailVerification emails a single-use verification link to the user's profile email
+// verifyURL is the page accepting the token; it is passed in the "token" query parameter
+// Nothing is sent if the email is already verified; a user without an email gets storage.ErrProfileNotFound
+func (s *AuthService) SendEmailVerification(ctx context.Context, userID, verifyURL string) error {
+	if s.mailer == nil {
+		return ErrMailNotConfigured
+	}
+	profile, err := s.store.GetUserProfile(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if profile.Email == "" {
+		return fmt.Errorf("%w: %s has no email", storage.ErrProfileNotFound, userID)
+	}
+	if !profile.EmailVerifiedAt.IsZero() {
+		return nil
+	}
+	email := profile.Email
+
+	link, err := url.Parse(verifyURL)
+	if err != nil {
+		return fmt.Errorf("invalid email verification URL: %w", err)
+	}
+	token, err := newRefreshToken()
+	if err != nil {
+		return err
+	}
+	if err := s.store.CreateEmailVerificationToken(ctx, &storage.EmailVerificationToken{
+		TokenHash: storage.HashToken(token),
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	}); err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return s.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("To confirm your email address, open the link below within %d hours:\n\n%s\n\n"+
+			"If you did not sign up, ignore this email.\n",
+			int(emailVerificationTTL.Hours()), link.String()),
+	})
+}
+
+// VerifyEmail marks the profile email as verified using a token from SendEmailVerification
+// Other users' unverified claims to the address are dropped; if another user verified it first, ErrEmailTaken is returned
+// Returns the ID of the verified user
+func (s *AuthService) VerifyEmail(ctx context.Context, token string) (string, error) {
+	verification, err := s.store.ConsumeEmailVerificationToken(ctx, storage.HashToken(token))
+	if errors.Is(err, storage.ErrEmailVerificationTokenNotFound) {
+		return "", ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return "", err
+	}
+	if time.Now().After(verification.ExpiresAt) {
+		return "", ErrInvalidVerificationToken
+	}
+	err = s.store.MarkEmailVerified(ctx, verification.UserID, verification.Email, time.Now())
+	if errors.Is(err, storage.ErrProfileNotFound) {
+		return "", ErrInvalidVerificationToken
+	}
+	if errors.Is(err, storage.ErrEmailTaken) {
+		return "", ErrEmailTaken
+	}
+	if err != nil {
+		return "", err
+	}
+	return verification.UserID, nil
+}
+
+// IsEmailVerified reports whether the user's profile email is verified
+// It implements middleware.EmailVerificationSource
+func (s *AuthService) IsEmailVerified(ctx context.Context, userID string) (bool, error) {
+	verifiedAt, err := s.store.GetEmailVerifiedAt(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return !verifiedAt.IsZero(), nil
+}
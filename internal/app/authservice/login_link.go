@@ -0,0 +1,148 @@
+package authservice
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/mail"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// Login link settings
+const (
+	// LoginLinkTTL is how long an emailed login link may be opened
+	LoginLinkTTL = 15 * time.Minute
+
+	// maxLoginLinks is the number of links a user may be sent within loginLinkWindow
+	maxLoginLinks = 3
+
+	// loginLinkWindow is the period maxLoginLinks applies to
+	loginLinkWindow = 15 * time.Minute
+)
+
+var (
+	// ErrInvalidLoginLink is returned for unknown, used or expired login links,
+	// for links opened in another browser than the one that asked for them,
+	// and for links sent to an address the user has changed since
+	ErrInvalidLoginLink = errors.New("invalid login link")
+
+	// ErrLoginLinkThrottled is returned when the user was sent too many login links recently
+	ErrLoginLinkThrottled = errors.New("too many login links requested")
+)
+
+// NewLoginLinkNonce returns a random nonce binding a login link to the browser that keeps it
+func NewLoginLinkNonce() (string, error) {
+	return newRefreshToken()
+}
+
+// RequestLoginLink emails a single-use login link to the owner of the email
+// linkURL is the page accepting the token; it is passed in the "token" query parameter
+// The link only works together with the nonce, which the requesting browser keeps in a cookie
+// Only a verified email finds its owner, so an unverified claim can not be used to sign in
+// An unknown email is not an error, so callers can not tell which accounts exist
+func (s *AuthService) RequestLoginLink(ctx context.Context, email, nonce, linkURL string) error {
+	if s.mailer == nil {
+		return ErrMailNotConfigured
+	}
+	if nonce == "" {
+		return ErrInvalidLoginLink
+	}
+	link, err := url.Parse(linkURL)
+	if err != nil {
+		return fmt.Errorf("invalid login link URL: %w", err)
+	}
+	// The lookup ignores case; the link goes to the address as the profile has it
+	profile, err := s.verifiedEmailOwner(ctx, email)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	userID := profile.UserID
+
+	now := time.Now()
+	sent, err := s.store.CountLoginLinks(ctx, userID, now.Add(-loginLinkWindow))
+	if err != nil {
+		return err
+	}
+	if sent >= maxLoginLinks {
+		return ErrLoginLinkThrottled
+	}
+
+	token, err := newRefreshToken()
+	if err != nil {
+		return err
+	}
+	if err := s.store.CreateLoginLink(ctx, &storage.LoginLink{
+		TokenHash: storage.HashToken(token),
+		UserID:    userID,
+		Email:     profile.Email,
+		NonceHash: storage.HashToken(nonce),
+		CreatedAt: now,
+		ExpiresAt: now.Add(LoginLinkTTL),
+	}); err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return s.mailer.Send(ctx, mail.Message{
+		To:      profile.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Open this link in the browser where you asked to sign in:\n\n%s\n\n"+
+			"The link is valid for %d minutes and can be used once.\n"+
+			"If you did not try to sign in, ignore this email; the link does not work in other browsers.\n",
+			link.String(), int(LoginLinkTTL.Minutes())),
+	})
+}
+
+// VerifyLoginLink checks a token from RequestLoginLink against the browser's nonce and returns the user ID
+// A link opened without the right nonce, e.g. by a mail scanner, stays usable; a matching one is used up
+// Links are only sent to verified emails; one sent before the user changed the email is rejected
+func (s *AuthService) VerifyLoginLink(ctx context.Context, token, nonce string) (string, error) {
+	tokenHash := storage.HashToken(token)
+	link, err := s.store.GetLoginLink(ctx, tokenHash)
+	if errors.Is(err, storage.ErrLoginLinkNotFound) {
+		return "", ErrInvalidLoginLink
+	}
+	if err != nil {
+		return "", err
+	}
+	if time.Now().After(link.ExpiresAt) {
+		_ = s.store.ConsumeLoginLink(ctx, tokenHash)
+		return "", ErrInvalidLoginLink
+	}
+	if subtle.ConstantTimeCompare([]byte(storage.HashToken(nonce)), []byte(link.NonceHash)) != 1 {
+		return "", ErrInvalidLoginLink
+	}
+
+	// Deleting the link is what makes it single-use; of concurrent requests only one succeeds
+	err = s.store.ConsumeLoginLink(ctx, tokenHash)
+	if errors.Is(err, storage.ErrLoginLinkNotFound) {
+		return "", ErrInvalidLoginLink
+	}
+	if err != nil {
+		return "", err
+	}
+
+	profile, err := s.GetProfile(ctx, link.UserID)
+	if err != nil {
+		return "", err
+	}
+	if profile.Email != link.Email {
+		return "", ErrInvalidLoginLink
+	}
+	if err := s.checkEmailUnchanged(ctx, link.UserID, link.Email); err != nil {
+		if errors.Is(err, storage.ErrProfileNotFound) {
+			return "", ErrInvalidLoginLink
+		}
+		return "", err
+	}
+	return link.UserID, nil
+}
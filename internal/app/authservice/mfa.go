@@ -0,0 +1,362 @@
+package authservice
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// MFA settings
+const (
+	// mfaChallengeTTL is how long the second factor may be entered after the password check
+	mfaChallengeTTL = 5 * time.Minute
+
+	// maxMFAAttempts is the number of wrong codes after which a challenge is dropped
+	maxMFAAttempts = 5
+
+	// maxMFAFailures is the number of wrong codes in a row, across challenges, that locks the second factor
+	maxMFAFailures = 10
+
+	// mfaLockoutDuration is how long the second factor stays locked after too many wrong codes
+	mfaLockoutDuration = 15 * time.Minute
+
+	// recoveryCodeCount is the number of recovery codes issued on enrollment
+	recoveryCodeCount = 10
+)
+
+// recoveryCodeAlphabet avoids characters that are easily confused (0/o, 1/l)
+const recoveryCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+var (
+	// ErrMFANotConfigured is returned when no TOTP encryption key is set (see WithMFAKey)
+	ErrMFANotConfigured = errors.New("MFA is not configured")
+
+	// ErrMFAAlreadyEnabled is returned when enrolling a user who already has a confirmed second factor
+	ErrMFAAlreadyEnabled = errors.New("MFA is already enabled")
+
+	// ErrMFANotEnrolled is returned when confirming or disabling a second factor the user does not have
+	ErrMFANotEnrolled = errors.New("MFA is not enrolled")
+
+	// ErrInvalidMFACode is returned for wrong, reused or malformed TOTP and recovery codes
+	ErrInvalidMFACode = errors.New("invalid MFA code")
+
+	// ErrInvalidMFAChallenge is returned for unknown, used or expired MFA challenge tokens,
+	// and for challenges with too many wrong codes
+	ErrInvalidMFAChallenge = errors.New("invalid MFA challenge")
+
+	// ErrMFALocked is returned while the second factor is locked after too many wrong codes
+	ErrMFALocked = errors.New("MFA is temporarily locked")
+)
+
+// MFA security events
+const (
+	// EventRecoveryCodeUsed is emitted when a user signs in with a recovery code
+	EventRecoveryCodeUsed = "mfa_recovery_code_used"
+
+	// EventMFALocked is emitted when the second factor is locked after too many wrong codes
+	EventMFALocked = "mfa_locked"
+)
+
+// WithMFAKey sets the AES-256 key encrypting TOTP secrets at rest; MFA enrollment is unavailable without it
+func WithMFAKey(key []byte) Option {
+	return func(s *AuthService) {
+		s.mfaKey = key
+	}
+}
+
+// MFAStatus describes the second factors of a user
+type MFAStatus struct {
+	TOTPEnabled       bool `json:"totp_enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// EnrollTOTP starts a TOTP enrollment and returns the base32 secret and its otpauth:// URI
+// The second factor is only enabled once a code is confirmed with ConfirmTOTP
+func (s *AuthService) EnrollTOTP(ctx context.Context, userID, issuer string) (string, string, error) {
+	if len(s.mfaKey) == 0 {
+		return "", "", ErrMFANotConfigured
+	}
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+	current, err := s.store.GetTOTPSecret(ctx, userID)
+	if err == nil && !current.ConfirmedAt.IsZero() {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+	if err != nil && !errors.Is(err, storage.ErrTOTPNotFound) {
+		return "", "", err
+	}
+
+	secret := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	sealed, err := sealSecret(s.mfaKey, userID, secret)
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.store.SetTOTPSecret(ctx, &storage.TOTPSecret{
+		UserID:    userID,
+		Secret:    sealed,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		return "", "", err
+	}
+	return totpEncoding.EncodeToString(secret), totpURI(issuer, user.Login, secret), nil
+}
+
+// ConfirmTOTP enables a pending TOTP enrollment with a code from the authenticator app
+// Returns the recovery codes; they are stored hashed and can not be shown again
+func (s *AuthService) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	current, err := s.store.GetTOTPSecret(ctx, userID)
+	if errors.Is(err, storage.ErrTOTPNotFound) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !current.ConfirmedAt.IsZero() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	secret, err := openSecret(s.mfaKey, userID, current.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totpMatch(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hashes[i] = storage.HashToken(normalizeRecoveryCode(codes[i]))
+	}
+	err = s.store.ConfirmTOTPSecret(ctx, userID, time.Now(), step, hashes)
+	if errors.Is(err, storage.ErrTOTPNotFound) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP removes the user's TOTP second factor and recovery codes after checking a current code
+func (s *AuthService) DisableTOTP(ctx context.Context, userID, code string) error {
+	if err := s.verifySecondFactor(ctx, userID, code); err != nil {
+		return err
+	}
+	return s.store.DeleteTOTPSecret(ctx, userID)
+}
+
+// GetMFAStatus returns the second factors of a user
+func (s *AuthService) GetMFAStatus(ctx context.Context, userID string) (MFAStatus, error) {
+	enabled, err := s.MFAEnabled(ctx, userID)
+	if err != nil || !enabled {
+		return MFAStatus{}, err
+	}
+	left, err := s.store.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return MFAStatus{}, err
+	}
+	return MFAStatus{TOTPEnabled: true, RecoveryCodesLeft: left}, nil
+}
+
+// MFAEnabled reports whether login requires a second factor for the user
+func (s *AuthService) MFAEnabled(ctx context.Context, userID string) (bool, error) {
+	current, err := s.store.GetTOTPSecret(ctx, userID)
+	if errors.Is(err, storage.ErrTOTPNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !current.ConfirmedAt.IsZero(), nil
+}
+
+// StartMFAChallenge is called after a successful first factor of a user with MFA enabled
+// methods are the amr values of the first factor; none means a password
+// Returns the challenge token to send with the second factor to VerifyMFAChallenge
+func (s *AuthService) StartMFAChallenge(ctx context.Context, userID string, methods ...string) (string, time.Time, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+	if err := s.store.CreateMFAChallenge(ctx, &storage.MFAChallenge{
+		TokenHash: storage.HashToken(token),
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		AMR:       methods,
+	}); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// VerifyMFAChallenge completes a login with a TOTP or recovery code
+// Returns the user ID and the amr values of both factors; the challenge can be used only once
+// and is dropped after too many wrong codes
+func (s *AuthService) VerifyMFAChallenge(ctx context.Context, token, code string) (string, []string, error) {
+	tokenHash := storage.HashToken(token)
+	challenge, err := s.store.GetMFAChallenge(ctx, tokenHash)
+	if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+		return "", nil, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if time.Now().After(challenge.ExpiresAt) {
+		_, _ = s.store.ConsumeMFAChallenge(ctx, tokenHash)
+		return "", nil, ErrInvalidMFAChallenge
+	}
+
+	// The attempt is counted before the code is checked so concurrent guesses can not exceed the limit
+	attempts, err := s.store.IncrementMFAChallengeAttempts(ctx, tokenHash)
+	if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+		return "", nil, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if attempts > maxMFAAttempts {
+		_, _ = s.store.ConsumeMFAChallenge(ctx, tokenHash)
+		return "", nil, ErrInvalidMFAChallenge
+	}
+
+	if err := s.verifySecondFactor(ctx, challenge.UserID, code); err != nil {
+		return "", nil, err
+	}
+	if _, err := s.store.ConsumeMFAChallenge(ctx, tokenHash); err != nil {
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			return "", nil, ErrInvalidMFAChallenge
+		}
+		return "", nil, err
+	}
+	methods := challenge.AMR
+	if len(methods) == 0 {
+		methods = []string{middleware.AMRPassword}
+	}
+	if !slices.Contains(methods, middleware.AMROTP) {
+		methods = append(slices.Clone(methods), middleware.AMROTP)
+	}
+	return challenge.UserID, append(slices.Clone(methods), middleware.AMRMultiFactor), nil
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a recovery code; both are single-use
+// Wrong codes are counted per user across challenges, and too many in a row lock the second factor
+func (s *AuthService) verifySecondFactor(ctx context.Context, userID, code string) error {
+	current, err := s.store.GetTOTPSecret(ctx, userID)
+	if errors.Is(err, storage.ErrTOTPNotFound) {
+		return ErrMFANotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if current.ConfirmedAt.IsZero() {
+		return ErrMFANotEnrolled
+	}
+	if time.Now().Before(current.LockedUntil) {
+		return ErrMFALocked
+	}
+
+	err = s.checkSecondFactor(ctx, userID, current, code)
+	if errors.Is(err, ErrInvalidMFACode) {
+		return s.recordMFAFailure(ctx, userID)
+	}
+	if err != nil {
+		return err
+	}
+	if current.FailedAttempts > 0 || !current.LockedUntil.IsZero() {
+		return s.store.ResetMFAFailures(ctx, userID, time.Time{})
+	}
+	return nil
+}
+
+// checkSecondFactor consumes a matching TOTP step or recovery code of a confirmed secret
+func (s *AuthService) checkSecondFactor(ctx context.Context, userID string, current *storage.TOTPSecret, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		secret, err := openSecret(s.mfaKey, userID, current.Secret)
+		if err != nil {
+			return err
+		}
+		step, ok := totpMatch(secret, code, time.Now())
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		err = s.store.UseTOTPStep(ctx, userID, step)
+		if errors.Is(err, storage.ErrTOTPStepUsed) {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+
+	err := s.store.UseRecoveryCode(ctx, userID, storage.HashToken(normalizeRecoveryCode(code)))
+	if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+		return ErrInvalidMFACode
+	}
+	if err != nil {
+		return err
+	}
+	s.onEvent(ctx, SecurityEvent{Type: EventRecoveryCodeUsed, UserID: userID, Time: time.Now()})
+	return nil
+}
+
+// recordMFAFailure counts a wrong code and locks the second factor once the limit is reached
+// Returns ErrInvalidMFACode, or ErrMFALocked for the code that triggered the lockout
+func (s *AuthService) recordMFAFailure(ctx context.Context, userID string) error {
+	failures, err := s.store.RecordMFAFailure(ctx, userID)
+	if errors.Is(err, storage.ErrTOTPNotFound) {
+		return ErrInvalidMFACode
+	}
+	if err != nil {
+		return err
+	}
+	if failures < maxMFAFailures {
+		return ErrInvalidMFACode
+	}
+	now := time.Now()
+	if err := s.store.ResetMFAFailures(ctx, userID, now.Add(mfaLockoutDuration)); err != nil {
+		return err
+	}
+	s.onEvent(ctx, SecurityEvent{Type: EventMFALocked, UserID: userID, Time: now})
+	return ErrMFALocked
+}
+
+// newRecoveryCode returns a random recovery code formatted as "xxxxx-xxxxx" (50 bits)
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := make([]byte, 0, 11)
+	for i, v := range b {
+		if i == 5 {
+			code = append(code, '-')
+		}
+		code = append(code, recoveryCodeAlphabet[int(v)%len(recoveryCodeAlphabet)])
+	}
+	return string(code), nil
+}
+
+// normalizeRecoveryCode makes recovery codes match regardless of case, spaces and dashes
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
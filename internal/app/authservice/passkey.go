@@ -0,0 +1,298 @@
+package authservice
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+	"github.com/vitalykrupin/auth-service/internal/app/webauthn"
+)
+
+// Passkey settings
+const (
+	// webauthnChallengeTTL is how long a started ceremony may be completed
+	webauthnChallengeTTL = 5 * time.Minute
+
+	// webauthnChallengeBytes is the size of ceremony challenges
+	webauthnChallengeBytes = 32
+
+	// maxPasskeyNameLength is the longest passkey name kept
+	maxPasskeyNameLength = 100
+
+	// defaultPasskeyName names passkeys registered without a name
+	defaultPasskeyName = "Passkey"
+)
+
+var (
+	// ErrPasskeysNotConfigured is returned when no relying party is set (see WithWebAuthn)
+	ErrPasskeysNotConfigured = errors.New("passkeys are not configured")
+
+	// ErrInvalidPasskey is returned for WebAuthn responses that fail verification,
+	// answer an unknown, used or expired challenge, or name an unknown credential
+	ErrInvalidPasskey = errors.New("invalid passkey")
+
+	// ErrPasskeyNotFound is returned when a passkey does not exist or belongs to someone else
+	ErrPasskeyNotFound = errors.New("passkey not found")
+)
+
+// EventPasskeyCloned is emitted when a passkey reports a signature counter that did not increase,
+// which indicates a cloned authenticator
+const EventPasskeyCloned = "passkey_cloned"
+
+// WithWebAuthn sets the relying party verifying passkeys; passkeys are unavailable without it
+func WithWebAuthn(rp *webauthn.RelyingParty) Option {
+	return func(s *AuthService) {
+		s.webauthn = rp
+	}
+}
+
+// Passkey is a registered WebAuthn credential of a user
+type Passkey struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	AAGUID          string    `json:"aaguid,omitempty"`
+	AttestationType string    `json:"attestation_type"`
+	CreatedAt       time.Time `json:"created_at"`
+	LastUsedAt      time.Time `json:"last_used_at,omitzero"`
+}
+
+// BeginPasskeyRegistration starts registering a passkey for the user
+// The returned options are passed to navigator.credentials.create; the response goes to FinishPasskeyRegistration
+func (s *AuthService) BeginPasskeyRegistration(ctx context.Context, userID string) (*webauthn.CreationOptions, error) {
+	if s.webauthn == nil {
+		return nil, ErrPasskeysNotConfigured
+	}
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := s.store.ListWebAuthnCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	exclude := make([][]byte, 0, len(creds))
+	for _, cred := range creds {
+		if id, err := base64.RawURLEncoding.DecodeString(cred.ID); err == nil {
+			exclude = append(exclude, id)
+		}
+	}
+
+	challenge, err := s.newWebAuthnChallenge(ctx, storage.WebAuthnRegistration, userID)
+	if err != nil {
+		return nil, err
+	}
+	displayName := profile.DisplayName
+	if displayName == "" {
+		displayName = user.Login
+	}
+	// The user handle is the opaque user ID, so the authenticator stores no personal data in it
+	return s.webauthn.CreationOptions(challenge, webauthn.UserEntity{
+		ID:          []byte(userID),
+		Name:        user.Login,
+		DisplayName: displayName,
+	}, exclude), nil
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response and stores the new passkey
+func (s *AuthService) FinishPasskeyRegistration(ctx context.Context, userID, name string, resp *webauthn.AttestationResponse) (*Passkey, error) {
+	if s.webauthn == nil {
+		return nil, ErrPasskeysNotConfigured
+	}
+	challenge, err := s.consumeWebAuthnChallenge(ctx, resp.Response.ClientDataJSON, storage.WebAuthnRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.UserID != userID {
+		return nil, ErrInvalidPasskey
+	}
+
+	cred, err := s.webauthn.VerifyRegistration(challenge.raw, resp.Response.ClientDataJSON, resp.Response.AttestationObject, true)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidPasskey, err)
+	}
+	if len(resp.RawID) != 0 && !bytes.Equal(resp.RawID, cred.ID) {
+		return nil, ErrInvalidPasskey
+	}
+
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "" {
+		name = defaultPasskeyName
+	}
+	stored := &storage.WebAuthnCredential{
+		ID:              base64.RawURLEncoding.EncodeToString(cred.ID),
+		UserID:          userID,
+		PublicKey:       cred.PublicKey,
+		SignCount:       cred.SignCount,
+		AttestationType: cred.AttestationType,
+		Name:            truncate(name, maxPasskeyNameLength),
+		CreatedAt:       time.Now(),
+	}
+	if aaguid, err := uuid.FromBytes(cred.AAGUID); err == nil && aaguid != uuid.Nil {
+		stored.AAGUID = aaguid.String()
+	}
+	if err := s.store.CreateWebAuthnCredential(ctx, stored); err != nil {
+		if errors.Is(err, storage.ErrWebAuthnCredentialExists) {
+			return nil, ErrInvalidPasskey
+		}
+		return nil, err
+	}
+	passkey := toPasskey(*stored)
+	return &passkey, nil
+}
+
+// BeginPasskeyLogin starts a passwordless login
+// No user is named: the authenticator offers its discoverable credentials for the RP ID
+func (s *AuthService) BeginPasskeyLogin(ctx context.Context) (*webauthn.RequestOptions, error) {
+	if s.webauthn == nil {
+		return nil, ErrPasskeysNotConfigured
+	}
+	challenge, err := s.newWebAuthnChallenge(ctx, storage.WebAuthnLogin, "")
+	if err != nil {
+		return nil, err
+	}
+	return s.webauthn.RequestOptions(challenge, nil), nil
+}
+
+// FinishPasskeyLogin verifies the authenticator's assertion and returns the user ID it belongs to
+// User verification is required, so the passkey alone stands in for password and second factor
+func (s *AuthService) FinishPasskeyLogin(ctx context.Context, resp *webauthn.AssertionResponse) (string, error) {
+	if s.webauthn == nil {
+		return "", ErrPasskeysNotConfigured
+	}
+	challenge, err := s.consumeWebAuthnChallenge(ctx, resp.Response.ClientDataJSON, storage.WebAuthnLogin)
+	if err != nil {
+		return "", err
+	}
+
+	cred, err := s.store.GetWebAuthnCredential(ctx, base64.RawURLEncoding.EncodeToString(resp.RawID))
+	if errors.Is(err, storage.ErrWebAuthnCredentialNotFound) {
+		return "", ErrInvalidPasskey
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Response.UserHandle) != 0 && string(resp.Response.UserHandle) != cred.UserID {
+		return "", ErrInvalidPasskey
+	}
+
+	assertion, err := s.webauthn.VerifyAssertion(challenge.raw, cred.PublicKey, resp.Response.ClientDataJSON,
+		resp.Response.AuthenticatorData, resp.Response.Signature, true)
+	if err != nil {
+		return "", errors.Join(ErrInvalidPasskey, err)
+	}
+	err = s.store.UseWebAuthnCredential(ctx, cred.ID, assertion.SignCount, time.Now())
+	if errors.Is(err, storage.ErrWebAuthnSignCount) {
+		s.onEvent(ctx, SecurityEvent{Type: EventPasskeyCloned, UserID: cred.UserID, Time: time.Now()})
+		return "", ErrInvalidPasskey
+	}
+	if errors.Is(err, storage.ErrWebAuthnCredentialNotFound) {
+		return "", ErrInvalidPasskey
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if s.requireVerifiedEmail {
+		verified, err := s.IsEmailVerified(ctx, cred.UserID)
+		if err != nil {
+			return "", err
+		}
+		if !verified {
+			return "", ErrEmailNotVerified
+		}
+	}
+	return cred.UserID, nil
+}
+
+// ListPasskeys returns the user's passkeys, oldest first
+func (s *AuthService) ListPasskeys(ctx context.Context, userID string) ([]Passkey, error) {
+	creds, err := s.store.ListWebAuthnCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	passkeys := make([]Passkey, 0, len(creds))
+	for _, cred := range creds {
+		passkeys = append(passkeys, toPasskey(cred))
+	}
+	return passkeys, nil
+}
+
+// DeletePasskey removes one of the user's passkeys
+func (s *AuthService) DeletePasskey(ctx context.Context, userID, passkeyID string) error {
+	err := s.store.DeleteWebAuthnCredential(ctx, userID, passkeyID)
+	if errors.Is(err, storage.ErrWebAuthnCredentialNotFound) {
+		return ErrPasskeyNotFound
+	}
+	return err
+}
+
+// toPasskey converts a stored credential to its public form
+func toPasskey(cred storage.WebAuthnCredential) Passkey {
+	return Passkey{
+		ID:              cred.ID,
+		Name:            cred.Name,
+		AAGUID:          cred.AAGUID,
+		AttestationType: cred.AttestationType,
+		CreatedAt:       cred.CreatedAt,
+		LastUsedAt:      cred.LastUsedAt,
+	}
+}
+
+// webauthnChallenge is a consumed ceremony together with its raw challenge
+type webauthnChallenge struct {
+	storage.WebAuthnChallenge
+	raw []byte
+}
+
+// newWebAuthnChallenge creates and stores a random challenge for a ceremony
+func (s *AuthService) newWebAuthnChallenge(ctx context.Context, ceremony, userID string) ([]byte, error) {
+	challenge := make([]byte, webauthnChallengeBytes)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	if err := s.store.CreateWebAuthnChallenge(ctx, &storage.WebAuthnChallenge{
+		ChallengeHash: storage.HashToken(base64.RawURLEncoding.EncodeToString(challenge)),
+		Ceremony:      ceremony,
+		UserID:        userID,
+		ExpiresAt:     time.Now().Add(webauthnChallengeTTL),
+	}); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// consumeWebAuthnChallenge looks up the ceremony a response answers and removes it, so each challenge is used once
+func (s *AuthService) consumeWebAuthnChallenge(ctx context.Context, clientDataJSON []byte, ceremony string) (*webauthnChallenge, error) {
+	raw, err := webauthn.ChallengeFromClientData(clientDataJSON)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidPasskey, err)
+	}
+	challenge, err := s.store.ConsumeWebAuthnChallenge(ctx, storage.HashToken(base64.RawURLEncoding.EncodeToString(raw)))
+	if errors.Is(err, storage.ErrWebAuthnChallengeNotFound) {
+		return nil, ErrInvalidPasskey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if challenge.Ceremony != ceremony || time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrInvalidPasskey
+	}
+	return &webauthnChallenge{WebAuthnChallenge: *challenge, raw: raw}, nil
+}
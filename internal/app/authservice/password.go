@@ -0,0 +1,68 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password policy
+const (
+	minPasswordLength = 8
+
+	// maxPasswordBytes is the bcrypt input limit; longer passwords would be silently truncated
+	maxPasswordBytes = 72
+)
+
+var (
+	// ErrInvalidPassword is returned when the current password does not match
+	ErrInvalidPassword = errors.New("invalid password")
+
+	// ErrWeakPassword is returned for new passwords that do not meet the password policy
+	ErrWeakPassword = errors.New("password does not meet the policy")
+)
+
+// ValidatePassword checks a new password against the password policy
+func ValidatePassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return fmt.Errorf("%w: at least %d characters required", ErrWeakPassword, minPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: at most %d bytes allowed", ErrWeakPassword, maxPasswordBytes)
+	}
+	return nil
+}
+
+// ChangePassword replaces the user's password after verifying the current one
+// Passwordless accounts set their first password with an empty currentPassword
+// Every access token issued so far is invalidated; refresh tokens are left to the caller, which ends the other
+// sessions with RevokeOtherSessions
+func (s *AuthService) ChangePassword(ctx context.Context, userID, currentPassword, newPassword string) error {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.HasPassword() || currentPassword != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)); err != nil {
+			return ErrInvalidPassword
+		}
+	}
+	if err := ValidatePassword(newPassword); err != nil {
+		return err
+	}
+	if newPassword == currentPassword {
+		return fmt.Errorf("%w: the new password must differ from the current one", ErrWeakPassword)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := s.store.UpdateUserPassword(ctx, userID, string(hashedPassword)); err != nil {
+		return err
+	}
+	return s.InvalidateAccessTokens(ctx, userID)
+}
@@ -0,0 +1,101 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/mail"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTTL is the lifetime of an emailed password reset link
+const passwordResetTTL = 30 * time.Minute
+
+var (
+	// ErrInvalidResetToken is returned for unknown, used or expired password reset tokens
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+
+	// ErrMailNotConfigured is returned when an email has to be sent but no sender is set (see WithMailSender)
+	ErrMailNotConfigured = errors.New("mail sender is not configured")
+)
+
+// RequestPasswordReset emails a single-use password reset link to the owner of the email
+// resetURL is the page accepting the token; it is passed in the "token" query parameter
+// Only a verified email finds its owner; an unverified one may have been typed in by someone else
+// An unknown email is not an error, so callers can not tell which accounts exist
+func (s *AuthService) RequestPasswordReset(ctx context.Context, email, resetURL string) error {
+	if s.mailer == nil {
+		return ErrMailNotConfigured
+	}
+	// The lookup folds case; the link goes to the address as the profile has it, never to the one typed in,
+	// which may be a look-alike mailbox of someone else
+	profile, err := s.verifiedEmailOwner(ctx, email)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	userID := profile.UserID
+
+	link, err := url.Parse(resetURL)
+	if err != nil {
+		return fmt.Errorf("invalid password reset URL: %w", err)
+	}
+	token, err := newRefreshToken()
+	if err != nil {
+		return err
+	}
+	if err := s.store.CreatePasswordResetToken(ctx, &storage.PasswordResetToken{
+		TokenHash: storage.HashToken(token),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}); err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return s.mailer.Send(ctx, mail.Message{
+		To:      profile.Email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("To set a new password, open the link below within %d minutes:\n\n%s\n\n"+
+			"If you did not ask to reset your password, ignore this email.\n",
+			int(passwordResetTTL.Minutes()), link.String()),
+	})
+}
+
+// ResetPassword sets a new password using a token from RequestPasswordReset
+// The token is consumed even if it has expired; every session and access token of the user is revoked
+func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if err := ValidatePassword(newPassword); err != nil {
+		return err
+	}
+	resetToken, err := s.store.ConsumePasswordResetToken(ctx, storage.HashToken(token))
+	if errors.Is(err, storage.ErrPasswordResetTokenNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	if time.Now().After(resetToken.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := s.store.UpdateUserPassword(ctx, resetToken.UserID, string(hashedPassword)); err != nil {
+		return err
+	}
+	if err := s.store.RevokeAllRefreshTokens(ctx, resetToken.UserID); err != nil {
+		return err
+	}
+	return s.InvalidateAccessTokens(ctx, resetToken.UserID)
+}
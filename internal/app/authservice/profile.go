@@ -0,0 +1,112 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata" // time zones are validated without relying on the system database
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// Profile field limits
+const (
+	maxDisplayNameLength = 100
+	maxAvatarURLLength   = 2048
+)
+
+// localePattern matches BCP 47 language tags such as "en", "pt-BR" or "zh-Hant-TW"
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// ErrInvalidProfile is returned for profile updates with malformed fields
+var ErrInvalidProfile = errors.New("invalid profile")
+
+// ProfileUpdate holds the profile fields to change; nil fields are left as they are
+// An empty string clears a field
+type ProfileUpdate struct {
+	Email       *string
+	DisplayName *string
+	Locale      *string
+	Timezone    *string
+	AvatarURL   *string
+}
+
+// GetProfile returns the user's profile; a user without one gets an empty profile
+func (s *AuthService) GetProfile(ctx context.Context, userID string) (*storage.Profile, error) {
+	profile, err := s.store.GetUserProfile(ctx, userID)
+	if errors.Is(err, storage.ErrProfileNotFound) {
+		return &storage.Profile{UserID: userID}, nil
+	}
+	return profile, err
+}
+
+// UpdateProfile applies the update to the user's profile and returns the result
+// A changed email starts unverified; send a new link with SendEmailVerification
+func (s *AuthService) UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) (*storage.Profile, error) {
+	profile, err := s.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Email != nil && *update.Email != profile.Email {
+		if *update.Email == "" && s.requireVerifiedEmail {
+			return nil, fmt.Errorf("%w: email is required", ErrInvalidProfile)
+		}
+		if *update.Email != "" {
+			if err := s.CheckEmail(ctx, *update.Email); err != nil {
+				return nil, err
+			}
+		}
+		profile.Email = *update.Email
+	}
+	if update.DisplayName != nil {
+		name := strings.TrimSpace(*update.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLength || strings.ContainsFunc(name, unicode.IsControl) {
+			return nil, fmt.Errorf("%w: display name must be at most %d printable characters", ErrInvalidProfile, maxDisplayNameLength)
+		}
+		profile.DisplayName = name
+	}
+	if update.Locale != nil {
+		if *update.Locale != "" && (len(*update.Locale) > 35 || !localePattern.MatchString(*update.Locale)) {
+			return nil, fmt.Errorf("%w: locale must be a BCP 47 language tag", ErrInvalidProfile)
+		}
+		profile.Locale = *update.Locale
+	}
+	if update.Timezone != nil {
+		if *update.Timezone != "" {
+			if _, err := time.LoadLocation(*update.Timezone); err != nil || *update.Timezone == "Local" {
+				return nil, fmt.Errorf("%w: unknown time zone", ErrInvalidProfile)
+			}
+		}
+		profile.Timezone = *update.Timezone
+	}
+	if update.AvatarURL != nil {
+		if *update.AvatarURL != "" && !validAvatarURL(*update.AvatarURL) {
+			return nil, fmt.Errorf("%w: avatar URL must be an absolute http(s) URL", ErrInvalidProfile)
+		}
+		profile.AvatarURL = *update.AvatarURL
+	}
+
+	if err := s.store.SetUserProfile(ctx, profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// validAvatarURL reports whether u is an absolute http(s) URL of acceptable length
+func validAvatarURL(u string) bool {
+	if len(u) > maxAvatarURLLength {
+		return false
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" || parsed.User != nil {
+		return false
+	}
+	return parsed.Scheme == "https" || parsed.Scheme == "http"
+}
@@ -0,0 +1,144 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// Limits for client supplied session metadata
+const (
+	maxUserAgentLength   = 512
+	maxDeviceLabelLength = 255
+)
+
+// ErrSessionNotFound is returned when the user has no active session with the given ID
+var ErrSessionNotFound = errors.New("session not found")
+
+// ClientInfo describes the client a session was started or refreshed from
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+
+	// DeviceLabel is a user supplied name such as "Work laptop"; it is set when a session starts
+	DeviceLabel string
+
+	// Authentication is how the user signed in; it is set when a session starts and kept by refreshed access tokens
+	Authentication middleware.Authentication
+
+	// ClientID is the OAuth client the session belongs to, empty for first-party logins
+	// Refresh tokens only work for the client they were issued to
+	ClientID string
+
+	// Scope is what the user granted the OAuth client; it is set when a session starts
+	Scope string
+}
+
+// ClientInfoFromRequest captures the user agent and remote IP of a request
+// X-Forwarded-For is ignored as any client can set it
+func ClientInfoFromRequest(req *http.Request, deviceLabel string) ClientInfo {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	return ClientInfo{
+		UserAgent:   truncate(req.UserAgent(), maxUserAgentLength),
+		IP:          ip,
+		DeviceLabel: truncate(deviceLabel, maxDeviceLabelLength),
+	}
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+// Session is an active login of a user, i.e. a refresh token family
+type Session struct {
+	ID          string    `json:"id"`
+	DeviceLabel string    `json:"device_label,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// ListSessions returns the active sessions of the user, newest first
+func (s *AuthService) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	tokens, err := s.store.ListActiveRefreshTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(tokens))
+	for _, rt := range tokens {
+		sessions = append(sessions, Session{
+			ID:          rt.FamilyID,
+			DeviceLabel: rt.DeviceLabel,
+			UserAgent:   rt.UserAgent,
+			IP:          rt.IP,
+			CreatedAt:   rt.CreatedAt,
+			LastUsedAt:  rt.LastUsedAt,
+			ExpiresAt:   rt.ExpiresAt,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one session of the user by revoking its token family
+// Returns ErrSessionNotFound if the session does not exist or belongs to someone else
+func (s *AuthService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	tokens, err := s.store.ListActiveRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, rt := range tokens {
+		if rt.FamilyID == sessionID {
+			return s.store.RevokeRefreshTokenFamily(ctx, sessionID)
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// RevokeOtherSessions ends every session of the user except the one the given refresh token belongs to
+// An empty or unknown refresh token ends every session
+func (s *AuthService) RevokeOtherSessions(ctx context.Context, userID, currentRefreshToken string) error {
+	current := ""
+	if currentRefreshToken != "" {
+		rt, err := s.store.GetRefreshToken(ctx, storage.HashToken(currentRefreshToken))
+		if err == nil && rt.UserID == userID {
+			current = rt.FamilyID
+		}
+	}
+	if current == "" {
+		return s.store.RevokeAllRefreshTokens(ctx, userID)
+	}
+	tokens, err := s.store.ListActiveRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, rt := range tokens {
+		if rt.FamilyID == current {
+			continue
+		}
+		if err := s.store.RevokeRefreshTokenFamily(ctx, rt.FamilyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeAllSessions ends every session of the user ("log out everywhere")
+// Access tokens already issued stay valid until they expire
+func (s *AuthService) RevokeAllSessions(ctx context.Context, userID string) error {
+	return s.store.RevokeAllRefreshTokens(ctx, userID)
+}
@@ -0,0 +1,121 @@
+package authservice
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// TOTP parameters (RFC 6238 defaults, which every authenticator app supports)
+const (
+	totpPeriod      = 30
+	totpDigits      = 6
+	totpSecretBytes = 20
+
+	// totpSkew is the number of time steps accepted before and after the current one
+	totpSkew = 1
+)
+
+// totpEncoding is the secret encoding used in otpauth:// URIs
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpStep returns the RFC 6238 time step of t
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode computes the HOTP (RFC 4226) value of secret for a time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for range totpDigits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// totpMatch finds the time step around now whose code is code
+func totpMatch(secret []byte, code string, now time.Time) (int64, bool) {
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI builds the otpauth:// URI authenticator apps import, usually from a QR code
+func totpURI(issuer, account string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", totpEncoding.EncodeToString(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}).String()
+}
+
+// sealSecret encrypts a TOTP secret with AES-256-GCM; the user ID is authenticated
+// so a ciphertext copied to another user does not decrypt
+func sealSecret(key []byte, userID string, secret []byte) ([]byte, error) {
+	aead, err := newSecretAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(secret)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, secret, []byte(userID)), nil
+}
+
+// openSecret decrypts a TOTP secret sealed by sealSecret
+func openSecret(key []byte, userID string, sealed []byte) ([]byte, error) {
+	aead, err := newSecretAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed secret is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	secret, err := aead.Open(nil, nonce, ciphertext, []byte(userID))
+	if err != nil {
+		return nil, fmt.Errorf("can not decrypt TOTP secret: %w", err)
+	}
+	return secret, nil
+}
+
+// newSecretAEAD creates the AES-GCM cipher for TOTP secrets
+func newSecretAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, ErrMFANotConfigured
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,59 @@
+package authservice
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B (SHA-1), truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(secret, totpStep(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("time %d: expected %s, got %s", tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestTOTPMatch_Skew(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+	previous := totpCode(secret, totpStep(now)-1)
+	if step, ok := totpMatch(secret, previous, now); !ok || step != totpStep(now)-1 {
+		t.Errorf("expected previous step to match, got %d %v", step, ok)
+	}
+	if _, ok := totpMatch(secret, totpCode(secret, totpStep(now)-2), now); ok {
+		t.Error("expected code two steps old to be rejected")
+	}
+}
+
+func TestSealSecret(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	secret := []byte("12345678901234567890")
+	sealed, err := sealSecret(key, "user-1", secret)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if bytes.Contains(sealed, secret) {
+		t.Fatal("expected secret to be encrypted")
+	}
+	opened, err := openSecret(key, "user-1", sealed)
+	if err != nil || !bytes.Equal(opened, secret) {
+		t.Fatalf("open: %v %q", err, opened)
+	}
+	if _, err := openSecret(key, "user-2", sealed); err == nil {
+		t.Error("expected secret of another user not to decrypt")
+	}
+}
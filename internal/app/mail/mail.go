@@ -0,0 +1,121 @@
+// Package mail provides outgoing email delivery
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email message
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers email messages
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPSender delivers messages through an SMTP server
+// STARTTLS is used when the server offers it; credentials are only sent over TLS or to localhost
+type SMTPSender struct {
+	addr     string
+	from     string
+	username string
+	password string
+}
+
+// NewSMTPSender is the constructor for SMTPSender
+// addr is the server host:port; username may be empty for servers without authentication
+func NewSMTPSender(addr, from, username, password string) *SMTPSender {
+	return &SMTPSender{
+		addr:     addr,
+		from:     from,
+		username: username,
+		password: password,
+	}
+}
+
+// Send delivers a message
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	data, err := buildMessage(s.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP address: %w", err)
+	}
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+	if err := smtp.SendMail(s.addr, auth, s.from, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("can not send mail: %w", err)
+	}
+	return nil
+}
+
+// buildMessage renders the message with its headers
+// Addresses are validated and the subject is encoded, so no header can be injected
+func buildMessage(from string, msg Message, now time.Time) ([]byte, error) {
+	if _, err := netmail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+	to, err := netmail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient address: %w", err)
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, errors.New("subject must be a single line")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
+
+// MemorySender keeps messages in memory instead of delivering them; intended for tests
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemorySender is the constructor for MemorySender
+func NewMemorySender() *MemorySender {
+	return &MemorySender{}
+}
+
+// Send records a message
+func (m *MemorySender) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far
+func (m *MemorySender) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
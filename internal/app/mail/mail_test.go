@@ -0,0 +1,54 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildMessage(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	data, err := buildMessage("auth@example.com", Message{
+		To:      "user@example.com",
+		Subject: "Сброс пароля",
+		Body:    "line one\nline two",
+	}, now)
+	if err != nil {
+		t.Fatalf("build message: %v", err)
+	}
+	msg := string(data)
+	for _, want := range []string{
+		"From: auth@example.com\r\n",
+		"To: <user@example.com>\r\n",
+		"Subject: =?utf-8?q?",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nline one\r\nline two",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected %q in message:\n%s", want, msg)
+		}
+	}
+}
+
+func TestBuildMessage_RejectsHeaderInjection(t *testing.T) {
+	tests := []Message{
+		{To: "user@example.com\r\nBcc: victim@example.com", Subject: "hi"},
+		{To: "user@example.com", Subject: "hi\r\nBcc: victim@example.com"},
+		{To: "not an address", Subject: "hi"},
+	}
+	for _, msg := range tests {
+		if _, err := buildMessage("auth@example.com", msg, time.Now()); err == nil {
+			t.Errorf("expected error for %+v", msg)
+		}
+	}
+}
+
+func TestMemorySender(t *testing.T) {
+	sender := NewMemorySender()
+	if err := sender.Send(t.Context(), Message{To: "user@example.com", Subject: "hi"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if msgs := sender.Messages(); len(msgs) != 1 || msgs[0].To != "user@example.com" {
+		t.Errorf("unexpected messages: %+v", msgs)
+	}
+}
@@ -0,0 +1,158 @@
+package oauth
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// authorizationCodeTTL is the lifetime of an authorization code
+const authorizationCodeTTL = 5 * time.Minute
+
+// consentPage renders the authorization request for the user to approve or deny
+// The form posts back to the same URL, so the request parameters travel in its query string
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize</title></head>
+<body>
+<p>{{if .Client.Name}}{{.Client.Name}}{{else}}{{.Client.ClientID}}{{end}} is requesting access to your account{{if .Scope}} ({{.Scope}}){{end}}.</p>
+<form method="post">
+<button type="submit" name="action" value="approve">Approve</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+// consentPageData is the template data of consentPage
+type consentPageData struct {
+	Client *storage.Client
+	Scope  string
+}
+
+// AuthorizeHandler handles requests to the authorization endpoint
+type AuthorizeHandler struct {
+	storage  storage.Storage
+	clients  *ClientRegistry
+	loginURL string
+}
+
+// NewAuthorizeHandler is the constructor for AuthorizeHandler
+// loginURL is where unauthenticated users are sent; it receives the original request as return_to
+func NewAuthorizeHandler(store storage.Storage, clients *ClientRegistry, loginURL string) *AuthorizeHandler {
+	return &AuthorizeHandler{
+		storage:  store,
+		clients:  clients,
+		loginURL: loginURL,
+	}
+}
+
+// ServeHTTP handles the authorization code request with mandatory PKCE
+// GET shows the consent page; the code is issued only when the user approves it with a same-origin POST,
+// so a page that merely navigates a logged-in user here can not obtain one
+func (handler *AuthorizeHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		log.Println("Only GET and POST requests are allowed!")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// The request parameters always come from the query string; the consent form only adds the decision
+	query := req.URL.Query()
+	clientID := query.Get("client_id")
+	redirectURI := query.Get("redirect_uri")
+	state := query.Get("state")
+
+	// Never redirect to an unverified URI
+	client, err := handler.clients.ValidateAuthorization(req.Context(), clientID, redirectURI)
+	if err != nil {
+		log.Println("Invalid authorization request", err)
+		http.Error(w, "Invalid client_id or redirect_uri", http.StatusBadRequest)
+		return
+	}
+	fail := func(code, description string) {
+		params := url.Values{"error": {code}, "error_description": {description}}
+		if state != "" {
+			params.Set("state", state)
+		}
+		redirectWithParams(w, req, redirectURI, params)
+	}
+
+	// The resource owner must already be logged in
+	claims, err := currentUser(req)
+	if err != nil {
+		if handler.loginURL != "" && req.Method == http.MethodGet {
+			redirectWithParams(w, req, handler.loginURL, url.Values{"return_to": {req.URL.RequestURI()}})
+			return
+		}
+		fail(errLoginRequired, "user is not authenticated")
+		return
+	}
+	if req.Method == http.MethodPost && !sameOrigin(req) {
+		http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+		return
+	}
+
+	if query.Get("response_type") != "code" {
+		fail(errUnsupportedResponseType, "only response_type=code is supported")
+		return
+	}
+	if !AllowsScope(client, query.Get("scope")) {
+		fail(errInvalidScope, "requested scope is not allowed for the client")
+		return
+	}
+	challenge := query.Get("code_challenge")
+	if query.Get("code_challenge_method") != pkceMethodS256 || !validPKCEValue(challenge) {
+		fail(errInvalidRequest, "PKCE with code_challenge_method=S256 is required")
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		handler.render(w, consentPageData{Client: client, Scope: query.Get("scope")})
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Malformed form", http.StatusBadRequest)
+		return
+	}
+	if req.PostForm.Get("action") != "approve" {
+		fail(errAccessDenied, "the user denied the request")
+		return
+	}
+
+	code := newCode()
+	if err := handler.storage.CreateAuthorizationCode(req.Context(), &storage.AuthorizationCode{
+		CodeHash:            storage.HashToken(code),
+		ClientID:            clientID,
+		UserID:              claims.UserID,
+		RedirectURI:         redirectURI,
+		Scope:               query.Get("scope"),
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: pkceMethodS256,
+		ExpiresAt:           time.Now().Add(authorizationCodeTTL),
+	}); err != nil {
+		log.Println("Failed to store authorization code", err)
+		fail(errServerError, "can not create authorization code")
+		return
+	}
+
+	params := url.Values{"code": {code}}
+	if state != "" {
+		params.Set("state", state)
+	}
+	redirectWithParams(w, req, redirectURI, params)
+}
+
+// render writes the consent page
+func (handler *AuthorizeHandler) render(w http.ResponseWriter, data consentPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(http.StatusOK)
+	if err := consentPage.Execute(w, data); err != nil {
+		log.Println("Can not render consent page", err)
+	}
+}
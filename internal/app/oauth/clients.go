@@ -0,0 +1,247 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Grant types supported by the token endpoint
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// supportedGrantTypes lists grant types clients may be registered for
+var supportedGrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials, GrantDeviceCode}
+
+// ErrInvalidClient is returned when client authentication or validation fails
+var ErrInvalidClient = errors.New("invalid client")
+
+// ClientParams holds the mutable settings of a client
+type ClientParams struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes"`
+}
+
+// ClientRegistry manages and validates registered OAuth clients
+type ClientRegistry struct {
+	store storage.Storage
+}
+
+// NewClientRegistry is the constructor for ClientRegistry
+func NewClientRegistry(store storage.Storage) *ClientRegistry {
+	return &ClientRegistry{store: store}
+}
+
+// Register creates a client with a random ID if none is given
+// Confidential clients get a generated secret, which is returned only here
+func (r *ClientRegistry) Register(ctx context.Context, clientID string, params ClientParams, confidential bool) (*storage.Client, string, error) {
+	if err := params.validate(); err != nil {
+		return nil, "", err
+	}
+	if clientID == "" {
+		clientID = strings.ToLower(rand.Text())
+	}
+	now := time.Now()
+	client := &storage.Client{
+		ClientID:     clientID,
+		Name:         params.Name,
+		RedirectURIs: params.RedirectURIs,
+		GrantTypes:   params.GrantTypes,
+		Scopes:       params.Scopes,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	var secret string
+	if confidential {
+		var err error
+		if secret, client.SecretHash, err = newClientSecret(); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := r.store.CreateClient(ctx, client); err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+// Get returns a registered client
+func (r *ClientRegistry) Get(ctx context.Context, clientID string) (*storage.Client, error) {
+	return r.store.GetClient(ctx, clientID)
+}
+
+// Update replaces the client's settings
+func (r *ClientRegistry) Update(ctx context.Context, clientID string, params ClientParams) (*storage.Client, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	client, err := r.store.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	client.Name = params.Name
+	client.RedirectURIs = params.RedirectURIs
+	client.GrantTypes = params.GrantTypes
+	client.Scopes = params.Scopes
+	client.UpdatedAt = time.Now()
+	if err := r.store.UpdateClient(ctx, client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// RotateSecret replaces the client secret, turning a public client into a confidential one
+// Returns the new secret, which is not stored in plaintext
+func (r *ClientRegistry) RotateSecret(ctx context.Context, clientID string) (string, error) {
+	client, err := r.store.GetClient(ctx, clientID)
+	if err != nil {
+		return "", err
+	}
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return "", err
+	}
+	client.SecretHash = hash
+	client.UpdatedAt = time.Now()
+	if err := r.store.UpdateClient(ctx, client); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// ValidateAuthorization checks a client for the authorization endpoint
+// The redirect URI must match a registered URI exactly and the requested scopes must be allowed
+func (r *ClientRegistry) ValidateAuthorization(ctx context.Context, clientID, redirectURI string) (*storage.Client, error) {
+	client, err := r.store.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, fmt.Errorf("%w: redirect_uri is not registered", ErrInvalidClient)
+	}
+	// Clients registered before redirect URIs were restricted may still have others
+	if u, err := url.Parse(redirectURI); err != nil || !allowedRedirectScheme(u) {
+		return nil, fmt.Errorf("%w: redirect_uri scheme is not allowed", ErrInvalidClient)
+	}
+	if !AllowsGrant(client, GrantAuthorizationCode) {
+		return nil, fmt.Errorf("%w: authorization_code grant is not allowed", ErrInvalidClient)
+	}
+	return client, nil
+}
+
+// Authenticate identifies the client of a token endpoint request
+// Credentials come from HTTP Basic or the client_id/client_secret form parameters;
+// public clients authenticate with client_id alone
+func (r *ClientRegistry) Authenticate(ctx context.Context, req *http.Request) (*storage.Client, error) {
+	clientID, secret, basic := req.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1: Basic credentials are form-urlencoded
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err != nil {
+			return nil, ErrInvalidClient
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, ErrInvalidClient
+		}
+		if formID := req.PostForm.Get("client_id"); formID != "" && formID != clientID {
+			return nil, ErrInvalidClient
+		}
+	} else {
+		clientID, secret = req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+	}
+	if clientID == "" {
+		return nil, ErrInvalidClient
+	}
+
+	client, err := r.store.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+	if client.SecretHash == "" {
+		return client, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)) != nil {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// AllowsGrant reports whether the client is registered for the grant type
+func AllowsGrant(client *storage.Client, grantType string) bool {
+	return slices.Contains(client.GrantTypes, grantType)
+}
+
+// AllowsScope reports whether every space separated scope is registered for the client
+func AllowsScope(client *storage.Client, scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(client.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks client settings
+func (p ClientParams) validate() error {
+	for _, uri := range p.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" || !allowedRedirectScheme(u) {
+			return fmt.Errorf("invalid redirect URI: %q", uri)
+		}
+	}
+	for _, grantType := range p.GrantTypes {
+		if !slices.Contains(supportedGrantTypes, grantType) {
+			return fmt.Errorf("unsupported grant type: %q", grantType)
+		}
+	}
+	for _, scope := range p.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \\\"") {
+			return fmt.Errorf("invalid scope: %q", scope)
+		}
+	}
+	return nil
+}
+
+// allowedRedirectScheme reports whether codes may be sent to the redirect URI (RFC 8252, section 7)
+// Web clients use https; native apps use http on a loopback host, or a private-use scheme
+// in reverse domain notation, so it can not be javascript:, data: or another scheme browsers handle
+func allowedRedirectScheme(u *url.URL) bool {
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	default:
+		return strings.Contains(u.Scheme, ".")
+	}
+}
+
+// newClientSecret generates a client secret and its bcrypt hash
+func newClientSecret() (string, string, error) {
+	secret := rand.Text() + rand.Text()
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, string(hash), nil
+}
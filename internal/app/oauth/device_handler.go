@@ -0,0 +1,292 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+const (
+	// deviceCodeTTL is the lifetime of a device authorization
+	deviceCodeTTL = 10 * time.Minute
+
+	// deviceInterval is the initial minimum polling interval
+	deviceInterval = 5 * time.Second
+
+	// deviceSlowDownStep is added to the interval when a client polls too fast
+	deviceSlowDownStep = 5 * time.Second
+
+	// userCodeAlphabet avoids vowels and look-alike characters (RFC 8628 section 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+	// userCodeLength is the number of characters in a user code
+	userCodeLength = 8
+
+	// userCodeAttempts bounds retries when a generated user code collides
+	userCodeAttempts = 3
+)
+
+// deviceAuthorizationResponse represents the JSON success structure of the device authorization endpoint
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceAuthorizationHandler handles POST requests to the device authorization endpoint
+type DeviceAuthorizationHandler struct {
+	storage         storage.Storage
+	clients         *ClientRegistry
+	verificationURI string
+}
+
+// NewDeviceAuthorizationHandler is the constructor for DeviceAuthorizationHandler
+// verificationURI is the absolute URL of the page where users enter the user code
+func NewDeviceAuthorizationHandler(store storage.Storage, clients *ClientRegistry, verificationURI string) *DeviceAuthorizationHandler {
+	return &DeviceAuthorizationHandler{
+		storage:         store,
+		clients:         clients,
+		verificationURI: verificationURI,
+	}
+}
+
+// ServeHTTP starts a device authorization (RFC 8628 section 3.1)
+func (handler *DeviceAuthorizationHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		log.Println("Only POST requests are allowed!")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "malformed form body")
+		return
+	}
+
+	client, err := handler.clients.Authenticate(req.Context(), req)
+	if err != nil {
+		if _, _, basic := req.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeError(w, http.StatusUnauthorized, errInvalidClient, "client authentication failed")
+		return
+	}
+	if !AllowsGrant(client, GrantDeviceCode) {
+		writeError(w, http.StatusBadRequest, errUnauthorizedClient, "grant type is not allowed for the client")
+		return
+	}
+	scope := req.PostForm.Get("scope")
+	if !AllowsScope(client, scope) {
+		writeError(w, http.StatusBadRequest, errInvalidScope, "scope is not allowed for the client")
+		return
+	}
+
+	deviceCode := newCode()
+	now := time.Now()
+	code := &storage.DeviceCode{
+		DeviceCodeHash: storage.HashToken(deviceCode),
+		ClientID:       client.ClientID,
+		Scope:          scope,
+		Status:         storage.DeviceCodePending,
+		Interval:       deviceInterval,
+		LastPolledAt:   now,
+		ExpiresAt:      now.Add(deviceCodeTTL),
+	}
+	for attempt := 1; ; attempt++ {
+		code.UserCode = newUserCode()
+		if err = handler.storage.CreateDeviceCode(req.Context(), code); err == nil || attempt == userCodeAttempts {
+			break
+		}
+	}
+	if err != nil {
+		log.Println("Failed to store device code", err)
+		writeError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+
+	userCode := formatUserCode(code.UserCode)
+	writeJSON(w, http.StatusOK, deviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         handler.verificationURI,
+		VerificationURIComplete: handler.verificationURI + "?" + url.Values{"user_code": {userCode}}.Encode(),
+		ExpiresIn:               int64(deviceCodeTTL.Seconds()),
+		Interval:                int64(deviceInterval.Seconds()),
+	})
+}
+
+// devicePage renders the user code entry and confirmation page
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Device login</title></head>
+<body>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Client}}
+<p>{{if .Client.Name}}{{.Client.Name}}{{else}}{{.Client.ClientID}}{{end}} is requesting access to your account{{if .Scope}} ({{.Scope}}){{end}}.</p>
+<p>Make sure the code <b>{{.UserCode}}</b> is the one shown on your device.</p>
+<form method="post">
+<input type="hidden" name="user_code" value="{{.UserCode}}">
+<button type="submit" name="action" value="approve">Approve</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{else if not .Done}}
+<form method="get">
+<label>Code shown on your device: <input name="user_code" value="{{.UserCode}}" autocomplete="off" autofocus></label>
+<button type="submit">Continue</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// devicePageData is the template data of devicePage
+type devicePageData struct {
+	Message  string
+	UserCode string
+	Scope    string
+	Client   *storage.Client
+	Done     bool
+}
+
+// DeviceVerificationHandler serves the page where a logged-in user approves a device
+type DeviceVerificationHandler struct {
+	storage  storage.Storage
+	clients  *ClientRegistry
+	loginURL string
+}
+
+// NewDeviceVerificationHandler is the constructor for DeviceVerificationHandler
+// loginURL is where unauthenticated users are sent; it receives the original request as return_to
+func NewDeviceVerificationHandler(store storage.Storage, clients *ClientRegistry, loginURL string) *DeviceVerificationHandler {
+	return &DeviceVerificationHandler{
+		storage:  store,
+		clients:  clients,
+		loginURL: loginURL,
+	}
+}
+
+// ServeHTTP shows the entry form (GET), the confirmation for a user code (GET with user_code)
+// and records the user's decision (POST)
+func (handler *DeviceVerificationHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		log.Println("Only GET and POST requests are allowed!")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	claims, err := currentUser(req)
+	if err != nil {
+		if handler.loginURL != "" && req.Method == http.MethodGet {
+			redirectWithParams(w, req, handler.loginURL, url.Values{"return_to": {req.URL.RequestURI()}})
+			return
+		}
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+	if req.Method == http.MethodPost && !sameOrigin(req) {
+		http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Malformed form", http.StatusBadRequest)
+		return
+	}
+
+	userCode := normalizeUserCode(req.Form.Get("user_code"))
+	if userCode == "" {
+		handler.render(w, http.StatusOK, devicePageData{})
+		return
+	}
+	code, err := handler.storage.GetDeviceCodeByUserCode(req.Context(), userCode)
+	if err != nil || code.Status != storage.DeviceCodePending || time.Now().After(code.ExpiresAt) {
+		handler.render(w, http.StatusBadRequest, devicePageData{Message: "The code is invalid or has expired."})
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		client, err := handler.clients.Get(req.Context(), code.ClientID)
+		if err != nil {
+			handler.render(w, http.StatusBadRequest, devicePageData{Message: "The code is invalid or has expired."})
+			return
+		}
+		handler.render(w, http.StatusOK, devicePageData{UserCode: formatUserCode(userCode), Scope: code.Scope, Client: client})
+		return
+	}
+
+	status, message := storage.DeviceCodeDenied, "The request was denied."
+	if req.PostForm.Get("action") == "approve" {
+		status, message = storage.DeviceCodeApproved, "Your device is now logged in. You can return to it."
+	}
+	if err := handler.storage.SetDeviceCodeStatus(req.Context(), userCode, status, claims.UserID); err != nil {
+		handler.render(w, http.StatusBadRequest, devicePageData{Message: "The code is invalid or has expired."})
+		return
+	}
+	handler.render(w, http.StatusOK, devicePageData{Message: message, Done: true})
+}
+
+// render writes the device page
+func (handler *DeviceVerificationHandler) render(w http.ResponseWriter, status int, data devicePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	if err := devicePage.Execute(w, data); err != nil {
+		log.Println("Can not render device page", err)
+	}
+}
+
+// sameOrigin rejects form posts from other sites
+// Browsers send Origin or at least Referer with form posts; a request with neither can not be told apart
+// from a cross-site one, so it is rejected too
+func sameOrigin(req *http.Request) bool {
+	source := req.Header.Get("Origin")
+	if source == "" {
+		source = req.Header.Get("Referer")
+	}
+	if source == "" {
+		return false
+	}
+	u, err := url.Parse(source)
+	return err == nil && u.Host != "" && u.Host == req.Host
+}
+
+// newUserCode returns a random user code drawn uniformly from userCodeAlphabet
+func newUserCode() string {
+	code := make([]byte, 0, userCodeLength)
+	buf := make([]byte, 1)
+	// Rejection sampling keeps the distribution uniform
+	limit := byte(256 - 256%len(userCodeAlphabet))
+	for len(code) < userCodeLength {
+		_, _ = rand.Read(buf)
+		if buf[0] < limit {
+			code = append(code, userCodeAlphabet[int(buf[0])%len(userCodeAlphabet)])
+		}
+	}
+	return string(code)
+}
+
+// normalizeUserCode uppercases a user code and drops separators and whitespace
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return -1
+	}, userCode)
+}
+
+// formatUserCode splits a user code into two halves for display, e.g. WDJB-MJHT
+func formatUserCode(userCode string) string {
+	half := len(userCode) / 2
+	return userCode[:half] + "-" + userCode[half:]
+}
@@ -0,0 +1,206 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// startDeviceAuthorization runs the device authorization endpoint for the given client
+func startDeviceAuthorization(t *testing.T, handler http.Handler, clientID string) deviceAuthorizationResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/oauth/device_authorization", strings.NewReader(url.Values{"client_id": {clientID}, "scope": {"links:read"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp deviceAuthorizationResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return resp
+}
+
+// verifyDevice calls the verification page with the given bearer token
+func verifyDevice(handler http.Handler, method, token string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if method == http.MethodGet {
+		req = httptest.NewRequest(method, "/oauth/device?"+form.Encode(), nil)
+	} else {
+		req = httptest.NewRequest(method, "/oauth/device", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "http://"+req.Host)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// pollError returns the OAuth error code of a token response
+func pollError(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp errorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return resp.Error
+}
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	store := newTestStorage(t)
+	registry := NewClientRegistry(store)
+	if _, _, err := registry.Register(t.Context(), "cli", ClientParams{
+		Name:       "Links CLI",
+		GrantTypes: []string{GrantDeviceCode},
+		Scopes:     []string{"links:read"},
+	}, false); err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	deviceHandler := NewDeviceAuthorizationHandler(store, registry, "https://auth.example.com/oauth/device")
+	verifyHandler := NewDeviceVerificationHandler(store, registry, "")
+	tokenHandler := NewTokenHandler(store, registry, authservice.NewAuthService(store))
+	userToken, err := middleware.GenerateToken("user-1")
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	device := startDeviceAuthorization(t, deviceHandler, "cli")
+	if len(device.UserCode) != 9 || device.Interval != 5 || !strings.HasPrefix(device.VerificationURIComplete, device.VerificationURI+"?user_code=") {
+		t.Errorf("unexpected device authorization response: %+v", device)
+	}
+	poll := url.Values{"grant_type": {GrantDeviceCode}, "device_code": {device.DeviceCode}, "client_id": {"cli"}}
+
+	// Polling before the interval elapsed
+	if code := pollError(t, exchange(tokenHandler, poll)); code != errSlowDown {
+		t.Errorf("expected slow_down, got %s", code)
+	}
+	hash := storage.HashToken(device.DeviceCode)
+	if err := store.UpdateDeviceCodePolling(t.Context(), hash, time.Now().Add(-time.Minute), 10*time.Second); err != nil {
+		t.Fatalf("update polling: %v", err)
+	}
+	if code := pollError(t, exchange(tokenHandler, poll)); code != errAuthorizationPending {
+		t.Errorf("expected authorization_pending, got %s", code)
+	}
+
+	// The verification page requires a user session
+	if rr := verifyDevice(verifyHandler, http.MethodGet, "", url.Values{"user_code": {device.UserCode}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without login, got %d", rr.Code)
+	}
+	clientToken, _ := middleware.GenerateTokenWithClaims(&middleware.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "svc"}, ClientID: "svc"})
+	if rr := verifyDevice(verifyHandler, http.MethodGet, clientToken, url.Values{"user_code": {device.UserCode}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for client token, got %d", rr.Code)
+	}
+
+	// User codes are case and separator insensitive
+	rr := verifyDevice(verifyHandler, http.MethodGet, userToken, url.Values{"user_code": {strings.ToLower(strings.ReplaceAll(device.UserCode, "-", " "))}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Links CLI") {
+		t.Errorf("expected confirmation page, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Posts from other sites, and posts that do not tell where they come from, are rejected
+	for _, header := range []http.Header{
+		{"Origin": {"https://evil.example.com"}},
+		{"Referer": {"https://evil.example.com/oauth/device"}},
+		{},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/oauth/device", strings.NewReader(url.Values{"user_code": {device.UserCode}, "action": {"approve"}}.Encode()))
+		req.Header = header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+userToken)
+		rr := httptest.NewRecorder()
+		verifyHandler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%v: expected 403 for cross-origin post, got %d", header, rr.Code)
+		}
+	}
+
+	if rr := verifyDevice(verifyHandler, http.MethodPost, userToken, url.Values{"user_code": {device.UserCode}, "action": {"approve"}}); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 on approval, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = exchange(tokenHandler, poll)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 after approval, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var tokens tokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	claims, err := middleware.ParseToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	if claims.UserID != "user-1" || claims.ClientID != "cli" || claims.Scope != "links:read" || tokens.RefreshToken == "" {
+		t.Errorf("unexpected tokens: %+v, claims %+v", tokens, claims)
+	}
+
+	// Device codes are single-use
+	if code := pollError(t, exchange(tokenHandler, poll)); code != errInvalidGrant {
+		t.Errorf("expected invalid_grant on reuse, got %s", code)
+	}
+}
+
+func TestDeviceAuthorizationFlow_DeniedAndExpired(t *testing.T) {
+	store := newTestStorage(t)
+	registry := NewClientRegistry(store)
+	if _, _, err := registry.Register(t.Context(), "cli", ClientParams{GrantTypes: []string{GrantDeviceCode}, Scopes: []string{"links:read"}}, false); err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	tokenHandler := NewTokenHandler(store, registry, authservice.NewAuthService(store))
+	userToken, _ := middleware.GenerateToken("user-1")
+
+	device := startDeviceAuthorization(t, NewDeviceAuthorizationHandler(store, registry, "https://auth.example.com/oauth/device"), "cli")
+	verifyHandler := NewDeviceVerificationHandler(store, registry, "")
+	if rr := verifyDevice(verifyHandler, http.MethodPost, userToken, url.Values{"user_code": {device.UserCode}, "action": {"deny"}}); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 on denial, got %d", rr.Code)
+	}
+	poll := url.Values{"grant_type": {GrantDeviceCode}, "device_code": {device.DeviceCode}, "client_id": {"cli"}}
+	if code := pollError(t, exchange(tokenHandler, poll)); code != errAccessDenied {
+		t.Errorf("expected access_denied, got %s", code)
+	}
+
+	if err := store.CreateDeviceCode(t.Context(), &storage.DeviceCode{
+		DeviceCodeHash: storage.HashToken("expired"),
+		UserCode:       "BBBBBBBB",
+		ClientID:       "cli",
+		Status:         storage.DeviceCodePending,
+		Interval:       deviceInterval,
+		ExpiresAt:      time.Now().Add(-time.Second),
+	}); err != nil {
+		t.Fatalf("create device code: %v", err)
+	}
+	poll.Set("device_code", "expired")
+	if code := pollError(t, exchange(tokenHandler, poll)); code != errExpiredToken {
+		t.Errorf("expected expired_token, got %s", code)
+	}
+	if rr := verifyDevice(verifyHandler, http.MethodGet, userToken, url.Values{"user_code": {"BBBB-BBBB"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for expired user code, got %d", rr.Code)
+	}
+}
+
+func TestUserCode(t *testing.T) {
+	code := newUserCode()
+	if len(code) != userCodeLength || strings.Trim(code, userCodeAlphabet) != "" {
+		t.Errorf("unexpected user code %q", code)
+	}
+	if got := normalizeUserCode(" wdjb-mjht "); got != "WDJBMJHT" {
+		t.Errorf("expected WDJBMJHT, got %q", got)
+	}
+	if got := formatUserCode("WDJBMJHT"); got != "WDJB-MJHT" {
+		t.Errorf("expected WDJB-MJHT, got %q", got)
+	}
+}
@@ -0,0 +1,126 @@
+package oauth
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// Token type hints (RFC 7009 section 2.1)
+const (
+	tokenTypeAccessToken  = "access_token"
+	tokenTypeRefreshToken = "refresh_token"
+)
+
+// introspectionResponse represents the JSON structure of the introspection endpoint (RFC 7662 section 2.2)
+type introspectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Nbf       int64    `json:"nbf,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+}
+
+// IntrospectHandler handles POST requests to the token introspection endpoint
+type IntrospectHandler struct {
+	storage storage.Storage
+	clients *ClientRegistry
+}
+
+// NewIntrospectHandler is the constructor for IntrospectHandler
+func NewIntrospectHandler(store storage.Storage, clients *ClientRegistry) *IntrospectHandler {
+	return &IntrospectHandler{
+		storage: store,
+		clients: clients,
+	}
+}
+
+// ServeHTTP reports whether an access or refresh token is active
+// Only confidential clients may introspect tokens
+func (handler *IntrospectHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		log.Println("Only POST requests are allowed!")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "malformed form body")
+		return
+	}
+	client, err := handler.clients.Authenticate(req.Context(), req)
+	if err != nil || client.SecretHash == "" {
+		if _, _, basic := req.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeError(w, http.StatusUnauthorized, errInvalidClient, "client authentication failed")
+		return
+	}
+	token := req.PostForm.Get("token")
+	if token == "" {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "token is required")
+		return
+	}
+
+	// The hint only decides which lookup runs first
+	lookups := []func(*http.Request, string) (introspectionResponse, bool){handler.accessToken, handler.refreshToken}
+	if req.PostForm.Get("token_type_hint") == tokenTypeRefreshToken {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+	for _, lookup := range lookups {
+		if resp, ok := lookup(req, token); ok {
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, introspectionResponse{Active: false})
+}
+
+// accessToken introspects a JWT access token, honouring the revocation list
+func (handler *IntrospectHandler) accessToken(req *http.Request, token string) (introspectionResponse, bool) {
+	claims, err := middleware.VerifyToken(req.Context(), token)
+	if err != nil {
+		return introspectionResponse{}, false
+	}
+	resp := introspectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		TokenType: "Bearer",
+		Sub:       claims.Subject,
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		resp.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		resp.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		resp.Nbf = claims.NotBefore.Unix()
+	}
+	return resp, true
+}
+
+// refreshToken introspects a refresh token against storage, honouring revocation and expiry
+func (handler *IntrospectHandler) refreshToken(req *http.Request, token string) (introspectionResponse, bool) {
+	rt, err := handler.storage.GetRefreshToken(req.Context(), storage.HashToken(token))
+	if err != nil || rt.Revoked || time.Now().After(rt.ExpiresAt) {
+		return introspectionResponse{}, false
+	}
+	return introspectionResponse{
+		Active: true,
+		Sub:    rt.UserID,
+		Exp:    rt.ExpiresAt.Unix(),
+	}, true
+}
@@ -0,0 +1,92 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+func TestIntrospectHandler(t *testing.T) {
+	store := newTestStorage(t)
+	registry := newTestRegistry(t, store)
+	rs, secret, err := registry.Register(t.Context(), "resource-server", ClientParams{}, true)
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	handler := NewIntrospectHandler(store, registry)
+	authSvc := authservice.NewAuthService(store)
+
+	introspect := func(clientID, clientSecret string, form url.Values) (*httptest.ResponseRecorder, introspectionResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientID, clientSecret)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var resp introspectionResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+		}
+		return rr, resp
+	}
+
+	accessToken, err := middleware.GenerateTokenWithClaims(&middleware.Claims{UserID: "user-1", ClientID: testClientID, Scope: "links:read"})
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	refreshToken, expiresAt, err := authSvc.IssueRefreshToken(t.Context(), "user-1", authservice.ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+
+	// Only confidential clients may introspect
+	if rr, _ := introspect(testClientID, "", url.Values{"token": {accessToken}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for public client, got %d", rr.Code)
+	}
+	if rr, _ := introspect(rs.ClientID, "wrong", url.Values{"token": {accessToken}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for wrong secret, got %d", rr.Code)
+	}
+
+	_, resp := introspect(rs.ClientID, secret, url.Values{"token": {accessToken}})
+	if !resp.Active || resp.Sub != "user-1" || resp.Scope != "links:read" || resp.ClientID != testClientID || resp.Exp == 0 {
+		t.Errorf("unexpected access token introspection: %+v", resp)
+	}
+
+	_, resp = introspect(rs.ClientID, secret, url.Values{"token": {refreshToken}, "token_type_hint": {"refresh_token"}})
+	if !resp.Active || resp.Sub != "user-1" || resp.Exp != expiresAt.Unix() {
+		t.Errorf("unexpected refresh token introspection: %+v", resp)
+	}
+
+	// A wrong hint still finds the token
+	if _, resp = introspect(rs.ClientID, secret, url.Values{"token": {refreshToken}, "token_type_hint": {"access_token"}}); !resp.Active {
+		t.Error("expected refresh token to be active despite the hint")
+	}
+
+	if err := store.RevokeRefreshToken(t.Context(), storage.HashToken(refreshToken)); err != nil {
+		t.Fatalf("revoke refresh token: %v", err)
+	}
+	if _, resp = introspect(rs.ClientID, secret, url.Values{"token": {refreshToken}}); resp.Active {
+		t.Error("expected revoked refresh token to be inactive")
+	}
+
+	if err := store.CreateRefreshToken(t.Context(), &storage.RefreshToken{TokenHash: storage.HashToken("expired-token"), UserID: "user-1", FamilyID: "expired-token", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("create refresh token: %v", err)
+	}
+	if _, resp = introspect(rs.ClientID, secret, url.Values{"token": {"expired-token"}}); resp.Active {
+		t.Error("expected expired refresh token to be inactive")
+	}
+
+	rr, resp := introspect(rs.ClientID, secret, url.Values{"token": {"garbage"}})
+	if rr.Code != http.StatusOK || resp.Active || strings.TrimSpace(rr.Body.String()) != `{"active":false}` {
+		t.Errorf("expected bare inactive response, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,106 @@
+// Package oauth provides OAuth 2.0 authorization server endpoints
+package oauth
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+)
+
+// OAuth 2.0 error codes (RFC 6749 section 4.1.2.1 and 5.2)
+const (
+	errInvalidRequest          = "invalid_request"
+	errInvalidClient           = "invalid_client"
+	errInvalidGrant            = "invalid_grant"
+	errInvalidScope            = "invalid_scope"
+	errUnauthorizedClient      = "unauthorized_client"
+	errUnsupportedGrantType    = "unsupported_grant_type"
+	errUnsupportedResponseType = "unsupported_response_type"
+	errLoginRequired           = "login_required"
+	errServerError             = "server_error"
+
+	// Device authorization grant errors (RFC 8628 section 3.5)
+	errAuthorizationPending = "authorization_pending"
+	errSlowDown             = "slow_down"
+	errAccessDenied         = "access_denied"
+	errExpiredToken         = "expired_token"
+)
+
+// errorResponse represents the JSON error structure of the token endpoint
+type errorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// tokenResponse represents the JSON success structure of the token endpoint
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// writeJSON writes a non-cacheable JSON response
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("Can not encode response", err)
+	}
+}
+
+// writeError writes an OAuth error response
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, errorResponse{Error: code, Description: description})
+}
+
+// redirectWithParams redirects to the target URI with extra query parameters
+func redirectWithParams(w http.ResponseWriter, req *http.Request, target string, params url.Values) {
+	u, err := url.Parse(target)
+	if err != nil {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, req, u.String(), http.StatusFound)
+}
+
+// newCode returns a random single-use code
+func newCode() string {
+	return rand.Text()
+}
+
+// currentUser returns the claims of the logged-in user's access token
+// Only first-party login tokens are accepted; a token issued to an OAuth client, on its own or a user's
+// behalf, must not grant access to other clients
+func currentUser(req *http.Request) (*middleware.Claims, error) {
+	tokenString, err := middleware.TokenFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := middleware.VerifyToken(req.Context(), tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Caller() != middleware.CallerUser {
+		return nil, errors.New("token does not belong to a user")
+	}
+	if claims.ClientID != "" {
+		return nil, errors.New("token was issued to an OAuth client")
+	}
+	return claims, nil
+}
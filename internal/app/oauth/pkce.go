@@ -0,0 +1,40 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// pkceMethodS256 is the only accepted PKCE challenge method
+const pkceMethodS256 = "S256"
+
+// validPKCEValue reports whether a code verifier or challenge has the RFC 7636 form:
+// 43 to 128 characters from [A-Z] / [a-z] / [0-9] / "-" / "." / "_" / "~"
+func validPKCEValue(value string) bool {
+	if len(value) < 43 || len(value) > 128 {
+		return false
+	}
+	for _, c := range value {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// pkceChallenge computes the S256 challenge for a verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verifyPKCE checks a code verifier against the stored challenge
+func verifyPKCE(verifier, challenge, method string) bool {
+	if method != pkceMethodS256 || !validPKCEValue(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(pkceChallenge(verifier)), []byte(challenge)) == 1
+}
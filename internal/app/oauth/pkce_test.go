@@ -0,0 +1,25 @@
+package oauth
+
+import "testing"
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if pkceChallenge(verifier) != challenge {
+		t.Fatalf("unexpected challenge %s", pkceChallenge(verifier))
+	}
+	if !verifyPKCE(verifier, challenge, pkceMethodS256) {
+		t.Error("expected verifier to match")
+	}
+	if verifyPKCE(verifier, challenge, "plain") {
+		t.Error("expected plain method to be rejected")
+	}
+	if verifyPKCE(verifier+"x", challenge, pkceMethodS256) {
+		t.Error("expected wrong verifier to be rejected")
+	}
+	if validPKCEValue("short") {
+		t.Error("expected short verifier to be invalid")
+	}
+}
@@ -0,0 +1,114 @@
+package oauth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// errForeignToken is returned when a client tries to revoke a token issued to another client
+var errForeignToken = errors.New("token was not issued to the client")
+
+// RevokeHandler handles POST requests to the token revocation endpoint
+type RevokeHandler struct {
+	storage     storage.Storage
+	clients     *ClientRegistry
+	authService *authservice.AuthService
+}
+
+// NewRevokeHandler is the constructor for RevokeHandler
+func NewRevokeHandler(store storage.Storage, clients *ClientRegistry, authService *authservice.AuthService) *RevokeHandler {
+	return &RevokeHandler{
+		storage:     store,
+		clients:     clients,
+		authService: authService,
+	}
+}
+
+// ServeHTTP revokes an access or refresh token (RFC 7009)
+// Unknown, invalid and expired tokens are answered with 200 as there is nothing left to revoke
+func (handler *RevokeHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		log.Println("Only POST requests are allowed!")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "malformed form body")
+		return
+	}
+	client, err := handler.clients.Authenticate(req.Context(), req)
+	if err != nil {
+		if _, _, basic := req.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeError(w, http.StatusUnauthorized, errInvalidClient, "client authentication failed")
+		return
+	}
+	token := req.PostForm.Get("token")
+	if token == "" {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "token is required")
+		return
+	}
+
+	// The hint only decides which token type is tried first
+	revokers := []func(*http.Request, *storage.Client, string) (bool, error){handler.revokeAccessToken, handler.revokeRefreshToken}
+	if req.PostForm.Get("token_type_hint") == tokenTypeRefreshToken {
+		revokers[0], revokers[1] = revokers[1], revokers[0]
+	}
+	for _, revoke := range revokers {
+		found, err := revoke(req, client, token)
+		if errors.Is(err, errForeignToken) {
+			writeError(w, http.StatusBadRequest, errUnauthorizedClient, err.Error())
+			return
+		}
+		if err != nil {
+			log.Println("Failed to revoke token", err)
+			writeError(w, http.StatusServiceUnavailable, errServerError, "")
+			return
+		}
+		if found {
+			break
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeAccessToken puts a valid access token on the denylist
+// Returns false if the token is not a valid access token
+func (handler *RevokeHandler) revokeAccessToken(req *http.Request, client *storage.Client, token string) (bool, error) {
+	claims, err := middleware.ParseToken(token)
+	if err != nil {
+		return false, nil
+	}
+	if claims.ClientID != client.ClientID {
+		return true, errForeignToken
+	}
+	return true, handler.authService.RevokeAccessToken(req.Context(), claims.ID, claims.ExpiresAt.Time)
+}
+
+// revokeRefreshToken revokes a stored refresh token issued to the client
+// Returns false if the refresh token does not exist
+func (handler *RevokeHandler) revokeRefreshToken(req *http.Request, client *storage.Client, token string) (bool, error) {
+	hash := storage.HashToken(token)
+	rt, err := handler.storage.GetRefreshToken(req.Context(), hash)
+	if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// First-party login sessions belong to no client and are ended through the session endpoints
+	if rt.ClientID != client.ClientID {
+		return true, errForeignToken
+	}
+	err = handler.storage.RevokeRefreshToken(req.Context(), hash)
+	if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,84 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+func TestRevokeHandler(t *testing.T) {
+	store := newTestStorage(t)
+	middleware.SetRevocationList(store)
+	defer middleware.SetRevocationList(nil)
+
+	registry := newTestRegistry(t, store)
+	authSvc := authservice.NewAuthService(store)
+	handler := NewRevokeHandler(store, registry, authSvc)
+
+	revoke := func(form url.Values) *httptest.ResponseRecorder {
+		form.Set("client_id", testClientID)
+		req := httptest.NewRequest(http.MethodPost, "/oauth/revoke", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	accessToken, err := middleware.GenerateTokenWithClaims(&middleware.Claims{UserID: "user-1", ClientID: testClientID})
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	if rr := revoke(url.Values{"token": {accessToken}}); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := middleware.VerifyToken(context.Background(), accessToken); !errors.Is(err, middleware.ErrTokenRevoked) {
+		t.Errorf("expected revoked access token, got %v", err)
+	}
+
+	refreshToken, _, err := authSvc.IssueRefreshToken(t.Context(), "user-1", authservice.ClientInfo{ClientID: testClientID})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	if rr := revoke(url.Values{"token": {refreshToken}, "token_type_hint": {"refresh_token"}}); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if rt, _ := store.GetRefreshToken(t.Context(), storage.HashToken(refreshToken)); rt == nil || !rt.Revoked {
+		t.Error("expected refresh token to be revoked")
+	}
+
+	// Unknown tokens are not an error
+	if rr := revoke(url.Values{"token": {"unknown"}}); rr.Code != http.StatusOK {
+		t.Errorf("expected 200 for unknown token, got %d", rr.Code)
+	}
+
+	// Access tokens of other clients and first-party logins are refused
+	userToken, _ := middleware.GenerateToken("user-1")
+	if rr := revoke(url.Values{"token": {userToken}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for token of another client, got %d", rr.Code)
+	}
+	if _, err := middleware.VerifyToken(context.Background(), userToken); err != nil {
+		t.Errorf("expected foreign token to stay valid, got %v", err)
+	}
+
+	// So are refresh tokens of other clients and first-party logins
+	for _, owner := range []string{"other", ""} {
+		foreign, _, err := authSvc.IssueRefreshToken(t.Context(), "user-1", authservice.ClientInfo{ClientID: owner})
+		if err != nil {
+			t.Fatalf("issue refresh token: %v", err)
+		}
+		if rr := revoke(url.Values{"token": {foreign}, "token_type_hint": {"refresh_token"}}); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for refresh token of client %q, got %d", owner, rr.Code)
+		}
+		if rt, _ := store.GetRefreshToken(t.Context(), storage.HashToken(foreign)); rt == nil || rt.Revoked {
+			t.Errorf("expected refresh token of client %q to stay valid", owner)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package oauth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+// TokenHandler handles POST requests to the token endpoint
+type TokenHandler struct {
+	storage     storage.Storage
+	clients     *ClientRegistry
+	authService *authservice.AuthService
+}
+
+// NewTokenHandler is the constructor for TokenHandler
+func NewTokenHandler(store storage.Storage, clients *ClientRegistry, authService *authservice.AuthService) *TokenHandler {
+	return &TokenHandler{
+		storage:     store,
+		clients:     clients,
+		authService: authService,
+	}
+}
+
+// ServeHTTP dispatches token requests by grant_type
+func (handler *TokenHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		log.Println("Only POST requests are allowed!")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "malformed form body")
+		return
+	}
+
+	grantType := req.PostForm.Get("grant_type")
+	if !slices.Contains(supportedGrantTypes, grantType) {
+		writeError(w, http.StatusBadRequest, errUnsupportedGrantType, "")
+		return
+	}
+
+	// Every grant requires a registered client
+	client, err := handler.clients.Authenticate(req.Context(), req)
+	if err != nil {
+		if _, _, basic := req.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeError(w, http.StatusUnauthorized, errInvalidClient, "client authentication failed")
+		return
+	}
+	if !AllowsGrant(client, grantType) {
+		writeError(w, http.StatusBadRequest, errUnauthorizedClient, "grant type is not allowed for the client")
+		return
+	}
+
+	switch grantType {
+	case GrantAuthorizationCode:
+		handler.authorizationCode(w, req, client)
+	case GrantRefreshToken:
+		handler.refreshToken(w, req, client)
+	case GrantClientCredentials:
+		handler.clientCredentials(w, req, client)
+	case GrantDeviceCode:
+		handler.deviceCode(w, req, client)
+	}
+}
+
+// authorizationCode exchanges an authorization code and PKCE verifier for tokens
+func (handler *TokenHandler) authorizationCode(w http.ResponseWriter, req *http.Request, client *storage.Client) {
+	form := req.PostForm
+	code := form.Get("code")
+	if code == "" || form.Get("code_verifier") == "" {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "code and code_verifier are required")
+		return
+	}
+
+	// Consuming first makes the code single-use even if validation fails
+	authCode, err := handler.storage.ConsumeAuthorizationCode(req.Context(), storage.HashToken(code))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidGrant, "unknown or used authorization code")
+		return
+	}
+	switch {
+	case time.Now().After(authCode.ExpiresAt):
+		writeError(w, http.StatusBadRequest, errInvalidGrant, "authorization code expired")
+		return
+	case authCode.ClientID != client.ClientID:
+		writeError(w, http.StatusBadRequest, errInvalidGrant, "client_id mismatch")
+		return
+	case authCode.RedirectURI != form.Get("redirect_uri"):
+		writeError(w, http.StatusBadRequest, errInvalidGrant, "redirect_uri mismatch")
+		return
+	case !verifyPKCE(form.Get("code_verifier"), authCode.CodeChallenge, authCode.CodeChallengeMethod):
+		writeError(w, http.StatusBadRequest, errInvalidGrant, "PKCE verification failed")
+		return
+	}
+
+	handler.issueTokens(w, req, authCode.UserID, authCode.ClientID, authCode.Scope)
+}
+
+// refreshToken rotates a refresh token issued to the client by the token endpoint
+// The new access token carries the client_id and scope of the original grant
+func (handler *TokenHandler) refreshToken(w http.ResponseWriter, req *http.Request, client *storage.Client) {
+	refreshToken := req.PostForm.Get("refresh_token")
+	if refreshToken == "" {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "refresh_token is required")
+		return
+	}
+	info := authservice.ClientInfoFromRequest(req, "")
+	info.ClientID = client.ClientID
+	userID, newRefreshToken, _, err := handler.authService.RefreshSession(req.Context(), refreshToken, info)
+	if errors.Is(err, authservice.ErrInvalidRefreshToken) {
+		writeError(w, http.StatusBadRequest, errInvalidGrant, "invalid refresh token")
+		return
+	}
+	if err != nil {
+		log.Println("Failed to refresh session", err)
+		writeError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+	scope, err := handler.authService.SessionScope(req.Context(), newRefreshToken)
+	if err != nil {
+		log.Println("Failed to read session scope", err)
+		writeError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+	accessToken, err := middleware.GenerateTokenWithClaims(&middleware.Claims{
+		UserID:   userID,
+		ClientID: client.ClientID,
+		Scope:    scope,
+	})
+	if err != nil {
+		log.Println("Failed to generate token", err)
+		writeError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(middleware.MaxTokenLifetime.Seconds()),
+		RefreshToken: newRefreshToken,
+		Scope:        scope,
+	})
+}
+
+// clientCredentials issues an access token to the client itself (RFC 6749 section 4.4)
+// Only confidential clients may use it; no refresh token is issued
+func (handler *TokenHandler) clientCredentials(w http.ResponseWriter, req *http.Request, client *storage.Client) {
+	if client.SecretHash == "" {
+		writeError(w, http.StatusBadRequest, errUnauthorizedClient, "client_credentials requires a confidential client")
+		return
+	}
+	scope := req.PostForm.Get("scope")
+	if scope == "" {
+		scope = strings.Join(client.Scopes, " ")
+	} else if !AllowsScope(client, scope) {
+		writeError(w, http.StatusBadRequest, errInvalidScope, "scope is not allowed for the client")
+		return
+	}
+
+	accessToken, err := middleware.GenerateTokenWithClaims(&middleware.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: client.ClientID},
+		ClientID:         client.ClientID,
+		Scope:            scope,
+	})
+	if err != nil {
+		log.Println("Failed to generate token", err)
+		writeError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(middleware.MaxTokenLifetime.Seconds()),
+		Scope:       scope,
+	})
+}
+
+// deviceCode answers a device polling request (RFC 8628 section 3.4)
+// Tokens are issued once the user approved the request; until then the client is told to keep polling
+func (handler *TokenHandler) deviceCode(w http.ResponseWriter, req *http.Request, client *storage.Client) {
+	deviceCode := req.PostForm.Get("device_code")
+	if deviceCode == "" {
+		writeError(w, http.StatusBadRequest, errInvalidRequest, "device_code is required")
+		return
+	}
+	hash := storage.HashToken(deviceCode)
+	code, err := handler.storage.GetDeviceCode(req.Context(), hash)
+	if err != nil || code.ClientID != client.ClientID {
+		writeError(w, http.StatusBadRequest, errInvalidGrant, "unknown device code")
+		return
+	}
+	now := time.Now()
+	if now.After(code.ExpiresAt) {
+		writeError(w, http.StatusBadRequest, errExpiredToken, "device code expired")
+		return
+	}
+
+	switch code.Status {
+	case storage.DeviceCodeDenied:
+		writeError(w, http.StatusBadRequest, errAccessDenied, "the user denied the request")
+		return
+	case storage.DeviceCodeApproved:
+		// Deleting first makes the device code single-use under concurrent polls
+		if err := handler.storage.DeleteDeviceCode(req.Context(), hash); err != nil {
+			writeError(w, http.StatusBadRequest, errInvalidGrant, "device code already used")
+			return
+		}
+		handler.issueTokens(w, req, code.UserID, code.ClientID, code.Scope)
+		return
+	}
+
+	// Polling faster than the interval increases it by 5 seconds
+	interval := code.Interval
+	tooFast := now.Sub(code.LastPolledAt) < interval
+	if tooFast {
+		interval += deviceSlowDownStep
+	}
+	if err := handler.storage.UpdateDeviceCodePolling(req.Context(), hash, now, interval); err != nil {
+		log.Println("Failed to update device code", err)
+		writeError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+	if tooFast {
+		writeError(w, http.StatusBadRequest, errSlowDown, "")
+		return
+	}
+	writeError(w, http.StatusBadRequest, errAuthorizationPending, "")
+}
+
+// issueTokens writes an access token and a new refresh token for the user
+func (handler *TokenHandler) issueTokens(w http.ResponseWriter, req *http.Request, userID, clientID, scope string) {
+	accessToken, err := middleware.GenerateTokenWithClaims(&middleware.Claims{
+		UserID:   userID,
+		ClientID: clientID,
+		Scope:    scope,
+	})
+	if err != nil {
+		log.Println("Failed to generate token", err)
+		writeError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+	// Sessions started through OAuth are labelled with the client ID and bound to the client and scope
+	info := authservice.ClientInfoFromRequest(req, clientID)
+	info.ClientID, info.Scope = clientID, scope
+	refreshToken, _, err := handler.authService.IssueRefreshToken(req.Context(), userID, info)
+	if err != nil {
+		log.Println("Failed to issue refresh token", err)
+		writeError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(middleware.MaxTokenLifetime.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	})
+}
@@ -0,0 +1,428 @@
+package oauth
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
+	"github.com/vitalykrupin/auth-service/internal/app/authservice"
+	"github.com/vitalykrupin/auth-service/internal/app/storage"
+)
+
+const (
+	testClientID    = "spa"
+	testRedirectURI = "https://app.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// newTestStorage creates file storage in a temporary directory
+func newTestStorage(t *testing.T) storage.Storage {
+	t.Helper()
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+	return store
+}
+
+// newTestRegistry creates a client registry with the public test client registered
+func newTestRegistry(t *testing.T, store storage.Storage) *ClientRegistry {
+	t.Helper()
+	registry := NewClientRegistry(store)
+	if _, _, err := registry.Register(t.Context(), testClientID, ClientParams{
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   []string{GrantAuthorizationCode, GrantRefreshToken},
+		Scopes:       []string{"links:read"},
+	}, false); err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	return registry
+}
+
+// authorize runs the authorization endpoint as the given user, approving the consent page when it is shown,
+// and returns the redirect location
+func authorize(t *testing.T, handler http.Handler, userID string, params url.Values) *url.URL {
+	t.Helper()
+	rr := consent(t, handler, http.MethodGet, userID, params, "")
+	if rr.Code == http.StatusOK {
+		rr = consent(t, handler, http.MethodPost, userID, params, "approve")
+	}
+	if rr.Code != http.StatusFound {
+		t.Fatalf("expected 302, got %d: %s", rr.Code, rr.Body.String())
+	}
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	return location
+}
+
+// consent sends an authorization request as the given user; POST requests carry the decision in action
+func consent(t *testing.T, handler http.Handler, method, userID string, params url.Values, action string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(url.Values{"action": {action}}.Encode())
+	}
+	req := httptest.NewRequest(method, "/oauth/authorize?"+params.Encode(), body)
+	if method == http.MethodPost {
+		// The consent page posts to itself
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Referer", "http://"+req.Host+req.URL.RequestURI())
+	}
+	if userID != "" {
+		token, err := middleware.GenerateToken(userID)
+		if err != nil {
+			t.Fatalf("generate token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// exchange posts a form to the token endpoint
+func exchange(handler http.Handler, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	store := newTestStorage(t)
+	registry := newTestRegistry(t, store)
+	authorizeHandler := NewAuthorizeHandler(store, registry, "")
+	tokenHandler := NewTokenHandler(store, registry, authservice.NewAuthService(store))
+
+	location := authorize(t, authorizeHandler, "user-1", url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirectURI},
+		"state":                 {"xyz"},
+		"scope":                 {"links:read"},
+		"code_challenge":        {pkceChallenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	})
+	code := location.Query().Get("code")
+	if code == "" || location.Query().Get("state") != "xyz" {
+		t.Fatalf("unexpected redirect: %s", location)
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {testClientID},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+	}
+	rr := exchange(tokenHandler, form)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp tokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	claims, err := middleware.ParseToken(resp.AccessToken)
+	if err != nil || claims.UserID != "user-1" || claims.Scope != "links:read" || claims.ClientID != testClientID {
+		t.Fatalf("unexpected access token claims %+v: %v", claims, err)
+	}
+	if resp.RefreshToken == "" {
+		t.Error("expected refresh token")
+	}
+
+	// Codes are single-use
+	if rr := exchange(tokenHandler, form); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 on code reuse, got %d", rr.Code)
+	}
+
+	// The refresh token works on the token endpoint and keeps the client and scope of the grant
+	rr = exchange(tokenHandler, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {resp.RefreshToken}, "client_id": {testClientID}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 on refresh, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var refreshed tokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&refreshed); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	claims, err = middleware.ParseToken(refreshed.AccessToken)
+	if err != nil || claims.UserID != "user-1" || claims.Scope != "links:read" || claims.ClientID != testClientID || refreshed.Scope != "links:read" {
+		t.Errorf("unexpected refreshed access token claims %+v: %v", claims, err)
+	}
+}
+
+func TestTokenHandler_RefreshTokenBoundToClient(t *testing.T) {
+	store := newTestStorage(t)
+	registry := newTestRegistry(t, store)
+	if _, _, err := registry.Register(t.Context(), "other", ClientParams{GrantTypes: []string{GrantRefreshToken}}, false); err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	authSvc := authservice.NewAuthService(store)
+	handler := NewTokenHandler(store, registry, authSvc)
+
+	issued, _, err := authSvc.IssueRefreshToken(t.Context(), "user-1", authservice.ClientInfo{ClientID: testClientID, Scope: "links:read"})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	rr := exchange(handler, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {issued}, "client_id": {"other"}})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errInvalidGrant) {
+		t.Errorf("expected invalid_grant for a token of another client, got %d: %s", rr.Code, rr.Body.String())
+	}
+	// The failed attempt does not use up the token
+	if rr := exchange(handler, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {issued}, "client_id": {testClientID}}); rr.Code != http.StatusOK {
+		t.Errorf("expected 200 for the owning client, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Refresh tokens of first-party logins belong to no client
+	login, _, err := authSvc.IssueRefreshToken(t.Context(), "user-1", authservice.ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+	if rr := exchange(handler, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {login}, "client_id": {"other"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a first-party refresh token, got %d", rr.Code)
+	}
+}
+
+func TestAuthorizationCodeFlow_WrongVerifier(t *testing.T) {
+	store := newTestStorage(t)
+	registry := newTestRegistry(t, store)
+	authorizeHandler := NewAuthorizeHandler(store, registry, "")
+	tokenHandler := NewTokenHandler(store, registry, authservice.NewAuthService(store))
+
+	location := authorize(t, authorizeHandler, "user-1", url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirectURI},
+		"code_challenge":        {pkceChallenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	})
+	rr := exchange(tokenHandler, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {location.Query().Get("code")},
+		"client_id":     {testClientID},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {strings.Repeat("a", 43)},
+	})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errInvalidGrant) {
+		t.Errorf("expected invalid_grant, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAuthorizeHandler_Errors(t *testing.T) {
+	store := newTestStorage(t)
+	handler := NewAuthorizeHandler(store, newTestRegistry(t, store), "https://app.example.com/login")
+
+	// Unregistered redirect URIs are never redirected to
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?client_id=spa&redirect_uri=https://evil.example.com", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unregistered redirect_uri, got %d", rr.Code)
+	}
+
+	// Anonymous users go to the login page
+	location := authorize(t, handler, "", url.Values{"client_id": {testClientID}, "redirect_uri": {testRedirectURI}})
+	if location.Host != "app.example.com" || location.Path != "/login" || location.Query().Get("return_to") == "" {
+		t.Errorf("expected login redirect, got %s", location)
+	}
+
+	// Scopes must be registered for the client
+	location = authorize(t, handler, "user-1", url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"links:write"},
+		"code_challenge":        {pkceChallenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	})
+	if location.Query().Get("error") != errInvalidScope {
+		t.Errorf("expected invalid_scope, got %s", location)
+	}
+
+	// PKCE is mandatory
+	location = authorize(t, handler, "user-1", url.Values{
+		"response_type": {"code"},
+		"client_id":     {testClientID},
+		"redirect_uri":  {testRedirectURI},
+	})
+	if location.Query().Get("error") != errInvalidRequest {
+		t.Errorf("expected invalid_request without PKCE, got %s", location)
+	}
+}
+
+func TestAuthorizeHandler_Consent(t *testing.T) {
+	store := newTestStorage(t)
+	handler := NewAuthorizeHandler(store, newTestRegistry(t, store), "")
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"links:read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {pkceChallenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	// Visiting the endpoint only shows the consent page
+	rr := consent(t, handler, http.MethodGet, "user-1", params, "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Location") != "" || !strings.Contains(rr.Body.String(), `method="post"`) {
+		t.Fatalf("expected consent page, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("X-Frame-Options") != "DENY" {
+		t.Error("expected the consent page to refuse framing")
+	}
+
+	// Approvals posted by other sites, or without Origin and Referer, are rejected
+	token, _ := middleware.GenerateToken("user-1")
+	for _, header := range []http.Header{
+		{"Origin": {"https://evil.example.com"}},
+		{"Origin": {"null"}, "Referer": {"http://example.com/oauth/authorize"}},
+		{"Referer": {"https://evil.example.com/"}},
+		{},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/oauth/authorize?"+params.Encode(), strings.NewReader("action=approve"))
+		req.Header = header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%v: expected 403 for cross-origin approval, got %d", header, rr.Code)
+		}
+	}
+
+	// Denying redirects with access_denied
+	rr = consent(t, handler, http.MethodPost, "user-1", params, "deny")
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	if rr.Code != http.StatusFound || location.Query().Get("error") != errAccessDenied ||
+		location.Query().Get("state") != "xyz" || location.Query().Get("code") != "" {
+		t.Errorf("expected access_denied, got %d %s", rr.Code, location)
+	}
+
+	// Approving issues the code
+	rr = consent(t, handler, http.MethodPost, "user-1", params, "approve")
+	location, _ = url.Parse(rr.Header().Get("Location"))
+	if rr.Code != http.StatusFound || location.Query().Get("code") == "" || location.Query().Get("state") != "xyz" {
+		t.Errorf("expected code, got %d %s", rr.Code, location)
+	}
+}
+
+func TestTokenHandler_ClientAuthentication(t *testing.T) {
+	store := newTestStorage(t)
+	registry := NewClientRegistry(store)
+	client, secret, err := registry.Register(t.Context(), "", ClientParams{GrantTypes: []string{GrantRefreshToken}}, true)
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	authSvc := authservice.NewAuthService(store)
+	handler := NewTokenHandler(store, registry, authSvc)
+
+	newRefreshToken := func() string {
+		token, _, err := authSvc.IssueRefreshToken(t.Context(), "user-1", authservice.ClientInfo{ClientID: client.ClientID})
+		if err != nil {
+			t.Fatalf("issue refresh token: %v", err)
+		}
+		return token
+	}
+
+	// Wrong secret
+	rr := exchange(handler, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {newRefreshToken()}, "client_id": {client.ClientID}, "client_secret": {"wrong"}})
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for wrong secret, got %d", rr.Code)
+	}
+
+	// client_secret_post
+	rr = exchange(handler, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {newRefreshToken()}, "client_id": {client.ClientID}, "client_secret": {secret}})
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 for client_secret_post, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// client_secret_basic
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {newRefreshToken()}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(client.ClientID, secret)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 for client_secret_basic, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Grant types are enforced
+	rr = exchange(handler, url.Values{"grant_type": {"authorization_code"}, "code": {"x"}, "code_verifier": {testVerifier}, "client_id": {client.ClientID}, "client_secret": {secret}})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errUnauthorizedClient) {
+		t.Errorf("expected unauthorized_client, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Rotation invalidates the old secret
+	if _, err := registry.RotateSecret(t.Context(), client.ClientID); err != nil {
+		t.Fatalf("rotate secret: %v", err)
+	}
+	rr = exchange(handler, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {newRefreshToken()}, "client_id": {client.ClientID}, "client_secret": {secret}})
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 after rotation, got %d", rr.Code)
+	}
+}
+
+func TestTokenHandler_ClientCredentials(t *testing.T) {
+	store := newTestStorage(t)
+	registry := newTestRegistry(t, store)
+	service, secret, err := registry.Register(t.Context(), "svc", ClientParams{
+		GrantTypes: []string{GrantClientCredentials},
+		Scopes:     []string{"links:read", "links:write"},
+	}, true)
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	handler := NewTokenHandler(store, registry, authservice.NewAuthService(store))
+
+	rr := exchange(handler, url.Values{"grant_type": {"client_credentials"}, "scope": {"links:read"}, "client_id": {service.ClientID}, "client_secret": {secret}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp tokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.RefreshToken != "" {
+		t.Error("client_credentials must not issue a refresh token")
+	}
+	claims, err := middleware.ParseToken(resp.AccessToken)
+	if err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	if claims.Subject != "svc" || claims.UserID != "" || claims.Scope != "links:read" || claims.Caller() != middleware.CallerClient {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	// Without scope every registered scope is granted
+	rr = exchange(handler, url.Values{"grant_type": {"client_credentials"}, "client_id": {service.ClientID}, "client_secret": {secret}})
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Scope != "links:read links:write" {
+		t.Errorf("expected all registered scopes, got %q", resp.Scope)
+	}
+
+	rr = exchange(handler, url.Values{"grant_type": {"client_credentials"}, "scope": {"admin"}, "client_id": {service.ClientID}, "client_secret": {secret}})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errInvalidScope) {
+		t.Errorf("expected invalid_scope, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Public clients can not use client_credentials
+	if _, err := registry.Update(t.Context(), testClientID, ClientParams{RedirectURIs: []string{testRedirectURI}, GrantTypes: []string{GrantClientCredentials}}); err != nil {
+		t.Fatalf("update client: %v", err)
+	}
+	rr = exchange(handler, url.Values{"grant_type": {"client_credentials"}, "client_id": {testClientID}})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errUnauthorizedClient) {
+		t.Errorf("expected unauthorized_client for public client, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -65,7 +66,8 @@ func (d *DB) PingStorage(ctx context.Context) error {
 // Returns the user and an error if retrieval failed
 func (d *DB) GetUserByLogin(ctx context.Context, login string) (user *User, err error) {
 	user = &User{}
-	err = d.pool.QueryRow(ctx, `SELECT id, login, password, user_id FROM users WHERE login = $1;`, login).Scan(&user.ID, &user.Login, &user.Password, &user.UserID)
+	err = d.pool.QueryRow(ctx, `SELECT id, login, COALESCE(password, ''), user_id, token_version FROM users WHERE login = $1;`, login).
+		Scan(&user.ID, &user.Login, &user.Password, &user.UserID, &user.TokenVersion)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("user not found for login: %s", login)
@@ -78,10 +80,10 @@ func (d *DB) GetUserByLogin(ctx context.Context, login string) (user *User, err
 
 // CreateUser creates a new user
 // ctx is the request context
-// user is the user to create
+// user is the user to create; passwordless users are stored with a NULL password
 // Returns an error if creation failed
 func (d *DB) CreateUser(ctx context.Context, user *User) error {
-	_, err := d.pool.Exec(ctx, `INSERT INTO users (login, password, user_id) VALUES ($1, $2, $3);`, user.Login, user.Password, user.UserID)
+	_, err := d.pool.Exec(ctx, `INSERT INTO users (login, password, user_id) VALUES ($1, NULLIF($2, ''), $3);`, user.Login, user.Password, user.UserID)
 	if err != nil {
 		log.Printf("Failed to create user in database: %v", err)
 		return fmt.Errorf("database error: %w", err)
@@ -89,58 +91,321 @@ func (d *DB) CreateUser(ctx context.Context, user *User) error {
 	return nil
 }
 
+// GetUserByID retrieves a user by user ID
+func (d *DB) GetUserByID(ctx context.Context, userID string) (*User, error) {
+	user := &User{}
+	err := d.pool.QueryRow(ctx, `SELECT id, login, COALESCE(password, ''), user_id, token_version FROM users WHERE user_id = $1;`, userID).
+		Scan(&user.ID, &user.Login, &user.Password, &user.UserID, &user.TokenVersion)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return user, nil
+}
+
+// UpdateUserPassword replaces the user's password hash
+func (d *DB) UpdateUserPassword(ctx context.Context, userID, passwordHash string) error {
+	tag, err := d.pool.Exec(ctx, `UPDATE users SET password = $2 WHERE user_id = $1;`, userID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+	}
+	return nil
+}
+
+// GetTokenVersion returns the user's access token version
+func (d *DB) GetTokenVersion(ctx context.Context, userID string) (int, error) {
+	var version int
+	err := d.pool.QueryRow(ctx, `SELECT token_version FROM users WHERE user_id = $1;`, userID).Scan(&version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	return version, nil
+}
+
+// IncrementTokenVersion bumps the user's access token version
+func (d *DB) IncrementTokenVersion(ctx context.Context, userID string) (int, error) {
+	var version int
+	err := d.pool.QueryRow(ctx, `
+        UPDATE users SET token_version = token_version + 1 WHERE user_id = $1 RETURNING token_version;`, userID).Scan(&version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+		}
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	return version, nil
+}
+
 // SetUserProfile upserts user's profile
-func (d *DB) SetUserProfile(ctx context.Context, userID, email string) error {
-	_, err := d.pool.Exec(ctx, `
-        INSERT INTO profiles (user_id, email)
-        VALUES ($1, $2)
-        ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email;`, userID, email)
+// Changing the email resets its verification
+func (d *DB) SetUserProfile(ctx context.Context, profile *Profile) error {
+	var verifiedAt *time.Time
+	err := d.pool.QueryRow(ctx, `
+        INSERT INTO profiles (user_id, email, display_name, locale, timezone, avatar_url)
+        VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''))
+        ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email,
+            email_verified_at = CASE WHEN profiles.email = EXCLUDED.email THEN profiles.email_verified_at END,
+            display_name = EXCLUDED.display_name, locale = EXCLUDED.locale, timezone = EXCLUDED.timezone,
+            avatar_url = EXCLUDED.avatar_url, updated_at = NOW()
+        RETURNING email_verified_at, created_at, updated_at;`,
+		profile.UserID, profile.Email, profile.DisplayName, profile.Locale, profile.Timezone, profile.AvatarURL).
+		Scan(&verifiedAt, &profile.CreatedAt, &profile.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("database error: %w", err)
 	}
+	profile.EmailVerifiedAt = time.Time{}
+	if verifiedAt != nil {
+		profile.EmailVerifiedAt = *verifiedAt
+	}
 	return nil
 }
 
-// GetUserProfile returns email if present
-func (d *DB) GetUserProfile(ctx context.Context, userID string) (string, error) {
-	var email string
-	err := d.pool.QueryRow(ctx, `SELECT email FROM profiles WHERE user_id = $1;`, userID).Scan(&email)
+// GetUserProfile returns user's profile
+func (d *DB) GetUserProfile(ctx context.Context, userID string) (*Profile, error) {
+	profile := &Profile{UserID: userID}
+	var verifiedAt *time.Time
+	err := d.pool.QueryRow(ctx, `
+        SELECT COALESCE(email, ''), email_verified_at, COALESCE(display_name, ''), COALESCE(locale, ''),
+            COALESCE(timezone, ''), COALESCE(avatar_url, ''), created_at, updated_at
+        FROM profiles WHERE user_id = $1;`, userID).
+		Scan(&profile.Email, &verifiedAt, &profile.DisplayName, &profile.Locale,
+			&profile.Timezone, &profile.AvatarURL, &profile.CreatedAt, &profile.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return "", fmt.Errorf("profile not found for user: %s", userID)
+			return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, userID)
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if verifiedAt != nil {
+		profile.EmailVerifiedAt = *verifiedAt
+	}
+	return profile, nil
+}
+
+// GetEmailVerifiedAt returns when the profile email was verified
+func (d *DB) GetEmailVerifiedAt(ctx context.Context, userID string) (time.Time, error) {
+	var verifiedAt *time.Time
+	err := d.pool.QueryRow(ctx, `SELECT email_verified_at FROM profiles WHERE user_id = $1;`, userID).Scan(&verifiedAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("database error: %w", err)
+	}
+	if verifiedAt == nil {
+		return time.Time{}, nil
+	}
+	return *verifiedAt, nil
+}
+
+// MarkEmailVerified sets the verification time of the profile email and drops other users' unverified claims
+func (d *DB) MarkEmailVerified(ctx context.Context, userID, email string, verifiedAt time.Time) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var taken bool
+	err = tx.QueryRow(ctx, `
+        SELECT EXISTS (SELECT 1 FROM profiles
+        WHERE lower(email) = lower($2) AND user_id <> $1 AND email_verified_at IS NOT NULL);`, userID, email).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+	tag, err := tx.Exec(ctx, `
+        UPDATE profiles SET email_verified_at = COALESCE(email_verified_at, $3)
+        WHERE user_id = $1 AND email = $2;`, userID, email, verifiedAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrProfileNotFound, userID)
+	}
+	if _, err := tx.Exec(ctx, `
+        UPDATE profiles SET email = NULL, updated_at = NOW()
+        WHERE lower(email) = lower($2) AND user_id <> $1 AND email_verified_at IS NULL;`, userID, email); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// GetUserIDByEmail finds the user who verified the email
+func (d *DB) GetUserIDByEmail(ctx context.Context, email string) (string, error) {
+	var userID string
+	err := d.pool.QueryRow(ctx, `
+        SELECT user_id FROM profiles WHERE lower(email) = lower($1) AND email_verified_at IS NOT NULL;`, email).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", fmt.Errorf("%w: %s", ErrUserNotFound, email)
 		}
 		return "", fmt.Errorf("database error: %w", err)
 	}
-	return email, nil
+	return userID, nil
 }
 
 // CreateRefreshToken stores a refresh token
-func (d *DB) CreateRefreshToken(ctx context.Context, token, userID string, expiresAt time.Time) error {
+func (d *DB) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
 	_, err := d.pool.Exec(ctx, `
-        INSERT INTO refresh_tokens (token, user_id, expires_at)
-        VALUES ($1, $2, $3);`, token, userID, expiresAt)
+        INSERT INTO refresh_tokens (token_hash, user_id, family_id, expires_at, revoked, created_at, last_used_at, user_agent, ip, device_label, auth_time, amr, client_id, scope)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14);`, token.TokenHash, token.UserID, token.FamilyID, token.ExpiresAt, token.Revoked,
+		token.CreatedAt, token.LastUsedAt, token.UserAgent, token.IP, token.DeviceLabel, nullTime(token.AuthTime), strings.Join(token.AMR, " "), token.ClientID, token.Scope)
 	if err != nil {
 		return fmt.Errorf("database error: %w", err)
 	}
 	return nil
 }
 
-// GetRefreshToken fetches refresh token info
-func (d *DB) GetRefreshToken(ctx context.Context, token string) (userID string, expiresAt time.Time, revoked bool, err error) {
-	err = d.pool.QueryRow(ctx, `
-        SELECT user_id, expires_at, revoked FROM refresh_tokens WHERE token = $1;`, token).Scan(&userID, &expiresAt, &revoked)
+// GetRefreshToken fetches refresh token info by token digest
+func (d *DB) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	rt := &RefreshToken{}
+	var authTime *time.Time
+	var amr string
+	err := d.pool.QueryRow(ctx, `
+        SELECT token_hash, user_id, family_id, expires_at, revoked, created_at, last_used_at, user_agent, ip, device_label, auth_time, amr, client_id, scope FROM refresh_tokens WHERE token_hash = $1;`, tokenHash).
+		Scan(&rt.TokenHash, &rt.UserID, &rt.FamilyID, &rt.ExpiresAt, &rt.Revoked, &rt.CreatedAt, &rt.LastUsedAt, &rt.UserAgent, &rt.IP, &rt.DeviceLabel, &authTime, &amr, &rt.ClientID, &rt.Scope)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return "", time.Time{}, false, fmt.Errorf("refresh token not found")
+			return nil, ErrRefreshTokenNotFound
 		}
-		return "", time.Time{}, false, fmt.Errorf("database error: %w", err)
+		return nil, fmt.Errorf("database error: %w", err)
 	}
-	return
+	rt.setAuthentication(authTime, amr)
+	return rt, nil
 }
 
 // RevokeRefreshToken marks a token as revoked
-func (d *DB) RevokeRefreshToken(ctx context.Context, token string) error {
-	_, err := d.pool.Exec(ctx, `UPDATE refresh_tokens SET revoked = TRUE WHERE token = $1;`, token)
+func (d *DB) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	tag, err := d.pool.Exec(ctx, `UPDATE refresh_tokens SET revoked = TRUE WHERE token_hash = $1;`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRefreshTokenNotFound
+	}
+	return nil
+}
+
+// RotateRefreshToken revokes the token and inserts next in one transaction
+// The row is locked with SELECT ... FOR UPDATE so concurrent rotations of the same token are serialized
+func (d *DB) RotateRefreshToken(ctx context.Context, tokenHash string, next *RefreshToken) (*RefreshToken, error) {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rt := &RefreshToken{}
+	var authTime *time.Time
+	var amr string
+	err = tx.QueryRow(ctx, `
+        SELECT token_hash, user_id, family_id, expires_at, revoked, created_at, last_used_at, user_agent, ip, device_label, auth_time, amr, client_id, scope FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE;`, tokenHash).
+		Scan(&rt.TokenHash, &rt.UserID, &rt.FamilyID, &rt.ExpiresAt, &rt.Revoked, &rt.CreatedAt, &rt.LastUsedAt, &rt.UserAgent, &rt.IP, &rt.DeviceLabel, &authTime, &amr, &rt.ClientID, &rt.Scope)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	rt.setAuthentication(authTime, amr)
+	if rt.Revoked {
+		return rt, ErrRefreshTokenRevoked
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		return rt, ErrRefreshTokenExpired
+	}
+
+	if _, err = tx.Exec(ctx, `UPDATE refresh_tokens SET revoked = TRUE WHERE token_hash = $1;`, tokenHash); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	next.UserID = rt.UserID
+	next.FamilyID = rt.FamilyID
+	next.CreatedAt = rt.CreatedAt
+	next.DeviceLabel = rt.DeviceLabel
+	next.AuthTime = rt.AuthTime
+	next.AMR = rt.AMR
+	next.ClientID = rt.ClientID
+	next.Scope = rt.Scope
+	_, err = tx.Exec(ctx, `
+        INSERT INTO refresh_tokens (token_hash, user_id, family_id, expires_at, revoked, created_at, last_used_at, user_agent, ip, device_label, auth_time, amr, client_id, scope)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14);`, next.TokenHash, next.UserID, next.FamilyID, next.ExpiresAt, next.Revoked,
+		next.CreatedAt, next.LastUsedAt, next.UserAgent, next.IP, next.DeviceLabel, nullTime(next.AuthTime), strings.Join(next.AMR, " "), next.ClientID, next.Scope)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return rt, nil
+}
+
+// setAuthentication fills the authentication fields from their nullable, space separated columns
+func (rt *RefreshToken) setAuthentication(authTime *time.Time, amr string) {
+	if authTime != nil {
+		rt.AuthTime = *authTime
+	}
+	rt.AMR = strings.Fields(amr)
+}
+
+// nullTime maps the zero time to NULL
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// RevokeRefreshTokenFamily marks every token of a family as revoked
+func (d *DB) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	_, err := d.pool.Exec(ctx, `UPDATE refresh_tokens SET revoked = TRUE WHERE family_id = $1;`, familyID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// ListActiveRefreshTokens returns the user's unrevoked, unexpired tokens, newest session first
+func (d *DB) ListActiveRefreshTokens(ctx context.Context, userID string) ([]*RefreshToken, error) {
+	rows, err := d.pool.Query(ctx, `
+        SELECT token_hash, user_id, family_id, expires_at, revoked, created_at, last_used_at, user_agent, ip, device_label, auth_time, amr, client_id, scope
+        FROM refresh_tokens WHERE user_id = $1 AND NOT revoked AND expires_at > NOW()
+        ORDER BY created_at DESC;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*RefreshToken
+	for rows.Next() {
+		rt := &RefreshToken{}
+		var authTime *time.Time
+		var amr string
+		if err := rows.Scan(&rt.TokenHash, &rt.UserID, &rt.FamilyID, &rt.ExpiresAt, &rt.Revoked, &rt.CreatedAt, &rt.LastUsedAt, &rt.UserAgent, &rt.IP, &rt.DeviceLabel, &authTime, &amr, &rt.ClientID, &rt.Scope); err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		rt.setAuthentication(authTime, amr)
+		tokens = append(tokens, rt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return tokens, nil
+}
+
+// RevokeAllRefreshTokens marks every token of the user as revoked
+func (d *DB) RevokeAllRefreshTokens(ctx context.Context, userID string) error {
+	_, err := d.pool.Exec(ctx, `UPDATE refresh_tokens SET revoked = TRUE WHERE user_id = $1 AND NOT revoked;`, userID)
 	if err != nil {
 		return fmt.Errorf("database error: %w", err)
 	}
@@ -155,3 +420,781 @@ func (d *DB) DeleteExpiredRefreshTokens(ctx context.Context) error {
 	}
 	return nil
 }
+
+// RevokeAccessToken adds an access token ID to the denylist
+func (d *DB) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := d.pool.Exec(ctx, `
+        INSERT INTO revoked_access_tokens (jti, expires_at) VALUES ($1, $2)
+        ON CONFLICT (jti) DO NOTHING;`, jti, expiresAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// IsAccessTokenRevoked reports whether an access token ID is on the denylist
+func (d *DB) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := d.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $1);`, jti).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("database error: %w", err)
+	}
+	return revoked, nil
+}
+
+// DeleteExpiredRevokedAccessTokens removes denylist entries of expired tokens
+func (d *DB) DeleteExpiredRevokedAccessTokens(ctx context.Context) error {
+	_, err := d.pool.Exec(ctx, `DELETE FROM revoked_access_tokens WHERE expires_at < NOW();`)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// GetGlobalNotBefore returns the global token cut-off
+func (d *DB) GetGlobalNotBefore(ctx context.Context) (time.Time, error) {
+	var notBefore time.Time
+	err := d.pool.QueryRow(ctx, `SELECT not_before FROM token_not_before;`).Scan(&notBefore)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("database error: %w", err)
+	}
+	return notBefore, nil
+}
+
+// SetGlobalNotBefore stores the cut-off, revokes older refresh tokens and writes the audit record in one transaction
+func (d *DB) SetGlobalNotBefore(ctx context.Context, notBefore time.Time, record *AuditRecord) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+        INSERT INTO token_not_before (id, not_before) VALUES (TRUE, $1)
+        ON CONFLICT (id) DO UPDATE SET not_before = EXCLUDED.not_before;`, notBefore); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked = TRUE WHERE created_at < $1 AND NOT revoked;`, notBefore); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+        INSERT INTO audit_log (action, actor, details, created_at) VALUES ($1, $2, $3, $4);`,
+		record.Action, record.Actor, record.Details, record.CreatedAt); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// CreateAuthorizationCode stores an OAuth authorization code
+func (d *DB) CreateAuthorizationCode(ctx context.Context, code *AuthorizationCode) error {
+	_, err := d.pool.Exec(ctx, `
+        INSERT INTO authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`,
+		code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, code.Scope, code.CodeChallenge, code.CodeChallengeMethod, code.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// ConsumeAuthorizationCode deletes an authorization code and returns it
+func (d *DB) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*AuthorizationCode, error) {
+	code := &AuthorizationCode{}
+	err := d.pool.QueryRow(ctx, `
+        DELETE FROM authorization_codes WHERE code_hash = $1
+        RETURNING code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, expires_at;`, codeHash).
+		Scan(&code.CodeHash, &code.ClientID, &code.UserID, &code.RedirectURI, &code.Scope, &code.CodeChallenge, &code.CodeChallengeMethod, &code.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("authorization code not found")
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return code, nil
+}
+
+// CreateEmailVerificationToken stores an email verification token
+func (d *DB) CreateEmailVerificationToken(ctx context.Context, token *EmailVerificationToken) error {
+	_, err := d.pool.Exec(ctx, `
+        INSERT INTO email_verification_tokens (token_hash, user_id, email, expires_at) VALUES ($1, $2, $3, $4);`,
+		token.TokenHash, token.UserID, token.Email, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// ConsumeEmailVerificationToken deletes an email verification token and returns it
+func (d *DB) ConsumeEmailVerificationToken(ctx context.Context, tokenHash string) (*EmailVerificationToken, error) {
+	token := &EmailVerificationToken{}
+	err := d.pool.QueryRow(ctx, `
+        DELETE FROM email_verification_tokens WHERE token_hash = $1
+        RETURNING token_hash, user_id, email, expires_at;`, tokenHash).
+		Scan(&token.TokenHash, &token.UserID, &token.Email, &token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrEmailVerificationTokenNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return token, nil
+}
+
+// DeleteExpiredEmailVerificationTokens removes expired email verification tokens
+func (d *DB) DeleteExpiredEmailVerificationTokens(ctx context.Context) error {
+	_, err := d.pool.Exec(ctx, `DELETE FROM email_verification_tokens WHERE expires_at < NOW();`)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// CreatePasswordResetToken stores a password reset token
+func (d *DB) CreatePasswordResetToken(ctx context.Context, token *PasswordResetToken) error {
+	_, err := d.pool.Exec(ctx, `
+        INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3);`,
+		token.TokenHash, token.UserID, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// ConsumePasswordResetToken deletes a password reset token and returns it
+func (d *DB) ConsumePasswordResetToken(ctx context.Context, tokenHash string) (*PasswordResetToken, error) {
+	token := &PasswordResetToken{}
+	err := d.pool.QueryRow(ctx, `
+        DELETE FROM password_reset_tokens WHERE token_hash = $1
+        RETURNING token_hash, user_id, expires_at;`, tokenHash).
+		Scan(&token.TokenHash, &token.UserID, &token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPasswordResetTokenNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return token, nil
+}
+
+// DeleteExpiredPasswordResetTokens removes expired password reset tokens
+func (d *DB) DeleteExpiredPasswordResetTokens(ctx context.Context) error {
+	_, err := d.pool.Exec(ctx, `DELETE FROM password_reset_tokens WHERE expires_at < NOW();`)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// SetTOTPSecret stores a pending TOTP enrollment
+func (d *DB) SetTOTPSecret(ctx context.Context, secret *TOTPSecret) error {
+	_, err := d.pool.Exec(ctx, `
+        INSERT INTO totp_secrets (user_id, secret, confirmed_at, last_used_step, created_at)
+        VALUES ($1, $2, NULL, 0, $3)
+        ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, confirmed_at = NULL,
+            last_used_step = 0, created_at = EXCLUDED.created_at, failed_attempts = 0, locked_until = NULL;`,
+		secret.UserID, secret.Secret, secret.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// GetTOTPSecret returns the user's TOTP secret
+func (d *DB) GetTOTPSecret(ctx context.Context, userID string) (*TOTPSecret, error) {
+	secret := &TOTPSecret{}
+	var confirmedAt, lockedUntil *time.Time
+	err := d.pool.QueryRow(ctx, `
+        SELECT user_id, secret, confirmed_at, last_used_step, created_at, failed_attempts, locked_until
+        FROM totp_secrets WHERE user_id = $1;`, userID).
+		Scan(&secret.UserID, &secret.Secret, &confirmedAt, &secret.LastUsedStep, &secret.CreatedAt,
+			&secret.FailedAttempts, &lockedUntil)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTOTPNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if confirmedAt != nil {
+		secret.ConfirmedAt = *confirmedAt
+	}
+	if lockedUntil != nil {
+		secret.LockedUntil = *lockedUntil
+	}
+	return secret, nil
+}
+
+// ConfirmTOTPSecret enables a pending TOTP secret and replaces the recovery codes in one transaction
+func (d *DB) ConfirmTOTPSecret(ctx context.Context, userID string, confirmedAt time.Time, step int64, recoveryCodeHashes []string) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+        UPDATE totp_secrets SET confirmed_at = $2, last_used_step = $3
+        WHERE user_id = $1 AND confirmed_at IS NULL;`, userID, confirmedAt, step)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTOTPNotFound
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1;`, userID); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		if _, err := tx.Exec(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2);`, userID, codeHash); err != nil {
+			return fmt.Errorf("database error: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// UseTOTPStep advances the last used time step of a confirmed TOTP secret
+func (d *DB) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	tag, err := d.pool.Exec(ctx, `
+        UPDATE totp_secrets SET last_used_step = $2
+        WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2;`, userID, step)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTOTPStepUsed
+	}
+	return nil
+}
+
+// DeleteTOTPSecret removes the TOTP secret and recovery codes in one transaction
+func (d *DB) DeleteTOTPSecret(ctx context.Context, userID string) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM totp_secrets WHERE user_id = $1;`, userID); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1;`, userID); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// UseRecoveryCode deletes a recovery code
+func (d *DB) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	tag, err := d.pool.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2;`, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+	return nil
+}
+
+// RecordMFAFailure increments the failed second-factor attempts of a user
+func (d *DB) RecordMFAFailure(ctx context.Context, userID string) (int, error) {
+	var attempts int
+	err := d.pool.QueryRow(ctx, `
+        UPDATE totp_secrets SET failed_attempts = failed_attempts + 1 WHERE user_id = $1 RETURNING failed_attempts;`, userID).
+		Scan(&attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrTOTPNotFound
+		}
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	return attempts, nil
+}
+
+// ResetMFAFailures clears the failed second-factor attempts and sets the lockout end
+func (d *DB) ResetMFAFailures(ctx context.Context, userID string, lockedUntil time.Time) error {
+	var until *time.Time
+	if !lockedUntil.IsZero() {
+		until = &lockedUntil
+	}
+	_, err := d.pool.Exec(ctx, `
+        UPDATE totp_secrets SET failed_attempts = 0, locked_until = $2 WHERE user_id = $1;`, userID, until)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes
+func (d *DB) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var count int
+	if err := d.pool.QueryRow(ctx, `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1;`, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	return count, nil
+}
+
+// SetEmailLoginCode stores the user's email login code, replacing any previous one
+func (d *DB) SetEmailLoginCode(ctx context.Context, code *EmailLoginCode) error {
+	_, err := d.pool.Exec(ctx, `
+        INSERT INTO email_login_codes (user_id, email, code_hash, created_at, expires_at, attempts)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email, code_hash = EXCLUDED.code_hash,
+            created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at, attempts = EXCLUDED.attempts;`,
+		code.UserID, code.Email, code.CodeHash, code.CreatedAt, code.ExpiresAt, code.Attempts)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// GetEmailLoginCode returns the user's email login code
+func (d *DB) GetEmailLoginCode(ctx context.Context, userID string) (*EmailLoginCode, error) {
+	code := &EmailLoginCode{}
+	err := d.pool.QueryRow(ctx, `
+        SELECT user_id, email, code_hash, created_at, expires_at, attempts FROM email_login_codes WHERE user_id = $1;`, userID).
+		Scan(&code.UserID, &code.Email, &code.CodeHash, &code.CreatedAt, &code.ExpiresAt, &code.Attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrEmailLoginCodeNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return code, nil
+}
+
+// IncrementEmailLoginCodeAttempts records an attempt to enter the user's email login code
+func (d *DB) IncrementEmailLoginCodeAttempts(ctx context.Context, userID string) (*EmailLoginCode, error) {
+	code := &EmailLoginCode{}
+	err := d.pool.QueryRow(ctx, `
+        UPDATE email_login_codes SET attempts = attempts + 1 WHERE user_id = $1
+        RETURNING user_id, email, code_hash, created_at, expires_at, attempts;`, userID).
+		Scan(&code.UserID, &code.Email, &code.CodeHash, &code.CreatedAt, &code.ExpiresAt, &code.Attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrEmailLoginCodeNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return code, nil
+}
+
+// ConsumeEmailLoginCode deletes the user's email login code if it matches
+func (d *DB) ConsumeEmailLoginCode(ctx context.Context, userID, codeHash string) error {
+	tag, err := d.pool.Exec(ctx, `DELETE FROM email_login_codes WHERE user_id = $1 AND code_hash = $2;`, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrEmailLoginCodeNotFound
+	}
+	return nil
+}
+
+// DeleteExpiredEmailLoginCodes removes expired email login codes
+func (d *DB) DeleteExpiredEmailLoginCodes(ctx context.Context) error {
+	_, err := d.pool.Exec(ctx, `DELETE FROM email_login_codes WHERE expires_at < NOW();`)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// CreateLoginLink stores a login link
+func (d *DB) CreateLoginLink(ctx context.Context, link *LoginLink) error {
+	_, err := d.pool.Exec(ctx, `
+        INSERT INTO login_links (token_hash, user_id, email, nonce_hash, created_at, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6);`,
+		link.TokenHash, link.UserID, link.Email, link.NonceHash, link.CreatedAt, link.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// GetLoginLink returns a login link
+func (d *DB) GetLoginLink(ctx context.Context, tokenHash string) (*LoginLink, error) {
+	link := &LoginLink{}
+	err := d.pool.QueryRow(ctx, `
+        SELECT token_hash, user_id, email, nonce_hash, created_at, expires_at FROM login_links WHERE token_hash = $1;`, tokenHash).
+		Scan(&link.TokenHash, &link.UserID, &link.Email, &link.NonceHash, &link.CreatedAt, &link.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrLoginLinkNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return link, nil
+}
+
+// ConsumeLoginLink deletes a login link
+func (d *DB) ConsumeLoginLink(ctx context.Context, tokenHash string) error {
+	tag, err := d.pool.Exec(ctx, `DELETE FROM login_links WHERE token_hash = $1;`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLoginLinkNotFound
+	}
+	return nil
+}
+
+// CountLoginLinks returns the number of the user's unused login links created since the given time
+func (d *DB) CountLoginLinks(ctx context.Context, userID string, since time.Time) (int, error) {
+	var count int
+	err := d.pool.QueryRow(ctx, `
+        SELECT COUNT(*) FROM login_links WHERE user_id = $1 AND created_at >= $2;`, userID, since).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	return count, nil
+}
+
+// DeleteExpiredLoginLinks removes expired login links
+func (d *DB) DeleteExpiredLoginLinks(ctx context.Context) error {
+	_, err := d.pool.Exec(ctx, `DELETE FROM login_links WHERE expires_at < NOW();`)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// CreateMFAChallenge stores an MFA login challenge
+func (d *DB) CreateMFAChallenge(ctx context.Context, challenge *MFAChallenge) error {
+	_, err := d.pool.Exec(ctx, `
+        INSERT INTO mfa_challenges (token_hash, user_id, expires_at, attempts, amr) VALUES ($1, $2, $3, $4, $5);`,
+		challenge.TokenHash, challenge.UserID, challenge.ExpiresAt, challenge.Attempts, strings.Join(challenge.AMR, " "))
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// GetMFAChallenge returns an MFA login challenge
+func (d *DB) GetMFAChallenge(ctx context.Context, tokenHash string) (*MFAChallenge, error) {
+	challenge := &MFAChallenge{}
+	var amr string
+	err := d.pool.QueryRow(ctx, `
+        SELECT token_hash, user_id, expires_at, attempts, amr FROM mfa_challenges WHERE token_hash = $1;`, tokenHash).
+		Scan(&challenge.TokenHash, &challenge.UserID, &challenge.ExpiresAt, &challenge.Attempts, &amr)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMFAChallengeNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	challenge.AMR = strings.Fields(amr)
+	return challenge, nil
+}
+
+// IncrementMFAChallengeAttempts records a wrong code for an MFA login challenge
+func (d *DB) IncrementMFAChallengeAttempts(ctx context.Context, tokenHash string) (int, error) {
+	var attempts int
+	err := d.pool.QueryRow(ctx, `
+        UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = $1 RETURNING attempts;`, tokenHash).Scan(&attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrMFAChallengeNotFound
+		}
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	return attempts, nil
+}
+
+// ConsumeMFAChallenge deletes an MFA login challenge and returns it
+func (d *DB) ConsumeMFAChallenge(ctx context.Context, tokenHash string) (*MFAChallenge, error) {
+	challenge := &MFAChallenge{}
+	var amr string
+	err := d.pool.QueryRow(ctx, `
+        DELETE FROM mfa_challenges WHERE token_hash = $1
+        RETURNING token_hash, user_id, expires_at, attempts, amr;`, tokenHash).
+		Scan(&challenge.TokenHash, &challenge.UserID, &challenge.ExpiresAt, &challenge.Attempts, &amr)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMFAChallengeNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	challenge.AMR = strings.Fields(amr)
+	return challenge, nil
+}
+
+// DeleteExpiredMFAChallenges removes expired MFA login challenges
+func (d *DB) DeleteExpiredMFAChallenges(ctx context.Context) error {
+	_, err := d.pool.Exec(ctx, `DELETE FROM mfa_challenges WHERE expires_at < NOW();`)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// CreateWebAuthnCredential stores a WebAuthn credential
+func (d *DB) CreateWebAuthnCredential(ctx context.Context, cred *WebAuthnCredential) error {
+	tag, err := d.pool.Exec(ctx, `
+        INSERT INTO webauthn_credentials (credential_id, user_id, public_key, sign_count, aaguid, attestation_type, name, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ON CONFLICT (credential_id) DO NOTHING;`,
+		cred.ID, cred.UserID, cred.PublicKey, int64(cred.SignCount), cred.AAGUID, cred.AttestationType, cred.Name, cred.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrWebAuthnCredentialExists
+	}
+	return nil
+}
+
+// webauthnCredentialColumns lists the columns scanned by scanWebAuthnCredential
+const webauthnCredentialColumns = `credential_id, user_id, public_key, sign_count, aaguid, attestation_type, name, created_at, last_used_at`
+
+// scanWebAuthnCredential scans a row selected with webauthnCredentialColumns
+func scanWebAuthnCredential(row pgx.Row) (*WebAuthnCredential, error) {
+	cred := &WebAuthnCredential{}
+	var signCount int64
+	var lastUsedAt *time.Time
+	if err := row.Scan(&cred.ID, &cred.UserID, &cred.PublicKey, &signCount, &cred.AAGUID, &cred.AttestationType,
+		&cred.Name, &cred.CreatedAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	cred.SignCount = uint32(signCount)
+	if lastUsedAt != nil {
+		cred.LastUsedAt = *lastUsedAt
+	}
+	return cred, nil
+}
+
+// GetWebAuthnCredential returns a WebAuthn credential
+func (d *DB) GetWebAuthnCredential(ctx context.Context, credentialID string) (*WebAuthnCredential, error) {
+	cred, err := scanWebAuthnCredential(d.pool.QueryRow(ctx,
+		`SELECT `+webauthnCredentialColumns+` FROM webauthn_credentials WHERE credential_id = $1;`, credentialID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrWebAuthnCredentialNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return cred, nil
+}
+
+// ListWebAuthnCredentials returns the user's WebAuthn credentials, oldest first
+func (d *DB) ListWebAuthnCredentials(ctx context.Context, userID string) ([]WebAuthnCredential, error) {
+	rows, err := d.pool.Query(ctx,
+		`SELECT `+webauthnCredentialColumns+` FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var creds []WebAuthnCredential
+	for rows.Next() {
+		cred, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		creds = append(creds, *cred)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return creds, nil
+}
+
+// UseWebAuthnCredential records a login with a WebAuthn credential
+// The counter check is part of the update, so concurrent logins with a cloned authenticator can not both pass
+func (d *DB) UseWebAuthnCredential(ctx context.Context, credentialID string, signCount uint32, usedAt time.Time) error {
+	tag, err := d.pool.Exec(ctx, `
+        UPDATE webauthn_credentials SET sign_count = $2, last_used_at = $3
+        WHERE credential_id = $1 AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0));`,
+		credentialID, int64(signCount), usedAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		if _, err := d.GetWebAuthnCredential(ctx, credentialID); err != nil {
+			return err
+		}
+		return ErrWebAuthnSignCount
+	}
+	return nil
+}
+
+// DeleteWebAuthnCredential removes one of the user's WebAuthn credentials
+func (d *DB) DeleteWebAuthnCredential(ctx context.Context, userID, credentialID string) error {
+	tag, err := d.pool.Exec(ctx, `DELETE FROM webauthn_credentials WHERE credential_id = $1 AND user_id = $2;`, credentialID, userID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+// CreateWebAuthnChallenge stores a WebAuthn challenge
+func (d *DB) CreateWebAuthnChallenge(ctx context.Context, challenge *WebAuthnChallenge) error {
+	_, err := d.pool.Exec(ctx, `
+        INSERT INTO webauthn_challenges (challenge_hash, ceremony, user_id, expires_at) VALUES ($1, $2, $3, $4);`,
+		challenge.ChallengeHash, challenge.Ceremony, challenge.UserID, challenge.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// ConsumeWebAuthnChallenge deletes a WebAuthn challenge and returns it
+func (d *DB) ConsumeWebAuthnChallenge(ctx context.Context, challengeHash string) (*WebAuthnChallenge, error) {
+	challenge := &WebAuthnChallenge{}
+	err := d.pool.QueryRow(ctx, `
+        DELETE FROM webauthn_challenges WHERE challenge_hash = $1
+        RETURNING challenge_hash, ceremony, user_id, expires_at;`, challengeHash).
+		Scan(&challenge.ChallengeHash, &challenge.Ceremony, &challenge.UserID, &challenge.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrWebAuthnChallengeNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return challenge, nil
+}
+
+// DeleteExpiredWebAuthnChallenges removes expired WebAuthn challenges
+func (d *DB) DeleteExpiredWebAuthnChallenges(ctx context.Context) error {
+	_, err := d.pool.Exec(ctx, `DELETE FROM webauthn_challenges WHERE expires_at < NOW();`)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// CreateDeviceCode stores an OAuth device authorization
+func (d *DB) CreateDeviceCode(ctx context.Context, code *DeviceCode) error {
+	_, err := d.pool.Exec(ctx, `
+        INSERT INTO device_codes (device_code_hash, user_code, client_id, scope, user_id, status, interval_seconds, last_polled_at, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`,
+		code.DeviceCodeHash, code.UserCode, code.ClientID, code.Scope, code.UserID, code.Status, int(code.Interval.Seconds()), code.LastPolledAt, code.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// GetDeviceCode returns a device authorization by device code hash
+func (d *DB) GetDeviceCode(ctx context.Context, deviceCodeHash string) (*DeviceCode, error) {
+	return d.getDeviceCode(ctx, "device_code_hash", deviceCodeHash)
+}
+
+// GetDeviceCodeByUserCode returns a device authorization by user code
+func (d *DB) GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*DeviceCode, error) {
+	return d.getDeviceCode(ctx, "user_code", userCode)
+}
+
+// getDeviceCode selects a device authorization by a unique column
+func (d *DB) getDeviceCode(ctx context.Context, column, value string) (*DeviceCode, error) {
+	code := &DeviceCode{}
+	var interval int
+	err := d.pool.QueryRow(ctx, `
+        SELECT device_code_hash, user_code, client_id, scope, user_id, status, interval_seconds, last_polled_at, expires_at
+        FROM device_codes WHERE `+column+` = $1;`, value).
+		Scan(&code.DeviceCodeHash, &code.UserCode, &code.ClientID, &code.Scope, &code.UserID, &code.Status, &interval, &code.LastPolledAt, &code.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("device code not found")
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	code.Interval = time.Duration(interval) * time.Second
+	return code, nil
+}
+
+// UpdateDeviceCodePolling records the last poll time and polling interval
+func (d *DB) UpdateDeviceCodePolling(ctx context.Context, deviceCodeHash string, polledAt time.Time, interval time.Duration) error {
+	tag, err := d.pool.Exec(ctx, `
+        UPDATE device_codes SET last_polled_at = $2, interval_seconds = $3 WHERE device_code_hash = $1;`,
+		deviceCodeHash, polledAt, int(interval.Seconds()))
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("device code not found")
+	}
+	return nil
+}
+
+// SetDeviceCodeStatus approves or denies a pending device authorization
+func (d *DB) SetDeviceCodeStatus(ctx context.Context, userCode, status, userID string) error {
+	tag, err := d.pool.Exec(ctx, `
+        UPDATE device_codes SET status = $2, user_id = $3 WHERE user_code = $1 AND status = 'pending';`,
+		userCode, status, userID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("pending device code not found")
+	}
+	return nil
+}
+
+// DeleteDeviceCode removes a device authorization
+func (d *DB) DeleteDeviceCode(ctx context.Context, deviceCodeHash string) error {
+	tag, err := d.pool.Exec(ctx, `DELETE FROM device_codes WHERE device_code_hash = $1;`, deviceCodeHash)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("device code not found")
+	}
+	return nil
+}
+
+// CreateClient stores a new OAuth client
+func (d *DB) CreateClient(ctx context.Context, client *Client) error {
+	_, err := d.pool.Exec(ctx, `
+        INSERT INTO clients (client_id, secret_hash, name, redirect_uris, grant_types, scopes, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`,
+		client.ClientID, client.SecretHash, client.Name, client.RedirectURIs, client.GrantTypes, client.Scopes, client.CreatedAt, client.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// GetClient retrieves an OAuth client by ID
+func (d *DB) GetClient(ctx context.Context, clientID string) (*Client, error) {
+	client := &Client{}
+	err := d.pool.QueryRow(ctx, `
+        SELECT client_id, secret_hash, name, redirect_uris, grant_types, scopes, created_at, updated_at
+        FROM clients WHERE client_id = $1;`, clientID).
+		Scan(&client.ClientID, &client.SecretHash, &client.Name, &client.RedirectURIs, &client.GrantTypes, &client.Scopes, &client.CreatedAt, &client.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("client not found: %s", clientID)
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return client, nil
+}
+
+// UpdateClient replaces an OAuth client's mutable fields
+func (d *DB) UpdateClient(ctx context.Context, client *Client) error {
+	tag, err := d.pool.Exec(ctx, `
+        UPDATE clients SET secret_hash = $2, name = $3, redirect_uris = $4, grant_types = $5, scopes = $6, updated_at = $7
+        WHERE client_id = $1;`,
+		client.ClientID, client.SecretHash, client.Name, client.RedirectURIs, client.GrantTypes, client.Scopes, client.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("client not found: %s", client.ClientID)
+	}
+	return nil
+}
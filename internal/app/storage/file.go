@@ -8,6 +8,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,20 +18,58 @@ import (
 type JSONUserFS struct {
 	ID       int    `json:"id"`
 	Login    string `json:"login"`
-	Password string `json:"password"`
+	Password string `json:"password,omitempty"`
 	UserID   string `json:"user_id"`
+
+	TokenVersion int `json:"token_version,omitempty"`
+}
+
+// JSONRevokedFS represents the JSON structure of a revoked access token in the denylist file
+type JSONRevokedFS struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// JSONTOTPFS represents the JSON structure of a user's second factor in the TOTP file
+// A later record replaces an earlier one for the same user
+type JSONTOTPFS struct {
+	TOTPSecret
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	Deleted       bool     `json:"deleted,omitempty"`
+}
+
+// JSONAuditFS represents the JSON structure of a break-glass record in the audit file
+type JSONAuditFS struct {
+	AuditRecord
+	NotBefore time.Time `json:"not_before"`
 }
 
 // FileStorage implements file-based data storage
+// All methods are safe for concurrent use
 type FileStorage struct {
-	usersFile *os.File
-	users     map[string]*User  // login -> user
-	profiles  map[string]string // userID -> email
-	refresh   map[string]struct {
-		UserID    string
-		ExpiresAt time.Time
-		Revoked   bool
-	}
+	mu          sync.Mutex
+	usersFile   *os.File
+	auditFile   *os.File                          // break-glass cut-offs and their audit records
+	revokedFile *os.File                          // access token denylist
+	totpFile    *os.File                          // TOTP secrets and recovery code hashes
+	users       map[string]*User                  // login -> user
+	profiles    map[string]Profile                // userID -> profile
+	refresh     map[string]RefreshToken           // tokenHash -> refresh token
+	revoked     map[string]time.Time              // jti -> expiresAt
+	authCodes   map[string]*AuthorizationCode     // codeHash -> code
+	resets      map[string]PasswordResetToken     // tokenHash -> password reset token
+	emailTokens map[string]EmailVerificationToken // tokenHash -> email verification token
+	loginCodes  map[string]EmailLoginCode         // userID -> email login code
+	loginLinks  map[string]LoginLink              // tokenHash -> login link
+	totp        map[string]TOTPSecret             // userID -> TOTP secret
+	recovery    map[string][]string               // userID -> recovery code hashes
+	mfa         map[string]MFAChallenge           // tokenHash -> MFA login challenge
+	passkeys    map[string]WebAuthnCredential     // credentialID -> WebAuthn credential
+	ceremonies  map[string]WebAuthnChallenge      // challengeHash -> WebAuthn challenge
+	deviceCodes map[string]*DeviceCode            // deviceCodeHash -> code
+	clients     map[string]*Client                // clientID -> client
+	notBefore   time.Time                         // global token cut-off
+	auditLog    []AuditRecord
 }
 
 // NewFileStorage creates a new file storage instance
@@ -46,24 +87,78 @@ func NewFileStorage(FileStoragePath string) (*FileStorage, error) {
 	}
 
 	fs := FileStorage{
-		usersFile: usersFile,
-		users:     make(map[string]*User),
-		profiles:  make(map[string]string),
-		refresh: make(map[string]struct {
-			UserID    string
-			ExpiresAt time.Time
-			Revoked   bool
-		}),
+		usersFile:   usersFile,
+		users:       make(map[string]*User),
+		profiles:    make(map[string]Profile),
+		refresh:     make(map[string]RefreshToken),
+		revoked:     make(map[string]time.Time),
+		authCodes:   make(map[string]*AuthorizationCode),
+		resets:      make(map[string]PasswordResetToken),
+		emailTokens: make(map[string]EmailVerificationToken),
+		loginCodes:  make(map[string]EmailLoginCode),
+		loginLinks:  make(map[string]LoginLink),
+		totp:        make(map[string]TOTPSecret),
+		recovery:    make(map[string][]string),
+		mfa:         make(map[string]MFAChallenge),
+		passkeys:    make(map[string]WebAuthnCredential),
+		ceremonies:  make(map[string]WebAuthnChallenge),
+		deviceCodes: make(map[string]*DeviceCode),
+		clients:     make(map[string]*Client),
 	}
 
 	if err := fs.loadUsersFromFile(); err != nil {
 		return nil, fmt.Errorf("can not load users from file: %w", err)
 	}
+	// The cut-off must survive a restart, or every token revoked by break-glass would be accepted again
+	fs.auditFile, err = openJournal(FileStoragePath+".audit", func(data []byte) error {
+		var record JSONAuditFS
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		fs.auditLog = append(fs.auditLog, record.AuditRecord)
+		if record.NotBefore.After(fs.notBefore) {
+			fs.notBefore = record.NotBefore
+		}
+		return nil
+	})
+	if err != nil {
+		fs.CloseStorage(context.Background())
+		return nil, fmt.Errorf("can not load audit file: %w", err)
+	}
+	// Revoked access tokens stay revoked after a restart until they expire
+	now := time.Now()
+	fs.revokedFile, err = openJournal(FileStoragePath+".revoked", func(data []byte) error {
+		var entry JSONRevokedFS
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+		if entry.ExpiresAt.After(now) {
+			fs.revoked[entry.JTI] = entry.ExpiresAt
+		}
+		return nil
+	})
+	if err != nil {
+		fs.CloseStorage(context.Background())
+		return nil, fmt.Errorf("can not load revoked access tokens: %w", err)
+	}
+	fs.totpFile, err = openJournal(FileStoragePath+".totp", func(data []byte) error {
+		var entry JSONTOTPFS
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+		fs.loadTOTP(entry)
+		return nil
+	})
+	if err != nil {
+		fs.CloseStorage(context.Background())
+		return nil, fmt.Errorf("can not load TOTP secrets: %w", err)
+	}
 
 	return &fs, nil
 }
 
 // loadUsersFromFile loads users from the file system
+// Updated users are appended again, so a later line replaces an earlier one for the same login
 // Returns an error if loading failed
 func (f *FileStorage) loadUsersFromFile() error {
 	if _, err := f.usersFile.Seek(0, 0); err != nil {
@@ -76,10 +171,11 @@ func (f *FileStorage) loadUsersFromFile() error {
 			return err
 		}
 		f.users[user.Login] = &User{
-			ID:       user.ID,
-			Login:    user.Login,
-			Password: user.Password,
-			UserID:   user.UserID,
+			ID:           user.ID,
+			Login:        user.Login,
+			Password:     user.Password,
+			UserID:       user.UserID,
+			TokenVersion: user.TokenVersion,
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -88,12 +184,47 @@ func (f *FileStorage) loadUsersFromFile() error {
 	return nil
 }
 
+// openJournal opens an append-only file of JSON lines and passes every line to load
+func openJournal(path string, load func(data []byte) error) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err := load(scanner.Bytes()); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// appendJournal writes a record as one JSON line; the caller must hold the lock
+func appendJournal(file *os.File, record any) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
 // CloseStorage closes the file storage
 func (f *FileStorage) CloseStorage(ctx context.Context) error {
-	if f.usersFile != nil {
-		return f.usersFile.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var errs []error
+	for _, file := range []*os.File{f.usersFile, f.auditFile, f.revokedFile, f.totpFile} {
+		if file != nil {
+			errs = append(errs, file.Close())
+		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 // PingStorage checks the file storage connection
@@ -106,6 +237,8 @@ func (f *FileStorage) PingStorage(ctx context.Context) error { return nil }
 // login is the user login
 // Returns the user and an error if retrieval failed
 func (f *FileStorage) GetUserByLogin(ctx context.Context, login string) (user *User, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if user, exists := f.users[login]; exists {
 		return user, nil
 	}
@@ -117,6 +250,8 @@ func (f *FileStorage) GetUserByLogin(ctx context.Context, login string) (user *U
 // user is the user to create
 // Returns an error if creation failed
 func (f *FileStorage) CreateUser(ctx context.Context, user *User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	// Check if user already exists
 	if _, exists := f.users[user.Login]; exists {
 		return fmt.Errorf("user already exists with login: %s", user.Login)
@@ -124,17 +259,21 @@ func (f *FileStorage) CreateUser(ctx context.Context, user *User) error {
 
 	// Add to memory map
 	f.users[user.Login] = user
+	return f.appendUser(user)
+}
 
-	// Write to file
+// appendUser writes a user record to the users file; the caller must hold the lock
+func (f *FileStorage) appendUser(user *User) error {
 	if f.usersFile == nil {
 		return errors.New("users file is not opened")
 	}
 
 	entry := JSONUserFS{
-		ID:       user.ID,
-		Login:    user.Login,
-		Password: user.Password,
-		UserID:   user.UserID,
+		ID:           user.ID,
+		Login:        user.Login,
+		Password:     user.Password,
+		UserID:       user.UserID,
+		TokenVersion: user.TokenVersion,
 	}
 	data, err := json.Marshal(entry)
 	if err != nil {
@@ -155,50 +294,256 @@ func (f *FileStorage) CreateUser(ctx context.Context, user *User) error {
 	return nil
 }
 
-// SetUserProfile stores user's email in memory (file-backed persistence not implemented for simplicity)
-func (f *FileStorage) SetUserProfile(ctx context.Context, userID, email string) error {
-	f.profiles[userID] = email
+// findUser returns the stored user with the given user ID; the caller must hold the lock
+func (f *FileStorage) findUser(userID string) *User {
+	for _, user := range f.users {
+		if user.UserID == userID {
+			return user
+		}
+	}
 	return nil
 }
 
-// GetUserProfile returns user's email if set
-func (f *FileStorage) GetUserProfile(ctx context.Context, userID string) (string, error) {
-	if email, ok := f.profiles[userID]; ok {
-		return email, nil
+// GetUserByID returns a copy of the user with the given user ID
+func (f *FileStorage) GetUserByID(ctx context.Context, userID string) (*User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user := f.findUser(userID); user != nil {
+		found := *user
+		return &found, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+}
+
+// UpdateUserPassword replaces the user's password hash and persists the user
+func (f *FileStorage) UpdateUserPassword(ctx context.Context, userID, passwordHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user := f.findUser(userID)
+	if user == nil {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+	}
+	updated := *user
+	updated.Password = passwordHash
+	if err := f.appendUser(&updated); err != nil {
+		return err
+	}
+	f.users[updated.Login] = &updated
+	return nil
+}
+
+// GetTokenVersion returns the user's access token version
+func (f *FileStorage) GetTokenVersion(ctx context.Context, userID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user := f.findUser(userID); user != nil {
+		return user.TokenVersion, nil
+	}
+	return 0, nil
+}
+
+// IncrementTokenVersion bumps the user's access token version and persists the user
+func (f *FileStorage) IncrementTokenVersion(ctx context.Context, userID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user := f.findUser(userID)
+	if user == nil {
+		return 0, fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+	}
+	updated := *user
+	updated.TokenVersion++
+	if err := f.appendUser(&updated); err != nil {
+		return 0, err
+	}
+	f.users[updated.Login] = &updated
+	return updated.TokenVersion, nil
+}
+
+// SetUserProfile stores user's profile in memory (file-backed persistence not implemented for simplicity)
+func (f *FileStorage) SetUserProfile(ctx context.Context, profile *Profile) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	stored := *profile
+	stored.CreatedAt, stored.UpdatedAt = now, now
+	if current, ok := f.profiles[profile.UserID]; ok {
+		stored.CreatedAt = current.CreatedAt
+		if current.Email == profile.Email {
+			stored.EmailVerifiedAt = current.EmailVerifiedAt
+		} else {
+			stored.EmailVerifiedAt = time.Time{}
+		}
+	} else {
+		stored.EmailVerifiedAt = time.Time{}
+	}
+	f.profiles[profile.UserID] = stored
+	profile.CreatedAt, profile.UpdatedAt, profile.EmailVerifiedAt = stored.CreatedAt, stored.UpdatedAt, stored.EmailVerifiedAt
+	return nil
+}
+
+// GetUserProfile returns user's profile if set
+func (f *FileStorage) GetUserProfile(ctx context.Context, userID string) (*Profile, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if profile, ok := f.profiles[userID]; ok {
+		return &profile, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, userID)
+}
+
+// GetEmailVerifiedAt returns when the profile email was verified
+func (f *FileStorage) GetEmailVerifiedAt(ctx context.Context, userID string) (time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.profiles[userID].EmailVerifiedAt, nil
+}
+
+// MarkEmailVerified sets the verification time of the profile email and drops other users' unverified claims
+func (f *FileStorage) MarkEmailVerified(ctx context.Context, userID, email string, verifiedAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	profile, ok := f.profiles[userID]
+	if !ok || profile.Email != email {
+		return fmt.Errorf("%w: %s", ErrProfileNotFound, userID)
+	}
+	for otherID, other := range f.profiles {
+		if otherID != userID && !other.EmailVerifiedAt.IsZero() && strings.EqualFold(other.Email, email) {
+			return ErrEmailTaken
+		}
+	}
+	if profile.EmailVerifiedAt.IsZero() {
+		profile.EmailVerifiedAt = verifiedAt
+		f.profiles[userID] = profile
+	}
+	for otherID, other := range f.profiles {
+		if otherID != userID && strings.EqualFold(other.Email, email) {
+			other.Email = ""
+			other.UpdatedAt = verifiedAt
+			f.profiles[otherID] = other
+		}
+	}
+	return nil
+}
+
+// GetUserIDByEmail finds the user who verified the email
+func (f *FileStorage) GetUserIDByEmail(ctx context.Context, email string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for userID, profile := range f.profiles {
+		if profile.Email != "" && !profile.EmailVerifiedAt.IsZero() && strings.EqualFold(profile.Email, email) {
+			return userID, nil
+		}
 	}
-	return "", fmt.Errorf("profile not found for user: %s", userID)
+	return "", fmt.Errorf("%w: %s", ErrUserNotFound, email)
 }
 
 // CreateRefreshToken stores refresh token in memory
-func (f *FileStorage) CreateRefreshToken(ctx context.Context, token, userID string, expiresAt time.Time) error {
-	f.refresh[token] = struct {
-		UserID    string
-		ExpiresAt time.Time
-		Revoked   bool
-	}{UserID: userID, ExpiresAt: expiresAt, Revoked: false}
+func (f *FileStorage) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refresh[token.TokenHash] = *token
 	return nil
 }
 
-// GetRefreshToken returns token payload
-func (f *FileStorage) GetRefreshToken(ctx context.Context, token string) (string, time.Time, bool, error) {
-	if r, ok := f.refresh[token]; ok {
-		return r.UserID, r.ExpiresAt, r.Revoked, nil
+// GetRefreshToken returns a copy of the token payload
+func (f *FileStorage) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r, ok := f.refresh[tokenHash]; ok {
+		return &r, nil
 	}
-	return "", time.Time{}, false, fmt.Errorf("refresh token not found")
+	return nil, ErrRefreshTokenNotFound
 }
 
 // RevokeRefreshToken marks token as revoked
-func (f *FileStorage) RevokeRefreshToken(ctx context.Context, token string) error {
-	if r, ok := f.refresh[token]; ok {
+func (f *FileStorage) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r, ok := f.refresh[tokenHash]; ok {
 		r.Revoked = true
-		f.refresh[token] = r
+		f.refresh[tokenHash] = r
 		return nil
 	}
-	return fmt.Errorf("refresh token not found")
+	return ErrRefreshTokenNotFound
+}
+
+// RotateRefreshToken revokes the token and stores next under a single lock
+func (f *FileStorage) RotateRefreshToken(ctx context.Context, tokenHash string, next *RefreshToken) (*RefreshToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, ok := f.refresh[tokenHash]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	current := r
+	if r.Revoked {
+		return &current, ErrRefreshTokenRevoked
+	}
+	if time.Now().After(r.ExpiresAt) {
+		return &current, ErrRefreshTokenExpired
+	}
+	r.Revoked = true
+	f.refresh[tokenHash] = r
+	next.UserID = r.UserID
+	next.FamilyID = r.FamilyID
+	next.CreatedAt = r.CreatedAt
+	next.DeviceLabel = r.DeviceLabel
+	next.AuthTime = r.AuthTime
+	next.AMR = r.AMR
+	next.ClientID = r.ClientID
+	next.Scope = r.Scope
+	f.refresh[next.TokenHash] = *next
+	return &current, nil
+}
+
+// RevokeRefreshTokenFamily marks every token of a family as revoked
+func (f *FileStorage) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k, r := range f.refresh {
+		if r.FamilyID == familyID {
+			r.Revoked = true
+			f.refresh[k] = r
+		}
+	}
+	return nil
+}
+
+// ListActiveRefreshTokens returns copies of the user's unrevoked, unexpired tokens, newest session first
+func (f *FileStorage) ListActiveRefreshTokens(ctx context.Context, userID string) ([]*RefreshToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	var tokens []*RefreshToken
+	for _, r := range f.refresh {
+		if r.UserID == userID && !r.Revoked && now.Before(r.ExpiresAt) {
+			found := r
+			tokens = append(tokens, &found)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b *RefreshToken) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return tokens, nil
+}
+
+// RevokeAllRefreshTokens marks every token of the user as revoked
+func (f *FileStorage) RevokeAllRefreshTokens(ctx context.Context, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k, r := range f.refresh {
+		if r.UserID == userID {
+			r.Revoked = true
+			f.refresh[k] = r
+		}
+	}
+	return nil
 }
 
 // DeleteExpiredRefreshTokens cleans memory map
 func (f *FileStorage) DeleteExpiredRefreshTokens(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	now := time.Now()
 	for k, v := range f.refresh {
 		if now.After(v.ExpiresAt) {
@@ -207,3 +552,684 @@ func (f *FileStorage) DeleteExpiredRefreshTokens(ctx context.Context) error {
 	}
 	return nil
 }
+
+// RevokeAccessToken adds an access token ID to the denylist and its file
+func (f *FileStorage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := appendJournal(f.revokedFile, JSONRevokedFS{JTI: jti, ExpiresAt: expiresAt}); err != nil {
+		return err
+	}
+	f.revoked[jti] = expiresAt
+	return nil
+}
+
+// IsAccessTokenRevoked reports whether an access token ID is on the denylist
+func (f *FileStorage) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.revoked[jti]
+	return ok, nil
+}
+
+// DeleteExpiredRevokedAccessTokens cleans expired denylist entries
+func (f *FileStorage) DeleteExpiredRevokedAccessTokens(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for jti, expiresAt := range f.revoked {
+		if now.After(expiresAt) {
+			delete(f.revoked, jti)
+		}
+	}
+	return nil
+}
+
+// GetGlobalNotBefore returns the global token cut-off
+func (f *FileStorage) GetGlobalNotBefore(ctx context.Context) (time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.notBefore, nil
+}
+
+// SetGlobalNotBefore stores the cut-off, revokes older refresh tokens and appends the audit record
+// The cut-off and the record are written to the audit file first, so they survive a restart
+func (f *FileStorage) SetGlobalNotBefore(ctx context.Context, notBefore time.Time, record *AuditRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := appendJournal(f.auditFile, JSONAuditFS{AuditRecord: *record, NotBefore: notBefore}); err != nil {
+		return err
+	}
+	f.notBefore = notBefore
+	for k, r := range f.refresh {
+		if r.CreatedAt.Before(notBefore) {
+			r.Revoked = true
+			f.refresh[k] = r
+		}
+	}
+	f.auditLog = append(f.auditLog, *record)
+	return nil
+}
+
+// CreateAuthorizationCode stores an authorization code in memory
+func (f *FileStorage) CreateAuthorizationCode(ctx context.Context, code *AuthorizationCode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.authCodes[code.CodeHash]; ok {
+		return fmt.Errorf("authorization code already exists")
+	}
+	stored := *code
+	f.authCodes[code.CodeHash] = &stored
+	return nil
+}
+
+// ConsumeAuthorizationCode removes an authorization code from memory and returns it
+func (f *FileStorage) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*AuthorizationCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code, ok := f.authCodes[codeHash]
+	if !ok {
+		return nil, fmt.Errorf("authorization code not found")
+	}
+	delete(f.authCodes, codeHash)
+	return code, nil
+}
+
+// CreateEmailVerificationToken stores an email verification token in memory
+func (f *FileStorage) CreateEmailVerificationToken(ctx context.Context, token *EmailVerificationToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.emailTokens[token.TokenHash] = *token
+	return nil
+}
+
+// ConsumeEmailVerificationToken removes an email verification token from memory and returns it
+func (f *FileStorage) ConsumeEmailVerificationToken(ctx context.Context, tokenHash string) (*EmailVerificationToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token, ok := f.emailTokens[tokenHash]
+	if !ok {
+		return nil, ErrEmailVerificationTokenNotFound
+	}
+	delete(f.emailTokens, tokenHash)
+	return &token, nil
+}
+
+// DeleteExpiredEmailVerificationTokens cleans expired email verification tokens
+func (f *FileStorage) DeleteExpiredEmailVerificationTokens(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for k, v := range f.emailTokens {
+		if now.After(v.ExpiresAt) {
+			delete(f.emailTokens, k)
+		}
+	}
+	return nil
+}
+
+// CreatePasswordResetToken stores a password reset token in memory
+func (f *FileStorage) CreatePasswordResetToken(ctx context.Context, token *PasswordResetToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resets[token.TokenHash] = *token
+	return nil
+}
+
+// ConsumePasswordResetToken removes a password reset token from memory and returns it
+func (f *FileStorage) ConsumePasswordResetToken(ctx context.Context, tokenHash string) (*PasswordResetToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token, ok := f.resets[tokenHash]
+	if !ok {
+		return nil, ErrPasswordResetTokenNotFound
+	}
+	delete(f.resets, tokenHash)
+	return &token, nil
+}
+
+// DeleteExpiredPasswordResetTokens cleans expired password reset tokens
+func (f *FileStorage) DeleteExpiredPasswordResetTokens(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for k, v := range f.resets {
+		if now.After(v.ExpiresAt) {
+			delete(f.resets, k)
+		}
+	}
+	return nil
+}
+
+// SetTOTPSecret stores a pending TOTP enrollment
+func (f *FileStorage) SetTOTPSecret(ctx context.Context, secret *TOTPSecret) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored := *secret
+	stored.Secret = slices.Clone(secret.Secret)
+	stored.ConfirmedAt = time.Time{}
+	stored.LastUsedStep = 0
+	stored.FailedAttempts = 0
+	stored.LockedUntil = time.Time{}
+	return f.saveTOTP(secret.UserID, &stored, f.recovery[secret.UserID])
+}
+
+// GetTOTPSecret returns a copy of the user's TOTP secret
+func (f *FileStorage) GetTOTPSecret(ctx context.Context, userID string) (*TOTPSecret, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret, ok := f.totp[userID]
+	if !ok {
+		return nil, ErrTOTPNotFound
+	}
+	secret.Secret = slices.Clone(secret.Secret)
+	return &secret, nil
+}
+
+// ConfirmTOTPSecret enables a pending TOTP secret and replaces the recovery codes
+func (f *FileStorage) ConfirmTOTPSecret(ctx context.Context, userID string, confirmedAt time.Time, step int64, recoveryCodeHashes []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret, ok := f.totp[userID]
+	if !ok || !secret.ConfirmedAt.IsZero() {
+		return ErrTOTPNotFound
+	}
+	secret.ConfirmedAt = confirmedAt
+	secret.LastUsedStep = step
+	return f.saveTOTP(userID, &secret, slices.Clone(recoveryCodeHashes))
+}
+
+// UseTOTPStep advances the last used time step of a confirmed TOTP secret
+func (f *FileStorage) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret, ok := f.totp[userID]
+	if !ok || secret.ConfirmedAt.IsZero() || secret.LastUsedStep >= step {
+		return ErrTOTPStepUsed
+	}
+	secret.LastUsedStep = step
+	return f.saveTOTP(userID, &secret, f.recovery[userID])
+}
+
+// DeleteTOTPSecret removes the TOTP secret and recovery codes
+func (f *FileStorage) DeleteTOTPSecret(ctx context.Context, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.saveTOTP(userID, nil, nil)
+}
+
+// UseRecoveryCode removes a recovery code
+func (f *FileStorage) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := slices.Index(f.recovery[userID], codeHash)
+	if i < 0 {
+		return ErrRecoveryCodeNotFound
+	}
+	codes := slices.Delete(slices.Clone(f.recovery[userID]), i, i+1)
+	if secret, ok := f.totp[userID]; ok {
+		return f.saveTOTP(userID, &secret, codes)
+	}
+	f.recovery[userID] = codes
+	return nil
+}
+
+// RecordMFAFailure increments the failed second-factor attempts of a user
+func (f *FileStorage) RecordMFAFailure(ctx context.Context, userID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret, ok := f.totp[userID]
+	if !ok {
+		return 0, ErrTOTPNotFound
+	}
+	secret.FailedAttempts++
+	if err := f.saveTOTP(userID, &secret, f.recovery[userID]); err != nil {
+		return 0, err
+	}
+	return secret.FailedAttempts, nil
+}
+
+// ResetMFAFailures clears the failed second-factor attempts and sets the lockout end
+func (f *FileStorage) ResetMFAFailures(ctx context.Context, userID string, lockedUntil time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret, ok := f.totp[userID]
+	if !ok {
+		return nil
+	}
+	secret.FailedAttempts = 0
+	secret.LockedUntil = lockedUntil
+	return f.saveTOTP(userID, &secret, f.recovery[userID])
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes
+func (f *FileStorage) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.recovery[userID]), nil
+}
+
+// saveTOTP writes the second factor of a user to the TOTP file and then to memory; a nil secret deletes it
+// Losing it on restart would let every enrolled user sign in with the password alone
+// The caller must hold the lock
+func (f *FileStorage) saveTOTP(userID string, secret *TOTPSecret, recoveryCodeHashes []string) error {
+	entry := JSONTOTPFS{TOTPSecret: TOTPSecret{UserID: userID}, Deleted: secret == nil}
+	if secret != nil {
+		entry.TOTPSecret = *secret
+		entry.RecoveryCodes = recoveryCodeHashes
+	}
+	if err := appendJournal(f.totpFile, entry); err != nil {
+		return err
+	}
+	f.loadTOTP(entry)
+	return nil
+}
+
+// loadTOTP applies a record of the TOTP file to memory; the caller must hold the lock
+func (f *FileStorage) loadTOTP(entry JSONTOTPFS) {
+	if entry.Deleted {
+		delete(f.totp, entry.UserID)
+		delete(f.recovery, entry.UserID)
+		return
+	}
+	f.totp[entry.UserID] = entry.TOTPSecret
+	if len(entry.RecoveryCodes) > 0 {
+		f.recovery[entry.UserID] = entry.RecoveryCodes
+	} else {
+		delete(f.recovery, entry.UserID)
+	}
+}
+
+// SetEmailLoginCode stores the user's email login code in memory, replacing any previous one
+func (f *FileStorage) SetEmailLoginCode(ctx context.Context, code *EmailLoginCode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loginCodes[code.UserID] = *code
+	return nil
+}
+
+// GetEmailLoginCode returns a copy of the user's email login code
+func (f *FileStorage) GetEmailLoginCode(ctx context.Context, userID string) (*EmailLoginCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code, ok := f.loginCodes[userID]
+	if !ok {
+		return nil, ErrEmailLoginCodeNotFound
+	}
+	return &code, nil
+}
+
+// IncrementEmailLoginCodeAttempts records an attempt to enter the user's email login code
+func (f *FileStorage) IncrementEmailLoginCodeAttempts(ctx context.Context, userID string) (*EmailLoginCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code, ok := f.loginCodes[userID]
+	if !ok {
+		return nil, ErrEmailLoginCodeNotFound
+	}
+	code.Attempts++
+	f.loginCodes[userID] = code
+	return &code, nil
+}
+
+// ConsumeEmailLoginCode removes the user's email login code from memory if it matches
+func (f *FileStorage) ConsumeEmailLoginCode(ctx context.Context, userID, codeHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code, ok := f.loginCodes[userID]
+	if !ok || code.CodeHash != codeHash {
+		return ErrEmailLoginCodeNotFound
+	}
+	delete(f.loginCodes, userID)
+	return nil
+}
+
+// DeleteExpiredEmailLoginCodes cleans expired email login codes
+func (f *FileStorage) DeleteExpiredEmailLoginCodes(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for k, v := range f.loginCodes {
+		if now.After(v.ExpiresAt) {
+			delete(f.loginCodes, k)
+		}
+	}
+	return nil
+}
+
+// CreateLoginLink stores a login link in memory
+func (f *FileStorage) CreateLoginLink(ctx context.Context, link *LoginLink) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loginLinks[link.TokenHash] = *link
+	return nil
+}
+
+// GetLoginLink returns a copy of a login link
+func (f *FileStorage) GetLoginLink(ctx context.Context, tokenHash string) (*LoginLink, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	link, ok := f.loginLinks[tokenHash]
+	if !ok {
+		return nil, ErrLoginLinkNotFound
+	}
+	return &link, nil
+}
+
+// ConsumeLoginLink removes a login link from memory
+func (f *FileStorage) ConsumeLoginLink(ctx context.Context, tokenHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.loginLinks[tokenHash]; !ok {
+		return ErrLoginLinkNotFound
+	}
+	delete(f.loginLinks, tokenHash)
+	return nil
+}
+
+// CountLoginLinks returns the number of the user's unused login links created since the given time
+func (f *FileStorage) CountLoginLinks(ctx context.Context, userID string, since time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, link := range f.loginLinks {
+		if link.UserID == userID && !link.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeleteExpiredLoginLinks cleans expired login links
+func (f *FileStorage) DeleteExpiredLoginLinks(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for k, v := range f.loginLinks {
+		if now.After(v.ExpiresAt) {
+			delete(f.loginLinks, k)
+		}
+	}
+	return nil
+}
+
+// CreateMFAChallenge stores an MFA login challenge in memory
+func (f *FileStorage) CreateMFAChallenge(ctx context.Context, challenge *MFAChallenge) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mfa[challenge.TokenHash] = *challenge
+	return nil
+}
+
+// GetMFAChallenge returns a copy of an MFA login challenge
+func (f *FileStorage) GetMFAChallenge(ctx context.Context, tokenHash string) (*MFAChallenge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	challenge, ok := f.mfa[tokenHash]
+	if !ok {
+		return nil, ErrMFAChallengeNotFound
+	}
+	return &challenge, nil
+}
+
+// IncrementMFAChallengeAttempts records a wrong code for an MFA login challenge
+func (f *FileStorage) IncrementMFAChallengeAttempts(ctx context.Context, tokenHash string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	challenge, ok := f.mfa[tokenHash]
+	if !ok {
+		return 0, ErrMFAChallengeNotFound
+	}
+	challenge.Attempts++
+	f.mfa[tokenHash] = challenge
+	return challenge.Attempts, nil
+}
+
+// ConsumeMFAChallenge removes an MFA login challenge from memory and returns it
+func (f *FileStorage) ConsumeMFAChallenge(ctx context.Context, tokenHash string) (*MFAChallenge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	challenge, ok := f.mfa[tokenHash]
+	if !ok {
+		return nil, ErrMFAChallengeNotFound
+	}
+	delete(f.mfa, tokenHash)
+	return &challenge, nil
+}
+
+// DeleteExpiredMFAChallenges cleans expired MFA login challenges
+func (f *FileStorage) DeleteExpiredMFAChallenges(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for k, v := range f.mfa {
+		if now.After(v.ExpiresAt) {
+			delete(f.mfa, k)
+		}
+	}
+	return nil
+}
+
+// CreateWebAuthnCredential stores a WebAuthn credential in memory
+func (f *FileStorage) CreateWebAuthnCredential(ctx context.Context, cred *WebAuthnCredential) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.passkeys[cred.ID]; ok {
+		return ErrWebAuthnCredentialExists
+	}
+	f.passkeys[cred.ID] = *cred
+	return nil
+}
+
+// GetWebAuthnCredential returns a copy of a WebAuthn credential
+func (f *FileStorage) GetWebAuthnCredential(ctx context.Context, credentialID string) (*WebAuthnCredential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cred, ok := f.passkeys[credentialID]
+	if !ok {
+		return nil, ErrWebAuthnCredentialNotFound
+	}
+	return &cred, nil
+}
+
+// ListWebAuthnCredentials returns the user's WebAuthn credentials, oldest first
+func (f *FileStorage) ListWebAuthnCredentials(ctx context.Context, userID string) ([]WebAuthnCredential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var creds []WebAuthnCredential
+	for _, cred := range f.passkeys {
+		if cred.UserID == userID {
+			creds = append(creds, cred)
+		}
+	}
+	slices.SortFunc(creds, func(a, b WebAuthnCredential) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return creds, nil
+}
+
+// UseWebAuthnCredential records a login with a WebAuthn credential
+func (f *FileStorage) UseWebAuthnCredential(ctx context.Context, credentialID string, signCount uint32, usedAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cred, ok := f.passkeys[credentialID]
+	if !ok {
+		return ErrWebAuthnCredentialNotFound
+	}
+	if signCount <= cred.SignCount && (signCount != 0 || cred.SignCount != 0) {
+		return ErrWebAuthnSignCount
+	}
+	cred.SignCount = signCount
+	cred.LastUsedAt = usedAt
+	f.passkeys[credentialID] = cred
+	return nil
+}
+
+// DeleteWebAuthnCredential removes one of the user's WebAuthn credentials
+func (f *FileStorage) DeleteWebAuthnCredential(ctx context.Context, userID, credentialID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cred, ok := f.passkeys[credentialID]
+	if !ok || cred.UserID != userID {
+		return ErrWebAuthnCredentialNotFound
+	}
+	delete(f.passkeys, credentialID)
+	return nil
+}
+
+// CreateWebAuthnChallenge stores a WebAuthn challenge in memory
+func (f *FileStorage) CreateWebAuthnChallenge(ctx context.Context, challenge *WebAuthnChallenge) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ceremonies[challenge.ChallengeHash] = *challenge
+	return nil
+}
+
+// ConsumeWebAuthnChallenge removes a WebAuthn challenge from memory and returns it
+func (f *FileStorage) ConsumeWebAuthnChallenge(ctx context.Context, challengeHash string) (*WebAuthnChallenge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	challenge, ok := f.ceremonies[challengeHash]
+	if !ok {
+		return nil, ErrWebAuthnChallengeNotFound
+	}
+	delete(f.ceremonies, challengeHash)
+	return &challenge, nil
+}
+
+// DeleteExpiredWebAuthnChallenges cleans expired WebAuthn challenges
+func (f *FileStorage) DeleteExpiredWebAuthnChallenges(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for k, v := range f.ceremonies {
+		if now.After(v.ExpiresAt) {
+			delete(f.ceremonies, k)
+		}
+	}
+	return nil
+}
+
+// CreateDeviceCode stores a device authorization in memory
+func (f *FileStorage) CreateDeviceCode(ctx context.Context, code *DeviceCode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.deviceCodes[code.DeviceCodeHash]; ok {
+		return fmt.Errorf("device code already exists")
+	}
+	if f.findDeviceCode(code.UserCode) != nil {
+		return fmt.Errorf("user code already exists")
+	}
+	stored := *code
+	f.deviceCodes[code.DeviceCodeHash] = &stored
+	return nil
+}
+
+// GetDeviceCode returns a copy of a device authorization by device code hash
+func (f *FileStorage) GetDeviceCode(ctx context.Context, deviceCodeHash string) (*DeviceCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code, ok := f.deviceCodes[deviceCodeHash]
+	if !ok {
+		return nil, fmt.Errorf("device code not found")
+	}
+	found := *code
+	return &found, nil
+}
+
+// GetDeviceCodeByUserCode returns a copy of a device authorization by user code
+func (f *FileStorage) GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*DeviceCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if code := f.findDeviceCode(userCode); code != nil {
+		found := *code
+		return &found, nil
+	}
+	return nil, fmt.Errorf("device code not found")
+}
+
+// findDeviceCode returns the stored device authorization with the given user code; the caller must hold the lock
+func (f *FileStorage) findDeviceCode(userCode string) *DeviceCode {
+	for _, code := range f.deviceCodes {
+		if code.UserCode == userCode {
+			return code
+		}
+	}
+	return nil
+}
+
+// UpdateDeviceCodePolling records the last poll time and polling interval
+func (f *FileStorage) UpdateDeviceCodePolling(ctx context.Context, deviceCodeHash string, polledAt time.Time, interval time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code, ok := f.deviceCodes[deviceCodeHash]
+	if !ok {
+		return fmt.Errorf("device code not found")
+	}
+	code.LastPolledAt = polledAt
+	code.Interval = interval
+	return nil
+}
+
+// SetDeviceCodeStatus approves or denies a pending device authorization
+func (f *FileStorage) SetDeviceCodeStatus(ctx context.Context, userCode, status, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, code := range f.deviceCodes {
+		if code.UserCode == userCode && code.Status == DeviceCodePending {
+			code.Status = status
+			code.UserID = userID
+			return nil
+		}
+	}
+	return fmt.Errorf("pending device code not found")
+}
+
+// DeleteDeviceCode removes a device authorization from memory
+func (f *FileStorage) DeleteDeviceCode(ctx context.Context, deviceCodeHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.deviceCodes[deviceCodeHash]; !ok {
+		return fmt.Errorf("device code not found")
+	}
+	delete(f.deviceCodes, deviceCodeHash)
+	return nil
+}
+
+// CreateClient stores an OAuth client in memory
+func (f *FileStorage) CreateClient(ctx context.Context, client *Client) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.clients[client.ClientID]; ok {
+		return fmt.Errorf("client already exists: %s", client.ClientID)
+	}
+	stored := *client
+	f.clients[client.ClientID] = &stored
+	return nil
+}
+
+// GetClient returns a copy of an OAuth client
+func (f *FileStorage) GetClient(ctx context.Context, clientID string) (*Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	client, ok := f.clients[clientID]
+	if !ok {
+		return nil, fmt.Errorf("client not found: %s", clientID)
+	}
+	copied := *client
+	return &copied, nil
+}
+
+// UpdateClient replaces an OAuth client in memory
+func (f *FileStorage) UpdateClient(ctx context.Context, client *Client) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored, ok := f.clients[client.ClientID]
+	if !ok {
+		return fmt.Errorf("client not found: %s", client.ClientID)
+	}
+	updated := *client
+	updated.CreatedAt = stored.CreatedAt
+	f.clients[client.ClientID] = &updated
+	return nil
+}
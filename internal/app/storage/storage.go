@@ -3,17 +3,296 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/vitalykrupin/auth-service/cmd/auth/config"
 )
 
+// ErrUserNotFound is returned when no user has the given user ID
+var ErrUserNotFound = errors.New("user not found")
+
+// Refresh token errors
+var (
+	// ErrRefreshTokenNotFound is returned when a refresh token does not exist
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	// ErrRefreshTokenRevoked is returned when rotating a token that was already revoked
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+	// ErrRefreshTokenExpired is returned when rotating a token past its expiry
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+)
+
+// ErrProfileNotFound is returned when a user has no profile, or its email no longer matches
+var ErrProfileNotFound = errors.New("profile not found")
+
+// ErrEmailTaken is returned when verifying an email another user has already verified
+var ErrEmailTaken = errors.New("email address is already verified by another user")
+
+// ErrEmailVerificationTokenNotFound is returned when an email verification token does not exist or was already used
+var ErrEmailVerificationTokenNotFound = errors.New("email verification token not found")
+
+// ErrPasswordResetTokenNotFound is returned when a password reset token does not exist or was already used
+var ErrPasswordResetTokenNotFound = errors.New("password reset token not found")
+
+// ErrEmailLoginCodeNotFound is returned when the user has no pending email login code, or it does not match
+var ErrEmailLoginCodeNotFound = errors.New("email login code not found")
+
+// ErrLoginLinkNotFound is returned when a login link does not exist or was already used
+var ErrLoginLinkNotFound = errors.New("login link not found")
+
+// Second factor errors
+var (
+	// ErrTOTPNotFound is returned when the user has no TOTP secret
+	ErrTOTPNotFound = errors.New("TOTP secret not found")
+	// ErrTOTPStepUsed is returned when a TOTP time step is not newer than the last accepted one
+	ErrTOTPStepUsed = errors.New("TOTP code already used")
+	// ErrRecoveryCodeNotFound is returned when a recovery code does not exist or was already used
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+	// ErrMFAChallengeNotFound is returned when an MFA challenge does not exist or was already used
+	ErrMFAChallengeNotFound = errors.New("MFA challenge not found")
+)
+
+// WebAuthn errors
+var (
+	// ErrWebAuthnCredentialNotFound is returned when a credential does not exist or belongs to another user
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+	// ErrWebAuthnCredentialExists is returned when a credential ID is already registered
+	ErrWebAuthnCredentialExists = errors.New("webauthn credential already registered")
+	// ErrWebAuthnSignCount is returned when a signature counter does not increase
+	ErrWebAuthnSignCount = errors.New("webauthn signature counter did not increase")
+	// ErrWebAuthnChallengeNotFound is returned when a WebAuthn challenge does not exist or was already used
+	ErrWebAuthnChallengeNotFound = errors.New("webauthn challenge not found")
+)
+
 // User represents a user in the system
 type User struct {
-	ID       int    `json:"id"`
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	ID    int    `json:"id"`
+	Login string `json:"login"`
+	// Password is the bcrypt hash; empty for passwordless accounts, which sign in by email code
+	Password string `json:"password,omitempty"`
 	UserID   string `json:"user_id"`
+
+	// TokenVersion is embedded in access tokens; bumping it invalidates every token issued before
+	TokenVersion int `json:"token_version"`
+}
+
+// HasPassword reports whether the user can sign in with a password
+func (u *User) HasPassword() bool {
+	return u.Password != ""
+}
+
+// RefreshToken represents an issued refresh token
+type RefreshToken struct {
+	// TokenHash is the SHA-256 digest of the token (see HashToken); the token itself is never stored
+	TokenHash string `json:"token_hash"`
+	UserID    string `json:"user_id"`
+
+	// FamilyID links every token rotated from the same login
+	FamilyID  string    `json:"family_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Revoked   bool      `json:"revoked"`
+
+	// Session metadata; CreatedAt is when the family started and is kept on rotation
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	UserAgent   string    `json:"user_agent"`
+	IP          string    `json:"ip"`
+	DeviceLabel string    `json:"device_label"`
+
+	// How and when the user authenticated to start the session; kept on rotation
+	// AMR holds RFC 8176 method references; both are empty for sessions started before they were recorded
+	AuthTime time.Time `json:"auth_time,omitzero"`
+	AMR      []string  `json:"amr,omitempty"`
+
+	// ClientID is the OAuth client the token was issued to and Scope what the user granted it; kept on rotation
+	// Both are empty for first-party login sessions
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// AuthorizationCode represents a pending OAuth 2.0 authorization code
+type AuthorizationCode struct {
+	// CodeHash is the SHA-256 digest of the code (see HashToken)
+	CodeHash            string    `json:"code_hash"`
+	ClientID            string    `json:"client_id"`
+	UserID              string    `json:"user_id"`
+	RedirectURI         string    `json:"redirect_uri"`
+	Scope               string    `json:"scope"`
+	CodeChallenge       string    `json:"code_challenge"`
+	CodeChallengeMethod string    `json:"code_challenge_method"`
+	ExpiresAt           time.Time `json:"expires_at"`
+}
+
+// Profile represents the editable details of a user
+type Profile struct {
+	UserID string `json:"user_id"`
+	// Email is empty if the user has not set one
+	Email string `json:"email,omitempty"`
+	// EmailVerifiedAt is zero while the email is unverified; it is only set by MarkEmailVerified
+	EmailVerifiedAt time.Time `json:"email_verified_at,omitzero"`
+	DisplayName     string    `json:"display_name,omitempty"`
+	// Locale is a BCP 47 language tag, e.g. "en-US"
+	Locale string `json:"locale,omitempty"`
+	// Timezone is an IANA time zone name, e.g. "Europe/Moscow"
+	Timezone  string    `json:"timezone,omitempty"`
+	AvatarURL string    `json:"avatar_url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PasswordResetToken represents a pending password reset
+type PasswordResetToken struct {
+	// TokenHash is the SHA-256 digest of the emailed token (see HashToken)
+	TokenHash string    `json:"token_hash"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// EmailVerificationToken represents a pending email address verification
+type EmailVerificationToken struct {
+	// TokenHash is the SHA-256 digest of the emailed token (see HashToken)
+	TokenHash string `json:"token_hash"`
+	UserID    string `json:"user_id"`
+	// Email is the address the token was sent to; it only verifies the profile while the email is unchanged
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LoginLink represents an emailed single-use login link
+type LoginLink struct {
+	// TokenHash is the SHA-256 digest of the token in the link (see HashToken)
+	TokenHash string `json:"token_hash"`
+	UserID    string `json:"user_id"`
+	// Email is the address the link was sent to; it only signs in while the profile email is unchanged
+	Email string `json:"email"`
+	// NonceHash is the SHA-256 digest of the nonce cookie of the browser that asked for the link
+	NonceHash string    `json:"nonce_hash"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TOTPSecret represents a user's TOTP (RFC 6238) second factor
+type TOTPSecret struct {
+	UserID string `json:"user_id"`
+	// Secret is the encrypted shared secret; storage never sees it in plain text
+	Secret []byte `json:"secret"`
+	// ConfirmedAt is zero while the enrollment is pending
+	ConfirmedAt time.Time `json:"confirmed_at,omitzero"`
+	// LastUsedStep is the last accepted time step; codes of this or earlier steps are rejected
+	LastUsedStep int64     `json:"last_used_step"`
+	CreatedAt    time.Time `json:"created_at"`
+	// FailedAttempts is the number of wrong codes entered since the last success or lockout, across challenges
+	FailedAttempts int `json:"failed_attempts"`
+	// LockedUntil is the end of the lockout after too many wrong codes; zero when not locked
+	LockedUntil time.Time `json:"locked_until,omitzero"`
+}
+
+// MFAChallenge represents a login that passed the password check and waits for the second factor
+type MFAChallenge struct {
+	// TokenHash is the SHA-256 digest of the challenge token (see HashToken)
+	TokenHash string    `json:"token_hash"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Attempts is the number of wrong codes entered so far
+	Attempts int `json:"attempts"`
+	// AMR holds the RFC 8176 methods of the first factor; empty for password logins started before it was recorded
+	AMR []string `json:"amr,omitempty"`
+}
+
+// EmailLoginCode represents a one-time code emailed for a passwordless login; a user has at most one
+type EmailLoginCode struct {
+	UserID string `json:"user_id"`
+	// Email is the address the code was sent to; it only signs in while the profile email is unchanged
+	Email string `json:"email"`
+	// CodeHash is the SHA-256 digest of the code salted with the user ID
+	CodeHash  string    `json:"code_hash"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Attempts is the number of codes entered so far, right or wrong
+	Attempts int `json:"attempts"`
+}
+
+// WebAuthnCredential represents a registered WebAuthn credential (passkey)
+type WebAuthnCredential struct {
+	// ID is the base64url encoded credential ID
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// PublicKey is the COSE encoded credential public key
+	PublicKey []byte `json:"public_key"`
+	// SignCount is the last signature counter reported by the authenticator; 0 if it keeps no counter
+	SignCount       uint32    `json:"sign_count"`
+	AAGUID          string    `json:"aaguid,omitempty"`
+	AttestationType string    `json:"attestation_type"`
+	Name            string    `json:"name"`
+	CreatedAt       time.Time `json:"created_at"`
+	LastUsedAt      time.Time `json:"last_used_at,omitzero"`
+}
+
+// WebAuthn ceremonies
+const (
+	WebAuthnRegistration = "registration"
+	WebAuthnLogin        = "login"
+)
+
+// WebAuthnChallenge represents a pending WebAuthn ceremony
+type WebAuthnChallenge struct {
+	// ChallengeHash is the SHA-256 digest of the base64url encoded challenge (see HashToken)
+	ChallengeHash string `json:"challenge_hash"`
+	// Ceremony is WebAuthnRegistration or WebAuthnLogin
+	Ceremony string `json:"ceremony"`
+	// UserID is the user registering a credential; empty for logins, where the credential names the user
+	UserID    string    `json:"user_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Device code statuses
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+)
+
+// DeviceCode represents a pending OAuth 2.0 device authorization (RFC 8628)
+type DeviceCode struct {
+	// DeviceCodeHash is the SHA-256 digest of the device code (see HashToken)
+	DeviceCodeHash string `json:"device_code_hash"`
+
+	// UserCode is the normalized code the user enters on the verification page
+	UserCode string `json:"user_code"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+
+	// UserID is set once the user approves the request
+	UserID string `json:"user_id"`
+	Status string `json:"status"`
+
+	// Interval is the minimum time between polls of the token endpoint
+	Interval     time.Duration `json:"interval"`
+	LastPolledAt time.Time     `json:"last_polled_at"`
+	ExpiresAt    time.Time     `json:"expires_at"`
+}
+
+// Client represents a registered OAuth 2.0 client
+type Client struct {
+	ClientID string `json:"client_id"`
+	// SecretHash is the bcrypt hash of the client secret; empty for public clients
+	SecretHash   string    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// AuditRecord is an entry of the audit log of administrative actions
+type AuditRecord struct {
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // Storage interface for authentication data storage operations
@@ -25,16 +304,156 @@ type Storage interface {
 	// CreateUser creates a new user
 	CreateUser(ctx context.Context, user *User) error
 
+	// GetUserByID retrieves a user by user ID
+	GetUserByID(ctx context.Context, userID string) (*User, error)
+
+	// UpdateUserPassword replaces the user's password hash
+	UpdateUserPassword(ctx context.Context, userID, passwordHash string) error
+
+	// GetTokenVersion returns the user's access token version; unknown users have version 0
+	GetTokenVersion(ctx context.Context, userID string) (int, error)
+	// IncrementTokenVersion bumps the user's access token version and returns the new value
+	IncrementTokenVersion(ctx context.Context, userID string) (int, error)
+
 	// Profile methods
-	SetUserProfile(ctx context.Context, userID, email string) error
-	GetUserProfile(ctx context.Context, userID string) (email string, err error)
+	// SetUserProfile creates or replaces the profile and fills in its EmailVerifiedAt, CreatedAt and UpdatedAt
+	// Changing the email resets its verification
+	SetUserProfile(ctx context.Context, profile *Profile) error
+	// GetUserProfile returns ErrProfileNotFound if the user has no profile yet
+	GetUserProfile(ctx context.Context, userID string) (*Profile, error)
+	// GetUserIDByEmail finds the user who verified the email, ignoring case
+	// Unverified claims are never matched: anyone can type in an address they do not own
+	GetUserIDByEmail(ctx context.Context, email string) (string, error)
+	// GetEmailVerifiedAt returns when the profile email was verified; zero if it was not
+	GetEmailVerifiedAt(ctx context.Context, userID string) (time.Time, error)
+	// MarkEmailVerified verifies the profile email if it still is email; otherwise returns ErrProfileNotFound
+	// Returns ErrEmailTaken if another user has verified it; unverified claims of other users are dropped
+	MarkEmailVerified(ctx context.Context, userID, email string, verifiedAt time.Time) error
 
 	// Refresh tokens
-	CreateRefreshToken(ctx context.Context, token, userID string, expiresAt time.Time) error
-	GetRefreshToken(ctx context.Context, token string) (userID string, expiresAt time.Time, revoked bool, err error)
-	RevokeRefreshToken(ctx context.Context, token string) error
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, tokenHash string) error
+	// RotateRefreshToken atomically revokes the token and stores next in its family
+	// next inherits UserID, FamilyID, CreatedAt and DeviceLabel; the current token is returned along with
+	// ErrRefreshTokenRevoked or ErrRefreshTokenExpired when it cannot be rotated
+	RotateRefreshToken(ctx context.Context, tokenHash string, next *RefreshToken) (*RefreshToken, error)
+	// RevokeRefreshTokenFamily revokes every token of a family
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	// ListActiveRefreshTokens returns the user's tokens that are neither revoked nor expired
+	ListActiveRefreshTokens(ctx context.Context, userID string) ([]*RefreshToken, error)
+	// RevokeAllRefreshTokens revokes every token of the user
+	RevokeAllRefreshTokens(ctx context.Context, userID string) error
 	DeleteExpiredRefreshTokens(ctx context.Context) error
 
+	// Access token denylist, keyed by jti and kept until the token would have expired
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredRevokedAccessTokens(ctx context.Context) error
+
+	// Global token cut-off ("break glass"); zero time if never set
+	GetGlobalNotBefore(ctx context.Context) (time.Time, error)
+	// SetGlobalNotBefore stores the cut-off, revokes every refresh token of a session started before it
+	// and writes the audit record, all or nothing
+	SetGlobalNotBefore(ctx context.Context, notBefore time.Time, record *AuditRecord) error
+
+	// OAuth authorization codes
+	CreateAuthorizationCode(ctx context.Context, code *AuthorizationCode) error
+	// ConsumeAuthorizationCode returns and deletes the code so it can be used only once
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*AuthorizationCode, error)
+
+	// Email verification tokens
+	CreateEmailVerificationToken(ctx context.Context, token *EmailVerificationToken) error
+	// ConsumeEmailVerificationToken returns and deletes the token so it can be used only once
+	ConsumeEmailVerificationToken(ctx context.Context, tokenHash string) (*EmailVerificationToken, error)
+	DeleteExpiredEmailVerificationTokens(ctx context.Context) error
+
+	// Password reset tokens
+	CreatePasswordResetToken(ctx context.Context, token *PasswordResetToken) error
+	// ConsumePasswordResetToken returns and deletes the token so it can be used only once
+	ConsumePasswordResetToken(ctx context.Context, tokenHash string) (*PasswordResetToken, error)
+	DeleteExpiredPasswordResetTokens(ctx context.Context) error
+
+	// Email login codes
+	// SetEmailLoginCode stores the user's code, replacing any previous one
+	SetEmailLoginCode(ctx context.Context, code *EmailLoginCode) error
+	GetEmailLoginCode(ctx context.Context, userID string) (*EmailLoginCode, error)
+	// IncrementEmailLoginCodeAttempts records an attempt and returns the code with the new number of attempts
+	IncrementEmailLoginCodeAttempts(ctx context.Context, userID string) (*EmailLoginCode, error)
+	// ConsumeEmailLoginCode deletes the user's code if its hash is codeHash, so it can be used only once
+	ConsumeEmailLoginCode(ctx context.Context, userID, codeHash string) error
+	DeleteExpiredEmailLoginCodes(ctx context.Context) error
+
+	// Login links
+	CreateLoginLink(ctx context.Context, link *LoginLink) error
+	GetLoginLink(ctx context.Context, tokenHash string) (*LoginLink, error)
+	// ConsumeLoginLink deletes the link so it can be used only once
+	ConsumeLoginLink(ctx context.Context, tokenHash string) error
+	// CountLoginLinks returns the number of the user's unused links created since the given time
+	CountLoginLinks(ctx context.Context, userID string, since time.Time) (int, error)
+	DeleteExpiredLoginLinks(ctx context.Context) error
+
+	// TOTP second factor
+	// SetTOTPSecret stores a pending enrollment, replacing any previous secret
+	SetTOTPSecret(ctx context.Context, secret *TOTPSecret) error
+	GetTOTPSecret(ctx context.Context, userID string) (*TOTPSecret, error)
+	// ConfirmTOTPSecret enables the secret, records step as used and replaces the recovery codes
+	ConfirmTOTPSecret(ctx context.Context, userID string, confirmedAt time.Time, step int64, recoveryCodeHashes []string) error
+	// UseTOTPStep records step as used; returns ErrTOTPStepUsed unless it is newer than the last one
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	// DeleteTOTPSecret removes the secret and the recovery codes
+	DeleteTOTPSecret(ctx context.Context, userID string) error
+	// UseRecoveryCode deletes a recovery code so it can be used only once
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) error
+	CountRecoveryCodes(ctx context.Context, userID string) (int, error)
+	// RecordMFAFailure counts a wrong second-factor code and returns the new number of failed attempts
+	RecordMFAFailure(ctx context.Context, userID string) (int, error)
+	// ResetMFAFailures clears the failed attempts and locks the second factor until lockedUntil; zero time unlocks it
+	ResetMFAFailures(ctx context.Context, userID string, lockedUntil time.Time) error
+
+	// MFA login challenges
+	CreateMFAChallenge(ctx context.Context, challenge *MFAChallenge) error
+	GetMFAChallenge(ctx context.Context, tokenHash string) (*MFAChallenge, error)
+	// IncrementMFAChallengeAttempts records a wrong code and returns the new number of attempts
+	IncrementMFAChallengeAttempts(ctx context.Context, tokenHash string) (int, error)
+	// ConsumeMFAChallenge returns and deletes the challenge so it can be used only once
+	ConsumeMFAChallenge(ctx context.Context, tokenHash string) (*MFAChallenge, error)
+	DeleteExpiredMFAChallenges(ctx context.Context) error
+
+	// WebAuthn credentials
+	// CreateWebAuthnCredential fails with ErrWebAuthnCredentialExists if the ID is already registered
+	CreateWebAuthnCredential(ctx context.Context, cred *WebAuthnCredential) error
+	GetWebAuthnCredential(ctx context.Context, credentialID string) (*WebAuthnCredential, error)
+	ListWebAuthnCredentials(ctx context.Context, userID string) ([]WebAuthnCredential, error)
+	// UseWebAuthnCredential records a login; returns ErrWebAuthnSignCount unless signCount is newer than the stored one
+	// A counter that stays 0 is accepted, since many authenticators do not keep one
+	UseWebAuthnCredential(ctx context.Context, credentialID string, signCount uint32, usedAt time.Time) error
+	// DeleteWebAuthnCredential removes one of the user's credentials
+	DeleteWebAuthnCredential(ctx context.Context, userID, credentialID string) error
+
+	// WebAuthn ceremonies
+	CreateWebAuthnChallenge(ctx context.Context, challenge *WebAuthnChallenge) error
+	// ConsumeWebAuthnChallenge returns and deletes the challenge so it can be used only once
+	ConsumeWebAuthnChallenge(ctx context.Context, challengeHash string) (*WebAuthnChallenge, error)
+	DeleteExpiredWebAuthnChallenges(ctx context.Context) error
+
+	// OAuth device codes (RFC 8628)
+	CreateDeviceCode(ctx context.Context, code *DeviceCode) error
+	GetDeviceCode(ctx context.Context, deviceCodeHash string) (*DeviceCode, error)
+	GetDeviceCodeByUserCode(ctx context.Context, userCode string) (*DeviceCode, error)
+	// UpdateDeviceCodePolling records a poll and the interval the client must respect
+	UpdateDeviceCodePolling(ctx context.Context, deviceCodeHash string, polledAt time.Time, interval time.Duration) error
+	// SetDeviceCodeStatus approves or denies a pending code; fails if the code is no longer pending
+	SetDeviceCodeStatus(ctx context.Context, userCode, status, userID string) error
+	// DeleteDeviceCode removes a code; fails if it was already removed
+	DeleteDeviceCode(ctx context.Context, deviceCodeHash string) error
+
+	// OAuth clients
+	CreateClient(ctx context.Context, client *Client) error
+	GetClient(ctx context.Context, clientID string) (*Client, error)
+	// UpdateClient replaces every mutable field, including the secret hash
+	UpdateClient(ctx context.Context, client *Client) error
+
 	// CloseStorage closes the storage connection
 	CloseStorage(ctx context.Context) error
 
@@ -50,3 +469,10 @@ func NewStorage(conf *config.Config) (Storage, error) {
 		return NewFileStorage(conf.FileStorePath)
 	}
 }
+
+// HashToken returns the hex encoded SHA-256 digest of a secret token
+// Secrets such as authorization codes are stored only in this form
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
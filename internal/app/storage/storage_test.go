@@ -2,8 +2,10 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/vitalykrupin/auth-service/cmd/auth/config"
 )
@@ -98,6 +100,146 @@ func TestFileStorage_UserOperations(t *testing.T) {
 	}
 }
 
+func TestFileStorage_TokenVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	store, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, &User{Login: "testuser", Password: "hashedpassword", UserID: "user123"}); err != nil {
+		t.Fatalf("Expected no error creating user, got %v", err)
+	}
+
+	if version, err := store.IncrementTokenVersion(ctx, "user123"); err != nil || version != 1 {
+		t.Fatalf("Expected version 1, got %d, %v", version, err)
+	}
+	if _, err := store.IncrementTokenVersion(ctx, "unknown"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	store.CloseStorage(ctx)
+
+	// The bumped version survives a restart
+	store, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Expected no error reopening storage, got %v", err)
+	}
+	defer store.CloseStorage(ctx)
+	if version, err := store.GetTokenVersion(ctx, "user123"); err != nil || version != 1 {
+		t.Errorf("Expected persisted version 1, got %d, %v", version, err)
+	}
+	if user, err := store.GetUserByLogin(ctx, "testuser"); err != nil || user.Password != "hashedpassword" {
+		t.Errorf("Expected user to be loaded once, got %+v, %v", user, err)
+	}
+}
+
+func TestFileStorage_GlobalNotBeforeSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	store, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ctx := context.Background()
+	notBefore := time.Now().Truncate(time.Second)
+	record := &AuditRecord{Action: "break_glass", Actor: "admin", CreatedAt: notBefore}
+	if err := store.SetGlobalNotBefore(ctx, notBefore, record); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	store.CloseStorage(ctx)
+
+	store, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Expected no error reopening storage, got %v", err)
+	}
+	defer store.CloseStorage(ctx)
+	if got, err := store.GetGlobalNotBefore(ctx); err != nil || !got.Equal(notBefore) {
+		t.Errorf("Expected persisted cut-off %v, got %v, %v", notBefore, got, err)
+	}
+	if len(store.auditLog) != 1 || store.auditLog[0].Actor != "admin" {
+		t.Errorf("Expected persisted audit record, got %+v", store.auditLog)
+	}
+}
+
+func TestFileStorage_RevokedAccessTokensSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	store, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ctx := context.Background()
+	if err := store.RevokeAccessToken(ctx, "jti-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := store.RevokeAccessToken(ctx, "jti-expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	store.CloseStorage(ctx)
+
+	store, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Expected no error reopening storage, got %v", err)
+	}
+	defer store.CloseStorage(ctx)
+	if revoked, err := store.IsAccessTokenRevoked(ctx, "jti-1"); err != nil || !revoked {
+		t.Errorf("Expected revocation to survive a restart, got %v, %v", revoked, err)
+	}
+	if _, ok := store.revoked["jti-expired"]; ok {
+		t.Error("Expected expired entries to be dropped on load")
+	}
+}
+
+func TestFileStorage_TOTPSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	store, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ctx := context.Background()
+	if err := store.SetTOTPSecret(ctx, &TOTPSecret{UserID: "user123", Secret: []byte("sealed"), CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := store.ConfirmTOTPSecret(ctx, "user123", time.Now(), 10, []string{"code-1", "code-2"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := store.UseTOTPStep(ctx, "user123", 11); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := store.UseRecoveryCode(ctx, "user123", "code-1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := store.RecordMFAFailure(ctx, "user123"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := store.SetTOTPSecret(ctx, &TOTPSecret{UserID: "other", Secret: []byte("sealed")}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := store.DeleteTOTPSecret(ctx, "other"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	store.CloseStorage(ctx)
+
+	// An enrolled second factor must not disappear, or the password alone would sign in
+	store, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("Expected no error reopening storage, got %v", err)
+	}
+	defer store.CloseStorage(ctx)
+	secret, err := store.GetTOTPSecret(ctx, "user123")
+	if err != nil || secret.ConfirmedAt.IsZero() || string(secret.Secret) != "sealed" ||
+		secret.LastUsedStep != 11 || secret.FailedAttempts != 1 {
+		t.Fatalf("Expected persisted TOTP secret, got %+v, %v", secret, err)
+	}
+	if err := store.UseRecoveryCode(ctx, "user123", "code-1"); !errors.Is(err, ErrRecoveryCodeNotFound) {
+		t.Errorf("Expected used recovery code to stay used, got %v", err)
+	}
+	if count, _ := store.CountRecoveryCodes(ctx, "user123"); count != 1 {
+		t.Errorf("Expected 1 recovery code, got %d", count)
+	}
+	if _, err := store.GetTOTPSecret(ctx, "other"); !errors.Is(err, ErrTOTPNotFound) {
+		t.Errorf("Expected deleted TOTP secret to stay deleted, got %v", err)
+	}
+}
+
 func TestFileStorage_ProfileOperations(t *testing.T) {
 	conf := &config.Config{
 		FileStorePath: filepath.Join(t.TempDir(), "test.json"),
@@ -113,19 +255,62 @@ func TestFileStorage_ProfileOperations(t *testing.T) {
 	defer store.CloseStorage(ctx)
 
 	// Test SetUserProfile
-	err = store.SetUserProfile(ctx, "user123", "test@example.com")
+	err = store.SetUserProfile(ctx, &Profile{UserID: "user123", Email: "test@example.com"})
 	if err != nil {
 		t.Fatalf("Expected no error setting profile, got %v", err)
 	}
 
 	// Test GetUserProfile
-	email, err := store.GetUserProfile(ctx, "user123")
+	profile, err := store.GetUserProfile(ctx, "user123")
 	if err != nil {
 		t.Fatalf("Expected no error getting profile, got %v", err)
 	}
 
-	if email != "test@example.com" {
-		t.Errorf("Expected email 'test@example.com', got %s", email)
+	if profile.Email != "test@example.com" {
+		t.Errorf("Expected email 'test@example.com', got %s", profile.Email)
+	}
+
+	// Updates keep the creation time and reset the verification of a changed email
+	if err := store.MarkEmailVerified(ctx, "user123", "test@example.com", time.Now()); err != nil {
+		t.Fatalf("Expected no error verifying email, got %v", err)
+	}
+	err = store.SetUserProfile(ctx, &Profile{UserID: "user123", Email: "test@example.com", DisplayName: "Test"})
+	if err != nil {
+		t.Fatalf("Expected no error updating profile, got %v", err)
+	}
+	updated, _ := store.GetUserProfile(ctx, "user123")
+	if updated.DisplayName != "Test" || !updated.CreatedAt.Equal(profile.CreatedAt) || updated.EmailVerifiedAt.IsZero() {
+		t.Errorf("Unexpected profile after update: %+v", updated)
+	}
+	err = store.SetUserProfile(ctx, &Profile{UserID: "user123", Email: "other@example.com"})
+	if err != nil {
+		t.Fatalf("Expected no error updating profile, got %v", err)
+	}
+	if updated, _ := store.GetUserProfile(ctx, "user123"); !updated.EmailVerifiedAt.IsZero() {
+		t.Error("Expected changed email to be unverified")
+	}
+
+	// Unverified claims are not found by email and are dropped once the owner verifies it
+	if err := store.SetUserProfile(ctx, &Profile{UserID: "user456", Email: "OTHER@example.com"}); err != nil {
+		t.Fatalf("Expected no error claiming the same email, got %v", err)
+	}
+	if _, err := store.GetUserIDByEmail(ctx, "other@example.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for an unverified email, got %v", err)
+	}
+	if err := store.MarkEmailVerified(ctx, "user123", "other@example.com", time.Now()); err != nil {
+		t.Fatalf("Expected no error verifying email, got %v", err)
+	}
+	if userID, err := store.GetUserIDByEmail(ctx, "Other@Example.com"); err != nil || userID != "user123" {
+		t.Errorf("Expected user123 for the verified email, got %q err=%v", userID, err)
+	}
+	if claim, _ := store.GetUserProfile(ctx, "user456"); claim.Email != "" {
+		t.Errorf("Expected the unverified claim to be dropped, got %q", claim.Email)
+	}
+	if err := store.SetUserProfile(ctx, &Profile{UserID: "user456", Email: "other@example.com"}); err != nil {
+		t.Fatalf("Expected no error claiming the email again, got %v", err)
+	}
+	if err := store.MarkEmailVerified(ctx, "user456", "other@example.com", time.Now()); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("Expected ErrEmailTaken verifying a verified email, got %v", err)
 	}
 }
 
@@ -148,22 +333,22 @@ func TestFileStorage_RefreshTokenOperations(t *testing.T) {
 	userID := "user123"
 	expiresAt := store.(*FileStorage).refresh["test"].ExpiresAt // This will be zero time
 
-	err = store.CreateRefreshToken(ctx, token, userID, expiresAt)
+	err = store.CreateRefreshToken(ctx, &RefreshToken{TokenHash: token, UserID: userID, FamilyID: token, ExpiresAt: expiresAt})
 	if err != nil {
 		t.Fatalf("Expected no error creating refresh token, got %v", err)
 	}
 
 	// Test GetRefreshToken
-	retrievedUserID, _, revoked, err := store.GetRefreshToken(ctx, token)
+	retrieved, err := store.GetRefreshToken(ctx, token)
 	if err != nil {
 		t.Fatalf("Expected no error getting refresh token, got %v", err)
 	}
 
-	if retrievedUserID != userID {
-		t.Errorf("Expected UserID %s, got %s", userID, retrievedUserID)
+	if retrieved.UserID != userID {
+		t.Errorf("Expected UserID %s, got %s", userID, retrieved.UserID)
 	}
 
-	if revoked {
+	if retrieved.Revoked {
 		t.Error("Expected token to not be revoked")
 	}
 
@@ -173,3 +358,34 @@ func TestFileStorage_RefreshTokenOperations(t *testing.T) {
 		t.Fatalf("Expected no error revoking refresh token, got %v", err)
 	}
 }
+
+func TestFileStorage_AuthorizationCodeOperations(t *testing.T) {
+	store, err := NewFileStorage(filepath.Join(t.TempDir(), "test.json"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ctx := context.Background()
+	defer store.CloseStorage(ctx)
+
+	code := &AuthorizationCode{
+		CodeHash:  HashToken("code-123"),
+		ClientID:  "client",
+		UserID:    "user123",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+	if err := store.CreateAuthorizationCode(ctx, code); err != nil {
+		t.Fatalf("Expected no error creating code, got %v", err)
+	}
+
+	consumed, err := store.ConsumeAuthorizationCode(ctx, HashToken("code-123"))
+	if err != nil {
+		t.Fatalf("Expected no error consuming code, got %v", err)
+	}
+	if consumed.UserID != "user123" || consumed.ClientID != "client" {
+		t.Errorf("Unexpected code: %+v", consumed)
+	}
+
+	if _, err := store.ConsumeAuthorizationCode(ctx, HashToken("code-123")); err == nil {
+		t.Error("Expected error consuming code twice")
+	}
+}
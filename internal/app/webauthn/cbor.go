@@ -0,0 +1,161 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxCBORDepth bounds nesting so hostile input can not exhaust the stack
+const maxCBORDepth = 16
+
+// errCBOR is returned for malformed or unsupported CBOR
+var errCBOR = errors.New("malformed CBOR")
+
+// decodeCBOR decodes the first CBOR data item (RFC 8949) of data and returns the remaining bytes
+// Integers decode to int64, byte strings to []byte, text to string, arrays to []any and maps to map[any]any;
+// only the subset used by WebAuthn is supported (no tags, no indefinite lengths, no floats)
+func decodeCBOR(data []byte) (any, []byte, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, d.data[d.off:], nil
+}
+
+// cborDecoder reads CBOR items from a buffer
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+// decode reads one data item
+func (d *cborDecoder) decode(depth int) (any, error) {
+	if depth > maxCBORDepth {
+		return nil, fmt.Errorf("%w: nesting too deep", errCBOR)
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		b, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(b), nil
+		}
+		return b, nil
+	case 4:
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, fmt.Errorf("%w: truncated array", errCBOR)
+		}
+		items := make([]any, 0, arg)
+		for range arg {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, fmt.Errorf("%w: truncated map", errCBOR)
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("%w: unsupported map key", errCBOR)
+			}
+			if _, dup := m[k]; dup {
+				return nil, fmt.Errorf("%w: duplicate map key", errCBOR)
+			}
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case 7:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unsupported major type %d", errCBOR, major)
+}
+
+// head reads the initial byte and argument of a data item
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, fmt.Errorf("%w: unexpected end of data", errCBOR)
+	}
+	b := d.data[d.off]
+	d.off++
+	major, info := b>>5, b&0x1f
+	if major == 7 && info < 24 {
+		return major, uint64(info), nil
+	}
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(d.data)-d.off < n {
+			return 0, 0, fmt.Errorf("%w: truncated argument", errCBOR)
+		}
+		var arg uint64
+		switch n {
+		case 1:
+			arg = uint64(d.data[d.off])
+		case 2:
+			arg = uint64(binary.BigEndian.Uint16(d.data[d.off:]))
+		case 4:
+			arg = uint64(binary.BigEndian.Uint32(d.data[d.off:]))
+		case 8:
+			arg = binary.BigEndian.Uint64(d.data[d.off:])
+		}
+		d.off += n
+		if major == 7 {
+			return 0, 0, fmt.Errorf("%w: floats are not supported", errCBOR)
+		}
+		return major, arg, nil
+	}
+	return 0, 0, fmt.Errorf("%w: indefinite lengths are not supported", errCBOR)
+}
+
+// bytes reads n bytes
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, fmt.Errorf("%w: truncated string", errCBOR)
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
@@ -0,0 +1,44 @@
+package webauthn
+
+import "testing"
+
+func TestDecodeCBOR(t *testing.T) {
+	// {1: 2, "a": [-1, h'0102', true], -2: null} followed by a trailing byte
+	data := []byte{0xa3, 0x01, 0x02, 0x61, 'a', 0x83, 0x20, 0x42, 0x01, 0x02, 0xf5, 0x21, 0xf6, 0xff}
+	v, rest, err := decodeCBOR(data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(rest) != 1 || rest[0] != 0xff {
+		t.Errorf("unexpected rest %x", rest)
+	}
+	m := v.(map[any]any)
+	if m[int64(1)] != int64(2) {
+		t.Errorf("unexpected value for 1: %v", m[int64(1)])
+	}
+	arr := m["a"].([]any)
+	if arr[0] != int64(-1) || string(arr[1].([]byte)) != "\x01\x02" || arr[2] != true {
+		t.Errorf("unexpected array %v", arr)
+	}
+	if v, ok := m[int64(-2)]; !ok || v != nil {
+		t.Errorf("expected null for -2, got %v", v)
+	}
+}
+
+func TestDecodeCBOR_Malformed(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":             {},
+		"truncated string":  {0x45, 0x01},
+		"huge array":        {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"indefinite length": {0x5f},
+		"duplicate key":     {0xa2, 0x01, 0x01, 0x01, 0x02},
+		"float":             {0xfb, 0, 0, 0, 0, 0, 0, 0, 0},
+		"tag":               {0xc0, 0x01},
+		"deep nesting":      {0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x01},
+	}
+	for name, data := range tests {
+		if _, _, err := decodeCBOR(data); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
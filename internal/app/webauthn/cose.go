@@ -0,0 +1,121 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers (RFC 9053) supported for credentials and attestation statements
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// COSE key parameters (RFC 9052 section 7)
+const (
+	coseKeyType  = 1
+	coseKeyAlg   = 3
+	coseKeyCrv   = -1
+	coseKeyX     = -2
+	coseKeyY     = -3
+	coseKeyRSAN  = -1
+	coseKeyRSAE  = -2
+	coseKtyOKP   = 1
+	coseKtyEC2   = 2
+	coseKtyRSA   = 3
+	coseCrvP256  = 1
+	coseCrvEd255 = 6
+)
+
+// minRSABits is the smallest RSA modulus accepted for RS256 keys
+const minRSABits = 2048
+
+// errUnsupportedKey is returned for COSE keys that can not be used
+var errUnsupportedKey = errors.New("unsupported credential public key")
+
+// parsePublicKey decodes a COSE_Key and returns its algorithm and public key
+// Only ES256 (P-256), EdDSA (Ed25519) and RS256 keys are supported
+func parsePublicKey(coseKey []byte) (int64, crypto.PublicKey, error) {
+	v, rest, err := decodeCBOR(coseKey)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(rest) != 0 {
+		return 0, nil, fmt.Errorf("%w: trailing data", errUnsupportedKey)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: not a map", errUnsupportedKey)
+	}
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseKeyAlg)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseKeyCrv)].(int64)
+		x, _ := m[int64(coseKeyX)].([]byte)
+		y, _ := m[int64(coseKeyY)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, fmt.Errorf("%w: invalid EC2 key", errUnsupportedKey)
+		}
+		// Validate the point through the uncompressed SEC 1 encoding
+		raw := append(append([]byte{0x04}, x...), y...)
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), raw)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", errUnsupportedKey, err)
+		}
+		return alg, pub, nil
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseKeyCrv)].(int64)
+		x, _ := m[int64(coseKeyX)].([]byte)
+		if crv != coseCrvEd255 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, fmt.Errorf("%w: invalid OKP key", errUnsupportedKey)
+		}
+		return alg, ed25519.PublicKey(x), nil
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseKeyRSAN)].([]byte)
+		e, _ := m[int64(coseKeyRSAE)].([]byte)
+		if len(e) == 0 || len(e) > 4 {
+			return 0, nil, fmt.Errorf("%w: invalid RSA exponent", errUnsupportedKey)
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSABits || pub.E < 3 || pub.E%2 == 0 {
+			return 0, nil, fmt.Errorf("%w: weak RSA key", errUnsupportedKey)
+		}
+		return alg, pub, nil
+	}
+	return 0, nil, fmt.Errorf("%w: kty %d, alg %d", errUnsupportedKey, kty, alg)
+}
+
+// verifySignature checks a signature over data made with the given COSE algorithm
+func verifySignature(alg int64, pub crypto.PublicKey, data, sig []byte) error {
+	ok := false
+	switch alg {
+	case AlgES256:
+		if key, isECDSA := pub.(*ecdsa.PublicKey); isECDSA {
+			digest := sha256.Sum256(data)
+			ok = ecdsa.VerifyASN1(key, digest[:], sig)
+		}
+	case AlgEdDSA:
+		if key, isEd25519 := pub.(ed25519.PublicKey); isEd25519 {
+			ok = ed25519.Verify(key, data, sig)
+		}
+	case AlgRS256:
+		if key, isRSA := pub.(*rsa.PublicKey); isRSA {
+			digest := sha256.Sum256(data)
+			ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+		}
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,516 @@
+// Package webauthn implements the relying party side of Web Authentication (W3C WebAuthn Level 2)
+// It covers the registration and authentication ceremonies for ES256, EdDSA and RS256 credentials
+// with "none" and "packed" attestation; storing credentials and challenges is left to the caller
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Authenticator data flags
+const (
+	FlagUserPresent            byte = 0x01
+	FlagUserVerified           byte = 0x04
+	FlagBackupEligible         byte = 0x08
+	FlagBackupState            byte = 0x10
+	FlagAttestedCredentialData byte = 0x40
+	FlagExtensionData          byte = 0x80
+)
+
+// Attestation types reported for registered credentials
+const (
+	AttestationNone  = "none"
+	AttestationSelf  = "self"
+	AttestationBasic = "basic"
+)
+
+// Ceremony timeout suggested to the client, in milliseconds
+const defaultTimeout = 5 * 60 * 1000
+
+// maxCredentialIDLength is the largest credential ID the specification allows
+const maxCredentialIDLength = 1023
+
+// ErrVerification is wrapped by every error caused by an invalid client response
+var ErrVerification = errors.New("webauthn verification failed")
+
+// idFIDOGenCeAAGUID is the certificate extension carrying the authenticator AAGUID
+var idFIDOGenCeAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// Bytes is binary data encoded as unpadded base64url in JSON, as used throughout WebAuthn
+type Bytes []byte
+
+// MarshalJSON encodes the bytes as base64url
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes base64url, with or without padding
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// RelyingParty verifies ceremonies for one RP ID
+type RelyingParty struct {
+	id      string
+	name    string
+	origins []string
+	idHash  [32]byte
+}
+
+// NewRelyingParty is the constructor for RelyingParty
+// id is the RP ID (a registrable domain such as "example.com"); origins lists the accepted client origins
+func NewRelyingParty(id, name string, origins []string) *RelyingParty {
+	return &RelyingParty{
+		id:      id,
+		name:    name,
+		origins: origins,
+		idHash:  sha256.Sum256([]byte(id)),
+	}
+}
+
+// ID returns the RP ID
+func (rp *RelyingParty) ID() string {
+	return rp.id
+}
+
+// UserEntity describes the account a credential is created for
+type UserEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialDescriptor identifies an existing credential
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         Bytes    `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// CredentialParameter is an acceptable credential algorithm
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// AuthenticatorSelection states requirements on the authenticator
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// RelyingPartyEntity describes the relying party
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// CreationOptions is the JSON form of PublicKeyCredentialCreationOptions
+// Clients pass it to navigator.credentials.create({publicKey: ...}) after decoding the binary fields
+type CreationOptions struct {
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              Bytes                  `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is the JSON form of PublicKeyCredentialRequestOptions
+// Clients pass it to navigator.credentials.get({publicKey: ...}) after decoding the binary fields
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions builds the options for a registration ceremony
+// exclude lists credentials the user already has, so the same authenticator is not registered twice
+// Discoverable credentials (passkeys) and user verification are required, so the credential alone can log the user in
+func (rp *RelyingParty) CreationOptions(challenge []byte, user UserEntity, exclude [][]byte) *CreationOptions {
+	opts := &CreationOptions{
+		RP:        RelyingPartyEntity{ID: rp.id, Name: rp.name},
+		User:      user,
+		Challenge: challenge,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:            defaultTimeout,
+		ExcludeCredentials: []CredentialDescriptor{},
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}
+	for _, id := range exclude {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+	return opts
+}
+
+// RequestOptions builds the options for an authentication ceremony
+// An empty allow list lets the authenticator offer any discoverable credential for the RP ID
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow [][]byte) *RequestOptions {
+	opts := &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          defaultTimeout,
+		RPID:             rp.id,
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "required",
+	}
+	for _, id := range allow {
+		opts.AllowCredentials = append(opts.AllowCredentials, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+	return opts
+}
+
+// AttestationResponse is the JSON form of a PublicKeyCredential returned by navigator.credentials.create
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes    `json:"clientDataJSON"`
+		AttestationObject Bytes    `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of a PublicKeyCredential returned by navigator.credentials.get
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AuthenticatorData Bytes `json:"authenticatorData"`
+		Signature         Bytes `json:"signature"`
+		UserHandle        Bytes `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// clientData is the CollectedClientData signed by the authenticator
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ChallengeFromClientData returns the challenge a client response answers
+// Callers use it to look up the stored ceremony before verifying the response
+func ChallengeFromClientData(clientDataJSON []byte) ([]byte, error) {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return nil, fmt.Errorf("%w: invalid client data: %v", ErrVerification, err)
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(cd.Challenge)
+	if err != nil || len(challenge) == 0 {
+		return nil, fmt.Errorf("%w: invalid challenge", ErrVerification)
+	}
+	return challenge, nil
+}
+
+// verifyClientData checks the ceremony type, challenge and origin of the client data
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return fmt.Errorf("%w: invalid client data: %v", ErrVerification, err)
+	}
+	if cd.Type != ceremony {
+		return fmt.Errorf("%w: unexpected client data type %q", ErrVerification, cd.Type)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrVerification)
+	}
+	if !slices.Contains(rp.origins, cd.Origin) {
+		return fmt.Errorf("%w: unexpected origin %q", ErrVerification, cd.Origin)
+	}
+	if cd.CrossOrigin {
+		return fmt.Errorf("%w: cross-origin ceremonies are not allowed", ErrVerification)
+	}
+	return nil
+}
+
+// authenticatorData is the parsed authenticator data structure
+type authenticatorData struct {
+	raw          []byte
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData decodes authenticator data including attested credential data when present
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrVerification)
+	}
+	ad := &authenticatorData{
+		raw:       data,
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+	if ad.flags&FlagAttestedCredentialData != 0 {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data too short", ErrVerification)
+		}
+		ad.aaguid = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > maxCredentialIDLength || len(rest) < idLen {
+			return nil, fmt.Errorf("%w: invalid credential ID", ErrVerification)
+		}
+		ad.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+		// The public key is a CBOR item of unknown length; decode it to find where it ends
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid credential public key: %v", ErrVerification, err)
+		}
+		ad.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+	if ad.flags&FlagExtensionData != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid extension data: %v", ErrVerification, err)
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing authenticator data", ErrVerification)
+	}
+	return ad, nil
+}
+
+// verifyAuthenticatorData checks the RP ID hash and the user presence and verification flags
+func (rp *RelyingParty) verifyAuthenticatorData(ad *authenticatorData, requireUserVerification bool) error {
+	if subtle.ConstantTimeCompare(ad.rpIDHash, rp.idHash[:]) != 1 {
+		return fmt.Errorf("%w: RP ID mismatch", ErrVerification)
+	}
+	if ad.flags&FlagUserPresent == 0 {
+		return fmt.Errorf("%w: user not present", ErrVerification)
+	}
+	if requireUserVerification && ad.flags&FlagUserVerified == 0 {
+		return fmt.Errorf("%w: user not verified", ErrVerification)
+	}
+	if ad.flags&FlagBackupEligible == 0 && ad.flags&FlagBackupState != 0 {
+		return fmt.Errorf("%w: invalid backup flags", ErrVerification)
+	}
+	return nil
+}
+
+// Credential is a credential created by a successful registration ceremony
+type Credential struct {
+	ID []byte
+
+	// PublicKey is the COSE_Key as sent by the authenticator; store it as is
+	PublicKey []byte
+
+	SignCount       uint32
+	AAGUID          []byte
+	AttestationType string
+	UserVerified    bool
+	BackupEligible  bool
+}
+
+// VerifyRegistration verifies the response to a registration ceremony started with challenge
+// Packed attestation certificates are checked for their format but not chained to a trust anchor,
+// since no attestation metadata is configured; the attestation type is reported for the caller to decide
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte, requireUserVerification bool) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	v, rest, err := decodeCBOR(attestationObject)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("%w: invalid attestation object", ErrVerification)
+	}
+	obj, _ := v.(map[any]any)
+	format, _ := obj["fmt"].(string)
+	attStmt, okStmt := obj["attStmt"].(map[any]any)
+	rawAuthData, okData := obj["authData"].([]byte)
+	if !okStmt || !okData {
+		return nil, fmt.Errorf("%w: invalid attestation object", ErrVerification)
+	}
+
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(ad, requireUserVerification); err != nil {
+		return nil, err
+	}
+	if ad.flags&FlagAttestedCredentialData == 0 {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrVerification)
+	}
+	alg, pub, err := parsePublicKey(ad.publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(bytes.Clone(rawAuthData), clientDataHash[:]...)
+
+	var attestationType string
+	switch format {
+	case "none":
+		if len(attStmt) != 0 {
+			return nil, fmt.Errorf("%w: unexpected attestation statement", ErrVerification)
+		}
+		attestationType = AttestationNone
+	case "packed":
+		attestationType, err = verifyPacked(attStmt, signed, alg, pub, ad.aaguid)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported attestation format %q", ErrVerification, format)
+	}
+
+	return &Credential{
+		ID:              bytes.Clone(ad.credentialID),
+		PublicKey:       bytes.Clone(ad.publicKey),
+		SignCount:       ad.signCount,
+		AAGUID:          bytes.Clone(ad.aaguid),
+		AttestationType: attestationType,
+		UserVerified:    ad.flags&FlagUserVerified != 0,
+		BackupEligible:  ad.flags&FlagBackupEligible != 0,
+	}, nil
+}
+
+// verifyPacked verifies a "packed" attestation statement (WebAuthn section 8.2)
+func verifyPacked(attStmt map[any]any, signed []byte, credAlg int64, credPub any, aaguid []byte) (string, error) {
+	alg, okAlg := attStmt["alg"].(int64)
+	sig, okSig := attStmt["sig"].([]byte)
+	if !okAlg || !okSig {
+		return "", fmt.Errorf("%w: invalid packed attestation statement", ErrVerification)
+	}
+
+	x5c, hasX5C := attStmt["x5c"].([]any)
+	if !hasX5C {
+		// Self attestation is signed with the credential key itself
+		if alg != credAlg {
+			return "", fmt.Errorf("%w: attestation algorithm does not match the credential", ErrVerification)
+		}
+		if err := verifySignature(alg, credPub, signed, sig); err != nil {
+			return "", fmt.Errorf("%w: attestation: %v", ErrVerification, err)
+		}
+		return AttestationSelf, nil
+	}
+
+	if len(x5c) == 0 {
+		return "", fmt.Errorf("%w: empty attestation certificate chain", ErrVerification)
+	}
+	der, _ := x5c[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid attestation certificate: %v", ErrVerification, err)
+	}
+	if err := verifySignature(alg, cert.PublicKey, signed, sig); err != nil {
+		return "", fmt.Errorf("%w: attestation: %v", ErrVerification, err)
+	}
+	if err := checkPackedCertificate(cert, aaguid); err != nil {
+		return "", err
+	}
+	return AttestationBasic, nil
+}
+
+// checkPackedCertificate enforces the packed attestation certificate requirements (WebAuthn section 8.2.1)
+func checkPackedCertificate(cert *x509.Certificate, aaguid []byte) error {
+	if cert.Version != 3 {
+		return fmt.Errorf("%w: attestation certificate must be X.509 v3", ErrVerification)
+	}
+	subject := cert.Subject
+	if len(subject.Country) == 0 || len(subject.Organization) == 0 || subject.CommonName == "" ||
+		!slices.Equal(subject.OrganizationalUnit, []string{"Authenticator Attestation"}) {
+		return fmt.Errorf("%w: invalid attestation certificate subject", ErrVerification)
+	}
+	if !cert.BasicConstraintsValid || cert.IsCA {
+		return fmt.Errorf("%w: attestation certificate must not be a CA", ErrVerification)
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(idFIDOGenCeAAGUID) {
+			continue
+		}
+		if ext.Critical {
+			return fmt.Errorf("%w: AAGUID extension must not be critical", ErrVerification)
+		}
+		var value []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &value); err != nil || len(rest) != 0 || !bytes.Equal(value, aaguid) {
+			return fmt.Errorf("%w: AAGUID mismatch", ErrVerification)
+		}
+	}
+	return nil
+}
+
+// Assertion is the result of a successful authentication ceremony
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+	BackupState  bool
+}
+
+// VerifyAssertion verifies the response to an authentication ceremony started with challenge
+// publicKey is the stored COSE_Key of the credential; checking the signature counter is left to the caller
+func (rp *RelyingParty) VerifyAssertion(challenge, publicKey, clientDataJSON, rawAuthData, signature []byte, requireUserVerification bool) (*Assertion, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(ad, requireUserVerification); err != nil {
+		return nil, err
+	}
+	alg, pub, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(bytes.Clone(rawAuthData), clientDataHash[:]...)
+	if err := verifySignature(alg, pub, signed, signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+	return &Assertion{
+		SignCount:    ad.signCount,
+		UserVerified: ad.flags&FlagUserVerified != 0,
+		BackupState:  ad.flags&FlagBackupState != 0,
+	}, nil
+}
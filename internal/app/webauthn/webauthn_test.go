@@ -0,0 +1,153 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vitalykrupin/auth-service/internal/app/webauthn"
+	"github.com/vitalykrupin/auth-service/internal/app/webauthn/webauthntest"
+)
+
+const origin = "https://example.com"
+
+func newRP() *webauthn.RelyingParty {
+	return webauthn.NewRelyingParty("example.com", "Example", []string{origin})
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	rp := newRP()
+	for _, format := range []string{webauthntest.FormatNone, webauthntest.FormatPacked, webauthntest.FormatPackedX5C} {
+		t.Run(format, func(t *testing.T) {
+			authenticator := webauthntest.New()
+			authenticator.Format = format
+
+			challenge := []byte("registration-challenge")
+			opts := rp.CreationOptions(challenge, webauthn.UserEntity{ID: []byte("user-1"), Name: "user"}, nil)
+			resp, err := authenticator.Create(origin, opts)
+			if err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			got, err := webauthn.ChallengeFromClientData(resp.Response.ClientDataJSON)
+			if err != nil || string(got) != string(challenge) {
+				t.Fatalf("unexpected challenge %q: %v", got, err)
+			}
+			cred, err := rp.VerifyRegistration(challenge, resp.Response.ClientDataJSON, resp.Response.AttestationObject, true)
+			if err != nil {
+				t.Fatalf("verify registration: %v", err)
+			}
+			if string(cred.ID) != string(authenticator.CredentialID()) || !cred.UserVerified {
+				t.Errorf("unexpected credential: %+v", cred)
+			}
+			wantType := map[string]string{
+				webauthntest.FormatNone:      webauthn.AttestationNone,
+				webauthntest.FormatPacked:    webauthn.AttestationSelf,
+				webauthntest.FormatPackedX5C: webauthn.AttestationBasic,
+			}[format]
+			if cred.AttestationType != wantType {
+				t.Errorf("expected attestation %q, got %q", wantType, cred.AttestationType)
+			}
+
+			challenge = []byte("login-challenge")
+			assertion, err := authenticator.Get(origin, rp.RequestOptions(challenge, nil))
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			res, err := rp.VerifyAssertion(challenge, cred.PublicKey, assertion.Response.ClientDataJSON,
+				assertion.Response.AuthenticatorData, assertion.Response.Signature, true)
+			if err != nil {
+				t.Fatalf("verify assertion: %v", err)
+			}
+			if res.SignCount != 1 || !res.UserVerified {
+				t.Errorf("unexpected assertion: %+v", res)
+			}
+			if string(assertion.Response.UserHandle) != "user-1" {
+				t.Errorf("unexpected user handle %q", assertion.Response.UserHandle)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistration_Rejects(t *testing.T) {
+	challenge := []byte("challenge")
+	user := webauthn.UserEntity{ID: []byte("user-1"), Name: "user"}
+	tests := []struct {
+		name   string
+		rp     *webauthn.RelyingParty
+		origin string
+		flags  byte
+		verify []byte
+	}{
+		{name: "wrong challenge", rp: newRP(), origin: origin, verify: []byte("other")},
+		{name: "wrong origin", rp: newRP(), origin: "https://evil.example"},
+		{name: "wrong RP ID", rp: webauthn.NewRelyingParty("other.example", "Other", []string{origin}), origin: origin},
+		{name: "user not verified", rp: newRP(), origin: origin, flags: webauthn.FlagUserPresent},
+		{name: "user not present", rp: newRP(), origin: origin, flags: webauthn.FlagUserVerified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := webauthntest.New()
+			if tt.flags != 0 {
+				authenticator.Flags = tt.flags
+			}
+			opts := newRP().CreationOptions(challenge, user, nil)
+			resp, err := authenticator.Create(tt.origin, opts)
+			if err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			verify := challenge
+			if tt.verify != nil {
+				verify = tt.verify
+			}
+			_, err = tt.rp.VerifyRegistration(verify, resp.Response.ClientDataJSON, resp.Response.AttestationObject, true)
+			if !errors.Is(err, webauthn.ErrVerification) {
+				t.Errorf("expected verification error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion_Rejects(t *testing.T) {
+	rp := newRP()
+	authenticator := webauthntest.New()
+	opts := rp.CreationOptions([]byte("challenge"), webauthn.UserEntity{ID: []byte("user-1"), Name: "user"}, nil)
+	resp, err := authenticator.Create(origin, opts)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	cred, err := rp.VerifyRegistration([]byte("challenge"), resp.Response.ClientDataJSON, resp.Response.AttestationObject, true)
+	if err != nil {
+		t.Fatalf("verify registration: %v", err)
+	}
+
+	challenge := []byte("login")
+	assertion, err := authenticator.Get(origin, rp.RequestOptions(challenge, nil))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	r := assertion.Response
+
+	// A registration response can not be replayed as an assertion
+	if _, err := rp.VerifyAssertion([]byte("challenge"), cred.PublicKey, resp.Response.ClientDataJSON, r.AuthenticatorData, r.Signature, true); !errors.Is(err, webauthn.ErrVerification) {
+		t.Errorf("expected type mismatch, got %v", err)
+	}
+
+	tampered := append([]byte(nil), r.AuthenticatorData...)
+	tampered[36]++
+	if _, err := rp.VerifyAssertion(challenge, cred.PublicKey, r.ClientDataJSON, tampered, r.Signature, true); !errors.Is(err, webauthn.ErrVerification) {
+		t.Errorf("expected signature error, got %v", err)
+	}
+
+	other := webauthntest.New()
+	otherResp, _ := other.Create(origin, opts)
+	otherCred, err := rp.VerifyRegistration([]byte("challenge"), otherResp.Response.ClientDataJSON, otherResp.Response.AttestationObject, true)
+	if err != nil {
+		t.Fatalf("verify registration: %v", err)
+	}
+	if _, err := rp.VerifyAssertion(challenge, otherCred.PublicKey, r.ClientDataJSON, r.AuthenticatorData, r.Signature, true); !errors.Is(err, webauthn.ErrVerification) {
+		t.Errorf("expected signature error for another key, got %v", err)
+	}
+
+	if _, err := rp.VerifyAssertion(challenge, cred.PublicKey, r.ClientDataJSON, r.AuthenticatorData, r.Signature, true); err != nil {
+		t.Errorf("expected valid assertion, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package webauthntest
+
+import "encoding/binary"
+
+// cborMap is a CBOR map whose entries are encoded in order
+type cborMap []cborPair
+
+// cborPair is a map entry
+type cborPair struct {
+	key   any
+	value any
+}
+
+// encode encodes v as CBOR; only the types produced by this package are supported
+func encode(v any) []byte {
+	switch v := v.(type) {
+	case int:
+		return encodeInt(int64(v))
+	case int64:
+		return encodeInt(v)
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []any:
+		out := head(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encode(item)...)
+		}
+		return out
+	case cborMap:
+		out := head(5, uint64(len(v)))
+		for _, p := range v {
+			out = append(out, encode(p.key)...)
+			out = append(out, encode(p.value)...)
+		}
+		return out
+	}
+	panic("webauthntest: unsupported CBOR value")
+}
+
+// encodeInt encodes a signed integer
+func encodeInt(n int64) []byte {
+	if n < 0 {
+		return head(1, uint64(-1-n))
+	}
+	return head(0, uint64(n))
+}
+
+// head encodes the initial byte and argument of a data item
+func head(major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return []byte{major | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major | 26}, uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major | 27}, arg)
+}
@@ -0,0 +1,207 @@
+// Package webauthntest provides a software authenticator for testing WebAuthn relying parties
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/vitalykrupin/auth-service/internal/app/webauthn"
+)
+
+// Attestation formats the authenticator can produce
+const (
+	FormatNone      = "none"
+	FormatPacked    = "packed"
+	FormatPackedX5C = "packed-x5c"
+)
+
+// Authenticator is an ES256 software authenticator holding a single discoverable credential
+type Authenticator struct {
+	// Format selects the attestation statement returned by Create; defaults to FormatNone
+	Format string
+
+	// AAGUID identifies the authenticator model in attested credential data
+	AAGUID []byte
+
+	// Flags are set in the authenticator data; defaults to user present and user verified
+	Flags byte
+
+	// SignCount is incremented before every assertion
+	SignCount uint32
+
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+}
+
+// New is the constructor for Authenticator
+func New() *Authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	credentialID := make([]byte, 16)
+	aaguid := make([]byte, 16)
+	rand.Read(credentialID)
+	rand.Read(aaguid)
+	return &Authenticator{
+		Format:       FormatNone,
+		AAGUID:       aaguid,
+		Flags:        webauthn.FlagUserPresent | webauthn.FlagUserVerified,
+		key:          key,
+		credentialID: credentialID,
+	}
+}
+
+// CredentialID returns the ID of the authenticator's credential
+func (a *Authenticator) CredentialID() []byte {
+	return a.credentialID
+}
+
+// Create answers a registration ceremony as a browser on origin would
+func (a *Authenticator) Create(origin string, opts *webauthn.CreationOptions) (*webauthn.AttestationResponse, error) {
+	clientDataJSON := clientData("webauthn.create", opts.Challenge, origin)
+	a.userHandle = opts.User.ID
+
+	x := a.key.PublicKey.X.FillBytes(make([]byte, 32))
+	y := a.key.PublicKey.Y.FillBytes(make([]byte, 32))
+	coseKey := encode(cborMap{{1, 2}, {3, webauthn.AlgES256}, {-1, 1}, {-2, x}, {-3, y}})
+
+	authData := a.authData(opts.RP.ID, webauthn.FlagAttestedCredentialData)
+	authData = append(authData, a.AAGUID...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, coseKey...)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	var format string
+	var attStmt cborMap
+	switch a.Format {
+	case "", FormatNone:
+		format = "none"
+	case FormatPacked:
+		sig, err := sign(a.key, signed)
+		if err != nil {
+			return nil, err
+		}
+		format = "packed"
+		attStmt = cborMap{{"alg", webauthn.AlgES256}, {"sig", sig}}
+	case FormatPackedX5C:
+		attKey, cert, err := attestationCertificate(a.AAGUID)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := sign(attKey, signed)
+		if err != nil {
+			return nil, err
+		}
+		format = "packed"
+		attStmt = cborMap{{"alg", webauthn.AlgES256}, {"sig", sig}, {"x5c", []any{cert}}}
+	default:
+		return nil, errors.New("unknown attestation format")
+	}
+
+	resp := &webauthn.AttestationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		RawID: a.credentialID,
+		Type:  "public-key",
+	}
+	resp.Response.ClientDataJSON = clientDataJSON
+	resp.Response.AttestationObject = encode(cborMap{{"fmt", format}, {"attStmt", attStmt}, {"authData", authData}})
+	resp.Response.Transports = []string{"internal"}
+	return resp, nil
+}
+
+// Get answers an authentication ceremony as a browser on origin would
+func (a *Authenticator) Get(origin string, opts *webauthn.RequestOptions) (*webauthn.AssertionResponse, error) {
+	clientDataJSON := clientData("webauthn.get", opts.Challenge, origin)
+	a.SignCount++
+	authData := a.authData(opts.RPID, 0)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	sig, err := sign(a.key, append(append([]byte(nil), authData...), clientDataHash[:]...))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &webauthn.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		RawID: a.credentialID,
+		Type:  "public-key",
+	}
+	resp.Response.ClientDataJSON = clientDataJSON
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = sig
+	resp.Response.UserHandle = a.userHandle
+	return resp, nil
+}
+
+// authData returns the fixed part of the authenticator data
+func (a *Authenticator) authData(rpID string, extraFlags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, a.Flags|extraFlags)
+	return binary.BigEndian.AppendUint32(data, a.SignCount)
+}
+
+// clientData returns the CollectedClientData JSON a browser would produce
+func clientData(typ string, challenge []byte, origin string) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+// sign creates an ES256 signature
+func sign(key *ecdsa.PrivateKey, data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	return ecdsa.SignASN1(rand.Reader, key, digest[:])
+}
+
+// attestationCertificate creates a self-signed packed attestation certificate for aaguid
+func attestationCertificate(aaguid []byte) (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	aaguidExt, err := asn1.Marshal(aaguid)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Example Authenticators"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Example Attestation",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}, Value: aaguidExt},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, der, nil
+}
@@ -0,0 +1,5 @@
+-- Drop OAuth 2.0 authorization codes
+
+DROP INDEX IF EXISTS idx_authorization_codes_expires_at;
+
+DROP TABLE IF EXISTS authorization_codes;
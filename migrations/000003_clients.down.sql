@@ -0,0 +1,3 @@
+-- Drop registered OAuth 2.0 clients
+
+DROP TABLE IF EXISTS clients;
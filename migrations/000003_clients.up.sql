@@ -0,0 +1,12 @@
+-- Registered OAuth 2.0 clients
+
+CREATE TABLE IF NOT EXISTS clients (
+    client_id VARCHAR(255) PRIMARY KEY,
+    secret_hash VARCHAR(255) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+    grant_types TEXT[] NOT NULL DEFAULT '{}',
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
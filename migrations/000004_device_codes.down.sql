@@ -0,0 +1,5 @@
+-- Drop OAuth 2.0 device authorizations
+
+DROP INDEX IF EXISTS idx_device_codes_expires_at;
+
+DROP TABLE IF EXISTS device_codes;
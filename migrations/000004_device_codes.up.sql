@@ -0,0 +1,15 @@
+-- OAuth 2.0 device authorizations (device codes stored as SHA-256 digests)
+
+CREATE TABLE IF NOT EXISTS device_codes (
+    device_code_hash VARCHAR(64) PRIMARY KEY,
+    user_code VARCHAR(16) NOT NULL UNIQUE,
+    client_id VARCHAR(255) NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    user_id VARCHAR(255) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    interval_seconds INTEGER NOT NULL,
+    last_polled_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_codes_expires_at ON device_codes (expires_at);
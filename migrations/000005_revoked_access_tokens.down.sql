@@ -0,0 +1,5 @@
+-- Drop the access token denylist
+
+DROP INDEX IF EXISTS idx_revoked_access_tokens_expires_at;
+
+DROP TABLE IF EXISTS revoked_access_tokens;
@@ -0,0 +1,8 @@
+-- Denylist of revoked access token IDs (jti), kept until the token would have expired
+
+CREATE TABLE IF NOT EXISTS revoked_access_tokens (
+    jti VARCHAR(255) PRIMARY KEY,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_access_tokens_expires_at ON revoked_access_tokens (expires_at);
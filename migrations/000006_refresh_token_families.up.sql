@@ -0,0 +1,10 @@
+-- Refresh token families: every token rotated from the same login shares a family_id
+-- Existing tokens each start their own family
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id VARCHAR(255);
+
+UPDATE refresh_tokens SET family_id = token WHERE family_id IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
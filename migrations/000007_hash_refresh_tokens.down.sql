@@ -0,0 +1,6 @@
+-- Restore the plaintext column name
+-- Digests can not be reversed: all existing refresh tokens are dropped
+
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
@@ -0,0 +1,11 @@
+-- Store refresh tokens as SHA-256 digests (hex) instead of plaintext
+-- Existing tokens are hashed in place, so tokens already handed out keep working
+-- Requires PostgreSQL 11+ for sha256()
+
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+
+UPDATE refresh_tokens SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
+
+-- Migration 000006 started every existing token's family with the plaintext token itself;
+-- family IDs are digested the same way so they keep grouping the tokens but no longer reveal them
+UPDATE refresh_tokens SET family_id = encode(sha256(convert_to(family_id, 'UTF8')), 'hex');
@@ -0,0 +1,7 @@
+-- Drop refresh token session metadata
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS device_label;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS ip;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,3 @@
+-- Drop access token version
+
+ALTER TABLE users DROP COLUMN IF EXISTS token_version;
@@ -0,0 +1,3 @@
+-- Access token version: tokens carrying an older version than the user's are rejected
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0;
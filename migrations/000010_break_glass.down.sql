@@ -0,0 +1,7 @@
+-- Drop the global token cut-off and the audit log
+
+DROP INDEX IF EXISTS idx_refresh_tokens_created_at;
+
+DROP TABLE IF EXISTS audit_log;
+
+DROP TABLE IF EXISTS token_not_before;
@@ -0,0 +1,16 @@
+-- Global "not before" cut-off for tokens (break glass) and the audit log
+
+CREATE TABLE IF NOT EXISTS token_not_before (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    not_before TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    action VARCHAR(64) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_created_at ON refresh_tokens (created_at);
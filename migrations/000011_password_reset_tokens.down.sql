@@ -0,0 +1,3 @@
+-- Drop password reset tokens
+
+DROP TABLE IF EXISTS password_reset_tokens;
@@ -0,0 +1,9 @@
+-- Single-use password reset tokens, stored as SHA-256 digests
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_expires_at ON password_reset_tokens (expires_at);
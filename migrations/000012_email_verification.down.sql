@@ -0,0 +1,5 @@
+-- Drop email verification
+
+DROP TABLE IF EXISTS email_verification_tokens;
+
+ALTER TABLE profiles DROP COLUMN IF EXISTS email_verified_at;
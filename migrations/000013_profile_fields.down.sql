@@ -0,0 +1,8 @@
+-- Drop editable profile fields
+
+ALTER TABLE profiles
+    DROP COLUMN IF EXISTS display_name,
+    DROP COLUMN IF EXISTS locale,
+    DROP COLUMN IF EXISTS timezone,
+    DROP COLUMN IF EXISTS avatar_url,
+    DROP COLUMN IF EXISTS updated_at;
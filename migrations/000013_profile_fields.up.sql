@@ -0,0 +1,8 @@
+-- Editable profile fields and modification time
+
+ALTER TABLE profiles
+    ADD COLUMN IF NOT EXISTS display_name VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS locale VARCHAR(35),
+    ADD COLUMN IF NOT EXISTS timezone VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS avatar_url VARCHAR(2048),
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
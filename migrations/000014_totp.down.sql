@@ -0,0 +1,5 @@
+-- Drop TOTP second factor
+
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS totp_secrets;
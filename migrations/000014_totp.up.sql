@@ -0,0 +1,24 @@
+-- TOTP second factor: encrypted shared secrets, hashed recovery codes and pending login challenges
+
+CREATE TABLE IF NOT EXISTS totp_secrets (
+    user_id VARCHAR(255) PRIMARY KEY,
+    secret BYTEA NOT NULL,
+    confirmed_at TIMESTAMP WITH TIME ZONE,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id VARCHAR(255) NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_expires_at ON mfa_challenges (expires_at);
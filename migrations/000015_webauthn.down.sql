@@ -0,0 +1,4 @@
+-- Drop WebAuthn credentials and ceremonies
+
+DROP TABLE IF EXISTS webauthn_challenges;
+DROP TABLE IF EXISTS webauthn_credentials;
@@ -0,0 +1,24 @@
+-- WebAuthn: registered credentials (passkeys) and pending ceremonies
+
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    credential_id VARCHAR(1400) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    aaguid VARCHAR(36) NOT NULL DEFAULT '',
+    attestation_type VARCHAR(16) NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials (user_id);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    challenge_hash VARCHAR(64) PRIMARY KEY,
+    ceremony VARCHAR(16) NOT NULL,
+    user_id VARCHAR(255) NOT NULL DEFAULT '',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_challenges_expires_at ON webauthn_challenges (expires_at);
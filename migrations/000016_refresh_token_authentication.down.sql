@@ -0,0 +1,4 @@
+-- Drop session authentication details
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS amr;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS auth_time;
@@ -0,0 +1,5 @@
+-- How and when the user authenticated to start a session; carried over on rotation
+-- amr is a space separated list of RFC 8176 method references
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS auth_time TIMESTAMP WITH TIME ZONE;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS amr TEXT NOT NULL DEFAULT '';
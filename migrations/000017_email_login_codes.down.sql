@@ -0,0 +1,6 @@
+-- Drop email login codes; passwordless accounts keep an empty password and can not sign in with one
+
+ALTER TABLE mfa_challenges DROP COLUMN IF EXISTS amr;
+DROP TABLE IF EXISTS email_login_codes;
+UPDATE users SET password = '' WHERE password IS NULL;
+ALTER TABLE users ALTER COLUMN password SET NOT NULL;
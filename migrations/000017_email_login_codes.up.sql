@@ -0,0 +1,17 @@
+-- Passwordless login by emailed one-time codes; accounts may have no password
+
+ALTER TABLE users ALTER COLUMN password DROP NOT NULL;
+
+CREATE TABLE IF NOT EXISTS email_login_codes (
+    user_id VARCHAR(255) PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_login_codes_expires_at ON email_login_codes (expires_at);
+
+-- The first factor of a pending MFA login, e.g. "pwd" or "otp" for an email code
+ALTER TABLE mfa_challenges ADD COLUMN IF NOT EXISTS amr TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Drop login links
+
+DROP TABLE IF EXISTS login_links;
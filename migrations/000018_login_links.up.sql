@@ -0,0 +1,13 @@
+-- Single-use login links bound to the browser that asked for them
+
+CREATE TABLE IF NOT EXISTS login_links (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    nonce_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_links_user_id_created_at ON login_links (user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_links_expires_at ON login_links (expires_at);
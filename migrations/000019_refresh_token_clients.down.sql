@@ -0,0 +1,4 @@
+-- Drop the client binding of refresh tokens
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS scope;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS client_id;
@@ -0,0 +1,10 @@
+-- The OAuth client a refresh token was issued to and the scope it was granted; empty for first-party logins
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS client_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
+
+-- Sessions started through OAuth were labelled with the client ID and have no auth_time, but users
+-- can label their own sessions the same way, so the label can not bind a session to a client.
+-- Such sessions are revoked instead: neither the client nor the user can redeem them any more
+UPDATE refresh_tokens SET revoked = TRUE
+WHERE auth_time IS NULL AND device_label IN (SELECT client_id FROM clients);
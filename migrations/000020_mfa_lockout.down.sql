@@ -0,0 +1,4 @@
+-- Drop the second-factor lockout
+
+ALTER TABLE totp_secrets DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE totp_secrets DROP COLUMN IF EXISTS failed_attempts;
@@ -0,0 +1,4 @@
+-- Wrong second-factor codes counted across login challenges and the lockout they trigger
+
+ALTER TABLE totp_secrets ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE totp_secrets ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
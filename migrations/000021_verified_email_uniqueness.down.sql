@@ -0,0 +1,5 @@
+-- Make every profile email unique again
+
+DROP INDEX IF EXISTS idx_profiles_verified_email;
+
+ALTER TABLE profiles ADD CONSTRAINT profiles_email_key UNIQUE (email);
@@ -0,0 +1,7 @@
+-- Only verified emails are unique: an unverified claim must not keep the real owner from the address,
+-- and lookups by email only match verified ones
+
+ALTER TABLE profiles DROP CONSTRAINT IF EXISTS profiles_email_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_profiles_verified_email ON profiles (lower(email))
+WHERE email_verified_at IS NOT NULL;
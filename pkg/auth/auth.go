@@ -16,8 +16,13 @@ type User = internalStorage.User
 // AuthService is the public alias for the internal authentication service.
 type AuthService = internalAuth.AuthService
 
+// AuthOption is the public alias for authentication service options.
+type AuthOption = internalAuth.Option
+
 // NewAuthService constructs a new authentication service.
-func NewAuthService(store Storage) *AuthService { return internalAuth.NewAuthService(store) }
+func NewAuthService(store Storage, opts ...AuthOption) *AuthService {
+	return internalAuth.NewAuthService(store, opts...)
+}
 
 // NewDB creates a new PostgreSQL storage by DSN.
 func NewDB(dsn string) (*internalStorage.DB, error) { return internalStorage.NewDB(dsn) }
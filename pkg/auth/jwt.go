@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	internalJWT "github.com/vitalykrupin/auth-service/internal/app/auth/middleware"
 )
@@ -9,14 +11,126 @@ import (
 // Claims is the public alias for JWT claims.
 type Claims = internalJWT.Claims
 
+// SigningKey is the public alias for JWT signing keys.
+type SigningKey = internalJWT.SigningKey
+
+// KeyRing is the public alias for the JWT key ring.
+type KeyRing = internalJWT.KeyRing
+
+// TokenSettings is the public alias for registered claim settings.
+type TokenSettings = internalJWT.TokenSettings
+
 // ContextKey is the alias for JWT context key type.
 type ContextKey = internalJWT.ContextKey
 
+// CallerType is the alias for the token holder type.
+type CallerType = internalJWT.CallerType
+
 // UserIDKey is the exported context key for user id.
 const UserIDKey = internalJWT.UserIDKey
 
+// Context keys and caller types set by JWTMiddleware.
+const (
+	CallerTypeKey = internalJWT.CallerTypeKey
+	ClientIDKey   = internalJWT.ClientIDKey
+	ScopeKey      = internalJWT.ScopeKey
+	CallerUser    = internalJWT.CallerUser
+	CallerClient  = internalJWT.CallerClient
+)
+
 // GenerateToken re-exports JWT token generator.
 func GenerateToken(userID string) (string, error) { return internalJWT.GenerateToken(userID) }
 
 // JWTMiddleware re-exports the HTTP middleware.
 func JWTMiddleware(next http.Handler) http.Handler { return internalJWT.JWTMiddleware(next) }
+
+// LoadSigningKey re-exports the PEM signing key loader.
+func LoadSigningKey(id, alg, path string) (*SigningKey, error) {
+	return internalJWT.LoadSigningKey(id, alg, path)
+}
+
+// SetSigningKey re-exports the signing key setter.
+func SetSigningKey(key *SigningKey) { internalJWT.SetSigningKey(key) }
+
+// SetKeyRing re-exports the key ring setter.
+func SetKeyRing(ring *KeyRing) { internalJWT.SetKeyRing(ring) }
+
+// SetTokenSettings re-exports the issuer/audience/leeway setter.
+func SetTokenSettings(settings TokenSettings) { internalJWT.SetTokenSettings(settings) }
+
+// CallerTypeFromContext re-exports the caller type accessor.
+func CallerTypeFromContext(ctx context.Context) CallerType {
+	return internalJWT.CallerTypeFromContext(ctx)
+}
+
+// RevocationList is the alias for the access token denylist interface.
+type RevocationList = internalJWT.RevocationList
+
+// SetRevocationList re-exports the denylist setter.
+func SetRevocationList(list RevocationList) { internalJWT.SetRevocationList(list) }
+
+// SetNotBefore re-exports the global token cut-off setter.
+func SetNotBefore(t time.Time) { internalJWT.SetNotBefore(t) }
+
+// TokenVersionSource is the alias for the user token version lookup.
+type TokenVersionSource = internalJWT.TokenVersionSource
+
+// SetTokenVersionSource re-exports the token version source setter.
+func SetTokenVersionSource(source TokenVersionSource, ttl time.Duration) {
+	internalJWT.SetTokenVersionSource(source, ttl)
+}
+
+// ForgetTokenVersion re-exports the token version cache invalidation.
+func ForgetTokenVersion(userID string) { internalJWT.ForgetTokenVersion(userID) }
+
+// EmailVerificationSource is the alias for the email verification lookup.
+type EmailVerificationSource = internalJWT.EmailVerificationSource
+
+// SetEmailVerificationSource re-exports the email verification source setter.
+func SetEmailVerificationSource(source EmailVerificationSource) {
+	internalJWT.SetEmailVerificationSource(source)
+}
+
+// VerifyToken re-exports the revocation-aware token verifier.
+func VerifyToken(ctx context.Context, token string) (*Claims, error) {
+	return internalJWT.VerifyToken(ctx, token)
+}
+
+// ParseToken re-exports the token verifier.
+func ParseToken(token string) (*Claims, error) { return internalJWT.ParseToken(token) }
+
+// JWKSHandler re-exports the JWK set handler.
+func JWKSHandler() http.Handler { return internalJWT.JWKSHandler() }
+
+// Authentication is the alias for how and when a user authenticated.
+type Authentication = internalJWT.Authentication
+
+// AuthPolicy is the alias for step-up authentication requirements.
+type AuthPolicy = internalJWT.AuthPolicy
+
+// Authentication methods (amr) and context classes (acr) recorded in user tokens.
+const (
+	AuthenticationKey = internalJWT.AuthenticationKey
+	AMRPassword       = internalJWT.AMRPassword
+	AMROTP            = internalJWT.AMROTP
+	AMRHardwareKey    = internalJWT.AMRHardwareKey
+	AMRUserPresence   = internalJWT.AMRUserPresence
+	AMRMultiFactor    = internalJWT.AMRMultiFactor
+	ACRSingleFactor   = internalJWT.ACRSingleFactor
+	ACRMultiFactor    = internalJWT.ACRMultiFactor
+)
+
+// AuthenticationFromContext re-exports the authentication accessor.
+func AuthenticationFromContext(ctx context.Context) (Authentication, bool) {
+	return internalJWT.AuthenticationFromContext(ctx)
+}
+
+// StepUpMiddleware re-exports the HTTP middleware demanding a recent or strong authentication.
+func StepUpMiddleware(policy AuthPolicy, next http.Handler) http.Handler {
+	return internalJWT.StepUpMiddleware(policy, next)
+}
+
+// RequireAuthentication re-exports the in-handler step-up check.
+func RequireAuthentication(w http.ResponseWriter, r *http.Request, policy AuthPolicy) bool {
+	return internalJWT.RequireAuthentication(w, r, policy)
+}